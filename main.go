@@ -2,82 +2,371 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/atotto/clipboard"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aymanbagabas/go-osc52/v2"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/lipgloss/table"
-	"log"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/charmbracelet/x/term"
+	"github.com/klauspost/compress/zstd"
+	"github.com/muesli/termenv"
+	"github.com/rivo/uniseg"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	parquetreader "github.com/xitongsys/parquet-go/reader"
+	parquettypes "github.com/xitongsys/parquet-go/types"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	_ "modernc.org/sqlite"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// pane holds the cursor and scroll position of one view over the data
+type pane struct {
+	cursorRow int
+	cursorCol int
+	viewportX int
+	viewportY int
+
+	// Screen line within the cursor row that paging through wrapped rows
+	// landed on. It only applies while the cursor stays on wrapLineRow.
+	wrapLine    int
+	wrapLineRow int
+}
+
 type model struct {
 	csvData      [][]string
 	filename     string
+	outputFile   string // Where saves go instead of filename, "" to save in place
 	delimiter    rune
+	quoting      csvQuoting
 	originalData [][]string
 	savePrompt   bool
 	hasChanges   bool
 
+	// Saving when the file to save to isn't writable
+	readOnly       bool      // Whether the save target can't be written
+	fromStdin      bool      // Whether the data was piped in, so there's no file to save back to
+	objectURL      string    // s3:// or gs:// URL the file was downloaded from, which saves upload back to
+	compressedFile string    // gzip or zstd file the data was decompressed from, which saves compress back to
+	compression    string    // "gzip" or "zstd" when the data came from a compressed file
+	jsonFile       string    // JSON or JSON Lines file the data was read from, which saves write back to
+	jsonFormat     string    // "json" or "jsonl" when the data came from a JSON file
+	jsonKinds      jsonKinds // Kind of each JSON value as read, which saves write back unless the cell was edited
+	parquetFile    string    // Parquet file the data was read from, which can't be written back
+	sqliteFile     string    // SQLite database the data was read from, which saves write back to
+	sqliteTable    string    // The table of sqliteFile the data was read from
+	sourcePath     string    // The local file named on the command line, which sidecars and git diffs go by, "" for stdin and URLs
+	pipeOutput     bool      // Whether quitting writes the rows in view to stdout instead of offering to save them
+	screen         *os.File  // The terminal the TUI draws on, nil for stdout
+	altSavePrompt  bool      // Whether to ask for another path to save to
+	altSaveInput   textinput.Model
+	privilegedSave bool   // Whether to confirm saving through sudo or doas
+	privilegedTool string // "sudo" or "doas"
+
 	// Active CSV data (what's currently being displayed)
 	activeHeaders     []string
 	activeRows        [][]string
 	activeColumnTypes []DataType
+	typesSampled      bool // Whether the types come from a sample while the full scan runs
 
 	// Original CSV data (before any filtering)
 	originalHeaders     []string
 	originalRows        [][]string
 	originalColumnTypes []DataType
 
-	// Navigation and display
-	cursorRow int
-	cursorCol int
-	viewportX int
-	viewportY int
-	width     int
-	height    int
-	renderer  *lipgloss.Renderer
+	// Source data row (0-based, excluding the header) of each active row,
+	// -1 for rows that only exist in a filtered view
+	rowIndex         []int
+	originalRowIndex []int
+
+	// Sorting (view only, the file keeps its row order)
+	sortColumn string // Header of the sort column, "" when unsorted
+	sortDesc   bool
+
+	// Navigation and display. The focused pane's cursor and viewport are
+	// embedded; the other pane is only drawn when the screen is split.
+	pane
+	otherPane   pane
+	splitView   bool
+	focusBottom bool // Whether the focused pane is the bottom one
+
+	// Companion file (e.g. a lookup table) shown read-only below the data
+	// instead of a split view, with its own cursor and scrolling
+	preview        *model
+	previewFocused bool
+	previewPrompt  bool
+	previewInput   textinput.Model
+	paneRows       int  // Data rows shown when drawn as a preview pane, 0 to fit the screen
+	frozenRows     int  // Leading data rows that stay visible while scrolling
+	frozenCols     int  // Leading columns that stay visible while scrolling
+	rowNumbers     bool // Whether the row-number gutter is shown
+	wrapCells      bool // Whether long values wrap onto several lines instead of widening the column
+	width          int
+	height         int
+	renderer       *lipgloss.Renderer
 
 	// Input modes
 	editMode       bool
 	textInput      textinput.Model
+	editSyntax     string // "JSON" or "XML" when editing in the structured multi-line editor
+	textArea       textarea.Model
+	editError      string
 	gotoMode       bool
 	gotoStep       int // 0 = row input, 1 = column input
 	rowInput       textinput.Model
 	colInput       textinput.Model
 	gotoError      string
 	searchMode     bool
-	searchStep     int // 0 = search term, 1 = row filter, 2 = column filter
+	searchStep     int // 0 = search term, 1 = row filter, 2 = column filter, 3 = case toggle, 4 = whole-cell toggle, 5 = headers toggle
 	searchInput    textinput.Model
 	searchRowInput textinput.Model
 	searchColInput textinput.Model
-	searchResults  [][]int // Array of [row, col] pairs
+	searchResults  [][]int // Array of [row, col] pairs, row -1 for header matches
 	searchIndex    int     // Current position in search results
 	hasSearched    bool    // Whether a search has been performed
 
+	// Search options, toggled from within search/replace mode
+	searchCaseSensitive bool // Match letter case exactly
+	searchWholeCell     bool // Require the whole cell to match rather than a substring
+	searchHeaders       bool // Also match column headers (search mode only)
+
+	// Replace functionality
+	replaceMode    bool // Whether we're in replace input mode
+	replaceStep    int  // 0 = search term, 1 = replacement, 2 = row filter, 3 = column filter, 4 = case toggle, 5 = whole-cell toggle
+	replaceInput   textinput.Model
+	replaceConfirm bool // Whether we're stepping through matches confirming each replacement
+	replaceCount   int  // Number of occurrences replaced in the current session
+
 	// Filter functionality
 	filterMode         bool // Whether we're in filter input mode
 	filterInput        textinput.Model
 	isFiltered         bool     // Whether data is currently filtered
+	columnIndex        []int    // The csvData column behind each active column while a SELECT picks them, -1 for computed ones
 	appliedFilters     []string // History of applied filters
 	saveFilteredPrompt bool     // Whether to show save filtered CSV prompt
+	exportAppend       bool     // Whether the filtered export appends to an existing file
 	saveFilteredInput  textinput.Model
+	saveFilteredWarned string // Source file the filtered view was about to overwrite, saved on a second Enter
+
+	// Column groups (two-level headers)
+	groupHeaderRow  []string          // Group row read from the file, written back on save
+	preamble        []string          // Comment lines read from above the header, written back on save
+	columnGroups    map[string]string // Header name -> group name
+	collapsedGroups map[string]bool   // Groups currently collapsed down to their first column
+
+	// Default values (by header) for inserted rows
+	rowTemplate map[string]string
+
+	// Column-centric controls
+	headerMode    bool // Whether the header row has focus for column commands
+	renameMode    bool
+	renameInput   textinput.Model
+	hiddenColumns map[string]bool    // Header names hidden from the table view
+	pinnedColumns map[string]bool    // Header names always drawn on the left
+	heatmaps      map[string]bool    // Header names of columns colored by value
+	dataBars      map[string]bool    // Header names of columns with a bar beside each number
+	columnWidths  map[string]int     // Header names with a manually set width
+	widthCache    map[int]int        // Column widths measured while rendering, nil outside View
+	rangeCache    map[int][2]float64 // Number ranges of heatmap and data bar columns, cleared when the rows in view change
+	statsView     string             // Rendered column statistics or file comments overlay, "" when closed
+
+	// First key of a two-key sequence, waiting for the second
+	pendingKey string
+
+	// Fuzzy jump to a column by typing part of its header
+	columnJumpMode   bool
+	columnJumpInput  textinput.Model
+	columnJumpOrigin int   // Column to return to on cancel
+	columnMatches    []int // Matching columns, best first
+	columnMatchIndex int   // Match under the cursor, cycled with Tab
+
+	// Theme editor overlay
+	themeEditor    bool
+	themeSetting   int  // Index into themeSettings
+	themeOnPalette bool // Focus is on the palette grid instead of the settings
+	themeSwatchRow int  // Palette grid cursor
+	themeSwatchCol int
+	themeHexMode   bool // Typing a color instead of picking one
+	themeHexInput  textinput.Model
+	themeEdits     map[string]string // Settings changed this session, not yet written
+
+	// Named cell bookmarks, set with a letter and listed in an overlay
+	bookmarks      map[string]bookmark
+	bookmarkPrompt bool // Waiting for the letter naming a new bookmark
+	bookmarkView   bool
+	bookmarkCursor int
+
+	// Keyboard macro: keys captured while recording, replayed on demand
+	recordingMacro bool
+	playingMacro   bool
+	macroKeys      []tea.KeyMsg
+	macroPrompt    bool
+	macroInput     textinput.Model
+
+	// Vim-style : command line
+	commandMode  bool
+	commandInput textinput.Model
+
+	// Problems overlay: cells whose values don't fit their column's type
+	problemsView  bool
+	problemList   []cellProblem
+	problemCursor int
+
+	// Multi-cell paste confirmation
+	pastePrompt bool
+	pasteText   string     // Raw clipboard text
+	pasteGrid   [][]string // Clipboard text split into rows and cells
+
+	// Visual (rectangular) selection, anchored where it was started
+	visualMode bool
+	anchorRow  int
+	anchorCol  int
+	dragging   bool // Whether the left button was pressed on a cell and is still down
+
+	// Bulk edit prompt for the selection, or the whole column without one
+	bulkEditMode   bool
+	bulkEditInput  textinput.Model
+	transformName  string              // Column transform awaiting confirmation, "" when none
+	transform      func(string) string // The pending transform
+	transformNote  string              // Shown with the pending transform, e.g. cells it can't handle
+	transformHead  string              // Header the cursor column gets once the transform is applied, "" to keep it
+	padPrompt      bool                // Whether to ask for the width to pad values to
+	padInput       textinput.Model
+	padSpaces      bool // Pad with spaces instead of zeros
+	datePrompt     bool // Whether to ask for the layout to rewrite dates in
+	dateInput      textinput.Model
+	zonePrompt     bool // Whether to ask for the time zones to convert times between
+	zoneFrom       textinput.Model
+	zoneTo         textinput.Model
+	unitPrompt     bool // Whether to ask for a unit conversion
+	unitRewrite    bool // Rewrite the values instead of showing them converted
+	unitInput      textinput.Model
+	computedPrompt bool // Whether to ask for the function of a computed column
+	computedInput  textinput.Model
+
+	// Undo history of cell edits, one entry per key press
+	undoBatch []cellChange   // Changes made while handling the current key
+	undoStack [][]cellChange // Most recent last
+
+	// Review notes on cells and rows, kept in a sidecar file
+	notes      map[noteKey]string
+	noteMode   bool
+	noteColumn string // Header of the cell being annotated, "" for a row note
+	noteInput  textinput.Model
+
+	// Review marks on rows, keyed by source row and kept in a sidecar file
+	marks            map[int]string
+	marksChanged     bool // Whether marks were set since the sidecar was saved, which saving or quitting does
+	markFilterPrompt bool
+
+	// Exporting marked rows to a new file
+	exportMarksPrompt bool
+	exportMarksInput  textinput.Model
+	exportMark        string // Mark of the rows to export, "" for any mark
+	exportMarkColumn  bool   // Whether the export gets a column holding each row's mark
+
+	// Rows pinned to the comparison tray, by source row in pinning order.
+	// They're drawn below the scrolling rows of the table.
+	tray             []int
+	trayExportPrompt bool
+	trayExportInput  textinput.Model
+
+	// Exporting the rows in view as SQL INSERT statements
+	sqlExportPrompt bool
+	sqlExportStep   int // 0 = table name, 1 = filename
+	sqlTableInput   textinput.Model
+	sqlFileInput    textinput.Model
+
+	// Diff against a git revision of the file
+	diffPrompt  bool
+	diffInput   textinput.Model
+	diffRef     string            // Revision being compared against, "" when no diff is shown
+	diffCells   map[diffCell]bool // Cells that differ from the revision
+	diffSummary string
+
+	// Inspector pane
+	showInspector bool // Whether the cell inspector pane is visible
+
+	// Formula bar above the table showing, and editing in place, the raw
+	// value of the cell under the cursor
+	formulaBar     bool
+	formulaEditing bool
+	formulaInput   textinput.Model
+	prettyPrint    bool // Whether JSON/XML cells are shown and edited in indented form
+
+	// Transient feedback shown in the status line until the next key press
+	statusMessage string
+	statusIsError bool
+
+	// Terminal title last sent, so it is only rewritten when it changes
+	lastWindowTitle string
+
+	// Lines drawn when running inline instead of on the alternate screen, 0
+	// in full-screen mode
+	inlineHeight int
+	quitting     bool // Set when quitting, so inline sessions leave a clean table behind
+
+	// What the session did, for the exit summary
+	viewedRows   map[int]bool // Source rows that have been on screen
+	filterLog    []string     // Every filter applied, including those since reset
+	cellsChanged int          // Cell edits, counting a cell edited twice twice and leaving out undone ones
+	filesWritten []string     // Files saved or exported to, in order
 
 	// UI components
 	keys       keyMap
+	modeKeys   map[string]keyMap // Keys of modes with their own config, see keysFor
 	help       help.Model
+	helpLevel  int // helpHint, helpShort, or helpFull
+	hideLegend bool
+	hideHelp   bool // Hide the help line unless the full help is open
+	plainText  bool // Style only the cursor, selection and header
 	config     *Config
+	theme      Theme
+	border     string // A tableBorders name or borderNone
+	locale     localeFormat
 	typeColors map[DataType]lipgloss.Color
 	dimColors  map[DataType]lipgloss.Color
+
+	unitConversions map[string]UnitConversion // Built-in and configured conversions, by name
+	units           map[string]string         // Conversion each column is shown in, by header
 }
 
 func parseDelimiterFlag(delimiterFlag string) (rune, error) {
@@ -95,11 +384,79 @@ func parseDelimiterFlag(delimiterFlag string) (rune, error) {
 		if len(delimiterFlag) == 1 {
 			return rune(delimiterFlag[0]), nil
 		}
-		return ',', fmt.Errorf("invalid delimiter '%s'. Use comma, semicolon, tab, pipe, or any single character", delimiterFlag)
+		return ',', fmt.Errorf("invalid delimiter '%s'. Use comma, semicolon, tab, pipe, any single character, text:SEPARATOR, or regex:PATTERN", delimiterFlag)
+	}
+}
+
+// fieldSeparator splits fields on a multi-character string or a regular
+// expression, for quasi-CSV dumps such as " | " separated logs. These formats
+// have no quoting, so fields are split and joined as they are.
+type fieldSeparator struct {
+	text    string         // Joins fields on save; for patterns, the first match in the file
+	pattern *regexp.Regexp // nil for literal separators
+}
+
+// parseDelimiterSpec parses a delimiter flag or config value. "text:" followed
+// by a string, or "regex:" followed by a pattern, gives a separator instead.
+func parseDelimiterSpec(value string) (rune, *fieldSeparator, error) {
+	if text, ok := strings.CutPrefix(value, "text:"); ok {
+		if text == "" {
+			return ',', nil, fmt.Errorf("delimiter text: needs a separator after it")
+		}
+		return ',', &fieldSeparator{text: text}, nil
+	}
+	if expr, ok := strings.CutPrefix(value, "regex:"); ok {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return ',', nil, fmt.Errorf("invalid delimiter pattern: %v", err)
+		}
+		if pattern.MatchString("") {
+			return ',', nil, fmt.Errorf("delimiter pattern %q matches an empty string", expr)
+		}
+		return ',', &fieldSeparator{pattern: pattern}, nil
+	}
+	delimiter, err := parseDelimiterFlag(value)
+	return delimiter, nil, err
+}
+
+// split splits a line into fields, remembering the first separator a pattern
+// matches so saving can join fields with it
+func (s *fieldSeparator) split(line string) []string {
+	if s.pattern == nil {
+		return strings.Split(line, s.text)
+	}
+	if s.text == "" {
+		s.text = s.pattern.FindString(line)
+	}
+	return s.pattern.Split(line, -1)
+}
+
+// readSeparated reads records split by a separator, one per line, skipping
+// blank lines like encoding/csv
+func readSeparated(r io.Reader, separator *fieldSeparator, options ImportConfig) ([][]string, error) {
+	reader := bufio.NewReader(r)
+	skipBOM(reader)
+	readPreamble(reader, options.CommentPrefix)
+
+	var records [][]string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		fields := separator.split(scanner.Text())
+		if options.TrimLeadingSpace {
+			for i, field := range fields {
+				fields[i] = strings.TrimLeft(field, " \t")
+			}
+		}
+		records = append(records, fields)
 	}
+	return records, scanner.Err()
 }
 
-func detectDelimiter(filename string) (rune, error) {
+func detectDelimiter(filename string, options ImportConfig) (rune, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return ',', fmt.Errorf("error opening file %s: %v", filename, err)
@@ -108,7 +465,10 @@ func detectDelimiter(filename string) (rune, error) {
 
 	// Common delimiters to test
 	delimiters := []rune{',', ';', '\t', '|'}
-	scanner := bufio.NewScanner(file)
+	reader := bufio.NewReader(file)
+	skipBOM(reader)
+	readPreamble(reader, options.CommentPrefix)
+	scanner := bufio.NewScanner(reader)
 
 	// Read up to 25 lines for analysis
 	var lines []string
@@ -168,17 +528,43 @@ func detectDelimiter(filename string) (rune, error) {
 	return bestDelimiter, nil
 }
 
-func readCSV(filename string, delimiter rune) ([][]string, error) {
+// newCSVReader returns a reader for delimited data, as tolerant of
+// malformed input as the import settings allow
+func newCSVReader(r io.Reader, delimiter rune, options ImportConfig) *csv.Reader {
+	// Skip a byte order mark so it doesn't end up in the first header, and
+	// comment lines so the header is found below them
+	buffered := bufio.NewReader(r)
+	skipBOM(buffered)
+	readPreamble(buffered, options.CommentPrefix)
+	reader := csv.NewReader(buffered)
+	reader.Comma = delimiter
+	reader.LazyQuotes = options.LazyQuotes
+	reader.TrimLeadingSpace = options.TrimLeadingSpace
+	return reader
+}
+
+func readCSV(filename string, delimiter rune, options ImportConfig) ([][]string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file %s: %v", filename, err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.Comma = delimiter
-	records, err := reader.ReadAll()
+	var records [][]string
+	if options.separator != nil {
+		records, err = readSeparated(file, options.separator, options)
+	} else {
+		reader := newCSVReader(file, delimiter, options)
+		reader.FieldsPerRecord = -1 // Ragged rows are reported as problems instead
+		records, err = reader.ReadAll()
+	}
 	if err != nil {
+		if options.separator != nil {
+			return nil, fmt.Errorf("error reading file: %v", err)
+		}
+		if !options.LazyQuotes && (errors.Is(err, csv.ErrBareQuote) || errors.Is(err, csv.ErrQuote)) {
+			return nil, fmt.Errorf("error reading CSV file with delimiter '%c': %v (set \"lazyQuotes\" under \"import\" in the config to allow stray quotes)", delimiter, err)
+		}
 		return nil, fmt.Errorf("error reading CSV file with delimiter '%c': %v", delimiter, err)
 	}
 
@@ -189,1665 +575,13404 @@ func readCSV(filename string, delimiter rune) ([][]string, error) {
 	return records, nil
 }
 
-func writeCSV(filename string, data [][]string, delimiter rune) error {
-	file, err := os.Create(filename)
+// usesCRLF reports whether data mostly ends its lines with \r\n. Counting
+// every line keeps a bare \n inside a quoted field, or a single line an
+// editor added, from deciding it.
+func usesCRLF(data []byte) bool {
+	crlf := bytes.Count(data, []byte("\r\n"))
+	return crlf > 0 && crlf >= bytes.Count(data, []byte("\n"))-crlf
+}
+
+// utf8BOM is the byte order mark some editors, Excel in particular, put at
+// the start of UTF-8 files
+const utf8BOM = "\ufeff"
+
+// detectBOM reports whether a file starts with a UTF-8 byte order mark
+func detectBOM(filename string) bool {
+	file, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("error creating file %s: %v", filename, err)
+		return false
 	}
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	writer.Comma = delimiter
-	defer writer.Flush()
+	buf := make([]byte, len(utf8BOM))
+	n, _ := io.ReadFull(file, buf)
+	return string(buf[:n]) == utf8BOM
+}
 
-	for _, record := range data {
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("error writing CSV record: %v", err)
-		}
+// skipBOM advances past a byte order mark at the start of the input
+func skipBOM(r *bufio.Reader) {
+	if start, _ := r.Peek(len(utf8BOM)); string(start) == utf8BOM {
+		r.Discard(len(utf8BOM))
 	}
-
-	return nil
 }
 
-func (m *model) writeBackup() error {
-	backupFilename := m.filename + ".temp"
-	return writeCSV(backupFilename, m.csvData, m.delimiter)
+// readPreamble reads the comment lines at the top of the input, keeping their
+// line endings so they can be written back unchanged
+func readPreamble(r *bufio.Reader, prefix string) []string {
+	var lines []string
+	for prefix != "" {
+		if start, _ := r.Peek(len(prefix)); string(start) != prefix {
+			break
+		}
+		line, err := r.ReadString('\n')
+		lines = append(lines, line)
+		if err != nil {
+			break
+		}
+	}
+	return lines
 }
 
-func (m *model) saveToOriginal() error {
-	if err := writeCSV(m.filename, m.csvData, m.delimiter); err != nil {
-		return err
+// filePreamble returns the comment lines above a file's header
+func filePreamble(filename string, options ImportConfig) []string {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil
 	}
+	defer file.Close()
 
-	// Remove backup file after successful save
-	backupFilename := m.filename + ".temp"
-	os.Remove(backupFilename) // Ignore error if file doesn't exist
-
-	m.hasChanges = false
-	return nil
+	reader := bufio.NewReader(file)
+	skipBOM(reader)
+	return readPreamble(reader, options.CommentPrefix)
 }
 
-type DataType int
+// detectCRLF reports whether a file ends its lines with \r\n, so saving
+// can keep them that way
+func detectCRLF(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	buf := make([]byte, 64*1024)
+	n, _ := io.ReadFull(file, buf)
+	return usesCRLF(buf[:n])
+}
 
+// Quoting styles for written CSV
 const (
-	DataTypeString DataType = iota
-	DataTypeInt
-	DataTypeFloat
-	DataTypeBool
-	DataTypeEmpty
+	quoteMinimal    = "minimal"     // Only fields that need it, like encoding/csv
+	quoteAlways     = "always"      // Every field
+	quoteNonNumeric = "non-numeric" // Every field that isn't a number
+	quoteLazy       = "lazy"        // Only fields that can't be read back otherwise, keeping stray quotes as they were read
 )
 
-type Config struct {
-	Colors  ColorConfig  `json:"colors,omitempty"`
-	Hotkeys HotkeyConfig `json:"hotkeys,omitempty"`
-}
+var quoteStyles = []string{quoteMinimal, quoteAlways, quoteNonNumeric, quoteLazy}
 
-type ColorConfig struct {
-	DataTypeString string `json:"DataTypeString,omitempty"`
-	DataTypeInt    string `json:"DataTypeInt,omitempty"`
-	DataTypeFloat  string `json:"DataTypeFloat,omitempty"`
-	DataTypeBool   string `json:"DataTypeBool,omitempty"`
-	DataTypeEmpty  string `json:"DataTypeEmpty,omitempty"`
-}
+// csvQuoting controls how fields are quoted and records end when writing CSV
+type csvQuoting struct {
+	style  string
+	quote  rune
+	escape rune // 0 to escape quotes by doubling them
+	crlf   bool // End records with \r\n, as files written on Windows usually do
+	bom    bool // Start the output with a UTF-8 byte order mark
 
-type HotkeyConfig struct {
-	Up           []string `json:"Up,omitempty"`
-	Down         []string `json:"Down,omitempty"`
-	Left         []string `json:"Left,omitempty"`
-	Right        []string `json:"Right,omitempty"`
-	PageUp       []string `json:"PageUp,omitempty"`
-	PageDown     []string `json:"PageDown,omitempty"`
-	PageLeft     []string `json:"PageLeft,omitempty"`
-	PageRight    []string `json:"PageRight,omitempty"`
-	Edit         []string `json:"Edit,omitempty"`
-	Help         []string `json:"Help,omitempty"`
-	Quit         []string `json:"Quit,omitempty"`
-	Save         []string `json:"Save,omitempty"`
-	Cancel       []string `json:"Cancel,omitempty"`
-	GoTo         []string `json:"GoTo,omitempty"`
-	Search       []string `json:"Search,omitempty"`
-	NextMatch    []string `json:"NextMatch,omitempty"`
-	PrevMatch    []string `json:"PrevMatch,omitempty"`
-	Tab          []string `json:"Tab,omitempty"`
-	Filter       []string `json:"Filter,omitempty"`
-	ResetFilters []string `json:"ResetFilters,omitempty"`
+	separator string // Joins fields unquoted in place of the delimiter, for files read with a fieldSeparator
 }
 
-func loadConfig() (*Config, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %v", err)
-	}
+var defaultQuoting = csvQuoting{style: quoteMinimal, quote: '"'}
 
-	configPath := filepath.Join(homeDir, ".csvtui.json")
+// quotingFromConfig validates the export quoting settings
+// bomModes are the accepted values of the export "bom" setting
+var bomModes = []string{"preserve", "always", "never"}
 
-	// Check if config file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		// Config file doesn't exist, return empty config (will use defaults)
-		return &Config{}, nil
+// writeBOM reports whether saving should start the file with a byte order
+// mark, given the export setting and whether the file had one on load
+func writeBOM(mode string, present bool) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
 	}
+	return present
+}
 
-	// Read config file
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %v", configPath, err)
+func quotingFromConfig(config ExportConfig) (csvQuoting, error) {
+	quoting := defaultQuoting
+	if config.Quoting != "" {
+		if !slices.Contains(quoteStyles, config.Quoting) {
+			return defaultQuoting, fmt.Errorf("unknown quoting style %q (use %s)", config.Quoting, strings.Join(quoteStyles, ", "))
+		}
+		quoting.style = config.Quoting
 	}
-
-	// Parse JSON
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file %s: %v", configPath, err)
+	if config.QuoteChar != "" {
+		runes := []rune(config.QuoteChar)
+		if len(runes) != 1 {
+			return defaultQuoting, fmt.Errorf("quote character must be a single character, got %q", config.QuoteChar)
+		}
+		quoting.quote = runes[0]
 	}
-
-	return &config, nil
-}
-
-func getDefaultColors() map[DataType]lipgloss.Color {
-	return map[DataType]lipgloss.Color{
-		DataTypeString: lipgloss.Color("#87CEEB"), // Sky blue for strings
-		DataTypeInt:    lipgloss.Color("#90EE90"), // Light green for integers
-		DataTypeFloat:  lipgloss.Color("#FFB6C1"), // Light pink for floats
-		DataTypeBool:   lipgloss.Color("#DDA0DD"), // Plum for booleans
-		DataTypeEmpty:  lipgloss.Color("#D3D3D3"), // Light gray for empty
+	if config.BOM != "" && !slices.Contains(bomModes, config.BOM) {
+		return defaultQuoting, fmt.Errorf("unknown bom setting %q (use %s)", config.BOM, strings.Join(bomModes, ", "))
 	}
-}
-
-func getDefaultDimColors() map[DataType]lipgloss.Color {
-	return map[DataType]lipgloss.Color{
-		DataTypeString: lipgloss.Color("#4682B4"), // Steel blue (dimmer)
-		DataTypeInt:    lipgloss.Color("#6B8E23"), // Olive drab (dimmer)
-		DataTypeFloat:  lipgloss.Color("#CD5C5C"), // Indian red (dimmer)
-		DataTypeBool:   lipgloss.Color("#9370DB"), // Medium purple (dimmer)
-		DataTypeEmpty:  lipgloss.Color("#A9A9A9"), // Dark gray (dimmer)
+	if config.EscapeChar != "" {
+		runes := []rune(config.EscapeChar)
+		if len(runes) != 1 {
+			return defaultQuoting, fmt.Errorf("escape character must be a single character, got %q", config.EscapeChar)
+		}
+		if runes[0] != quoting.quote {
+			quoting.escape = runes[0]
+		}
 	}
+	return quoting, nil
 }
 
-func applyConfigColors(config *Config, defaultColors, defaultDimColors map[DataType]lipgloss.Color) (map[DataType]lipgloss.Color, map[DataType]lipgloss.Color) {
-	colors := make(map[DataType]lipgloss.Color)
-	dimColors := make(map[DataType]lipgloss.Color)
-
-	// Copy defaults
-	for k, v := range defaultColors {
-		colors[k] = v
+// needsQuotes reports whether a field has to be quoted under the given style
+func (q csvQuoting) needsQuotes(field string, delimiter rune) bool {
+	switch q.style {
+	case quoteAlways:
+		return true
+	case quoteNonNumeric:
+		if dataType := detectDataType(field); dataType != DataTypeInt && dataType != DataTypeFloat {
+			return true
+		}
+	case quoteLazy:
+		return strings.ContainsRune(field, delimiter) || strings.ContainsAny(field, "\r\n") ||
+			strings.HasPrefix(field, string(q.quote))
 	}
-	for k, v := range defaultDimColors {
-		dimColors[k] = v
+	if field == "" {
+		return false
 	}
+	return strings.ContainsRune(field, delimiter) || strings.ContainsRune(field, q.quote) ||
+		strings.ContainsAny(field, "\r\n") || field[0] == ' ' || field[0] == '\t' ||
+		(q.escape != 0 && strings.ContainsRune(field, q.escape))
+}
 
-	// Apply config overrides
-	if config.Colors.DataTypeString != "" {
-		colors[DataTypeString] = lipgloss.Color(config.Colors.DataTypeString)
-		// Create a dimmer version by using the same color but with reduced brightness
-		dimColors[DataTypeString] = lipgloss.Color(config.Colors.DataTypeString)
+// writeRecords writes records as CSV with the given delimiter and quoting
+func writeRecords(w io.Writer, data [][]string, delimiter rune, quoting csvQuoting) error {
+	// Files split on a separator have no quoting, so their fields can't hold
+	// the separator or a line break
+	if quoting.separator != "" {
+		for _, record := range data {
+			for _, field := range record {
+				if strings.Contains(field, quoting.separator) || strings.ContainsAny(field, "\r\n") {
+					return fmt.Errorf("%q can't be saved unquoted between %q separators", field, quoting.separator)
+				}
+			}
+		}
 	}
-	if config.Colors.DataTypeInt != "" {
-		colors[DataTypeInt] = lipgloss.Color(config.Colors.DataTypeInt)
-		dimColors[DataTypeInt] = lipgloss.Color(config.Colors.DataTypeInt)
+
+	// encoding/csv covers the default style exactly
+	lineEnd := "\n"
+	if quoting.crlf {
+		lineEnd = "\r\n"
 	}
-	if config.Colors.DataTypeFloat != "" {
-		colors[DataTypeFloat] = lipgloss.Color(config.Colors.DataTypeFloat)
-		dimColors[DataTypeFloat] = lipgloss.Color(config.Colors.DataTypeFloat)
+	quoting.crlf = false
+	if quoting.bom {
+		if _, err := io.WriteString(w, utf8BOM); err != nil {
+			return err
+		}
+		quoting.bom = false
 	}
-	if config.Colors.DataTypeBool != "" {
-		colors[DataTypeBool] = lipgloss.Color(config.Colors.DataTypeBool)
-		dimColors[DataTypeBool] = lipgloss.Color(config.Colors.DataTypeBool)
+	if quoting.separator != "" {
+		for _, record := range data {
+			if _, err := io.WriteString(w, strings.Join(record, quoting.separator)+lineEnd); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	if config.Colors.DataTypeEmpty != "" {
-		colors[DataTypeEmpty] = lipgloss.Color(config.Colors.DataTypeEmpty)
-		dimColors[DataTypeEmpty] = lipgloss.Color(config.Colors.DataTypeEmpty)
+	if quoting == defaultQuoting {
+		writer := csv.NewWriter(w)
+		writer.Comma = delimiter
+		writer.UseCRLF = lineEnd == "\r\n"
+		writer.WriteAll(data)
+		return writer.Error()
 	}
 
-	return colors, dimColors
-}
+	quote := string(quoting.quote)
+	escapedQuote := quote + quote
+	replacer := strings.NewReplacer(quote, escapedQuote)
+	if quoting.escape != 0 {
+		escape := string(quoting.escape)
+		replacer = strings.NewReplacer(quote, escape+quote, escape, escape+escape)
+	}
 
-func getDefaultHotkeys() map[string][]string {
-	return map[string][]string{
-		"Up":           {"up", "k"},
-		"Down":         {"down", "j"},
-		"Left":         {"left", "h"},
-		"Right":        {"right", "l"},
-		"PageUp":       {"pgup", "i"},
-		"PageDown":     {"pgdown", "u"},
-		"PageLeft":     {"y"},
-		"PageRight":    {"o"},
-		"Edit":         {"e"},
-		"Help":         {"?"},
-		"Quit":         {"q", "ctrl+c"},
-		"Save":         {"enter"},
-		"Cancel":       {"esc"},
-		"GoTo":         {"\\"},
-		"Search":       {" "},
-		"NextMatch":    {"n"},
-		"PrevMatch":    {"b"},
-		"Tab":          {"tab"},
-		"Filter":       {"~"},
-		"ResetFilters": {"="},
+	var b strings.Builder
+	for _, record := range data {
+		b.Reset()
+		for i, field := range record {
+			if i > 0 {
+				b.WriteRune(delimiter)
+			}
+			if quoting.needsQuotes(field, delimiter) {
+				// Line breaks inside fields follow the record line endings, like encoding/csv
+				if lineEnd != "\n" {
+					field = strings.ReplaceAll(field, "\n", lineEnd)
+				}
+				b.WriteString(quote + replacer.Replace(field) + quote)
+			} else {
+				b.WriteString(field)
+			}
+		}
+		b.WriteString(lineEnd)
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func applyConfigHotkeys(config *Config, defaults map[string][]string) map[string][]string {
-	hotkeys := make(map[string][]string)
+func writeCSV(filename string, data [][]string, delimiter rune, quoting csvQuoting) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %v", filename, err)
+	}
+	defer file.Close()
 
-	// Copy defaults
-	for k, v := range defaults {
-		hotkeys[k] = make([]string, len(v))
-		copy(hotkeys[k], v)
+	if err := writeRecords(file, data, delimiter, quoting); err != nil {
+		return fmt.Errorf("error writing CSV record: %v", err)
 	}
 
-	// Apply config overrides
-	if len(config.Hotkeys.Up) > 0 {
-		hotkeys["Up"] = config.Hotkeys.Up
+	return nil
+}
+
+// appendCSV appends rows to an existing CSV file whose header has the same
+// columns, reordering the rows to match its column order. A missing file is
+// created with the header.
+func appendCSV(filename string, headers []string, rows [][]string, delimiter rune, quoting csvQuoting) error {
+	existing, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return writeCSV(filename, append([][]string{headers}, rows...), delimiter, quoting)
 	}
-	if len(config.Hotkeys.Down) > 0 {
-		hotkeys["Down"] = config.Hotkeys.Down
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", filename, err)
 	}
-	if len(config.Hotkeys.Left) > 0 {
-		hotkeys["Left"] = config.Hotkeys.Left
+
+	var targetHeaders []string
+	if quoting.separator != "" {
+		records, _ := readSeparated(bytes.NewReader(existing), &fieldSeparator{text: quoting.separator}, ImportConfig{})
+		if len(records) == 0 {
+			return fmt.Errorf("error reading header of %s: file is empty", filename)
+		}
+		targetHeaders = records[0]
+	} else if targetHeaders, err = newCSVReader(bytes.NewReader(existing), delimiter, ImportConfig{}).Read(); err != nil {
+		return fmt.Errorf("error reading header of %s: %v", filename, err)
 	}
-	if len(config.Hotkeys.Right) > 0 {
-		hotkeys["Right"] = config.Hotkeys.Right
+
+	// Map each target column to the matching active column
+	columns := make(map[string]int)
+	for i, header := range headers {
+		columns[header] = i
 	}
-	if len(config.Hotkeys.PageUp) > 0 {
-		hotkeys["PageUp"] = config.Hotkeys.PageUp
+	order := make([]int, len(targetHeaders))
+	var missing []string
+	for i, header := range targetHeaders {
+		col, ok := columns[header]
+		if !ok {
+			missing = append(missing, header)
+		}
+		order[i] = col
+		delete(columns, header)
 	}
-	if len(config.Hotkeys.PageDown) > 0 {
-		hotkeys["PageDown"] = config.Hotkeys.PageDown
+	if len(missing) > 0 || len(columns) > 0 {
+		var extra []string
+		for _, header := range headers {
+			if _, ok := columns[header]; ok {
+				extra = append(extra, header)
+			}
+		}
+		return fmt.Errorf("headers don't match %s (missing: %s; extra: %s)", filepath.Base(filename),
+			strings.Join(missing, ", "), strings.Join(extra, ", "))
 	}
-	if len(config.Hotkeys.PageLeft) > 0 {
-		hotkeys["PageLeft"] = config.Hotkeys.PageLeft
+
+	ordered := make([][]string, len(rows))
+	for r, row := range rows {
+		ordered[r] = make([]string, len(order))
+		for i, col := range order {
+			if col < len(row) {
+				ordered[r][i] = row[col]
+			}
+		}
 	}
-	if len(config.Hotkeys.PageRight) > 0 {
-		hotkeys["PageRight"] = config.Hotkeys.PageRight
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", filename, err)
 	}
-	if len(config.Hotkeys.Edit) > 0 {
-		hotkeys["Edit"] = config.Hotkeys.Edit
+	defer file.Close()
+
+	// Follow the target file's line endings, and don't glue the first row
+	// onto an unterminated last line
+	quoting.crlf = usesCRLF(existing)
+	quoting.bom = false
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		lineEnd := "\n"
+		if quoting.crlf {
+			lineEnd = "\r\n"
+		}
+		if _, err := file.WriteString(lineEnd); err != nil {
+			return fmt.Errorf("error writing CSV record: %v", err)
+		}
 	}
-	if len(config.Hotkeys.Help) > 0 {
-		hotkeys["Help"] = config.Hotkeys.Help
+	if err := writeRecords(file, ordered, delimiter, quoting); err != nil {
+		return fmt.Errorf("error writing CSV record: %v", err)
 	}
-	if len(config.Hotkeys.Quit) > 0 {
-		hotkeys["Quit"] = config.Hotkeys.Quit
+	return nil
+}
+
+// writeFile writes the data back out in the source file's layout, with any
+// comment lines that were above the header ahead of the records. Files that
+// call for it are compressed or written as JSON, or to a SQLite table.
+func (m *model) writeFile(filename string) error {
+	return m.writeAs(filename, filename)
+}
+
+// writeAs writes the data to filename like writeFile would write target, in
+// the format and compression target calls for. Temp files headed for the
+// target are written this way.
+func (m *model) writeAs(filename, target string) error {
+	if database, table, ok := m.sqliteTarget(target); ok {
+		// Temp files headed for a database start as a copy of it
+		local := database
+		if isObjectURL(database) {
+			local = m.sqliteFile
+		}
+		file, _ := splitSQLiteName(filename)
+		if local != "" && file != local {
+			if err := copySQLite(local, file); err != nil {
+				return err
+			}
+		}
+		if err := writeSQLite(file, table, m.csvData, false, false); err != nil {
+			return fmt.Errorf("error writing table %s to %s: %v", table, file, err)
+		}
+		return nil
 	}
-	if len(config.Hotkeys.Save) > 0 {
-		hotkeys["Save"] = config.Hotkeys.Save
+	return m.writeRecordsAs(filename, target, m.csvData, m.groupHeaderRow, m.preamble)
+}
+
+// writeRecordsAs writes records, headers first, to filename in the format and
+// compression target calls for. Delimited files get the group row and the
+// comment lines ahead of the records, when there are any.
+func (m *model) writeRecordsAs(filename, target string, records [][]string, groupRow, preamble []string) error {
+	compression, format := m.compressionFor(target), m.formatFor(target)
+	delimited := records
+	if groupRow != nil {
+		delimited = append([][]string{groupRow}, records...)
 	}
-	if len(config.Hotkeys.Cancel) > 0 {
-		hotkeys["Cancel"] = config.Hotkeys.Cancel
+	if len(preamble) == 0 && compression == "" && format == "" {
+		return writeCSV(filename, delimited, m.delimiter, m.quoting)
 	}
-	if len(config.Hotkeys.GoTo) > 0 {
-		hotkeys["GoTo"] = config.Hotkeys.GoTo
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %v", filename, err)
 	}
-	if len(config.Hotkeys.Search) > 0 {
-		hotkeys["Search"] = config.Hotkeys.Search
+	defer file.Close()
+	w, err := compressWriter(file, compression)
+	if err != nil {
+		return err
 	}
-	if len(config.Hotkeys.NextMatch) > 0 {
-		hotkeys["NextMatch"] = config.Hotkeys.NextMatch
+
+	if format != "" {
+		if err := writeConverted(w, format, records, m.quoting, m.jsonKinds); err != nil {
+			return fmt.Errorf("error writing %s: %v", filename, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error compressing %s: %v", filename, err)
+		}
+		return file.Close()
 	}
-	if len(config.Hotkeys.PrevMatch) > 0 {
-		hotkeys["PrevMatch"] = config.Hotkeys.PrevMatch
+
+	// The byte order mark goes ahead of the comments
+	quoting := m.quoting
+	comments := strings.Join(preamble, "")
+	if quoting.bom {
+		comments = utf8BOM + comments
+		quoting.bom = false
 	}
-	if len(config.Hotkeys.Tab) > 0 {
-		hotkeys["Tab"] = config.Hotkeys.Tab
+	if _, err := io.WriteString(w, comments); err != nil {
+		return fmt.Errorf("error writing comment lines: %v", err)
 	}
-	if len(config.Hotkeys.Filter) > 0 {
-		hotkeys["Filter"] = config.Hotkeys.Filter
+	if err := writeRecords(w, delimited, m.delimiter, quoting); err != nil {
+		return fmt.Errorf("error writing CSV record: %v", err)
 	}
-	if len(config.Hotkeys.ResetFilters) > 0 {
-		hotkeys["ResetFilters"] = config.Hotkeys.ResetFilters
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error compressing %s: %v", filename, err)
 	}
+	return file.Close()
+}
 
-	return hotkeys
+// compressionExtensions map the extensions of compressed files to their
+// compression
+var compressionExtensions = map[string]string{
+	".gz":  "gzip",
+	".zst": "zstd",
 }
 
-func createKeyMapFromConfig(hotkeys map[string][]string) keyMap {
-	return keyMap{
-		Up: key.NewBinding(
-			key.WithKeys(hotkeys["Up"]...),
-			key.WithHelp("↑/k", "move up"),
-		),
-		Down: key.NewBinding(
-			key.WithKeys(hotkeys["Down"]...),
-			key.WithHelp("↓/j", "move down"),
-		),
-		Left: key.NewBinding(
-			key.WithKeys(hotkeys["Left"]...),
-			key.WithHelp("←/h", "move left"),
-		),
-		Right: key.NewBinding(
-			key.WithKeys(hotkeys["Right"]...),
-			key.WithHelp("→/l", "move right"),
-		),
-		PageUp: key.NewBinding(
-			key.WithKeys(hotkeys["PageUp"]...),
-			key.WithHelp("pgup/i", "page up"),
-		),
-		PageDown: key.NewBinding(
-			key.WithKeys(hotkeys["PageDown"]...),
-			key.WithHelp("pgdn/u", "page down"),
-		),
-		PageLeft: key.NewBinding(
-			key.WithKeys(hotkeys["PageLeft"]...),
-			key.WithHelp("y", "page left"),
-		),
-		PageRight: key.NewBinding(
-			key.WithKeys(hotkeys["PageRight"]...),
-			key.WithHelp("o", "page right"),
-		),
-		Edit: key.NewBinding(
-			key.WithKeys(hotkeys["Edit"]...),
-			key.WithHelp("e", "edit cell"),
-		),
-		Help: key.NewBinding(
-			key.WithKeys(hotkeys["Help"]...),
-			key.WithHelp("?", "toggle help"),
-		),
-		Quit: key.NewBinding(
-			key.WithKeys(hotkeys["Quit"]...),
-			key.WithHelp("q", "quit"),
-		),
-		Save: key.NewBinding(
-			key.WithKeys(hotkeys["Save"]...),
-			key.WithHelp("enter", "save edit"),
-		),
-		Cancel: key.NewBinding(
-			key.WithKeys(hotkeys["Cancel"]...),
-			key.WithHelp("esc", "cancel"),
-		),
-		GoTo: key.NewBinding(
-			key.WithKeys(hotkeys["GoTo"]...),
-			key.WithHelp("\\", "go to position"),
-		),
-		Search: key.NewBinding(
-			key.WithKeys(hotkeys["Search"]...),
-			key.WithHelp("space", "search"),
-		),
-		NextMatch: key.NewBinding(
-			key.WithKeys(hotkeys["NextMatch"]...),
-			key.WithHelp("n", "next match"),
-		),
-		PrevMatch: key.NewBinding(
-			key.WithKeys(hotkeys["PrevMatch"]...),
-			key.WithHelp("b", "prev match"),
-		),
-		Tab: key.NewBinding(
-			key.WithKeys(hotkeys["Tab"]...),
-			key.WithHelp("tab", "next field"),
-		),
-		Filter: key.NewBinding(
-			key.WithKeys(hotkeys["Filter"]...),
-			key.WithHelp("~", "filter data"),
-		),
-		ResetFilters: key.NewBinding(
-			key.WithKeys(hotkeys["ResetFilters"]...),
-			key.WithHelp("=", "reset filters"),
-		),
-	}
-}
-
-// keyMap defines keybindings for the CSV TUI
-type keyMap struct {
-	Up           key.Binding
-	Down         key.Binding
-	Left         key.Binding
-	Right        key.Binding
-	PageUp       key.Binding
-	PageDown     key.Binding
-	PageLeft     key.Binding
-	PageRight    key.Binding
-	Edit         key.Binding
-	Help         key.Binding
-	Quit         key.Binding
-	Save         key.Binding
-	Cancel       key.Binding
-	GoTo         key.Binding
-	Search       key.Binding
-	NextMatch    key.Binding
-	PrevMatch    key.Binding
-	Tab          key.Binding
-	Filter       key.Binding
-	ResetFilters key.Binding
-}
-
-// ShortHelp returns keybindings to be shown in the mini help view
-func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Help, k.Edit, k.Quit}
+// compressionMagic are the bytes compressed files start with
+var compressionMagic = map[string][]byte{
+	"gzip": {0x1f, 0x8b},
+	"zstd": {0x28, 0xb5, 0x2f, 0xfd},
 }
 
-// FullHelp returns keybindings for the expanded help view
-func (k keyMap) FullHelp() [][]key.Binding {
-	return [][]key.Binding{
-		{k.Up, k.Down, k.Left, k.Right},                 // Navigation
-		{k.PageUp, k.PageDown, k.PageLeft, k.PageRight}, // Page navigation
-		{k.Edit, k.GoTo, k.Search, k.Save, k.Cancel},    // Edit actions
-		{k.NextMatch, k.PrevMatch},                      // Search navigation
-		{k.Filter, k.ResetFilters},                      // Filter actions
-		{k.Help, k.Quit},                                // General
+// detectCompression returns "gzip" or "zstd" for a compressed file, going by
+// its first bytes, or "" for a plain one
+func detectCompression(filename string) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(file, header)
+	for compression, magic := range compressionMagic {
+		if bytes.HasPrefix(header[:n], magic) {
+			return compression
+		}
 	}
+	return ""
 }
 
-func detectDataType(value string) DataType {
-	value = strings.TrimSpace(value)
-
-	if value == "" {
-		return DataTypeEmpty
+// decompressInput returns a plain temp copy of a gzip or zstd file to read
+// in its place, along with its compression. A plain file is returned as is,
+// with no compression. The caller removes the copy.
+func decompressInput(filename string) (string, string, error) {
+	compression := detectCompression(filename)
+	if compression == "" {
+		return filename, "", nil
 	}
-
-	if strings.ToLower(value) == "true" || strings.ToLower(value) == "false" {
-		return DataTypeBool
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", "", err
 	}
+	defer file.Close()
 
-	if _, err := strconv.Atoi(value); err == nil {
-		return DataTypeInt
+	var r io.Reader
+	if compression == "gzip" {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+		defer decoder.Close()
+		r = decoder
 	}
 
-	if _, err := strconv.ParseFloat(value, 64); err == nil {
-		return DataTypeFloat
+	// The copy keeps the extension under the compression one, which may pick
+	// the delimiter
+	plainName := filename
+	if _, ok := compressionExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		plainName = strings.TrimSuffix(filename, filepath.Ext(filename))
 	}
-
-	return DataTypeString
+	plain, err := os.CreateTemp("", "csvtui-*"+filepath.Ext(plainName))
+	if err != nil {
+		return "", "", err
+	}
+	defer plain.Close()
+	if _, err := io.Copy(plain, r); err != nil {
+		os.Remove(plain.Name())
+		return "", "", fmt.Errorf("failed to decompress %s: %v", filename, err)
+	}
+	return plain.Name(), compression, nil
 }
 
-func analyzeColumnTypes(rows [][]string) []DataType {
-	if len(rows) == 0 {
-		return []DataType{}
+// compressWriter wraps w to compress what is written with gzip or zstd. With
+// no compression, closing it does nothing.
+func compressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
 	}
+	return nopWriteCloser{w}, nil
+}
 
-	maxCols := 0
-	for _, row := range rows {
-		if len(row) > maxCols {
-			maxCols = len(row)
-		}
-	}
+// nopWriteCloser adds a Close that does nothing to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
 
-	columnTypes := make([]DataType, maxCols)
-	typeCounts := make([]map[DataType]int, maxCols)
+func (nopWriteCloser) Close() error { return nil }
 
-	for i := range typeCounts {
-		typeCounts[i] = make(map[DataType]int)
+// compressionFor returns the compression a file should be written with: the
+// one its extension names, or that of the compressed file the data came from
+// when saving back to it
+func (m model) compressionFor(filename string) string {
+	if compression, ok := compressionExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		return compression
 	}
-
-	for _, row := range rows {
-		for i, cell := range row {
-			if i < maxCols {
-				dataType := detectDataType(cell)
-				typeCounts[i][dataType]++
-			}
-		}
+	if filename == m.compressedFile || (filename == m.objectURL && m.compressedFile != "") {
+		return m.compression
 	}
+	return ""
+}
 
-	for i := range columnTypes {
-		maxCount := 0
-		dominantType := DataTypeString
-
-		for dataType, count := range typeCounts[i] {
-			if count > maxCount && dataType != DataTypeEmpty {
-				maxCount = count
-				dominantType = dataType
-			}
-		}
-
-		columnTypes[i] = dominantType
+// formatFor returns "json" or "jsonl" for a file that should be written as
+// a JSON array or JSON Lines: one named that way, or the JSON file the data
+// came from when saving back to it. Delimited files get "".
+func (m model) formatFor(filename string) string {
+	if slices.Contains([]string{m.filename, m.jsonFile, m.compressedFile, m.objectURL}, filename) {
+		return m.jsonFormat
 	}
-
-	return columnTypes
+	name := strings.ToLower(filename)
+	if _, ok := compressionExtensions[filepath.Ext(name)]; ok {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if format := convertFormats[filepath.Ext(name)]; format == "json" || format == "jsonl" {
+		return format
+	}
+	return ""
 }
 
-func (m model) createColorLegend(styles StyleConfig) string {
-	legendItems := []string{}
-
-	typeOrder := []DataType{DataTypeString, DataTypeInt, DataTypeFloat, DataTypeBool, DataTypeEmpty}
-	typeNames := map[DataType]string{
-		DataTypeString: "str",
-		DataTypeInt:    "int",
-		DataTypeFloat:  "float",
-		DataTypeBool:   "bool",
-		DataTypeEmpty:  "empty",
+// jsonInput returns a CSV temp copy of a file holding a JSON array of objects,
+// or JSON Lines with an object on each line, to read in its place, along with
+// "json" or "jsonl". The copy has a column for every key found in any object,
+// in the order they first appear. Strings, numbers and booleans become the
+// cells' text, null an empty cell, and nested arrays and objects their JSON,
+// with the kinds of the values to write them back as. Anything else is
+// returned as is, with no format, unless its name says it must be JSON. The
+// caller removes the copy.
+func jsonInput(filename string) (string, string, jsonKinds, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", "", nil, err
 	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	skipBOM(reader)
 
-	for _, dataType := range typeOrder {
-		typeName := typeNames[dataType]
-		color := styles.typeColors[dataType]
-		if color != "" {
-			coloredText := styles.baseStyle.Foreground(color).Bold(true).Render("■") +
-				styles.baseStyle.Foreground(lipgloss.Color("252")).Render(typeName)
-			legendItems = append(legendItems, coloredText)
+	named := convertFormats[strings.ToLower(filepath.Ext(filename))]
+	if named != "json" && named != "jsonl" {
+		named = ""
+	}
+	start, _ := reader.Peek(512)
+	var format string
+	var records [][]string
+	var kinds jsonKinds
+	switch trimmed := bytes.TrimLeft(start, " \t\r\n"); {
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		format = "json"
+		records, kinds, err = readJSONArray(reader)
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		format = "jsonl"
+		records, kinds, err = readJSONLines(reader)
+	default:
+		err = fmt.Errorf("expected a JSON array or an object on each line")
+	}
+	if err != nil {
+		if named != "" {
+			return "", "", nil, fmt.Errorf("failed to read %s: %v", filename, err)
 		}
+		return filename, "", nil, nil
 	}
 
-	if len(legendItems) > 0 {
-		return "Legend: " + strings.Join(legendItems, " ")
+	plain, err := os.CreateTemp("", "csvtui-*.csv")
+	if err != nil {
+		return "", "", nil, err
 	}
-	return ""
+	plain.Close()
+	if err := writeCSV(plain.Name(), records, ',', defaultQuoting); err != nil {
+		os.Remove(plain.Name())
+		return "", "", nil, err
+	}
+	return plain.Name(), format, kinds, nil
 }
 
-type StyleConfig struct {
-	baseStyle     lipgloss.Style
-	headerStyle   lipgloss.Style
-	selectedStyle lipgloss.Style
-	typeColors    map[DataType]lipgloss.Color
-	dimTypeColors map[DataType]lipgloss.Color
-	evenRowColor  lipgloss.Color
-	oddRowColor   lipgloss.Color
+// jsonTable collects flat JSON objects into records
+type jsonTable struct {
+	headers []string
+	columns map[string]int // Column of each key
+	rows    [][]string
+	kinds   jsonKinds
 }
 
-func createTableStyles(renderer *lipgloss.Renderer, typeColors, dimTypeColors map[DataType]lipgloss.Color) StyleConfig {
-	baseStyle := renderer.NewStyle().Padding(0, 1)
-	headerStyle := baseStyle.Foreground(lipgloss.Color("252")).Bold(true)
-	selectedStyle := baseStyle.Foreground(lipgloss.Color("#01BE85")).Background(lipgloss.Color("#00432F"))
+// jsonKinds records the kind of JSON value each key of a JSON file held, by
+// the text it was read as, so saves write values back the way they were
+// rather than typing them by column. The kind is "string", "number", "bool",
+// "null" or "raw" for arrays and objects, and "" when values read as the
+// same text were of different kinds.
+type jsonKinds map[string]map[string]string
+
+// add records the kind of a value of key, read as text
+func (k jsonKinds) add(key, text string, value json.RawMessage) {
+	kind := "number"
+	switch trimmed := bytes.TrimSpace(value); {
+	case len(trimmed) == 0:
+		kind = ""
+	case trimmed[0] == '"':
+		kind = "string"
+	case trimmed[0] == 't', trimmed[0] == 'f':
+		kind = "bool"
+	case trimmed[0] == 'n':
+		kind = "null"
+	case trimmed[0] == '[', trimmed[0] == '{':
+		kind = "raw"
+	}
+	if k[key] == nil {
+		k[key] = make(map[string]string)
+	}
+	if previous, ok := k[key][text]; ok && previous != kind {
+		kind = ""
+	}
+	k[key][text] = kind
+}
 
-	return StyleConfig{
-		baseStyle:     baseStyle,
-		headerStyle:   headerStyle,
-		selectedStyle: selectedStyle,
-		typeColors:    typeColors,
-		dimTypeColors: dimTypeColors,
-		evenRowColor:  lipgloss.Color("245"),
-		oddRowColor:   lipgloss.Color("252"),
+// value encodes text under key as the kind of JSON value it was read from,
+// reporting false for text that wasn't read, or not as one kind
+func (k jsonKinds) value(key, text string) (json.RawMessage, bool) {
+	switch k[key][text] {
+	case "string":
+		encoded, _ := json.Marshal(text)
+		return encoded, true
+	case "number", "bool", "raw":
+		return json.RawMessage(text), true
+	case "null":
+		return json.RawMessage("null"), true
 	}
+	return nil, false
 }
-func (m *model) adjustViewportAfterResize() {
-	// Adjust horizontal viewport if cursor is out of visible area
-	startCol, endCol := m.calculateVisibleColumns()
-	if m.cursorCol < startCol {
-		m.viewportX = m.cursorCol
-	} else if m.cursorCol >= endCol {
-		// Move viewport right to show cursor
-		m.viewportX = m.cursorCol - (endCol - startCol - 1)
-		if m.viewportX < 0 {
-			m.viewportX = 0
+
+// readObject reads the next value from decoder as a row, adding a column for
+// any key not seen before
+func (t *jsonTable) readObject(decoder *json.Decoder) error {
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fmt.Errorf("not an object")
+	}
+	if t.columns == nil {
+		t.columns = make(map[string]int)
+		t.kinds = make(jsonKinds)
+	}
+	row := make([]string, len(t.headers))
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
 		}
-		if m.viewportX >= len(m.activeHeaders) {
-			m.viewportX = len(m.activeHeaders) - 1
+		key := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		col, ok := t.columns[key]
+		if !ok {
+			col = len(t.headers)
+			t.columns[key] = col
+			t.headers = append(t.headers, key)
+		}
+		for len(row) <= col {
+			row = append(row, "")
 		}
+		row[col] = jsonCell(value)
+		t.kinds.add(key, row[col], value)
 	}
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	t.rows = append(t.rows, row)
+	return nil
+}
 
-	// Adjust vertical viewport if cursor is out of visible area
-	maxRows := m.height - 7 // Account for table, legend, and status lines
-	if maxRows < 1 {
-		maxRows = 1
+// records returns the headers followed by the rows, each padded to every
+// column, since objects read before a key was first seen don't have it
+func (t *jsonTable) records() ([][]string, error) {
+	if len(t.headers) == 0 {
+		return nil, fmt.Errorf("no objects with keys")
+	}
+	for i := range t.rows {
+		for len(t.rows[i]) < len(t.headers) {
+			t.rows[i] = append(t.rows[i], "")
+		}
 	}
+	return append([][]string{t.headers}, t.rows...), nil
+}
 
-	if m.cursorRow < m.viewportY {
-		m.viewportY = m.cursorRow
-	} else if m.cursorRow >= m.viewportY+maxRows {
-		m.viewportY = m.cursorRow - maxRows + 1
-		if m.viewportY < 0 {
-			m.viewportY = 0
+// readJSONArray reads a JSON array of flat objects as records, headers first,
+// along with the kinds of their values
+func readJSONArray(r io.Reader) ([][]string, jsonKinds, error) {
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, nil, fmt.Errorf("expected a JSON array")
+	}
+	var table jsonTable
+	for decoder.More() {
+		if err := table.readObject(decoder); err != nil {
+			return nil, nil, fmt.Errorf("element %d: %v", len(table.rows)+1, err)
 		}
 	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	records, err := table.records()
+	return records, table.kinds, err
 }
 
-func (m model) Init() tea.Cmd {
-	return nil
+// readJSONLines reads JSON Lines, a flat object on each line, as records,
+// headers first, along with the kinds of their values. Blank lines are
+// skipped.
+func readJSONLines(r *bufio.Reader) ([][]string, jsonKinds, error) {
+	var table jsonTable
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			if err := table.readObject(decoder); err != nil {
+				return nil, nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			if decoder.More() {
+				return nil, nil, fmt.Errorf("line %d: more than one value", lineNumber)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	records, err := table.records()
+	return records, table.kinds, err
 }
 
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.WindowSizeMsg:
-		m.width = msg.Width
-		m.height = msg.Height
-		m.help.Width = msg.Width
+// jsonCell returns the text of a JSON value for a cell
+func jsonCell(value json.RawMessage) string {
+	var text string
+	switch {
+	case json.Unmarshal(value, &text) == nil:
+		return text
+	case string(value) == "null":
+		return ""
+	}
+	var compact bytes.Buffer
+	if json.Compact(&compact, value) != nil {
+		return string(value)
+	}
+	return compact.String()
+}
 
-		// Adjust viewport if necessary after resize
-		(&m).adjustViewportAfterResize()
-	case tea.KeyMsg:
-		// Handle save prompt mode first
-		if m.savePrompt {
-			switch msg.String() {
-			case "y", "Y":
-				// Save changes to original file
-				if err := m.saveToOriginal(); err != nil {
-					// Could show error, but for now just quit anyway
-				}
-				return m, tea.Quit
-			case "n", "N":
-				// Don't save, just quit
-				return m, tea.Quit
+// readableInput returns a delimited copy of a SQLite table or a compressed,
+// JSON or Parquet file to read in its place, for the subcommands, along with
+// a function that removes the copies. Other files are returned as is.
+func readableInput(filename string) (string, func(), error) {
+	var copies []string
+	cleanup := func() {
+		for _, path := range copies {
+			os.Remove(path)
+		}
+	}
+	if database, table := splitSQLiteName(filename); isSQLiteFile(database) {
+		if table == "" {
+			tables, err := sqliteTables(database)
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to read %s: %v", database, err)
 			}
-			if key.Matches(msg, m.keys.Cancel) {
-				// Cancel save prompt
-				m.savePrompt = false
-				return m, nil
+			if len(tables) != 1 {
+				return "", nil, fmt.Errorf("%s has %d tables, name one as %s:TABLE (%s)",
+					database, len(tables), database, strings.Join(tables, ", "))
 			}
+			table = tables[0]
 		}
+		path, _, err := sqliteInput(database, table)
+		if err != nil {
+			return "", nil, err
+		}
+		copies = append(copies, path)
+		filename = path
+	}
+	path, compression, err := decompressInput(filename)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if compression != "" {
+		copies = append(copies, path)
+	}
+	path, jsonFormat, _, err := jsonInput(path)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if jsonFormat != "" {
+		copies = append(copies, path)
+	}
+	path, parquetTypes, err := parquetInput(path)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if parquetTypes != nil {
+		copies = append(copies, path)
+	}
+	return path, cleanup, nil
+}
 
-		// Handle save filtered CSV prompt
-		if m.saveFilteredPrompt {
-			if key.Matches(msg, m.keys.Save) {
-				filename := m.saveFilteredInput.Value()
-				if filename != "" {
-					// Create filtered CSV data
-					filteredData := make([][]string, 0, len(m.activeRows)+1)
-					filteredData = append(filteredData, m.activeHeaders)
-					filteredData = append(filteredData, m.activeRows...)
-
-					if err := writeCSV(filename, filteredData, m.delimiter); err != nil {
-						// Could show error, but for now just quit anyway
-					}
-				}
-				return m, tea.Quit
-			}
-			if key.Matches(msg, m.keys.Cancel) {
-				// Cancel save filtered prompt
-				m.saveFilteredPrompt = false
-				return m, nil
-			}
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
 
-			// Update save filtered input
-			var cmd tea.Cmd
-			m.saveFilteredInput, cmd = m.saveFilteredInput.Update(msg)
-			return m, cmd
+// parquetInput returns a CSV temp copy of a Parquet file to read in its
+// place, along with the type of each column going by the file's schema. Any
+// other file is returned as is, with no types. The caller removes the copy.
+func parquetInput(filename string) (string, []DataType, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	magic := make([]byte, len(parquetMagic))
+	_, err = io.ReadFull(file, magic)
+	file.Close()
+	if err != nil || string(magic) != parquetMagic {
+		return filename, nil, nil
+	}
+
+	records, columnTypes, err := readParquet(filename)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+	plain, err := os.CreateTemp("", "csvtui-*.csv")
+	if err != nil {
+		return "", nil, err
+	}
+	plain.Close()
+	if err := writeCSV(plain.Name(), records, ',', defaultQuoting); err != nil {
+		os.Remove(plain.Name())
+		return "", nil, err
+	}
+	return plain.Name(), columnTypes, nil
+}
+
+// readParquet reads every column of a Parquet file as text, headers first,
+// along with each column's type. Nested columns are named by their path, as
+// in "address.city", and repeated values are listed as a JSON array.
+func readParquet(filename string) ([][]string, []DataType, error) {
+	file, err := local.NewLocalFileReader(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	pr, err := parquetreader.NewParquetColumnReader(file, 4)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pr.ReadStop()
+
+	numRows := pr.GetNumRows()
+	records := make([][]string, numRows+1)
+	for i := range records {
+		records[i] = make([]string, len(pr.SchemaHandler.ValueColumns))
+	}
+	columnTypes := make([]DataType, len(pr.SchemaHandler.ValueColumns))
+	for col, path := range pr.SchemaHandler.ValueColumns {
+		element := pr.SchemaHandler.SchemaElements[pr.SchemaHandler.MapIndex[path]]
+		exPath := strings.Split(pr.SchemaHandler.InPathToExPath[path], "\x01")
+		records[0][col] = strings.Join(exPath[1:], ".")
+		columnTypes[col] = parquetType(element)
+		if numRows == 0 {
+			continue
 		}
 
-		// Handle filter input mode
-		if m.filterMode {
-			if key.Matches(msg, m.keys.Save) {
-				// Apply the filter
-				query := m.filterInput.Value()
-				if query != "" {
-					if err := m.applyFilter(query); err != nil {
-						// Could show error in status, but for now just ignore
-					}
-				}
-				m.filterMode = false
-				return m, nil
+		values, repetitions, _, err := pr.ReadColumnByPath(path, numRows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %s: %v", records[0][col], err)
+		}
+		// A repetition level of 0 starts the next row; anything else adds
+		// to the current row's list
+		var lists [][]string
+		for i, value := range values {
+			if i == 0 || repetitions[i] == 0 {
+				lists = append(lists, nil)
 			}
-			if key.Matches(msg, m.keys.Cancel) {
-				// Cancel filter mode
-				m.filterMode = false
-				return m, nil
+			if value != nil {
+				lists[len(lists)-1] = append(lists[len(lists)-1], parquetText(value, element))
 			}
-
-			// Update filter input
-			var cmd tea.Cmd
-			m.filterInput, cmd = m.filterInput.Update(msg)
-			return m, cmd
 		}
-
-		// Handle edit mode
-		if m.editMode {
-			if key.Matches(msg, m.keys.Save) {
-				// Save the edit
-				if m.cursorRow < len(m.activeRows) && m.cursorCol < len(m.activeRows[m.cursorRow]) {
-					newValue := m.textInput.Value()
-					oldValue := m.activeRows[m.cursorRow][m.cursorCol]
-					if newValue != oldValue {
-						m.activeRows[m.cursorRow][m.cursorCol] = newValue
-
-						// Only mark as changed and update csvData if not filtered
-						// When filtered, changes are only to the filtered view
-						if !m.isFiltered {
-							m.hasChanges = true
-							m.csvData[m.cursorRow+1][m.cursorCol] = newValue
-						}
-					}
-				}
-				m.editMode = false
-				return m, nil
+		maxRepetition, _ := pr.SchemaHandler.MaxRepetitionLevel(strings.Split(path, "\x01"))
+		repeated := maxRepetition > 0
+		for row, list := range lists {
+			if row >= int(numRows) {
+				break
 			}
-			if key.Matches(msg, m.keys.Cancel) {
-				// Cancel edit
-				m.editMode = false
-				return m, nil
+			switch {
+			case len(list) == 1 && !repeated:
+				records[row+1][col] = list[0]
+			case len(list) > 0:
+				encoded, _ := json.Marshal(list)
+				records[row+1][col] = string(encoded)
 			}
+		}
+	}
+	return records, columnTypes, nil
+}
 
-			// Update text input
-			var cmd tea.Cmd
-			m.textInput, cmd = m.textInput.Update(msg)
-			return m, cmd
+// parquetType returns the type of a Parquet column's values once they're
+// text: dates and times are strings, and decimals floats unless they have no
+// scale
+func parquetType(element *parquet.SchemaElement) DataType {
+	logical := element.GetLogicalType()
+	switch {
+	case logical != nil && (logical.IsSetDATE() || logical.IsSetTIME() || logical.IsSetTIMESTAMP()):
+		return DataTypeString
+	case logical != nil && logical.IsSetDECIMAL(), element.IsSetConvertedType() && element.GetConvertedType() == parquet.ConvertedType_DECIMAL:
+		if element.GetScale() == 0 {
+			return DataTypeInt
 		}
-		// Handle goto mode keys
-		if m.gotoMode {
+		return DataTypeFloat
+	case element.IsSetConvertedType() && slices.Contains([]parquet.ConvertedType{parquet.ConvertedType_DATE,
+		parquet.ConvertedType_TIME_MILLIS, parquet.ConvertedType_TIME_MICROS,
+		parquet.ConvertedType_TIMESTAMP_MILLIS, parquet.ConvertedType_TIMESTAMP_MICROS}, element.GetConvertedType()):
+		return DataTypeString
+	}
+	switch element.GetType() {
+	case parquet.Type_BOOLEAN:
+		return DataTypeBool
+	case parquet.Type_INT32, parquet.Type_INT64:
+		return DataTypeInt
+	case parquet.Type_FLOAT, parquet.Type_DOUBLE:
+		return DataTypeFloat
+	}
+	return DataTypeString
+}
 
-			// Clear error when user starts typing
-			if m.gotoError != "" {
-				m.gotoError = ""
+// parquetText returns a Parquet value as cell text, following the column's
+// logical type: dates as YYYY-MM-DD, timestamps as RFC 3339, decimals with
+// their scale, and binary that isn't text as hex
+func parquetText(value any, element *parquet.SchemaElement) string {
+	logical := element.GetLogicalType()
+	converted := parquet.ConvertedType(-1)
+	if element.IsSetConvertedType() {
+		converted = element.GetConvertedType()
+	}
+	isDecimal := converted == parquet.ConvertedType_DECIMAL || logical != nil && logical.IsSetDECIMAL()
+
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int32:
+		switch {
+		case isDecimal:
+			return decimalText(big.NewInt(int64(v)), int(element.GetScale()))
+		case converted == parquet.ConvertedType_DATE || logical != nil && logical.IsSetDATE():
+			return time.Unix(int64(v)*24*60*60, 0).UTC().Format("2006-01-02")
+		case converted == parquet.ConvertedType_TIME_MILLIS || logical != nil && logical.IsSetTIME():
+			return time.UnixMilli(int64(v)).UTC().Format("15:04:05.000")
+		case converted == parquet.ConvertedType_UINT_32:
+			return strconv.FormatUint(uint64(uint32(v)), 10)
+		}
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		var unit time.Duration
+		switch {
+		case logical != nil && logical.IsSetTIMESTAMP():
+			unit = time.Millisecond
+			if timeUnit := logical.GetTIMESTAMP().GetUnit(); timeUnit.IsSetMICROS() {
+				unit = time.Microsecond
+			} else if timeUnit.IsSetNANOS() {
+				unit = time.Nanosecond
 			}
-
-			// Update the appropriate text input
-			var cmd tea.Cmd
-			if m.gotoStep == 0 {
-				m.rowInput, cmd = m.rowInput.Update(msg)
-			} else {
-				m.colInput, cmd = m.colInput.Update(msg)
+		case converted == parquet.ConvertedType_TIMESTAMP_MILLIS:
+			unit = time.Millisecond
+		case converted == parquet.ConvertedType_TIMESTAMP_MICROS:
+			unit = time.Microsecond
+		}
+		switch {
+		case isDecimal:
+			return decimalText(big.NewInt(v), int(element.GetScale()))
+		case unit != 0:
+			return time.Unix(0, 0).Add(time.Duration(v) * unit).UTC().Format(time.RFC3339Nano)
+		case converted == parquet.ConvertedType_TIME_MICROS || logical != nil && logical.IsSetTIME():
+			return time.UnixMicro(v).UTC().Format("15:04:05.000000")
+		case converted == parquet.ConvertedType_UINT_64:
+			return strconv.FormatUint(uint64(v), 10)
+		}
+		return strconv.FormatInt(v, 10)
+	case string:
+		switch {
+		case element.GetType() == parquet.Type_INT96 && len(v) == 12:
+			return parquettypes.INT96ToTime(v).UTC().Format(time.RFC3339Nano)
+		case isDecimal:
+			// Big-endian two's complement
+			unscaled := new(big.Int).SetBytes([]byte(v))
+			if len(v) > 0 && v[0]&0x80 != 0 {
+				unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(v))))
 			}
-			return m, cmd
+			return decimalText(unscaled, int(element.GetScale()))
+		case logical != nil && logical.IsSetUUID() && len(v) == 16:
+			h := hex.EncodeToString([]byte(v))
+			return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+		case !utf8.ValidString(v):
+			return hex.EncodeToString([]byte(v))
 		}
+		return v
+	}
+	return fmt.Sprint(value)
+}
 
-		// Handle goto mode keys
-		if m.gotoMode {
-			if key.Matches(msg, m.keys.Save) {
-				// Process the current input
-				if m.gotoStep == 0 {
-					// Validate row input
-					rowStr := m.rowInput.Value()
-					if rowNum, err := strconv.Atoi(rowStr); err != nil || rowNum < 1 || rowNum > len(m.activeRows) {
-						// Invalid row input - show error
-						m.gotoError = fmt.Sprintf("Invalid row: valid range 1-%d", len(m.activeRows))
-						return m, nil
-					}
+// decimalText formats an unscaled decimal with scale digits after the point
+func decimalText(unscaled *big.Int, scale int) string {
+	digits := new(big.Int).Abs(unscaled).String()
+	sign := ""
+	if unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if scale <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
 
-					// Row input valid, move to column input
-					m.gotoError = "" // Clear any previous error
-					m.gotoStep = 1
-					m.colInput = textinput.New()
-					m.colInput.Focus()
-					m.colInput.Placeholder = "Enter column number (1-" + strconv.Itoa(len(m.activeHeaders)) + ")"
-					return m, textinput.Blink
-				} else {
-					// Validate column input
-					colStr := m.colInput.Value()
-					if colNum, err := strconv.Atoi(colStr); err != nil || colNum < 1 || colNum > len(m.activeHeaders) {
-						// Invalid column input - show error
-						m.gotoError = fmt.Sprintf("Invalid column: valid range 1-%d", len(m.activeHeaders))
-						return m, nil
-					}
+// sqliteMagic starts every SQLite database file
+const sqliteMagic = "SQLite format 3\x00"
 
-					// Both inputs valid - jump to position
-					rowNum, _ := strconv.Atoi(m.rowInput.Value())
-					colNum, _ := strconv.Atoi(colStr)
+// sqliteExtensions are the extensions that make a file written as a SQLite
+// database even before it exists
+var sqliteExtensions = map[string]bool{
+	".db":      true,
+	".sqlite":  true,
+	".sqlite3": true,
+}
 
-					// Jump to the specified position (convert from 1-based to 0-based)
-					m.cursorRow = rowNum - 1
-					m.cursorCol = colNum - 1
+// isSQLiteFile reports whether a file is a SQLite database, going by its first
+// bytes
+func isSQLiteFile(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	header := make([]byte, len(sqliteMagic))
+	_, err = io.ReadFull(file, header)
+	return err == nil && string(header) == sqliteMagic
+}
 
-					// Adjust viewport to show the new cursor position
-					m.adjustViewportAfterResize()
-					// Exit goto mode
-					m.gotoMode = false
-					m.gotoStep = 0
-					m.gotoError = ""
-					return m, nil
-				}
-			}
-			if key.Matches(msg, m.keys.Cancel) {
-				// Cancel goto mode
-				m.gotoMode = false
-				m.gotoStep = 0
-				return m, nil
-			}
+// splitSQLiteName splits the table off a database name like data.db:people.
+// Other names are returned as is, with no table.
+func splitSQLiteName(name string) (string, string) {
+	i := strings.LastIndex(name, ":")
+	if i <= 0 || isObjectURL(name) {
+		return name, ""
+	}
+	if _, err := os.Stat(name); err == nil {
+		return name, ""
+	}
+	database := name[:i]
+	if isSQLiteFile(database) || sqliteExtensions[strings.ToLower(filepath.Ext(database))] {
+		return database, name[i+1:]
+	}
+	return name, ""
+}
 
-			// Update the appropriate text input
-			var cmd tea.Cmd
-			if m.gotoStep == 0 {
-				m.rowInput, cmd = m.rowInput.Update(msg)
-			} else {
-				m.colInput, cmd = m.colInput.Update(msg)
-			}
-			return m, cmd
-		}
+// sqliteTarget returns the database and table a save to target writes: a
+// table named like data.db:people, the table the data came from when saving
+// back to its database, or else one named after the file. ok is false for
+// targets that aren't SQLite databases.
+func (m model) sqliteTarget(target string) (database, table string, ok bool) {
+	database, table = splitSQLiteName(target)
+	local := database
+	if isObjectURL(database) {
+		local = m.sqliteFile
+	}
+	if !isSQLiteFile(local) && !sqliteExtensions[strings.ToLower(filepath.Ext(database))] {
+		return "", "", false
+	}
+	if table == "" {
+		table = m.sqliteTable
+	}
+	if table == "" {
+		table = strings.TrimSuffix(filepath.Base(database), filepath.Ext(database))
+	}
+	return database, table, true
+}
 
-		// Handle search mode keys
-		if m.searchMode {
-			if key.Matches(msg, m.keys.Save) {
-				// Perform search with filters
-				query := m.searchInput.Value()
-				rowFilter := m.searchRowInput.Value()
-				colFilter := m.searchColInput.Value()
-				m.performSearchWithFilters(query, rowFilter, colFilter)
-				m.searchMode = false
-				m.searchStep = 0
-				return m, nil
-			}
-			if key.Matches(msg, m.keys.Cancel) {
-				// Cancel search mode
-				m.searchMode = false
-				m.searchStep = 0
-				return m, nil
-			}
-			if key.Matches(msg, m.keys.Tab) {
-				// Navigate between search inputs
-				m.searchStep = (m.searchStep + 1) % 3
-				switch m.searchStep {
-				case 0:
-					m.searchInput.Focus()
-					m.searchRowInput.Blur()
-					m.searchColInput.Blur()
-				case 1:
-					m.searchInput.Blur()
-					m.searchRowInput.Focus()
-					m.searchColInput.Blur()
-				case 2:
-					m.searchInput.Blur()
-					m.searchRowInput.Blur()
-					m.searchColInput.Focus()
-				}
-				return m, textinput.Blink
-			}
+// quoteIdentifier quotes a table or column name for SQL
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
 
-			// Update the appropriate search input
-			var cmd tea.Cmd
-			switch m.searchStep {
-			case 0:
-				m.searchInput, cmd = m.searchInput.Update(msg)
-			case 1:
-				m.searchRowInput, cmd = m.searchRowInput.Update(msg)
-			case 2:
-				m.searchColInput, cmd = m.searchColInput.Update(msg)
-			}
-			return m, cmd
+// sqliteTables lists the tables of a SQLite database, leaving out SQLite's own
+func sqliteTables(database string) ([]string, error) {
+	db, err := sql.Open("sqlite", database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
 		}
-		// Normal navigation mode
-		switch {
-		case key.Matches(msg, m.keys.Quit):
-			// Check if we're viewing filtered data and offer to save
-			if m.isFiltered {
-				m.saveFilteredPrompt = true
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// sqliteColumn is a column of a SQLite table with the type it was declared
+// with, which may be blank
+type sqliteColumn struct {
+	name     string
+	declared string
+}
+
+// sqliteColumns returns the columns of a table, or none when there's no such
+// table
+func sqliteColumns(q interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, table string) ([]sqliteColumn, error) {
+	rows, err := q.Query("SELECT name, type FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []sqliteColumn
+	for rows.Next() {
+		var column sqliteColumn
+		if err := rows.Scan(&column.name, &column.declared); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// sqliteBlobColumns reports which columns of a table hold binary data: those
+// declared as BLOB, and those declared without a type holding only blobs.
+// Their cells read as hex, which writeSQLite writes back as the bytes.
+func sqliteBlobColumns(q interface {
+	QueryRow(string, ...any) *sql.Row
+}, table string, columns []sqliteColumn) ([]bool, error) {
+	blobs := make([]bool, len(columns))
+	for col, column := range columns {
+		declared := strings.ToUpper(column.declared)
+		if declared != "" {
+			blobs[col] = strings.Contains(declared, "BLOB")
+			continue
+		}
+		var blobValues, otherValues int
+		name := quoteIdentifier(column.name)
+		err := q.QueryRow("SELECT count(*) FILTER (WHERE typeof("+name+") = 'blob'), "+
+			"count(*) FILTER (WHERE typeof("+name+") NOT IN ('blob', 'null')) FROM "+quoteIdentifier(table)).Scan(&blobValues, &otherValues)
+		if err != nil {
+			return nil, err
+		}
+		blobs[col] = blobValues > 0 && otherValues == 0
+	}
+	return blobs, nil
+}
+
+// sqliteHasTable reports whether a SQLite database exists and has a table.
+// Databases that don't exist yet aren't opened, as that would create them.
+func sqliteHasTable(database, table string) bool {
+	if !isSQLiteFile(database) {
+		return false
+	}
+	db, err := sql.Open("sqlite", database)
+	if err != nil {
+		return false
+	}
+	defer db.Close()
+	columns, err := sqliteColumns(db, table)
+	return err == nil && len(columns) > 0
+}
+
+// sqliteType returns the column type of a declared SQLite column type,
+// following SQLite's type affinity rules, or false when none was declared
+func sqliteType(declared string) (DataType, bool) {
+	declared = strings.ToUpper(declared)
+	switch {
+	case declared == "":
+		return DataTypeString, false
+	case strings.Contains(declared, "INT"):
+		return DataTypeInt, true
+	case strings.Contains(declared, "BOOL"):
+		return DataTypeBool, true
+	case strings.Contains(declared, "CHAR"), strings.Contains(declared, "CLOB"),
+		strings.Contains(declared, "TEXT"), strings.Contains(declared, "BLOB"),
+		strings.Contains(declared, "DATE"), strings.Contains(declared, "TIME"):
+		return DataTypeString, true
+	case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"),
+		strings.Contains(declared, "DOUB"), strings.Contains(declared, "NUM"),
+		strings.Contains(declared, "DEC"):
+		return DataTypeFloat, true
+	}
+	return DataTypeString, true
+}
+
+// sqliteDeclaration returns the type a new SQLite column is declared with
+func sqliteDeclaration(columnType DataType) string {
+	switch columnType {
+	case DataTypeInt:
+		return "INTEGER"
+	case DataTypeFloat:
+		return "REAL"
+	case DataTypeBool:
+		return "BOOLEAN"
+	}
+	return "TEXT"
+}
+
+// sqliteText returns a SQLite value as cell text, with NULL left blank and
+// blobs as hex when they're binary data or aren't text
+func sqliteText(value any, binary bool) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		if utf8.Valid(v) && !binary {
+			return string(v)
+		}
+		return hex.EncodeToString(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// readSQLite reads a table of a SQLite database as text, headers first, along
+// with each column's type. Declared types decide the column types, and
+// columns declared without one get them from their values.
+func readSQLite(database, table string) ([][]string, []DataType, error) {
+	db, err := sql.Open("sqlite", database)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+	columns, err := sqliteColumns(db, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(columns) == 0 {
+		return nil, nil, fmt.Errorf("no table %q in %s", table, database)
+	}
+	blobs, err := sqliteBlobColumns(db, table, columns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unary + leaves values as stored, where selecting a DATE or TIMESTAMP
+	// column as is would have the driver parse it as a time
+	headers := make([]string, len(columns))
+	selected := make([]string, len(columns))
+	for col, column := range columns {
+		headers[col] = column.name
+		selected[col] = "+" + quoteIdentifier(column.name)
+	}
+	rows, err := db.Query("SELECT " + strings.Join(selected, ", ") + " FROM " + quoteIdentifier(table))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	records := [][]string{headers}
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		record := make([]string, len(values))
+		for col, value := range values {
+			record[col] = sqliteText(value, blobs[col])
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	columnTypes := make([]DataType, len(columns))
+	copy(columnTypes, analyzeColumnTypes(records[1:]))
+	for col, column := range columns {
+		if columnType, ok := sqliteType(column.declared); ok {
+			columnTypes[col] = columnType
+		}
+		if blobs[col] {
+			columnTypes[col] = DataTypeString
+		}
+	}
+	return records, columnTypes, nil
+}
+
+// sqliteInput returns a CSV temp copy of a table of a SQLite database, along
+// with its column types. The caller removes the copy.
+func sqliteInput(database, table string) (string, []DataType, error) {
+	records, columnTypes, err := readSQLite(database, table)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %v", database, err)
+	}
+	plain, err := os.CreateTemp("", "csvtui-*.csv")
+	if err != nil {
+		return "", nil, err
+	}
+	plain.Close()
+	if err := writeCSV(plain.Name(), records, ',', defaultQuoting); err != nil {
+		os.Remove(plain.Name())
+		return "", nil, err
+	}
+	return plain.Name(), columnTypes, nil
+}
+
+// writeSQLite writes records to a table of a SQLite database, creating either
+// as needed. An existing table with the same columns keeps its schema and has
+// its rows replaced, or the records added to them with appendRows. One with
+// other columns is only dropped and created again with replaceTable, losing
+// its indexes, constraints and triggers, and can't be appended to. Blank cells in
+// numeric, boolean and BLOB columns are written as NULL, and hex in BLOB
+// columns as the bytes it spells.
+func writeSQLite(database, table string, records [][]string, appendRows, replaceTable bool) error {
+	headers, rows := records[0], records[1:]
+	db, err := sql.Open("sqlite", database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := sqliteColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(existing))
+	for i, column := range existing {
+		names[i] = column.name
+	}
+	sameColumns := len(existing) > 0 && len(names) == len(headers)
+	for _, header := range headers {
+		sameColumns = sameColumns && slices.Contains(names, header)
+	}
+	if len(existing) > 0 && !sameColumns && appendRows {
+		return fmt.Errorf("the columns don't match those of table %s (%s)", table, strings.Join(names, ", "))
+	}
+	if len(existing) > 0 && !sameColumns && !replaceTable {
+		return fmt.Errorf("the columns don't match those of table %s (%s), and replacing it would drop its indexes, constraints and triggers; save to a new table instead",
+			table, strings.Join(names, ", "))
+	}
+
+	columnTypes := make([]DataType, len(headers))
+	copy(columnTypes, analyzeColumnTypes(rows))
+	blobs := make([]bool, len(headers))
+	quoted := make([]string, len(headers))
+	for col, header := range headers {
+		quoted[col] = quoteIdentifier(header)
+	}
+	switch {
+	case sameColumns:
+		existingBlobs, err := sqliteBlobColumns(tx, table, existing)
+		if err != nil {
+			return err
+		}
+		for col, header := range headers {
+			i := slices.Index(names, header)
+			if columnType, ok := sqliteType(existing[i].declared); ok {
+				columnTypes[col] = columnType
+			}
+			blobs[col] = existingBlobs[i]
+		}
+		if !appendRows {
+			if _, err := tx.Exec("DELETE FROM " + quoteIdentifier(table)); err != nil {
+				return err
+			}
+		}
+	default:
+		if len(existing) > 0 {
+			if _, err := tx.Exec("DROP TABLE " + quoteIdentifier(table)); err != nil {
+				return err
+			}
+		}
+		definitions := make([]string, len(headers))
+		for col := range headers {
+			definitions[col] = quoted[col] + " " + sqliteDeclaration(columnTypes[col])
+		}
+		if _, err := tx.Exec("CREATE TABLE " + quoteIdentifier(table) + " (" + strings.Join(definitions, ", ") + ")"); err != nil {
+			return err
+		}
+	}
+
+	insert, err := tx.Prepare("INSERT INTO " + quoteIdentifier(table) + " (" + strings.Join(quoted, ", ") +
+		") VALUES (" + strings.TrimSuffix(strings.Repeat("?, ", len(headers)), ", ") + ")")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	values := make([]any, len(headers))
+	for _, row := range rows {
+		for col := range headers {
+			value := ""
+			if col < len(row) {
+				value = row[col]
+			}
+			values[col] = value
+			if (columnTypes[col] != DataTypeString || blobs[col]) && strings.TrimSpace(value) == "" {
+				values[col] = nil
+			} else if data, err := hex.DecodeString(value); err == nil && blobs[col] {
+				values[col] = data
+			}
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// copySQLite copies a database for a temp file headed for it to start from,
+// so its other tables survive the save. A database that doesn't exist yet
+// isn't copied.
+func copySQLite(database, filename string) error {
+	source, err := os.Open(database)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	copied, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(copied, source); err != nil {
+		copied.Close()
+		return err
+	}
+	return copied.Close()
+}
+
+// tablePicker lists the tables of a SQLite database to pick the one to open
+type tablePicker struct {
+	database string
+	tables   []string
+	cursor   int
+	chosen   string
+}
+
+func (p tablePicker) Init() tea.Cmd {
+	return nil
+}
+
+func (p tablePicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return p, nil
+	}
+	switch keyMsg.String() {
+	case "up", "k":
+		p.cursor = max(p.cursor-1, 0)
+	case "down", "j":
+		p.cursor = min(p.cursor+1, len(p.tables)-1)
+	case "home", "g":
+		p.cursor = 0
+	case "end", "G":
+		p.cursor = len(p.tables) - 1
+	case "enter":
+		p.chosen = p.tables[p.cursor]
+		return p, tea.Quit
+	case "esc", "q", "ctrl+c":
+		return p, tea.Quit
+	}
+	return p, nil
+}
+
+func (p tablePicker) View() string {
+	if p.chosen != "" {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tables in %s:\n", filepath.Base(p.database))
+	for i, table := range p.tables {
+		if i == p.cursor {
+			b.WriteString(lipgloss.NewStyle().Bold(true).Render("> "+table) + "\n")
+		} else {
+			b.WriteString("  " + table + "\n")
+		}
+	}
+	b.WriteString("\n↑/↓ to select, Enter to open, Esc to quit\n")
+	return b.String()
+}
+
+// pickTable asks which table of a database to open, unless it only has one.
+// An empty name means none was picked.
+func pickTable(database string, options ...tea.ProgramOption) (string, error) {
+	tables, err := sqliteTables(database)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", database, err)
+	}
+	switch len(tables) {
+	case 0:
+		return "", fmt.Errorf("%s has no tables", database)
+	case 1:
+		return tables[0], nil
+	}
+	final, err := tea.NewProgram(tablePicker{database: database, tables: tables}, options...).Run()
+	if err != nil {
+		return "", err
+	}
+	return final.(tablePicker).chosen, nil
+}
+
+// saveFilename returns the file saves are written to. With an output file the
+// input is treated as read-only.
+func (m *model) saveFilename() string {
+	if m.outputFile != "" {
+		return m.outputFile
+	}
+	if m.objectURL != "" {
+		return m.objectURL
+	}
+	if m.compressedFile != "" {
+		return m.compressedFile
+	}
+	if m.jsonFile != "" {
+		return m.jsonFile
+	}
+	if m.sqliteFile != "" {
+		return m.sqliteFile
+	}
+	return m.filename
+}
+
+// expandHome expands a leading ~ in a path to the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// pathCompletions lists the files and directories a partly typed path could
+// name, each written out the way the path was typed so far. Directories end
+// in a slash so completing can go on inside them, and hidden files are only
+// listed once a dot is typed.
+func pathCompletions(typed string) []string {
+	dir, base := "", typed
+	if i := strings.LastIndexAny(typed, "/"+string(filepath.Separator)); i >= 0 {
+		dir, base = typed[:i+1], typed[i+1:]
+	}
+	listed := expandHome(dir)
+	if listed == "" {
+		listed = "."
+	}
+	entries, err := os.ReadDir(listed)
+	if err != nil {
+		return nil
+	}
+	var completions []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(listed, name)); err == nil && info.IsDir() {
+			name += "/"
+		}
+		completions = append(completions, dir+name)
+	}
+	return completions
+}
+
+// isSourceFile reports whether a path names the file the data came from or
+// saves go to, or the table a SQLite database's data came from
+func (m model) isSourceFile(path string) bool {
+	if database, table, ok := m.sqliteTarget(path); ok && database == m.sqliteFile && table != m.sqliteTable {
+		return false
+	}
+	path, _ = splitSQLiteName(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	for _, source := range []string{m.saveFilename(), m.filename, m.compressedFile, m.jsonFile, m.parquetFile, m.sqliteFile} {
+		if source == "" || isObjectURL(source) {
+			continue
+		}
+		if sourceInfo, err := os.Stat(source); err == nil && os.SameFile(info, sourceInfo) {
+			return true
+		}
+	}
+	return false
+}
+
+// lossySaveWarning spells out what writing the filtered view over the source
+// file drops
+func (m model) lossySaveWarning(path string) string {
+	kept := fmt.Sprintf("the %d filtered row(s)", len(m.activeRows))
+	if columns := len(m.csvData[0]); len(m.activeHeaders) < columns {
+		kept += fmt.Sprintf(" and %d of %d columns", len(m.activeHeaders), columns)
+	}
+	return fmt.Sprintf("%s is the file the data came from: only %s of %d row(s) will be left in it. Press Enter again to overwrite it, or change the filename",
+		path, kept, len(m.csvData)-1)
+}
+
+// saveTo writes the data to a file, or uploads it to an S3 or Cloud Storage
+// object URL
+func (m *model) saveTo(path string) error {
+	if !isObjectURL(path) {
+		return m.writeFile(path)
+	}
+	file, err := os.CreateTemp("", "csvtui-*.csv")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	if err := m.writeAs(file.Name(), path); err != nil {
+		return err
+	}
+	return uploadObject(file.Name(), path, m.contentTypeFor(path))
+}
+
+// contentTypeFor returns the Content-Type of an object saved to target, going
+// by the format and compression it's written in
+func (m model) contentTypeFor(target string) string {
+	switch m.compressionFor(target) {
+	case "gzip":
+		return "application/gzip"
+	case "zstd":
+		return "application/zstd"
+	}
+	switch m.formatFor(target) {
+	case "json":
+		return "application/json"
+	case "jsonl":
+		return "application/x-ndjson"
+	}
+	if _, _, ok := m.sqliteTarget(target); ok {
+		return "application/vnd.sqlite3"
+	}
+	if m.delimiter == '\t' {
+		return "text/tab-separated-values"
+	}
+	return "text/csv"
+}
+
+// backupFilename returns the file the backup is written to on suspend, next
+// to the save target, or to the local copy of an object. Backups aren't
+// compressed.
+func (m *model) backupFilename() string {
+	if isObjectURL(m.saveFilename()) {
+		return m.filename + ".temp"
+	}
+	return m.saveFilename() + ".temp"
+}
+
+// spoolStdin copies data piped in on stdin to a temp file, so it can be read
+// like any other file. The caller removes the file.
+func spoolStdin() (string, error) {
+	file, err := os.CreateTemp("", "csvtui-stdin-*.csv")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, os.Stdin); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// isObjectURL reports whether a path names an object in S3 or Google Cloud
+// Storage rather than a local file
+func isObjectURL(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+// splitObjectURL splits an s3:// or gs:// URL into its scheme, bucket and key
+func splitObjectURL(rawURL string) (scheme, bucket, key string, err error) {
+	scheme, rest, _ := strings.Cut(rawURL, "://")
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", "", fmt.Errorf("%s should look like %s://bucket/path/to/file.csv", rawURL, scheme)
+	}
+	return scheme, bucket, key, nil
+}
+
+// downloadObject copies an S3 or Cloud Storage object to a temp file, so it
+// can be read like any other file. The caller removes the file.
+func downloadObject(rawURL string) (string, error) {
+	scheme, bucket, key, err := splitObjectURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp("", "csvtui-*"+filepath.Ext(key))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	ctx := context.Background()
+	if scheme == "s3" {
+		err = downloadS3(ctx, bucket, key, file)
+	} else {
+		err = downloadGCS(ctx, bucket, key, file)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to download %s: %v", rawURL, err)
+	}
+	return file.Name(), nil
+}
+
+// uploadObject copies a local file to an S3 or Cloud Storage object with the
+// given Content-Type
+func uploadObject(path, rawURL, contentType string) error {
+	scheme, bucket, key, err := splitObjectURL(rawURL)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctx := context.Background()
+	if scheme == "s3" {
+		err = uploadS3(ctx, bucket, key, file, contentType)
+	} else {
+		err = uploadGCS(ctx, bucket, key, file, contentType)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload to %s: %v", rawURL, err)
+	}
+	return nil
+}
+
+// s3Client returns an S3 client with credentials and region found the usual
+// AWS ways: environment variables, the shared config and credentials files,
+// SSO, or the instance role. AWS_ENDPOINT_URL points it at S3-compatible
+// stores such as MinIO.
+func s3Client(ctx context.Context) (*s3.Client, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if o.Region == "" {
+			o.Region = "us-east-1"
+		}
+		// Most S3-compatible stores only serve path-style URLs
+		o.UsePathStyle = o.BaseEndpoint != nil
+	}), nil
+}
+
+func downloadS3(ctx context.Context, bucket, key string, w io.Writer) error {
+	client, err := s3Client(ctx)
+	if err != nil {
+		return err
+	}
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+	_, err = io.Copy(w, object.Body)
+	return err
+}
+
+func uploadS3(ctx context.Context, bucket, key string, file *os.File, contentType string) error {
+	client, err := s3Client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// gcsEndpoint returns the Cloud Storage JSON API endpoint, or the emulator
+// named by STORAGE_EMULATOR_HOST, which needs no credentials
+func gcsEndpoint() (string, bool) {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return strings.TrimSuffix(host, "/"), true
+	}
+	return "https://storage.googleapis.com", false
+}
+
+// gcsClient returns an HTTP client for the Cloud Storage JSON API, with
+// credentials found the usual Google ways: GOOGLE_OAUTH_ACCESS_TOKEN, the
+// credentials file named by GOOGLE_APPLICATION_CREDENTIALS or written by
+// gcloud auth application-default login, or the metadata server of the
+// machine it runs on. The emulator needs none.
+func gcsClient(ctx context.Context) (*http.Client, error) {
+	if _, emulated := gcsEndpoint(); emulated {
+		return http.DefaultClient, nil
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})), nil
+	}
+	credentials, err := google.FindDefaultCredentials(ctx, gcsScope)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, credentials.TokenSource), nil
+}
+
+// gcsRequest sends a Cloud Storage JSON API request with a gcsClient
+func gcsRequest(ctx context.Context, method, url, contentType string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	client, err := gcsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		defer response.Body.Close()
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(response.Body)
+		if json.Unmarshal(data, &failure) == nil && failure.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", response.Status, failure.Error.Message)
+		}
+		return nil, errors.New(response.Status)
+	}
+	return response, nil
+}
+
+func downloadGCS(ctx context.Context, bucket, key string, w io.Writer) error {
+	endpoint, _ := gcsEndpoint()
+	response, err := gcsRequest(ctx, http.MethodGet,
+		fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", endpoint, url.PathEscape(bucket), url.PathEscape(key)), "", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+func uploadGCS(ctx context.Context, bucket, key string, file *os.File, contentType string) error {
+	endpoint, _ := gcsEndpoint()
+	response, err := gcsRequest(ctx, http.MethodPost,
+		fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", endpoint, url.PathEscape(bucket), url.QueryEscape(key)),
+		contentType, file)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// gcsScope is the OAuth scope for reading and writing Cloud Storage objects
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// unsavableSource names where the data came from when there's nothing to
+// save it back to, stdin or a Parquet file, and is "" otherwise
+func (m model) unsavableSource() string {
+	switch {
+	case m.fromStdin:
+		return "stdin"
+	case m.parquetFile != "":
+		return "a Parquet file"
+	}
+	return ""
+}
+
+// readOnlyReason explains why changes can't be saved back in place
+func (m model) readOnlyReason() string {
+	if source := m.unsavableSource(); source != "" && m.outputFile == "" {
+		return "the data came from " + source
+	}
+	return fmt.Sprintf("%s isn't writable", m.saveFilename())
+}
+
+// isWritable reports whether a file can be written, or created when it doesn't
+// exist yet, without changing it
+func isWritable(path string) bool {
+	if isObjectURL(path) {
+		// Only trying the upload tells
+		return true
+	}
+	path, _ = splitSQLiteName(path)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		file.Close()
+		return true
+	}
+	if !os.IsNotExist(err) {
+		return false
+	}
+	probe, err := os.CreateTemp(filepath.Dir(path), ".csvtui-*")
+	if err != nil {
+		return false
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return true
+}
+
+// privilegedSaveMsg is sent when the sudo or doas copy of a save exits
+type privilegedSaveMsg struct {
+	path string // Temp file holding the records
+	err  error
+}
+
+// findPrivilegeTool returns sudo, or doas when only that is installed
+func findPrivilegeTool() (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", fmt.Errorf("saving with elevated rights isn't supported on Windows")
+	}
+	for _, tool := range []string{"sudo", "doas"} {
+		if _, err := exec.LookPath(tool); err == nil {
+			return tool, nil
+		}
+	}
+	return "", fmt.Errorf("neither sudo nor doas is installed")
+}
+
+// saveWithPrivileges writes the records to a temp file and copies it over the
+// save target with sudo or doas. Copying into the existing file keeps its
+// owner and permissions.
+func (m *model) saveWithPrivileges() tea.Cmd {
+	file, err := os.CreateTemp("", "csvtui-*.csv")
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Could not create temp file: %v", err)
+		m.statusIsError = true
+		return nil
+	}
+	file.Close()
+	if err := m.writeAs(file.Name(), m.saveFilename()); err != nil {
+		os.Remove(file.Name())
+		m.statusMessage = fmt.Sprintf("Could not write temp file: %v", err)
+		m.statusIsError = true
+		return nil
+	}
+
+	path := file.Name()
+	target, _ := splitSQLiteName(m.saveFilename())
+	cmd := exec.Command(m.privilegedTool, "cp", "--", path, target)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return privilegedSaveMsg{path: path, err: err}
+	})
+}
+
+// finishPrivilegedSave quits once the privileged copy succeeded, and reports
+// the failure otherwise
+func (m *model) finishPrivilegedSave(msg privilegedSaveMsg) tea.Cmd {
+	os.Remove(msg.path)
+	if msg.err != nil {
+		m.statusMessage = fmt.Sprintf("%s failed: %v", m.privilegedTool, msg.err)
+		m.statusIsError = true
+		return nil
+	}
+	m.noteWritten(m.saveFilename())
+	m.removeBackup()
+	m.hasChanges = false
+	m.statusMessage, m.statusIsError = "", false
+	m.saveSidecars()
+	if m.statusIsError {
+		// Stay to show what else failed to save
+		return nil
+	}
+	m.quitting = true
+	return tea.Quit
+}
+
+func (m *model) writeBackup() error {
+	return m.writeFile(m.backupFilename())
+}
+
+func (m *model) saveToOriginal() error {
+	if err := m.saveTo(m.saveFilename()); err != nil {
+		return err
+	}
+	m.noteWritten(m.saveFilename())
+
+	// Remove backup file after successful save
+	m.removeBackup()
+
+	m.saveSidecars()
+	m.hasChanges = false
+	return nil
+}
+
+// saveSidecars writes the notes, marks and column widths kept beside the
+// file once it's saved, as inserted rows move the notes and marks below them.
+// The data is saved either way, so failing to save them is shown rather than
+// returned.
+func (m *model) saveSidecars() {
+	if len(m.notes) > 0 {
+		if err := m.saveNotes(); err != nil {
+			m.statusMessage = fmt.Sprintf("Saved, but failed to save notes: %v", err)
+			m.statusIsError = true
+		}
+	}
+	if len(m.marks) > 0 || m.marksChanged {
+		if err := m.saveMarks(); err != nil {
+			m.statusMessage = fmt.Sprintf("Saved, but failed to save marks: %v", err)
+			m.statusIsError = true
+		} else {
+			m.marksChanged = false
+		}
+	}
+	m.saveColumnWidths()
+}
+
+// expandTemplateValue fills in the placeholders supported in row templates
+func expandTemplateValue(value string, now time.Time) string {
+	return strings.NewReplacer(
+		"{today}", now.Format("2006-01-02"),
+		"{now}", now.Format(time.RFC3339),
+		"{time}", now.Format("15:04:05"),
+	).Replace(value)
+}
+
+// insertRow inserts a new row below the cursor, pre-filled from the row
+// template. In a filtered view the row goes into the data too, below the
+// cursor's source row, so it's still there once the filters are reset.
+func (m *model) insertRow() {
+	now := time.Now()
+	csvRow := make([]string, len(m.csvData[0]))
+	for i, header := range m.csvData[0] {
+		csvRow[i] = expandTemplateValue(m.rowTemplate[header], now)
+	}
+	newRow := make([]string, len(m.activeHeaders))
+	for i := range newRow {
+		if col := m.sourceColumn(i); col >= 0 && col < len(csvRow) {
+			newRow[i] = csvRow[col]
+		}
+	}
+
+	position := m.cursorRow + 1
+	if len(m.activeRows) == 0 {
+		position = 0
+	}
+	m.activeRows = append(m.activeRows[:position], append([][]string{newRow}, m.activeRows[position:]...)...)
+
+	// Insert right after the cursor's source row, which may differ from the
+	// view position when sorted
+	source := 0
+	if position > 0 {
+		source = m.sourceRow(position-1) + 1
+	}
+	for _, rowIndex := range [][]int{m.rowIndex, m.originalRowIndex} {
+		for i, index := range rowIndex {
+			if index >= source {
+				rowIndex[i]++
+			}
+		}
+	}
+	if len(m.notes) > 0 {
+		shifted := make(map[noteKey]string)
+		for key, note := range m.notes {
+			if key.row >= source {
+				key.row++
+			}
+			shifted[key] = note
+		}
+		m.notes = shifted
+	}
+	for name, b := range m.bookmarks {
+		if b.row >= source {
+			b.row++
+			m.bookmarks[name] = b
+		}
+	}
+	for i, row := range m.tray {
+		if row >= source {
+			m.tray[i]++
+		}
+	}
+	if len(m.marks) > 0 {
+		shifted := make(map[int]string)
+		for row, mark := range m.marks {
+			if row >= source {
+				row++
+			}
+			shifted[row] = mark
+		}
+		m.marks = shifted
+	}
+
+	dataPosition := source + 1 // Account for the header row
+	m.csvData = append(m.csvData[:dataPosition], append([][]string{csvRow}, m.csvData[dataPosition:]...)...)
+	m.hasChanges = true
+	m.rowIndex = append(m.rowIndex[:position], append([]int{source}, m.rowIndex[position:]...)...)
+
+	// The unfiltered rows get it below the same row
+	if m.isFiltered {
+		original := 0
+		if i := slices.Index(m.originalRowIndex, source-1); i >= 0 {
+			original = i + 1
+		}
+		m.originalRows = slices.Insert(m.originalRows, original, slices.Clone(csvRow))
+		m.originalRowIndex = slices.Insert(m.originalRowIndex, original, source)
+	}
+
+	m.cursorRow = position
+	m.adjustViewportAfterResize()
+}
+
+// formatRecords encodes records as delimited text, quoting values as needed
+func (m *model) formatRecords(records [][]string) string {
+	var b strings.Builder
+	writer := csv.NewWriter(&b)
+	writer.Comma = m.delimiter
+	writer.WriteAll(records)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// clipboardMsg is sent when the OSC 52 escape sequence for a copy has been
+// written to the terminal
+type clipboardMsg struct {
+	what string // What was copied, for the status message
+	err  error
+}
+
+// copyToClipboard writes text to the system clipboard. When no clipboard
+// utility is available (e.g. over SSH) it returns a command that writes the
+// OSC 52 escape sequence to the terminal instead, so it goes out like the
+// program's other terminal commands rather than from inside Update.
+func (m *model) copyToClipboard(text, what string) tea.Cmd {
+	if err := clipboard.WriteAll(text); err == nil {
+		m.statusMessage = fmt.Sprintf("Copied %s to clipboard", what)
+		return nil
+	}
+	sequence := osc52.New(text)
+	if os.Getenv("TMUX") != "" {
+		sequence = sequence.Tmux()
+	}
+	screen := os.Stdout
+	if m.screen != nil {
+		screen = m.screen
+	}
+	return func() tea.Msg {
+		_, err := sequence.WriteTo(screen)
+		return clipboardMsg{what: what, err: err}
+	}
+}
+
+// finishCopy reports how writing the OSC 52 escape sequence went
+func (m *model) finishCopy(msg clipboardMsg) {
+	if msg.err != nil {
+		m.statusMessage = fmt.Sprintf("Copy failed: %v", msg.err)
+		m.statusIsError = true
+		return
+	}
+	m.statusMessage = fmt.Sprintf("Copied %s to clipboard", msg.what)
+}
+
+// selectionBounds returns the rows and columns (inclusive) spanned by the
+// visual selection, which runs from the anchor to the cursor
+func (m model) selectionBounds() (int, int, int, int) {
+	rowStart, rowEnd := m.anchorRow, m.cursorRow
+	if rowStart > rowEnd {
+		rowStart, rowEnd = rowEnd, rowStart
+	}
+	colStart, colEnd := m.anchorCol, m.cursorCol
+	if colStart > colEnd {
+		colStart, colEnd = colEnd, colStart
+	}
+	return rowStart, rowEnd, colStart, colEnd
+}
+
+// isSelected reports whether a cell is inside the visual selection
+func (m model) isSelected(row, col int) bool {
+	if !m.visualMode {
+		return false
+	}
+	rowStart, rowEnd, colStart, colEnd := m.selectionBounds()
+	return row >= rowStart && row <= rowEnd && col >= colStart && col <= colEnd && !m.isColumnHidden(col)
+}
+
+// selectedColumns returns the shown columns inside the visual selection
+func (m model) selectedColumns() []int {
+	_, _, colStart, colEnd := m.selectionBounds()
+	var cols []int
+	for c := colStart; c <= colEnd; c++ {
+		if !m.isColumnHidden(c) {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// selectionRecords returns the values inside the visual selection
+func (m model) selectionRecords() [][]string {
+	rowStart, rowEnd, _, _ := m.selectionBounds()
+	cols := m.selectedColumns()
+	var records [][]string
+	for r := rowStart; r <= rowEnd && r < len(m.activeRows); r++ {
+		record := make([]string, len(cols))
+		for j, c := range cols {
+			if c < len(m.activeRows[r]) {
+				record[j] = m.activeRows[r][c]
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// clearSelection blanks every cell inside the visual selection
+func (m *model) clearSelection() {
+	rowStart, rowEnd, _, _ := m.selectionBounds()
+	cleared := 0
+	for r := rowStart; r <= rowEnd; r++ {
+		for _, c := range m.selectedColumns() {
+			if m.setCell(r, c, "") {
+				cleared++
+			}
+		}
+	}
+	if cleared > 0 {
+		m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	}
+	m.statusMessage = fmt.Sprintf("Cleared %d cell(s)", cleared)
+}
+
+// editTargets returns the rows and columns a bulk operation applies to: the
+// visual selection, or the cursor column from the given row down
+func (m model) editTargets(fromRow int) ([]int, []int) {
+	rowStart, rowEnd, cols := fromRow, len(m.activeRows)-1, []int{m.cursorCol}
+	if m.visualMode {
+		rowStart, rowEnd, _, _ = m.selectionBounds()
+		cols = m.selectedColumns()
+	}
+	var rows []int
+	for r := rowStart; r <= rowEnd && r < len(m.activeRows); r++ {
+		rows = append(rows, r)
+	}
+	return rows, cols
+}
+
+// editTargetName describes the cells editTargets returns, for prompts
+func (m model) editTargetName() string {
+	if m.visualMode {
+		return "selection"
+	}
+	return fmt.Sprintf("column %q", m.activeHeaders[m.cursorCol])
+}
+
+// fillDown copies the top cell of each target column into the cells below it
+func (m *model) fillDown() {
+	rows, cols := m.editTargets(m.cursorRow)
+	if len(rows) < 2 {
+		m.statusMessage = "Nothing below to fill"
+		return
+	}
+
+	filled := 0
+	for _, c := range cols {
+		if c >= len(m.activeRows[rows[0]]) {
+			continue
+		}
+		value := m.activeRows[rows[0]][c]
+		for _, r := range rows[1:] {
+			if m.setCell(r, c, value) {
+				filled++
+			}
+		}
+	}
+	if filled > 0 {
+		m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	}
+	m.statusMessage = fmt.Sprintf("Filled %d cell(s)", filled)
+}
+
+// startBulkEdit opens the prompt for a value to set on every target cell
+func (m *model) startBulkEdit() tea.Cmd {
+	rows, cols := m.editTargets(0)
+	m.bulkEditMode = true
+	m.bulkEditInput = textinput.New()
+	m.bulkEditInput.Focus()
+	m.bulkEditInput.Placeholder = fmt.Sprintf("Value for %d cell(s)", len(rows)*len(cols))
+	return textinput.Blink
+}
+
+// bulkEdit sets every target cell to the same value
+func (m *model) bulkEdit(value string) {
+	rows, cols := m.editTargets(0)
+	edited := 0
+	for _, r := range rows {
+		for _, c := range cols {
+			if m.setCell(r, c, value) {
+				edited++
+			}
+		}
+	}
+	if edited > 0 {
+		m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	}
+	m.statusMessage = fmt.Sprintf("Set %d cell(s)", edited)
+}
+
+// caseTransforms are the case changes that can be applied to a column
+var caseTransforms = map[string]func(string) string{
+	"UPPER":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"Title Case": titleCase,
+}
+
+// titleCase capitalizes the first letter of every word and lowercases the rest
+func titleCase(s string) string {
+	runes := []rune(s)
+	start := true
+	for i, r := range runes {
+		if start {
+			runes[i] = unicode.ToTitle(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+		start = !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}
+	return string(runes)
+}
+
+// padValue left-pads a value to width with the fill character. Zeros go
+// after a sign, so -7 becomes -007 rather than 00-7. Empty values are left
+// alone.
+func padValue(value string, width int, fill rune) string {
+	length := utf8.RuneCountInString(value)
+	if value == "" || length >= width {
+		return value
+	}
+	padding := strings.Repeat(string(fill), width-length)
+	if fill == '0' && (value[0] == '-' || value[0] == '+') {
+		return value[:1] + padding + value[1:]
+	}
+	return padding + value
+}
+
+// leadingZeros matches a zero-padded number, capturing its sign and the
+// number without the extra zeros
+var leadingZeros = regexp.MustCompile(`^([+-]?)0+(\d+(?:\.\d*)?)$`)
+
+// unpadValue strips the padding padValue adds: leading spaces, and leading
+// zeros of numbers
+func unpadValue(value string) string {
+	return leadingZeros.ReplaceAllString(strings.TrimLeft(value, " "), "$1$2")
+}
+
+// UnitConversion converts numbers to another unit: values are multiplied by
+// Scale, then have Offset added
+type UnitConversion struct {
+	Scale    float64 `json:"scale"`
+	Offset   float64 `json:"offset,omitempty"`
+	Decimals *int    `json:"decimals,omitempty"` // Decimal places, as many as needed if unset
+	Unit     string  `json:"unit,omitempty"`     // Unit noted in the column header
+	clock    bool    // Write the result, in seconds, as h:mm:ss
+}
+
+// places returns a number of decimal places for a UnitConversion
+func places(n int) *int {
+	return &n
+}
+
+// unitConversions are the built-in conversions. "->" works in place of "→"
+// when typing a name.
+var unitConversions = map[string]UnitConversion{
+	"bytes→KB":        {Scale: 1e-3, Decimals: places(1), Unit: "KB"},
+	"bytes→MB":        {Scale: 1e-6, Decimals: places(2), Unit: "MB"},
+	"bytes→GB":        {Scale: 1e-9, Decimals: places(2), Unit: "GB"},
+	"cents→dollars":   {Scale: 0.01, Decimals: places(2), Unit: "$"},
+	"ms→seconds":      {Scale: 1e-3, Decimals: places(3), Unit: "s"},
+	"seconds→h:mm:ss": {Scale: 1, Unit: "h:mm:ss", clock: true},
+	"minutes→h:mm:ss": {Scale: 60, Unit: "h:mm:ss", clock: true},
+	"°C→°F":           {Scale: 1.8, Offset: 32, Decimals: places(1), Unit: "°F"},
+	"°F→°C":           {Scale: 1 / 1.8, Offset: -32 / 1.8, Decimals: places(1), Unit: "°C"},
+	"km→miles":        {Scale: 1 / 1.609344, Decimals: places(2), Unit: "mi"},
+	"miles→km":        {Scale: 1.609344, Decimals: places(2), Unit: "km"},
+	"kg→lb":           {Scale: 1 / 0.45359237, Decimals: places(2), Unit: "lb"},
+	"lb→kg":           {Scale: 0.45359237, Decimals: places(2), Unit: "kg"},
+}
+
+// unitsFromConfig returns the built-in conversions merged with the configured
+// ones, and the conversions columns are shown in on open. Invalid entries
+// are left out and reported in the error.
+func unitsFromConfig(config *Config) (map[string]UnitConversion, map[string]string, error) {
+	var problems []string
+	conversions := make(map[string]UnitConversion, len(unitConversions)+len(config.UnitConversions))
+	for name, conversion := range unitConversions {
+		conversions[name] = conversion
+	}
+	for name, conversion := range config.UnitConversions {
+		if conversion.Scale == 0 {
+			problems = append(problems, fmt.Sprintf("unit conversion %q has no scale", name))
+			continue
+		}
+		if conversion.Decimals != nil && *conversion.Decimals < 0 {
+			problems = append(problems, fmt.Sprintf("unit conversion %q has negative decimals", name))
+			continue
+		}
+		conversions[unitName(name)] = conversion
+	}
+	units := make(map[string]string, len(config.Units))
+	for header, name := range config.Units {
+		if _, ok := conversions[unitName(name)]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown unit conversion %q for column %q", name, header))
+			continue
+		}
+		units[header] = unitName(name)
+	}
+	if len(problems) > 0 {
+		return conversions, units, errors.New(strings.Join(problems, "; "))
+	}
+	return conversions, units, nil
+}
+
+// unitName normalizes a typed conversion name
+func unitName(name string) string {
+	return strings.ReplaceAll(strings.TrimSpace(name), "->", "→")
+}
+
+// apply converts a number, leaving any other value as it is
+func (u UnitConversion) apply(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if dataType := detectDataType(trimmed); dataType != DataTypeInt && dataType != DataTypeFloat {
+		return value
+	}
+	number, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+		return value
+	}
+	converted := number*u.Scale + u.Offset
+	if u.clock {
+		sign := ""
+		if converted < 0 {
+			sign, converted = "-", -converted
+		}
+		seconds := int64(math.Round(converted))
+		return fmt.Sprintf("%s%d:%02d:%02d", sign, seconds/3600, seconds/60%60, seconds%60)
+	}
+	decimals := -1
+	if u.Decimals != nil {
+		decimals = *u.Decimals
+	}
+	return strconv.FormatFloat(converted, 'f', decimals, 64)
+}
+
+// unitSuffix matches a unit already noted at the end of a header, such as
+// " (bytes)" or " [ms]"
+var unitSuffix = regexp.MustCompile(`\s*(\([^()]*\)|\[[^\[\]]*\])$`)
+
+// unitHeader returns a header noting the unit its values were converted to
+func unitHeader(header, unit string) string {
+	return unitSuffix.ReplaceAllString(header, "") + " (" + unit + ")"
+}
+
+// cellText returns a value as the table shows it, in the column's unit
+func (m model) cellText(value string, col int) string {
+	if name, ok := m.units[m.activeHeaders[col]]; ok {
+		return m.unitConversions[name].apply(value)
+	}
+	return value
+}
+
+// headerText returns a column's header as the table shows it, noting the
+// unit it is shown in
+func (m model) headerText(col int) string {
+	header := m.activeHeaders[col]
+	if name, ok := m.units[header]; ok {
+		return header + " →" + m.unitConversions[name].Unit
+	}
+	return header
+}
+
+// unitNames returns the names of the conversions, in the order Tab cycles
+// through them
+func (m model) unitNames() []string {
+	names := make([]string, 0, len(m.unitConversions))
+	for name := range m.unitConversions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// startUnits asks for the conversion to show the cursor column in, or with
+// rewrite, to convert the target cells with
+func (m *model) startUnits(rewrite bool) tea.Cmd {
+	m.unitPrompt = true
+	m.unitRewrite = rewrite
+	m.unitInput = textinput.New()
+	m.unitInput.Placeholder = "Conversion, e.g. bytes->MB"
+	if !rewrite {
+		m.unitInput.Placeholder += " (empty to show as stored)"
+	}
+	m.unitInput.SetValue(m.units[m.activeHeaders[m.cursorCol]])
+	m.unitInput.CursorEnd()
+	m.unitInput.Focus()
+	return textinput.Blink
+}
+
+// setUnits shows the cursor column in the named conversion, or as stored
+// when name is ""
+func (m *model) setUnits(name string) error {
+	header := m.activeHeaders[m.cursorCol]
+	if name == "" {
+		delete(m.units, header)
+		m.statusMessage = fmt.Sprintf("Showing column %q as stored", header)
+		return nil
+	}
+	conversion, ok := m.unitConversions[name]
+	if !ok {
+		return fmt.Errorf("unknown conversion %q", name)
+	}
+	if m.units == nil {
+		m.units = make(map[string]string)
+	}
+	m.units[header] = name
+	m.statusMessage = fmt.Sprintf("Showing column %q in %s; the values are unchanged", header, conversion.Unit)
+	return nil
+}
+
+// transformChanges returns the target cells the pending transform would
+// change, as [row, col] pairs
+func (m model) transformChanges() [][2]int {
+	rows, cols := m.editTargets(0)
+	var changes [][2]int
+	for _, r := range rows {
+		for _, c := range cols {
+			if c < len(m.activeRows[r]) && m.transform(m.activeRows[r][c]) != m.activeRows[r][c] {
+				changes = append(changes, [2]int{r, c})
+			}
+		}
+	}
+	return changes
+}
+
+// startTransform asks to confirm a transform of the target cells, unless it
+// changes nothing
+func (m *model) startTransform(name string, transform func(string) string) {
+	m.transformName, m.transform = name, transform
+	if len(m.transformChanges()) == 0 {
+		m.statusMessage = fmt.Sprintf("No cells to change to %s", name)
+		if m.transformNote != "" {
+			m.statusMessage += "; " + m.transformNote
+		}
+		m.transformName, m.transform, m.transformNote, m.transformHead = "", nil, "", ""
+		m.visualMode = false
+	}
+}
+
+// applyTransform applies the pending transform to the target cells
+func (m *model) applyTransform() {
+	changed := 0
+	for _, cell := range m.transformChanges() {
+		if m.setCell(cell[0], cell[1], m.transform(m.activeRows[cell[0]][cell[1]])) {
+			changed++
+		}
+	}
+	if changed > 0 {
+		m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	}
+	m.statusMessage = fmt.Sprintf("Changed %d cell(s) to %s", changed, m.transformName)
+	if m.transformNote != "" {
+		m.statusMessage += "; " + m.transformNote
+	}
+	if m.transformHead != "" && changed > 0 {
+		// The column no longer needs showing converted
+		delete(m.units, m.activeHeaders[m.cursorCol])
+		if err := m.renameColumn(m.cursorCol, m.transformHead); err != nil {
+			m.statusMessage += fmt.Sprintf("; header kept (%v)", err)
+		}
+	}
+	m.transformName, m.transform, m.transformNote, m.transformHead = "", nil, "", ""
+}
+
+// dateLayouts are the date formats the date transform can read
+var dateLayouts = []string{
+	time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02", "2006/1/2",
+	"1/2/2006 15:04:05", "1/2/2006 15:04", "1/2/2006 3:04 PM", "1/2/2006", "2/1/2006 15:04", "2/1/2006", "1/2/06", "2/1/06",
+	"2.1.2006 15:04", "2.1.2006", "2-1-2006", "Jan 2, 2006", "January 2, 2006", "2 Jan 2006", "2 January 2006",
+	"2-Jan-2006", "Mon, 2 Jan 2006", "Monday, January 2, 2006",
+}
+
+// dateFormatPresets are the target layouts Tab cycles through in the date
+// prompt
+var dateFormatPresets = []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05", "01/02/2006", "02/01/2006", "02.01.2006", "Jan 2, 2006"}
+
+// dateReformat returns a transform rewriting the dates in the target cells
+// in the given layout, and a note on the cells that aren't dates. Each cell
+// is read with the first layout that parses it, trying the layouts that fit
+// most cells first, so a column's unambiguous dates settle whether 03/04 is
+// March or April.
+func (m model) dateReformat(layout string) (func(string) string, string) {
+	ranked, note := m.rankLayouts(dateLayouts, "dates")
+	transform := func(value string) string {
+		for _, l := range ranked {
+			if t, err := time.Parse(l, strings.TrimSpace(value)); err == nil {
+				return t.Format(layout)
+			}
+		}
+		return value
+	}
+	return transform, note
+}
+
+// rankLayouts returns the layouts that parse any of the target cells, those
+// fitting the most cells first, and a note on the cells none of them parse
+func (m model) rankLayouts(layouts []string, kind string) ([]string, string) {
+	rows, cols := m.editTargets(0)
+	var unparsed [][2]int
+	counts := make(map[string]int)
+	for _, r := range rows {
+		for _, c := range cols {
+			if c >= len(m.activeRows[r]) || strings.TrimSpace(m.activeRows[r][c]) == "" {
+				continue
+			}
+			value := strings.TrimSpace(m.activeRows[r][c])
+			parsed := false
+			for _, l := range layouts {
+				if _, err := time.Parse(l, value); err == nil {
+					counts[l]++
+					parsed = true
+				}
+			}
+			if !parsed {
+				unparsed = append(unparsed, [2]int{r, c})
+			}
+		}
+	}
+
+	var ranked []string
+	for _, l := range layouts {
+		if counts[l] > 0 {
+			ranked = append(ranked, l)
+		}
+	}
+	sort.SliceStable(ranked, func(a, b int) bool { return counts[ranked[a]] > counts[ranked[b]] })
+
+	note := ""
+	if len(unparsed) > 0 {
+		first := unparsed[0]
+		note = fmt.Sprintf("%d cell(s) aren't %s, first [%d,%d] %q", len(unparsed), kind, first[0]+1, first[1]+1, m.activeRows[first[0]][first[1]])
+	}
+	return ranked, note
+}
+
+// timeLayouts are the datetime formats the time zone conversion can read:
+// the date layouts that have a time of day, and ones with a UTC offset
+var timeLayouts = append([]string{
+	time.RFC3339Nano, "2006-01-02T15:04:05Z0700", "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00", time.RFC1123Z, "02/Jan/2006:15:04:05 -0700",
+}, slices.DeleteFunc(slices.Clone(dateLayouts), func(layout string) bool {
+	return !strings.Contains(layout, "15:04") && !strings.Contains(layout, "3:04")
+})...)
+
+// timezoneConversion returns a transform moving the times in the target cells
+// from one zone to another, keeping each value's format, and a note on the
+// cells that aren't times. Values with a UTC offset are read in their own
+// zone; from only applies to the others.
+func (m model) timezoneConversion(from, to *time.Location) (func(string) string, string) {
+	ranked, note := m.rankLayouts(timeLayouts, "times")
+	transform := func(value string) string {
+		for _, l := range ranked {
+			if t, err := time.ParseInLocation(l, strings.TrimSpace(value), from); err == nil {
+				return t.In(to).Format(l)
+			}
+		}
+		return value
+	}
+	return transform, note
+}
+
+// startTimezoneConversion asks for the zones to convert the target times
+// between, suggesting the ones configured for the cursor column
+func (m *model) startTimezoneConversion() tea.Cmd {
+	zones := m.config.Timezones[m.activeHeaders[m.cursorCol]]
+	if zones.From == "" {
+		zones.From = "Local"
+	}
+	if zones.To == "" {
+		zones.To = "UTC"
+	}
+	m.zonePrompt = true
+	m.zoneFrom = textinput.New()
+	m.zoneFrom.Placeholder = "Zone of values without an offset"
+	m.zoneFrom.SetValue(zones.From)
+	m.zoneFrom.CursorEnd()
+	m.zoneTo = textinput.New()
+	m.zoneTo.Placeholder = "Target zone"
+	m.zoneTo.SetValue(zones.To)
+	m.zoneTo.CursorEnd()
+	m.zoneTo.Focus()
+	return textinput.Blink
+}
+
+// startDateReformat asks for the layout to rewrite the target dates in
+func (m *model) startDateReformat() tea.Cmd {
+	m.datePrompt = true
+	m.dateInput = textinput.New()
+	m.dateInput.Placeholder = "Go time layout"
+	m.dateInput.SetValue(dateFormatPresets[0])
+	m.dateInput.CursorEnd()
+	m.dateInput.Focus()
+	return textinput.Blink
+}
+
+// startPad asks for the width to pad the target cells to, suggesting the
+// widest value
+func (m *model) startPad() tea.Cmd {
+	rows, cols := m.editTargets(0)
+	width := 0
+	for _, r := range rows {
+		for _, c := range cols {
+			if c < len(m.activeRows[r]) {
+				width = max(width, utf8.RuneCountInString(m.activeRows[r][c]))
+			}
+		}
+	}
+	m.padPrompt = true
+	m.padInput = textinput.New()
+	m.padInput.Placeholder = "Width"
+	if width > 0 {
+		m.padInput.SetValue(strconv.Itoa(width))
+		m.padInput.CursorEnd()
+	}
+	m.padInput.Focus()
+	return textinput.Blink
+}
+
+// parseClipboardGrid splits clipboard text into rows and cells. Tab-separated
+// text (as copied from spreadsheets) is split on tabs, anything else on the
+// file's delimiter. Text that isn't valid delimited data is a single cell.
+func parseClipboardGrid(text string, delimiter rune) [][]string {
+	text = strings.TrimRight(text, "\r\n")
+	if strings.Contains(text, "\t") {
+		delimiter = '\t'
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	grid, err := reader.ReadAll()
+	if err != nil || len(grid) == 0 {
+		return [][]string{{text}}
+	}
+	return grid
+}
+
+// paste pastes the clipboard at the cursor. Multi-cell data asks for
+// confirmation before it is spread across adjacent cells.
+func (m *model) paste() {
+	text, err := clipboard.ReadAll()
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Paste failed: %v", err)
+		m.statusIsError = true
+		return
+	}
+
+	grid := parseClipboardGrid(text, m.delimiter)
+	if len(grid) == 1 && len(grid[0]) == 1 {
+		if m.setCell(m.cursorRow, m.cursorCol, grid[0][0]) {
+			m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+			m.statusMessage = "Pasted into cell"
+		}
+		return
+	}
+
+	m.pastePrompt = true
+	m.pasteText = strings.TrimRight(text, "\r\n")
+	m.pasteGrid = grid
+}
+
+// pasteGridAtCursor writes the pending paste grid into the cells starting at
+// the cursor, moving right over shown columns and down over active rows.
+// Cells that fall outside the table are dropped.
+func (m *model) pasteGridAtCursor() {
+	pasted, clipped := 0, 0
+	row := m.cursorRow
+	for _, values := range m.pasteGrid {
+		col := m.cursorCol
+		for i, value := range values {
+			if i > 0 {
+				next := m.stepColumn(col, 1)
+				if next == col {
+					clipped += len(values) - i
+					break
+				}
+				col = next
+			}
+			if row < len(m.activeRows) && m.setCell(row, col, value) {
+				pasted++
+			} else {
+				clipped++
+			}
+		}
+		row++
+	}
+
+	m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	m.statusMessage = fmt.Sprintf("Pasted %d cell(s)", pasted)
+	if clipped > 0 {
+		m.statusMessage += fmt.Sprintf(", %d outside the table dropped", clipped)
+	}
+}
+
+// removeBackup deletes the backup written on suspend, if any
+func (m *model) removeBackup() {
+	os.Remove(m.backupFilename()) // Ignore error if file doesn't exist
+}
+
+// Manual column widths move in steps and never go below the minimum
+const (
+	columnWidthStep = 2
+	minColumnWidth  = 3
+)
+
+// sidecarPath returns the path the notes, marks, widths and group sidecars are
+// named after: the file named on the command line rather than the temp copy
+// read in its place, with the table for a SQLite database. It's "" when the
+// data has no local file to keep them beside.
+func sidecarPath(sourcePath, sqliteTable string) string {
+	if sourcePath == "" || sqliteTable == "" {
+		return sourcePath
+	}
+	return sourcePath + ":" + sqliteTable
+}
+
+// widthsFilename returns the sidecar file holding the remembered column widths
+func widthsFilename(filename string) string {
+	return filename + ".widths.json"
+}
+
+// loadColumnWidths reads the column widths sidecar of a CSV file, if there is one
+func loadColumnWidths(filename string) (map[string]int, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(widthsFilename(filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read column widths %s: %v", widthsFilename(filename), err)
+	}
+
+	var widths map[string]int
+	if err := json.Unmarshal(data, &widths); err != nil {
+		return nil, fmt.Errorf("failed to parse column widths %s: %v", widthsFilename(filename), err)
+	}
+	for header, width := range widths {
+		if width < minColumnWidth {
+			delete(widths, header)
+		}
+	}
+	return widths, nil
+}
+
+// saveColumnWidths writes the column widths sidecar when widths are
+// remembered, removing it once no width is overridden
+func (m *model) saveColumnWidths() {
+	sidecar := sidecarPath(m.sourcePath, m.sqliteTable)
+	if !m.config.RememberWidths || sidecar == "" {
+		return
+	}
+	path := widthsFilename(sidecar)
+	var err error
+	if len(m.columnWidths) == 0 {
+		if err = os.Remove(path); os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		var data []byte
+		if data, err = json.MarshalIndent(m.columnWidths, "", "  "); err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0644)
+		}
+	}
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to save column widths: %v", err)
+		m.statusIsError = true
+	}
+}
+
+// naturalColumnWidth returns the width the table draws a column at when its
+// width isn't set manually, which is the width of its widest value
+func (m model) naturalColumnWidth(col int) int {
+	width := displayWidth(m.activeHeaders[col])
+	for _, row := range m.activeRows {
+		if col < len(row) {
+			for _, line := range strings.Split(row[col], "\n") {
+				width = max(width, displayWidth(line))
+			}
+		}
+	}
+	return width
+}
+
+// resizeColumn widens or narrows the column under the cursor by delta,
+// overriding its calculated width. Going back to the natural width drops the
+// override.
+func (m *model) resizeColumn(delta int) {
+	if m.cursorCol >= len(m.activeHeaders) {
+		return
+	}
+	header := m.activeHeaders[m.cursorCol]
+	natural := m.naturalColumnWidth(m.cursorCol)
+	width, ok := m.columnWidths[header]
+	if !ok {
+		width = natural
+	}
+	width = max(width+delta, minColumnWidth)
+
+	if width == natural {
+		delete(m.columnWidths, header)
+	} else {
+		if m.columnWidths == nil {
+			m.columnWidths = make(map[string]int)
+		}
+		m.columnWidths[header] = width
+	}
+	m.statusMessage = fmt.Sprintf("Column %q is %d wide", header, width)
+	m.saveColumnWidths()
+	m.adjustViewportAfterResize()
+}
+
+// noteGlyph marks cells and rows that have notes
+const noteGlyph = "✎"
+
+// noteKey identifies an annotated cell by source row and header, or a whole
+// row when the column is ""
+type noteKey struct {
+	row    int
+	column string
+}
+
+// noteRecord is a note as stored in the sidecar file, with a 1-based row
+type noteRecord struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Note   string `json:"note"`
+}
+
+// notesFilename returns the sidecar file holding the notes for a CSV file
+func notesFilename(filename string) string {
+	return filename + ".notes.json"
+}
+
+// loadNotes reads the notes sidecar of a CSV file, if there is one
+func loadNotes(filename string) (map[noteKey]string, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(notesFilename(filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes %s: %v", notesFilename(filename), err)
+	}
+
+	var records []noteRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse notes %s: %v", notesFilename(filename), err)
+	}
+	notes := make(map[noteKey]string)
+	for _, record := range records {
+		notes[noteKey{record.Row - 1, record.Column}] = record.Note
+	}
+	return notes, nil
+}
+
+// saveNotes writes the notes sidecar, removing it once the last note is gone
+func (m *model) saveNotes() error {
+	sidecar := sidecarPath(m.sourcePath, m.sqliteTable)
+	if sidecar == "" {
+		return nil
+	}
+	path := notesFilename(sidecar)
+	if len(m.notes) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	records := make([]noteRecord, 0, len(m.notes))
+	for key, note := range m.notes {
+		records = append(records, noteRecord{Row: key.row + 1, Column: key.column, Note: note})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Row != records[j].Row {
+			return records[i].Row < records[j].Row
+		}
+		return records[i].Column < records[j].Column
+	})
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// startNote opens the note prompt for the cell under the cursor, or its row
+func (m *model) startNote(column string) tea.Cmd {
+	if m.cursorRow >= len(m.activeRows) || m.sourceRow(m.cursorRow) < 0 {
+		m.statusMessage = "Notes can only be attached to rows in the file"
+		m.statusIsError = true
+		return nil
+	}
+	m.noteMode = true
+	m.noteColumn = column
+	m.noteInput = textinput.New()
+	m.noteInput.Focus()
+	m.noteInput.Placeholder = "Note text (leave empty to remove the note)"
+	m.noteInput.SetValue(m.notes[noteKey{m.sourceRow(m.cursorRow), column}])
+	m.noteInput.CursorEnd()
+	return textinput.Blink
+}
+
+// setNote attaches a note to the cursor's cell or row and saves the sidecar
+func (m *model) setNote(column, text string) {
+	key := noteKey{m.sourceRow(m.cursorRow), column}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		delete(m.notes, key)
+	} else {
+		if m.notes == nil {
+			m.notes = make(map[noteKey]string)
+		}
+		m.notes[key] = text
+	}
+
+	if err := m.saveNotes(); err != nil {
+		m.statusMessage = fmt.Sprintf("Failed to save notes: %v", err)
+		m.statusIsError = true
+	}
+}
+
+// Review marks, in the order the mark key cycles through them
+const (
+	markTodo     = "todo"
+	markApproved = "approved"
+	markFlagged  = "flagged"
+)
+
+var reviewMarks = []string{markTodo, markApproved, markFlagged}
+
+// markGlyphs are drawn next to marked rows
+var markGlyphs = map[string]string{
+	markTodo:     "○",
+	markApproved: "✓",
+	markFlagged:  "⚑",
+}
+
+// markRecord is a review mark as stored in the sidecar file, with a 1-based row
+type markRecord struct {
+	Row  int    `json:"row"`
+	Mark string `json:"mark"`
+}
+
+// marksFilename returns the sidecar file holding the review marks for a CSV file
+func marksFilename(filename string) string {
+	return filename + ".marks.json"
+}
+
+// loadMarks reads the review marks sidecar of a CSV file, if there is one
+func loadMarks(filename string) (map[int]string, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(marksFilename(filename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read marks %s: %v", marksFilename(filename), err)
+	}
+
+	var records []markRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse marks %s: %v", marksFilename(filename), err)
+	}
+	marks := make(map[int]string)
+	for _, record := range records {
+		if !slices.Contains(reviewMarks, record.Mark) {
+			return nil, fmt.Errorf("unknown mark %q on row %d in %s", record.Mark, record.Row, marksFilename(filename))
+		}
+		marks[record.Row-1] = record.Mark
+	}
+	return marks, nil
+}
+
+// saveMarks writes the review marks sidecar, removing it once the last mark is gone
+func (m *model) saveMarks() error {
+	sidecar := sidecarPath(m.sourcePath, m.sqliteTable)
+	if sidecar == "" {
+		return nil
+	}
+	path := marksFilename(sidecar)
+	if len(m.marks) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	records := make([]markRecord, 0, len(m.marks))
+	for row, mark := range m.marks {
+		records = append(records, markRecord{Row: row + 1, Mark: mark})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Row < records[j].Row })
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// nextMark returns the mark that follows the given one, "" after the last
+func nextMark(mark string) string {
+	i := slices.Index(reviewMarks, mark)
+	if i+1 < len(reviewMarks) {
+		return reviewMarks[i+1]
+	}
+	return ""
+}
+
+// cycleMark moves the cursor row, or every selected row, on to the next
+// review mark. A selection takes the mark that follows the cursor row's.
+func (m *model) cycleMark() {
+	rows := []int{m.cursorRow}
+	if m.visualMode {
+		rows, _ = m.editTargets(0)
+	}
+	if m.cursorRow >= len(m.activeRows) || m.sourceRow(m.cursorRow) < 0 {
+		m.statusMessage = "Marks can only be set on rows in the file"
+		m.statusIsError = true
+		return
+	}
+
+	mark := nextMark(m.marks[m.sourceRow(m.cursorRow)])
+	marked := 0
+	for _, row := range rows {
+		source := m.sourceRow(row)
+		if source < 0 {
+			continue
+		}
+		if mark == "" {
+			delete(m.marks, source)
+		} else {
+			if m.marks == nil {
+				m.marks = make(map[int]string)
+			}
+			m.marks[source] = mark
+		}
+		marked++
+	}
+
+	m.marksChanged = true
+	if mark == "" {
+		m.statusMessage = fmt.Sprintf("Cleared the mark on %d row(s)", marked)
+	} else {
+		m.statusMessage = fmt.Sprintf("Marked %d row(s) %s", marked, mark)
+	}
+}
+
+// markColumnHeader names the column added to exports to hold each row's mark
+const markColumnHeader = "flag"
+
+// markedRecords returns the header and the file's rows carrying a review mark,
+// or any mark when mark is "", in file order. With withColumn set each row
+// gets its mark in an extra column.
+func (m model) markedRecords(mark string, withColumn bool) ([][]string, error) {
+	header := m.csvData[0]
+	if withColumn {
+		if slices.Contains(header, markColumnHeader) {
+			return nil, fmt.Errorf("column %q already exists", markColumnHeader)
+		}
+		header = append(slices.Clone(header), markColumnHeader)
+	}
+
+	records := [][]string{header}
+	for i, row := range m.csvData[1:] {
+		rowMark, ok := m.marks[i]
+		if !ok || (mark != "" && rowMark != mark) {
+			continue
+		}
+		if withColumn {
+			row = append(slices.Clone(row), rowMark)
+		}
+		records = append(records, row)
+	}
+	if len(records) == 1 {
+		return nil, fmt.Errorf("no rows to export")
+	}
+	return records, nil
+}
+
+// exportMarked writes the marked rows chosen in the export prompt to filename
+func (m *model) exportMarked(filename string) error {
+	records, err := m.markedRecords(m.exportMark, m.exportMarkColumn)
+	if err != nil {
+		return err
+	}
+	if err := writeCSV(filename, records, m.delimiter, m.quoting); err != nil {
+		return err
+	}
+	m.noteWritten(filename)
+	m.statusMessage = fmt.Sprintf("Exported %d row(s) to %s", len(records)-1, filename)
+	return nil
+}
+
+const trayGlyph = "◆"
+
+// togglePinnedRow pins the cursor row to the comparison tray, or unpins it
+func (m *model) togglePinnedRow() {
+	if m.cursorRow >= len(m.activeRows) {
+		return
+	}
+	source := m.sourceRow(m.cursorRow)
+	if source < 0 {
+		m.statusMessage = "Rows added while filtered can't be pinned"
+		m.statusIsError = true
+		return
+	}
+	if i := slices.Index(m.tray, source); i >= 0 {
+		m.tray = slices.Delete(m.tray, i, i+1)
+		m.statusMessage = fmt.Sprintf("Unpinned row %d from the tray", source+1)
+	} else {
+		m.tray = append(m.tray, source)
+		m.statusMessage = fmt.Sprintf("Pinned row %d to the tray (%d rows)", source+1, len(m.tray))
+	}
+	m.adjustViewportAfterResize()
+}
+
+// trayRow returns the current values of a row pinned to the tray
+func (m model) trayRow(source int) []string {
+	if i := slices.Index(m.rowIndex, source); i >= 0 && i < len(m.activeRows) {
+		return m.activeRows[i]
+	}
+	// Filtered out of the view
+	if source+1 < len(m.csvData) {
+		return m.csvData[source+1]
+	}
+	return nil
+}
+
+// exportTray writes the headers and the rows pinned to the tray to filename
+func (m *model) exportTray(filename string) error {
+	records := [][]string{m.activeHeaders}
+	for _, source := range m.tray {
+		if row := m.trayRow(source); row != nil {
+			records = append(records, row)
+		}
+	}
+	if err := writeCSV(filename, records, m.delimiter, m.quoting); err != nil {
+		return err
+	}
+	m.noteWritten(filename)
+	m.statusMessage = fmt.Sprintf("Exported %d tray row(s) to %s", len(records)-1, filename)
+	return nil
+}
+
+// exportSQL writes the rows in view to a file as INSERT statements into table
+func (m *model) exportSQL(filename, table string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %v", filename, err)
+	}
+	defer file.Close()
+	if err := writeSQLInserts(file, table, m.activeHeaders, m.activeRows, m.activeColumnTypes); err != nil {
+		return fmt.Errorf("error writing %s: %v", filename, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	m.noteWritten(filename)
+	m.statusMessage = fmt.Sprintf("Exported %d row(s) to %s as INSERTs into %s", len(m.activeRows), filename, table)
+	return nil
+}
+
+// reviewProgress summarizes the review marks across every row in the file
+func (m model) reviewProgress() string {
+	counts := make(map[string]int)
+	for _, mark := range m.marks {
+		counts[mark]++
+	}
+	total := len(m.csvData) - 1
+	return fmt.Sprintf("%d/%d approved, %d flagged, %d todo",
+		counts[markApproved], total, counts[markFlagged], counts[markTodo])
+}
+
+// diffCell identifies a cell by source row and header, so diff markers
+// survive sorting and filtering
+type diffCell struct {
+	row    int
+	header string
+}
+
+// readGitRevision reads the file as it was at a git revision. A SQLite table
+// or a compressed, JSON or Parquet file is converted the way it was when
+// opened, from a temp copy of the revision.
+func readGitRevision(filename, table, ref string, delimiter rune, options ImportConfig) ([][]string, error) {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "-C", filepath.Dir(absPath), "show", ref+":./"+filepath.Base(absPath))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s", message)
+		}
+		return nil, err
+	}
+
+	revision, err := os.CreateTemp("", "csvtui-*-"+filepath.Base(absPath))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(revision.Name())
+	_, err = revision.Write(output)
+	if closeErr := revision.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	input := revision.Name()
+	if table != "" {
+		input += ":" + table
+	}
+	path, cleanup, err := readableInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at %s: %v", filepath.Base(filename), ref, err)
+	}
+	defer cleanup()
+	if output, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	if options.separator != nil {
+		records, err = readSeparated(bytes.NewReader(output), options.separator, options)
+	} else {
+		reader := newCSVReader(bytes.NewReader(output), delimiter, options)
+		reader.FieldsPerRecord = -1
+		records, err = reader.ReadAll()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s at %s: %v", filepath.Base(filename), ref, err)
+	}
+	return records, nil
+}
+
+// diffAgainstRevision marks the cells that differ from a git revision of the
+// file. Columns are matched by header. Rows are matched by skipping the
+// unchanged rows at the start and end, then by position in between, so a
+// single block of inserted or deleted rows doesn't mark everything below it.
+func (m *model) diffAgainstRevision(ref string) error {
+	if m.sourcePath == "" {
+		return fmt.Errorf("%s isn't a local file in git", m.sourceName())
+	}
+	records, err := readGitRevision(m.sourcePath, m.sqliteTable, ref, m.delimiter, m.config.Import)
+	if err != nil {
+		return err
+	}
+	if m.groupHeaderRow != nil && len(records) > 0 {
+		records = records[1:]
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("%s at %s is empty", m.sourceName(), ref)
+	}
+
+	oldColumns := make(map[string]int)
+	for i, header := range records[0] {
+		oldColumns[header] = i
+	}
+	oldRows := records[1:]
+	headers := m.csvData[0]
+	rows := m.csvData[1:]
+
+	// cellDiffers compares a current cell against a cell of the old revision
+	cellDiffers := func(row []string, c int, oldRow []string) bool {
+		value := ""
+		if c < len(row) {
+			value = row[c]
+		}
+		oldCol, ok := oldColumns[headers[c]]
+		return !ok || oldCol >= len(oldRow) || oldRow[oldCol] != value
+	}
+	rowDiffers := func(row, oldRow []string) bool {
+		for c := range headers {
+			if cellDiffers(row, c, oldRow) {
+				return true
+			}
+		}
+		return false
+	}
+
+	prefix := 0
+	for prefix < len(rows) && prefix < len(oldRows) && !rowDiffers(rows[prefix], oldRows[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(rows)-prefix && suffix < len(oldRows)-prefix &&
+		!rowDiffers(rows[len(rows)-1-suffix], oldRows[len(oldRows)-1-suffix]) {
+		suffix++
+	}
+
+	cells := make(map[diffCell]bool)
+	changed, added := 0, 0
+	oldMiddle := len(oldRows) - prefix - suffix
+	for r := prefix; r < len(rows)-suffix; r++ {
+		if r-prefix >= oldMiddle {
+			// Past the end of the old block, so the whole row is new
+			added++
+			for _, header := range headers {
+				cells[diffCell{r, header}] = true
+			}
+			continue
+		}
+		for c, header := range headers {
+			if cellDiffers(rows[r], c, oldRows[r]) {
+				cells[diffCell{r, header}] = true
+				changed++
+			}
+		}
+	}
+
+	removed := 0
+	if oldMiddle > len(rows)-prefix-suffix {
+		removed = oldMiddle - (len(rows) - prefix - suffix)
+	}
+	m.diffRef = ref
+	m.diffCells = cells
+	m.diffSummary = fmt.Sprintf("%d cell(s) changed, +%d/-%d rows", changed, added, removed)
+	return nil
+}
+
+// cellChange records the previous value of an edited cell. The row is kept
+// by reference so the change can be found again after sorting.
+type cellChange struct {
+	row     []string
+	col     int
+	old     string
+	through bool // Whether the change was written through to csvData from a filtered view
+}
+
+// externalEditMsg is sent when the external editor opened on a cell exits
+type externalEditMsg struct {
+	path string
+	row  []string // Edited row, by reference so sorting in between is harmless
+	col  int
+	err  error
+}
+
+// editInExternalEditor suspends the TUI and opens the cell under the cursor
+// in $VISUAL or $EDITOR (falling back to vi)
+func (m *model) editInExternalEditor() tea.Cmd {
+	value, ok := m.cursorValue()
+	if !ok {
+		return nil
+	}
+
+	// Give the editor a hint for syntax highlighting
+	pattern := "csvtui-*.txt"
+	switch detectCellSyntax(value) {
+	case "JSON":
+		pattern = "csvtui-*.json"
+	case "XML":
+		pattern = "csvtui-*.xml"
+	}
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Could not create temp file: %v", err)
+		m.statusIsError = true
+		return nil
+	}
+	_, err = file.WriteString(value)
+	file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		m.statusMessage = fmt.Sprintf("Could not write temp file: %v", err)
+		m.statusIsError = true
+		return nil
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	// Editor paths often contain spaces on Windows, so only split the
+	// setting into arguments when it isn't a program by itself
+	args := []string{editor, file.Name()}
+	if _, err := exec.LookPath(editor); err != nil {
+		args = append(strings.Fields(editor), file.Name())
+	}
+
+	row, col, path := m.activeRows[m.cursorRow], m.cursorCol, file.Name()
+	return tea.ExecProcess(exec.Command(args[0], args[1:]...), func(err error) tea.Msg {
+		return externalEditMsg{path: path, row: row, col: col, err: err}
+	})
+}
+
+// applyExternalEdit writes the result of an external edit back into the cell
+func (m *model) applyExternalEdit(msg externalEditMsg) {
+	defer os.Remove(msg.path)
+	if msg.err != nil {
+		m.statusMessage = fmt.Sprintf("Editor failed: %v", msg.err)
+		m.statusIsError = true
+		return
+	}
+	data, err := os.ReadFile(msg.path)
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("Could not read edited value: %v", err)
+		m.statusIsError = true
+		return
+	}
+
+	// Editors add a final newline that isn't part of the value
+	value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	for r, row := range m.activeRows {
+		if len(row) > 0 && &row[0] == &msg.row[0] {
+			if m.setCell(r, msg.col, value) {
+				m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+			}
+			return
+		}
+	}
+	m.statusMessage = "Edited row is no longer in view"
+	m.statusIsError = true
+}
+
+// undo reverts the most recent batch of cell edits
+func (m *model) undo() {
+	if len(m.undoStack) == 0 {
+		m.statusMessage = "Nothing to undo"
+		return
+	}
+	changes := m.undoStack[len(m.undoStack)-1]
+	m.undoStack = m.undoStack[:len(m.undoStack)-1]
+
+	reverted, edits := 0, m.cellsChanged
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		for r, row := range m.activeRows {
+			if len(row) > 0 && &row[0] == &change.row[0] {
+				m.setCell(r, change.col, change.old)
+				if change.through {
+					m.writeThrough(r, change.col, change.old)
+				}
+				m.cursorRow, m.cursorCol = r, change.col
+				reverted++
+				break
+			}
+		}
+	}
+	// Reverting isn't itself undoable, or counted as changing cells, and
+	// takes back the edits it reverts
+	m.undoBatch = nil
+	m.cellsChanged = max(edits-reverted, 0)
+
+	m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	m.adjustViewportAfterResize()
+	m.statusMessage = fmt.Sprintf("Undid %d change(s)", reverted)
+}
+
+// setCell writes a value into the active view, mirroring it into csvData when
+// the view is unfiltered. Returns whether the cell actually changed.
+func (m *model) setCell(row, col int, value string) bool {
+	if row >= len(m.activeRows) || col >= max(len(m.activeRows[row]), len(m.activeHeaders)) {
+		return false
+	}
+	if col >= len(m.activeRows[row]) {
+		// A cell missing from a ragged row reads as empty until it's given a value
+		if value == "" {
+			return false
+		}
+		m.padRow(row)
+	}
+	if m.activeRows[row][col] == value {
+		return false
+	}
+	m.undoBatch = append(m.undoBatch, cellChange{m.activeRows[row], col, m.activeRows[row][col], false})
+	m.activeRows[row][col] = value
+	m.cellsChanged++
+	clear(m.rangeCache)
+
+	// Only mark as changed and update csvData if not filtered
+	// When filtered, changes are only to the filtered view
+	if !m.isFiltered {
+		m.hasChanges = true
+		m.csvData[m.sourceRow(row)+1][col] = value
+	}
+	return true
+}
+
+// writeThrough mirrors a change to a filtered view into csvData and into the
+// unfiltered rows kept for resetting the filters, which share its columns.
+// Columns a filter computed have nothing behind them to write to.
+func (m *model) writeThrough(row, col int, value string) {
+	source, sourceCol := m.sourceRow(row), m.sourceColumn(col)
+	if source < 0 || source+1 >= len(m.csvData) || sourceCol < 0 || sourceCol >= len(m.csvData[0]) {
+		return
+	}
+	moved := make(map[*string][]string)
+	m.csvData[source+1] = padRecord(m.csvData[source+1], len(m.csvData[0]), moved)
+	m.csvData[source+1][sourceCol] = value
+	m.hasChanges = true
+	if i := slices.Index(m.originalRowIndex, source); i >= 0 && sourceCol < len(m.originalHeaders) {
+		m.originalRows[i] = padRecord(m.originalRows[i], len(m.originalHeaders), moved)
+		m.originalRows[i][sourceCol] = value
+	}
+}
+
+// sourceColumn returns the csvData column behind an active column, or -1 for
+// a column a filter computed
+func (m *model) sourceColumn(col int) int {
+	if m.columnIndex == nil {
+		return col
+	}
+	if col < len(m.columnIndex) {
+		return m.columnIndex[col]
+	}
+	return -1
+}
+
+// padRow widens a ragged row to the header width so its missing cells can be
+// written, along with its csvData row when the view is unfiltered
+func (m *model) padRow(row int) {
+	moved := make(map[*string][]string)
+	m.activeRows[row] = padRecord(m.activeRows[row], len(m.activeHeaders), moved)
+	if !m.isFiltered {
+		source := m.sourceRow(row) + 1
+		m.csvData[source] = padRecord(m.csvData[source], len(m.activeHeaders), moved)
+	}
+	m.moveUndoEntries(moved)
+}
+
+// padRecord returns record widened to width with empty fields. The new slice
+// is recorded in moved under the old one's first element, so references to
+// the row can follow it.
+func padRecord(record []string, width int, moved map[*string][]string) []string {
+	if len(record) >= width {
+		return record
+	}
+	padded := make([]string, width)
+	copy(padded, record)
+	if len(record) > 0 {
+		moved[&record[0]] = padded
+	}
+	return padded
+}
+
+// moveUndoEntries points undo entries for rows that were replaced by wider
+// copies at the new slices
+func (m *model) moveUndoEntries(moved map[*string][]string) {
+	for _, changes := range append(m.undoStack, m.undoBatch) {
+		for j, change := range changes {
+			if len(change.row) == 0 {
+				continue
+			}
+			if padded, ok := moved[&change.row[0]]; ok {
+				changes[j].row = padded
+			}
+		}
+	}
+}
+
+// cursorValue returns the value under the cursor, reporting false when the
+// cursor isn't on a row. Cells missing from ragged rows read as empty.
+func (m model) cursorValue() (string, bool) {
+	if m.cursorRow >= len(m.activeRows) || m.cursorCol >= len(m.activeHeaders) {
+		return "", false
+	}
+	if row := m.activeRows[m.cursorRow]; m.cursorCol < len(row) {
+		return row[m.cursorCol], true
+	}
+	return "", true
+}
+
+// sourceRow returns the csvData row (excluding the header) behind an active row
+func (m *model) sourceRow(row int) int {
+	if row < len(m.rowIndex) {
+		return m.rowIndex[row]
+	}
+	return row
+}
+
+type DataType int
+
+const (
+	DataTypeString DataType = iota
+	DataTypeInt
+	DataTypeFloat
+	DataTypeBool
+	DataTypeEmpty
+)
+
+type Config struct {
+	Colors      ColorConfig       `json:"colors,omitempty"`
+	Hotkeys     HotkeyConfig      `json:"hotkeys,omitempty"`
+	PrettyPrint PrettyPrintConfig `json:"prettyPrint,omitempty"`
+	RowTemplate map[string]string `json:"rowTemplate,omitempty"` // Header -> default value for inserted rows
+	Import      ImportConfig      `json:"import,omitempty"`
+	Export      ExportConfig      `json:"export,omitempty"`
+	RowNumbers  bool              `json:"rowNumbers,omitempty"`  // Start with the row-number gutter shown
+	FormulaBar  bool              `json:"formulaBar,omitempty"`  // Start with the formula bar shown
+	HideLegend  bool              `json:"hideLegend,omitempty"`  // Start with the color legend hidden
+	HideHelpBar bool              `json:"hideHelpBar,omitempty"` // Start with the help line hidden
+
+	// Style only the cursor, selection and header, leaving values, the
+	// row-number gutter and borders in the terminal's own color
+	PlainText bool `json:"plainText,omitempty"`
+
+	// Remember manually adjusted column widths in a <file>.widths.json sidecar
+	RememberWidths bool `json:"rememberWidths,omitempty"`
+
+	// Print what the session did (rows viewed, filters, edits and files
+	// written) on quit, like -summary
+	ExitSummary bool `json:"exitSummary,omitempty"`
+
+	TypeSample TypeSampleConfig `json:"typeSample,omitempty"`
+
+	// Sorts applied on open; the first rule matching the file's headers wins
+	SortRules []SortRule `json:"sortRules,omitempty"`
+
+	// Delimiters for file extensions, e.g. {".psv": "pipe", ".txt": "tab"},
+	// used instead of detecting one unless -delimiter is given
+	Delimiters map[string]string `json:"delimiters,omitempty"`
+
+	// Locale numbers and dates are displayed in, e.g. "de-DE"; the data
+	// itself is never changed
+	Locale string `json:"locale,omitempty"`
+
+	// Key starting the "<leader> ..." key sequences, "," by default; "space"
+	// is the space bar
+	Leader string `json:"leader,omitempty"`
+
+	// Alternative set of default key bindings: "default", "vim", "emacs",
+	// "arrows-only", or "colemak"
+	KeyPreset string `json:"keyPreset,omitempty"`
+
+	// Table border: "normal" (default), "rounded", "thick", "double", or
+	// "none" for a compact table without borders
+	Border string `json:"border,omitempty"`
+
+	// Time zones the time zone conversion suggests for datetime columns, by
+	// header, e.g. {"created": {"from": "UTC", "to": "Europe/Berlin"}}
+	Timezones map[string]TimezoneConfig `json:"timezones,omitempty"`
+
+	// Unit conversions columns are shown in on open, by header, e.g.
+	// {"size": "bytes→MB"}; the values themselves are unchanged
+	Units map[string]string `json:"units,omitempty"`
+
+	// Conversions offered alongside the built-in ones, by name
+	UnitConversions map[string]UnitConversion `json:"unitConversions,omitempty"`
+}
+
+// TimezoneConfig holds the zones suggested when converting a column's times
+type TimezoneConfig struct {
+	From string `json:"from,omitempty"` // Zone of values without an offset, "Local" if unset
+	To   string `json:"to,omitempty"`   // Target zone, "UTC" if unset
+}
+
+// TypeSampleConfig limits the rows scanned for column types at startup. The
+// types of bigger files are refined by a full scan in the background.
+type TypeSampleConfig struct {
+	HeadRows   int `json:"headRows,omitempty"`   // Leading rows scanned, 10000 if unset
+	RandomRows int `json:"randomRows,omitempty"` // Rows sampled from the rest, 1000 if unset
+}
+
+// SortRule sorts files that have a column by it when they're opened, e.g.
+// exports with a timestamp column newest first
+type SortRule struct {
+	Column  string   `json:"column"`            // Header to sort by; files without it don't match
+	Order   string   `json:"order,omitempty"`   // "asc" (default) or "desc"
+	Headers []string `json:"headers,omitempty"` // Other headers a file must have to match
+}
+
+// matchSortRule returns the header and direction of the first sort rule
+// whose columns a file has, with headers matched case-insensitively
+func matchSortRule(rules []SortRule, headers []string) (string, bool, bool) {
+	find := func(name string) (string, bool) {
+		for _, header := range headers {
+			if strings.EqualFold(header, name) {
+				return header, true
+			}
+		}
+		return "", false
+	}
+
+	for _, rule := range rules {
+		column, ok := find(rule.Column)
+		for _, required := range rule.Headers {
+			if _, found := find(required); !found {
+				ok = false
+			}
+		}
+		if ok {
+			return column, strings.EqualFold(rule.Order, "desc"), true
+		}
+	}
+	return "", false, false
+}
+
+// ImportConfig makes reading CSV files tolerate common defects
+type ImportConfig struct {
+	LazyQuotes       bool   `json:"lazyQuotes,omitempty"`       // Allow stray quotes in unquoted fields and unescaped ones in quoted fields
+	TrimLeadingSpace bool   `json:"trimLeadingSpace,omitempty"` // Ignore spaces after delimiters
+	CommentPrefix    string `json:"commentPrefix,omitempty"`    // Lines above the header starting with this, such as "#", are kept as comments; off by default
+
+	separator *fieldSeparator // From a multi-character or pattern delimiter, nil for encoding/csv
+}
+
+type ExportConfig struct {
+	Quoting    string `json:"quoting,omitempty"`    // "minimal" (default), "always", "non-numeric", or "lazy"
+	QuoteChar  string `json:"quoteChar,omitempty"`  // Defaults to a double quote
+	EscapeChar string `json:"escapeChar,omitempty"` // Placed before quote characters inside quoted fields; defaults to doubling them
+	BOM        string `json:"bom,omitempty"`        // "preserve" (default) keeps a byte order mark if the file had one, "always" or "never"
+}
+
+type PrettyPrintConfig struct {
+	Enabled    bool   `json:"enabled,omitempty"`    // Start with pretty-printing turned on
+	SaveFormat string `json:"saveFormat,omitempty"` // "minified" (default) or "as-was"
+}
+
+type ColorConfig struct {
+	Theme          string `json:"Theme,omitempty"`        // Built-in theme the colors below override; picked by background if empty
+	Background     string `json:"Background,omitempty"`   // "auto" (default) detects the terminal background, "dark" or "light" forces it
+	ColorProfile   string `json:"ColorProfile,omitempty"` // "auto" (default) detects the terminal's colors, "truecolor", "256", "16", or "none" forces them
+	DataTypeString string `json:"DataTypeString,omitempty"`
+	DataTypeInt    string `json:"DataTypeInt,omitempty"`
+	DataTypeFloat  string `json:"DataTypeFloat,omitempty"`
+	DataTypeBool   string `json:"DataTypeBool,omitempty"`
+	DataTypeEmpty  string `json:"DataTypeEmpty,omitempty"`
+
+	Header             string `json:"Header,omitempty"`
+	SelectedForeground string `json:"SelectedForeground,omitempty"`
+	SelectedBackground string `json:"SelectedBackground,omitempty"`
+	Border             string `json:"Border,omitempty"`
+	EvenRow            string `json:"EvenRow,omitempty"`
+	OddRow             string `json:"OddRow,omitempty"`
+	StatusLine         string `json:"StatusLine,omitempty"`
+	Error              string `json:"Error,omitempty"`
+	HeatmapLow         string `json:"HeatmapLow,omitempty"`  // Background of a heatmap column's lowest value
+	HeatmapHigh        string `json:"HeatmapHigh,omitempty"` // Background of a heatmap column's highest value
+}
+
+// Theme holds the colors of every part of the UI
+type Theme struct {
+	Header             lipgloss.Color
+	Text               lipgloss.Color // Legend and group band labels
+	SelectedForeground lipgloss.Color
+	SelectedBackground lipgloss.Color
+	VisualForeground   lipgloss.Color
+	VisualBackground   lipgloss.Color
+	DiffForeground     lipgloss.Color
+	DiffBackground     lipgloss.Color
+	BandBackground     lipgloss.Color // Column group band
+	Border             lipgloss.Color
+	EvenRow            lipgloss.Color // Rows in columns without a type color
+	OddRow             lipgloss.Color
+	Accent             lipgloss.Color // Titles, JSON keys, and XML tags
+	Muted              lipgloss.Color // Row-number gutter and help hints
+	Subtle             lipgloss.Color // Labels and punctuation
+	StatusLine         lipgloss.Color // Empty leaves the status line in the terminal's color
+	Error              lipgloss.Color
+	Note               lipgloss.Color
+	HeatmapLow         lipgloss.Color // Heatmap columns blend from this background
+	HeatmapHigh        lipgloss.Color // to this one
+	TypeColors         map[DataType]lipgloss.Color
+	DimTypeColors      map[DataType]lipgloss.Color // Used on alternate rows
+}
+
+// themes are the built-in themes, selected by name with the Theme color setting
+var themes = map[string]Theme{
+	"default": {
+		Header:             "252",
+		Text:               "252",
+		SelectedForeground: "#01BE85",
+		SelectedBackground: "#00432F",
+		VisualForeground:   "255",
+		VisualBackground:   "#264F78",
+		DiffForeground:     "230",
+		DiffBackground:     "#5C4B00",
+		BandBackground:     "236",
+		Border:             "238",
+		EvenRow:            "245",
+		OddRow:             "252",
+		Accent:             "#01BE85",
+		Muted:              "241",
+		Subtle:             "245",
+		Error:              "#FF6B6B",
+		Note:               "#FFD93D",
+		HeatmapLow:         "#1D3557",
+		HeatmapHigh:        "#9B2226",
+		TypeColors:         getDefaultColors(),
+		DimTypeColors:      getDefaultDimColors(),
+	},
+	"light": {
+		Header:             "235",
+		Text:               "236",
+		SelectedForeground: "#00754F",
+		SelectedBackground: "#C8F0E0",
+		VisualForeground:   "0",
+		VisualBackground:   "#BBD6F2",
+		DiffForeground:     "0",
+		DiffBackground:     "#F5E6A8",
+		BandBackground:     "253",
+		Border:             "250",
+		EvenRow:            "240",
+		OddRow:             "235",
+		Accent:             "#00875F",
+		Muted:              "245",
+		Subtle:             "243",
+		Error:              "#C62828",
+		Note:               "#B8860B",
+		HeatmapLow:         "#D6E6F5",
+		HeatmapHigh:        "#F5C6C2",
+		TypeColors: map[DataType]lipgloss.Color{
+			DataTypeString: "#1F5F99",
+			DataTypeInt:    "#2E7D32",
+			DataTypeFloat:  "#AD1457",
+			DataTypeBool:   "#6A1B9A",
+			DataTypeEmpty:  "#9E9E9E",
+		},
+		DimTypeColors: map[DataType]lipgloss.Color{
+			DataTypeString: "#4A7FB0",
+			DataTypeInt:    "#558B2F",
+			DataTypeFloat:  "#C2185B",
+			DataTypeBool:   "#8E24AA",
+			DataTypeEmpty:  "#BDBDBD",
+		},
+	},
+	"solarized": {
+		Header:             "#93A1A1",
+		Text:               "#93A1A1",
+		SelectedForeground: "#002B36",
+		SelectedBackground: "#2AA198",
+		VisualForeground:   "#FDF6E3",
+		VisualBackground:   "#268BD2",
+		DiffForeground:     "#002B36",
+		DiffBackground:     "#B58900",
+		BandBackground:     "#073642",
+		Border:             "#586E75",
+		EvenRow:            "#839496",
+		OddRow:             "#93A1A1",
+		Accent:             "#2AA198",
+		Muted:              "#586E75",
+		Subtle:             "#657B83",
+		Error:              "#DC322F",
+		Note:               "#B58900",
+		HeatmapLow:         "#073642",
+		HeatmapHigh:        "#CB4B16",
+		TypeColors: map[DataType]lipgloss.Color{
+			DataTypeString: "#268BD2",
+			DataTypeInt:    "#859900",
+			DataTypeFloat:  "#D33682",
+			DataTypeBool:   "#6C71C4",
+			DataTypeEmpty:  "#657B83",
+		},
+		DimTypeColors: map[DataType]lipgloss.Color{
+			DataTypeString: "#1F6FA8",
+			DataTypeInt:    "#6B7A00",
+			DataTypeFloat:  "#A82B68",
+			DataTypeBool:   "#555A9C",
+			DataTypeEmpty:  "#4A5D63",
+		},
+	},
+	"monochrome": {
+		Header:             "255",
+		Text:               "252",
+		SelectedForeground: "0",
+		SelectedBackground: "255",
+		VisualForeground:   "0",
+		VisualBackground:   "248",
+		DiffForeground:     "255",
+		DiffBackground:     "240",
+		BandBackground:     "236",
+		Border:             "240",
+		EvenRow:            "245",
+		OddRow:             "252",
+		Accent:             "255",
+		Muted:              "241",
+		Subtle:             "245",
+		Error:              "255",
+		Note:               "250",
+		HeatmapLow:         "235",
+		HeatmapHigh:        "250",
+		TypeColors: map[DataType]lipgloss.Color{
+			DataTypeString: "252",
+			DataTypeInt:    "252",
+			DataTypeFloat:  "252",
+			DataTypeBool:   "252",
+			DataTypeEmpty:  "241",
+		},
+		DimTypeColors: map[DataType]lipgloss.Color{
+			DataTypeString: "245",
+			DataTypeInt:    "245",
+			DataTypeFloat:  "245",
+			DataTypeBool:   "245",
+			DataTypeEmpty:  "238",
+		},
+	},
+}
+
+// themeFromConfig returns the configured built-in theme with the configured
+// colors applied on top. Without a configured theme, dark backgrounds get the
+// default theme and light ones the light theme. Data type colors are applied
+// by applyConfigColors.
+func themeFromConfig(colors ColorConfig, darkBackground bool) (Theme, error) {
+	name := colors.Theme
+	if name == "" {
+		name = "default"
+		if !darkBackground {
+			name = "light"
+		}
+	}
+	theme, ok := themes[name]
+	if !ok {
+		names := make([]string, 0, len(themes))
+		for name := range themes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return themes["default"], fmt.Errorf("unknown theme %q (available: %s)", colors.Theme, strings.Join(names, ", "))
+	}
+
+	overrides := []struct {
+		value string
+		color *lipgloss.Color
+	}{
+		{colors.Header, &theme.Header},
+		{colors.SelectedForeground, &theme.SelectedForeground},
+		{colors.SelectedBackground, &theme.SelectedBackground},
+		{colors.Border, &theme.Border},
+		{colors.EvenRow, &theme.EvenRow},
+		{colors.OddRow, &theme.OddRow},
+		{colors.StatusLine, &theme.StatusLine},
+		{colors.Error, &theme.Error},
+		{colors.HeatmapLow, &theme.HeatmapLow},
+		{colors.HeatmapHigh, &theme.HeatmapHigh},
+	}
+	for _, override := range overrides {
+		if override.value != "" {
+			*override.color = lipgloss.Color(override.value)
+		}
+	}
+	return theme, nil
+}
+
+// colorProfiles are the terminal color profiles the ColorProfile setting can
+// force instead of detecting one
+var colorProfiles = map[string]termenv.Profile{
+	"truecolor": termenv.TrueColor,
+	"256":       termenv.ANSI256,
+	"16":        termenv.ANSI,
+	"none":      termenv.Ascii,
+}
+
+// colorRGB returns the red, green, and blue of a hex color or an ANSI 256
+// color number, or false for the 16 basic colors and anything unparsable
+func colorRGB(color lipgloss.Color) (r, g, b int, ok bool) {
+	value := string(color)
+	if strings.HasPrefix(value, "#") && len(value) == 7 {
+		rgb, err := strconv.ParseUint(value[1:], 16, 32)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		return int(rgb >> 16), int(rgb >> 8 & 0xFF), int(rgb & 0xFF), true
+	}
+	n, err := strconv.Atoi(value)
+	switch {
+	case err != nil || n < 16 || n > 255:
+		return 0, 0, 0, false
+	case n >= 232:
+		// Grayscale ramp
+		gray := 8 + (n-232)*10
+		return gray, gray, gray, true
+	default:
+		// 6x6x6 color cube
+		levels := []int{0, 95, 135, 175, 215, 255}
+		n -= 16
+		return levels[n/36], levels[n/6%6], levels[n%6], true
+	}
+}
+
+// ansiColor maps a color to the nearest of the 16 basic terminal colors by
+// hue and lightness, instead of by distance, which turns dark tints into
+// black and pastels into white. Grays keep four steps so dim and bright
+// text stay apart, and background grays never match the terminal's own
+// black or white.
+func ansiColor(color lipgloss.Color, background bool) lipgloss.Color {
+	r, g, b, ok := colorRGB(color)
+	if !ok {
+		return color
+	}
+	high, low := max(r, g, b), min(r, g, b)
+	lightness := float64(high+low) / 2 / 255
+	saturation := 0.0
+	if high != low {
+		saturation = float64(high-low) / float64(255-abs(high+low-255))
+	}
+
+	if saturation < 0.25 {
+		switch {
+		case background && lightness < 0.5:
+			return "8"
+		case background:
+			return "7"
+		case lightness < 0.2:
+			return "0"
+		case lightness < 0.45:
+			return "8"
+		case lightness < 0.8:
+			return "7"
+		default:
+			return "15"
+		}
+	}
+
+	// Hue in degrees. Green and blue span more hues than the others, so
+	// olives stay green and steel blues stay blue.
+	var hue float64
+	delta := float64(high - low)
+	switch high {
+	case r:
+		hue = 60 * float64(g-b) / delta
+	case g:
+		hue = 60 * (float64(b-r)/delta + 2)
+	default:
+		hue = 60 * (float64(r-g)/delta + 4)
+	}
+	if hue < 0 {
+		hue += 360
+	}
+	var basic int
+	switch {
+	case hue < 20 || hue >= 330:
+		basic = 1 // Red
+	case hue < 70:
+		basic = 3 // Yellow
+	case hue < 165:
+		basic = 2 // Green
+	case hue < 200:
+		basic = 6 // Cyan
+	case hue < 260:
+		basic = 4 // Blue
+	default:
+		basic = 5 // Magenta
+	}
+	if lightness > 0.55 && !background {
+		basic += 8
+	}
+	return lipgloss.Color(strconv.Itoa(basic))
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ansiTheme maps every theme color to the 16 basic colors, giving text on a
+// colored background black or white so it stays readable
+func ansiTheme(theme Theme) Theme {
+	foregrounds := []*lipgloss.Color{&theme.Header, &theme.Text, &theme.Border, &theme.EvenRow, &theme.OddRow,
+		&theme.Accent, &theme.Muted, &theme.Subtle, &theme.StatusLine, &theme.Error, &theme.Note}
+	for _, color := range foregrounds {
+		*color = ansiColor(*color, false)
+	}
+	theme.BandBackground = ansiColor(theme.BandBackground, true)
+
+	pairs := []struct{ foreground, background *lipgloss.Color }{
+		{&theme.SelectedForeground, &theme.SelectedBackground},
+		{&theme.VisualForeground, &theme.VisualBackground},
+		{&theme.DiffForeground, &theme.DiffBackground},
+	}
+	for _, pair := range pairs {
+		*pair.background = ansiColor(*pair.background, true)
+		switch *pair.background {
+		case "2", "3", "6", "7":
+			*pair.foreground = "0"
+		case "1", "4", "5", "8":
+			*pair.foreground = "15"
+		default:
+			*pair.foreground = ansiColor(*pair.foreground, false)
+		}
+	}
+
+	theme.TypeColors = ansiColors(theme.TypeColors)
+	theme.DimTypeColors = ansiColors(theme.DimTypeColors)
+	return theme
+}
+
+// ansiColors maps data type colors to the 16 basic colors
+func ansiColors(colors map[DataType]lipgloss.Color) map[DataType]lipgloss.Color {
+	mapped := make(map[DataType]lipgloss.Color, len(colors))
+	for dataType, color := range colors {
+		mapped[dataType] = ansiColor(color, false)
+	}
+	return mapped
+}
+
+// localeFormat is how numbers and dates are displayed for a locale
+type localeFormat struct {
+	decimal    string // Decimal separator
+	group      string // Thousands separator
+	dateLayout string // time.Format layout for dates
+}
+
+// locales are the locales numbers and dates can be displayed in
+var locales = map[string]localeFormat{
+	"en-US": {".", ",", "01/02/2006"},
+	"en-GB": {".", ",", "02/01/2006"},
+	"de-DE": {",", ".", "02.01.2006"},
+	"de-CH": {".", "'", "02.01.2006"},
+	"fr-FR": {",", " ", "02/01/2006"},
+	"es-ES": {",", ".", "02/01/2006"},
+	"it-IT": {",", ".", "02/01/2006"},
+	"nl-NL": {",", ".", "02-01-2006"},
+	"pt-BR": {",", ".", "02/01/2006"},
+	"sv-SE": {",", " ", "2006-01-02"},
+	"ja-JP": {".", ",", "2006/01/02"},
+}
+
+// isoDateLayouts are the date formats recognized in cells, paired with the
+// time of day shown after the locale's date
+var isoDateLayouts = []struct{ layout, clock string }{
+	{"2006-01-02", ""},
+	{"2006-01-02 15:04:05", " 15:04:05"},
+	{"2006-01-02T15:04:05", " 15:04:05"},
+	{time.RFC3339, " 15:04:05 Z07:00"},
+}
+
+// localeFromConfig validates the configured display locale. The zero
+// localeFormat shows values as stored.
+func localeFromConfig(locale string) (localeFormat, error) {
+	if locale == "" {
+		return localeFormat{}, nil
+	}
+	format, ok := locales[locale]
+	if !ok {
+		names := make([]string, 0, len(locales))
+		for name := range locales {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return localeFormat{}, fmt.Errorf("unknown locale %q (use %s)", locale, strings.Join(names, ", "))
+	}
+	return format, nil
+}
+
+// format returns a number or ISO date the way the locale writes it, and any
+// other value unchanged
+func (l localeFormat) format(value string) string {
+	if l == (localeFormat{}) {
+		return value
+	}
+	trimmed := strings.TrimSpace(value)
+	switch detectDataType(trimmed) {
+	case DataTypeInt, DataTypeFloat:
+		return l.formatNumber(trimmed, value)
+	}
+	for _, date := range isoDateLayouts {
+		if t, err := time.Parse(date.layout, trimmed); err == nil {
+			return t.Format(l.dateLayout + date.clock)
+		}
+	}
+	return value
+}
+
+// formatNumber groups the digits of a plain decimal number and swaps in the
+// locale's decimal separator. Exponents, and integers with leading zeros
+// such as codes, are left as they are.
+func (l localeFormat) formatNumber(number, value string) string {
+	sign := ""
+	if number[0] == '-' || number[0] == '+' {
+		sign, number = number[:1], number[1:]
+	}
+	whole, fraction, hasFraction := strings.Cut(number, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" || strings.Trim(fraction, "0123456789") != "" ||
+		(len(whole) > 1 && whole[0] == '0') {
+		return value
+	}
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(l.group)
+		}
+		grouped.WriteRune(digit)
+	}
+	if hasFraction {
+		grouped.WriteString(l.decimal + fraction)
+	}
+	return sign + grouped.String()
+}
+
+// tableBorders are the border styles the table can be drawn with. The
+// borderless compact style is borderNone.
+var tableBorders = map[string]lipgloss.Border{
+	"normal":  lipgloss.NormalBorder(),
+	"rounded": lipgloss.RoundedBorder(),
+	"thick":   lipgloss.ThickBorder(),
+	"double":  lipgloss.DoubleBorder(),
+}
+
+const borderNone = "none"
+
+// borderFromConfig validates the configured table border style
+func borderFromConfig(border string) (string, error) {
+	if border == "" {
+		return "normal", nil
+	}
+	if _, ok := tableBorders[border]; !ok && border != borderNone {
+		return "normal", fmt.Errorf("unknown border %q (use normal, rounded, thick, double, or none)", border)
+	}
+	return border, nil
+}
+
+type HotkeyConfig struct {
+	// Keys used only while editing a cell, searching, or filtering, on top
+	// of the ones below, e.g. "editMode": {"Cancel": ["ctrl+g"]}
+	EditMode   *HotkeyConfig `json:"editMode,omitempty"`
+	SearchMode *HotkeyConfig `json:"searchMode,omitempty"`
+	FilterMode *HotkeyConfig `json:"filterMode,omitempty"`
+
+	Up               []string `json:"Up,omitempty"`
+	Down             []string `json:"Down,omitempty"`
+	Left             []string `json:"Left,omitempty"`
+	Right            []string `json:"Right,omitempty"`
+	PageUp           []string `json:"PageUp,omitempty"`
+	PageDown         []string `json:"PageDown,omitempty"`
+	PageLeft         []string `json:"PageLeft,omitempty"`
+	PageRight        []string `json:"PageRight,omitempty"`
+	Edit             []string `json:"Edit,omitempty"`
+	Help             []string `json:"Help,omitempty"`
+	Quit             []string `json:"Quit,omitempty"`
+	Save             []string `json:"Save,omitempty"`
+	Cancel           []string `json:"Cancel,omitempty"`
+	GoTo             []string `json:"GoTo,omitempty"`
+	Search           []string `json:"Search,omitempty"`
+	NextMatch        []string `json:"NextMatch,omitempty"`
+	PrevMatch        []string `json:"PrevMatch,omitempty"`
+	Tab              []string `json:"Tab,omitempty"`
+	Filter           []string `json:"Filter,omitempty"`
+	ResetFilters     []string `json:"ResetFilters,omitempty"`
+	Inspect          []string `json:"Inspect,omitempty"`
+	Replace          []string `json:"Replace,omitempty"`
+	TogglePretty     []string `json:"TogglePretty,omitempty"`
+	SaveMultiline    []string `json:"SaveMultiline,omitempty"`
+	ToggleGroup      []string `json:"ToggleGroup,omitempty"`
+	HelpGrow         []string `json:"HelpGrow,omitempty"`
+	HelpShrink       []string `json:"HelpShrink,omitempty"`
+	InsertRow        []string `json:"InsertRow,omitempty"`
+	SetTemplate      []string `json:"SetTemplate,omitempty"`
+	CopyCell         []string `json:"CopyCell,omitempty"`
+	CopyRow          []string `json:"CopyRow,omitempty"`
+	CopyColumn       []string `json:"CopyColumn,omitempty"`
+	HeaderMode       []string `json:"HeaderMode,omitempty"`
+	Paste            []string `json:"Paste,omitempty"`
+	DiffRevision     []string `json:"DiffRevision,omitempty"`
+	Visual           []string `json:"Visual,omitempty"`
+	Undo             []string `json:"Undo,omitempty"`
+	FillDown         []string `json:"FillDown,omitempty"`
+	BulkEdit         []string `json:"BulkEdit,omitempty"`
+	ExternalEdit     []string `json:"ExternalEdit,omitempty"`
+	ToggleAppend     []string `json:"ToggleAppend,omitempty"`
+	JumpMin          []string `json:"JumpMin,omitempty"`
+	JumpMax          []string `json:"JumpMax,omitempty"`
+	JumpBlank        []string `json:"JumpBlank,omitempty"`
+	SplitView        []string `json:"SplitView,omitempty"`
+	SwitchPane       []string `json:"SwitchPane,omitempty"`
+	FreezeRows       []string `json:"FreezeRows,omitempty"`
+	FreezeCols       []string `json:"FreezeCols,omitempty"`
+	ToggleRowNumbers []string `json:"ToggleRowNumbers,omitempty"`
+	CellNote         []string `json:"CellNote,omitempty"`
+	RowNote          []string `json:"RowNote,omitempty"`
+	WidenColumn      []string `json:"WidenColumn,omitempty"`
+	NarrowColumn     []string `json:"NarrowColumn,omitempty"`
+	Mark             []string `json:"Mark,omitempty"`
+	FilterMarks      []string `json:"FilterMarks,omitempty"`
+	WrapCells        []string `json:"WrapCells,omitempty"`
+	ExportMarked     []string `json:"ExportMarked,omitempty"`
+	ToggleMarkColumn []string `json:"ToggleMarkColumn,omitempty"`
+	SearchColumn     []string `json:"SearchColumn,omitempty"`
+	NextProblem      []string `json:"NextProblem,omitempty"`
+	PrevProblem      []string `json:"PrevProblem,omitempty"`
+	ProblemsList     []string `json:"ProblemsList,omitempty"`
+	ToggleLegend     []string `json:"ToggleLegend,omitempty"`
+	ToggleHelpBar    []string `json:"ToggleHelpBar,omitempty"`
+	CommandLine      []string `json:"CommandLine,omitempty"`
+	GoTop            []string `json:"GoTop,omitempty"`
+	GoBottom         []string `json:"GoBottom,omitempty"`
+	RecordMacro      []string `json:"RecordMacro,omitempty"`
+	PlayMacro        []string `json:"PlayMacro,omitempty"`
+	SetBookmark      []string `json:"SetBookmark,omitempty"`
+	Bookmarks        []string `json:"Bookmarks,omitempty"`
+	ColumnJump       []string `json:"ColumnJump,omitempty"`
+	ThemeEditor      []string `json:"ThemeEditor,omitempty"`
+	FirstColumn      []string `json:"FirstColumn,omitempty"`
+	LastColumn       []string `json:"LastColumn,omitempty"`
+	HalfPageDown     []string `json:"HalfPageDown,omitempty"`
+	HalfPageUp       []string `json:"HalfPageUp,omitempty"`
+	CenterCursor     []string `json:"CenterCursor,omitempty"`
+	ColumnStats      []string `json:"ColumnStats,omitempty"`
+	HideColumn       []string `json:"HideColumn,omitempty"`
+	ShowColumns      []string `json:"ShowColumns,omitempty"`
+	PinColumn        []string `json:"PinColumn,omitempty"`
+	OpenPreview      []string `json:"OpenPreview,omitempty"`
+	CopyFromPreview  []string `json:"CopyFromPreview,omitempty"`
+	PinRow           []string `json:"PinRow,omitempty"`
+	ClearTray        []string `json:"ClearTray,omitempty"`
+	ExportTray       []string `json:"ExportTray,omitempty"`
+	ToggleFormulaBar []string `json:"ToggleFormulaBar,omitempty"`
+	FixRaggedRows    []string `json:"FixRaggedRows,omitempty"`
+	FileComments     []string `json:"FileComments,omitempty"`
+	CaseUpper        []string `json:"CaseUpper,omitempty"`
+	CaseLower        []string `json:"CaseLower,omitempty"`
+	CaseTitle        []string `json:"CaseTitle,omitempty"`
+	PadColumn        []string `json:"PadColumn,omitempty"`
+	UnpadColumn      []string `json:"UnpadColumn,omitempty"`
+	ReformatDates    []string `json:"ReformatDates,omitempty"`
+	ConvertTimezone  []string `json:"ConvertTimezone,omitempty"`
+	ShowUnits        []string `json:"ShowUnits,omitempty"`
+	ConvertUnits     []string `json:"ConvertUnits,omitempty"`
+	ComputedColumn   []string `json:"ComputedColumn,omitempty"`
+	ToggleHeatmap    []string `json:"ToggleHeatmap,omitempty"`
+	ToggleDataBars   []string `json:"ToggleDataBars,omitempty"`
+	ExportSQL        []string `json:"ExportSQL,omitempty"`
+}
+
+// findConfigPath returns where the config file lives: ~/.csvtui.json, or
+// %APPDATA%\csvtui\config.json on Windows unless there is a ~/.csvtui.json
+func findConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %v", err)
+	}
+	homePath := filepath.Join(homeDir, ".csvtui.json")
+	if runtime.GOOS != "windows" {
+		return homePath, nil
+	}
+
+	if _, err := os.Stat(homePath); err == nil {
+		return homePath, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config directory: %v", err)
+	}
+	return filepath.Join(configDir, "csvtui", "config.json"), nil
+}
+
+func loadConfig() (*Config, error) {
+	configPath, err := findConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if config file exists
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		// Config file doesn't exist, return empty config (will use defaults)
+		return &Config{}, nil
+	}
+
+	// Read config file
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", configPath, err)
+	}
+
+	// Parse JSON
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", configPath, err)
+	}
+
+	return &config, nil
+}
+
+// writeConfigColors sets color settings in the config file, creating it if
+// needed, and returns its path. Other settings are kept as they are.
+func writeConfigColors(colors map[string]string) (string, error) {
+	configPath, err := findConfigPath()
+	if err != nil {
+		return "", err
+	}
+
+	config := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(configPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &config); err != nil {
+			return "", fmt.Errorf("failed to parse config file %s: %v", configPath, err)
+		}
+	case !os.IsNotExist(err):
+		return "", fmt.Errorf("failed to read config file %s: %v", configPath, err)
+	}
+
+	settings := make(map[string]any)
+	if raw, ok := config["colors"]; ok {
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return "", fmt.Errorf("failed to parse colors in %s: %v", configPath, err)
+		}
+	}
+	for setting, color := range colors {
+		settings[setting] = color
+	}
+	if config["colors"], err = json.Marshal(settings); err != nil {
+		return "", err
+	}
+
+	if data, err = json.MarshalIndent(config, "", "  "); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %v", filepath.Dir(configPath), err)
+	}
+	if err := os.WriteFile(configPath, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write config file %s: %v", configPath, err)
+	}
+	return configPath, nil
+}
+
+func getDefaultColors() map[DataType]lipgloss.Color {
+	return map[DataType]lipgloss.Color{
+		DataTypeString: lipgloss.Color("#87CEEB"), // Sky blue for strings
+		DataTypeInt:    lipgloss.Color("#90EE90"), // Light green for integers
+		DataTypeFloat:  lipgloss.Color("#FFB6C1"), // Light pink for floats
+		DataTypeBool:   lipgloss.Color("#DDA0DD"), // Plum for booleans
+		DataTypeEmpty:  lipgloss.Color("#D3D3D3"), // Light gray for empty
+	}
+}
+
+func getDefaultDimColors() map[DataType]lipgloss.Color {
+	return map[DataType]lipgloss.Color{
+		DataTypeString: lipgloss.Color("#4682B4"), // Steel blue (dimmer)
+		DataTypeInt:    lipgloss.Color("#6B8E23"), // Olive drab (dimmer)
+		DataTypeFloat:  lipgloss.Color("#CD5C5C"), // Indian red (dimmer)
+		DataTypeBool:   lipgloss.Color("#9370DB"), // Medium purple (dimmer)
+		DataTypeEmpty:  lipgloss.Color("#A9A9A9"), // Dark gray (dimmer)
+	}
+}
+
+func applyConfigColors(config *Config, defaultColors, defaultDimColors map[DataType]lipgloss.Color) (map[DataType]lipgloss.Color, map[DataType]lipgloss.Color) {
+	colors := make(map[DataType]lipgloss.Color)
+	dimColors := make(map[DataType]lipgloss.Color)
+
+	// Copy defaults
+	for k, v := range defaultColors {
+		colors[k] = v
+	}
+	for k, v := range defaultDimColors {
+		dimColors[k] = v
+	}
+
+	// Apply config overrides
+	if config.Colors.DataTypeString != "" {
+		colors[DataTypeString] = lipgloss.Color(config.Colors.DataTypeString)
+		// Create a dimmer version by using the same color but with reduced brightness
+		dimColors[DataTypeString] = lipgloss.Color(config.Colors.DataTypeString)
+	}
+	if config.Colors.DataTypeInt != "" {
+		colors[DataTypeInt] = lipgloss.Color(config.Colors.DataTypeInt)
+		dimColors[DataTypeInt] = lipgloss.Color(config.Colors.DataTypeInt)
+	}
+	if config.Colors.DataTypeFloat != "" {
+		colors[DataTypeFloat] = lipgloss.Color(config.Colors.DataTypeFloat)
+		dimColors[DataTypeFloat] = lipgloss.Color(config.Colors.DataTypeFloat)
+	}
+	if config.Colors.DataTypeBool != "" {
+		colors[DataTypeBool] = lipgloss.Color(config.Colors.DataTypeBool)
+		dimColors[DataTypeBool] = lipgloss.Color(config.Colors.DataTypeBool)
+	}
+	if config.Colors.DataTypeEmpty != "" {
+		colors[DataTypeEmpty] = lipgloss.Color(config.Colors.DataTypeEmpty)
+		dimColors[DataTypeEmpty] = lipgloss.Color(config.Colors.DataTypeEmpty)
+	}
+
+	return colors, dimColors
+}
+
+func getDefaultHotkeys() map[string][]string {
+	return map[string][]string{
+		"Up":               {"up", "k"},
+		"Down":             {"down", "j"},
+		"Left":             {"left", "h"},
+		"Right":            {"right", "l"},
+		"PageUp":           {"pgup", "i"},
+		"PageDown":         {"pgdown", "u"},
+		"PageLeft":         {"y"},
+		"PageRight":        {"o"},
+		"Edit":             {"e"},
+		"Help":             {"?"},
+		"Quit":             {"q", "ctrl+c"},
+		"Save":             {"enter"},
+		"Cancel":           {"esc"},
+		"GoTo":             {"\\"},
+		"Search":           {" "},
+		"NextMatch":        {"n"},
+		"PrevMatch":        {"b"},
+		"Tab":              {"tab"},
+		"Filter":           {"~"},
+		"ResetFilters":     {"="},
+		"Inspect":          {"I"},
+		"Replace":          {"r"},
+		"TogglePretty":     {"P"},
+		"SaveMultiline":    {"ctrl+s"},
+		"ToggleGroup":      {"ctrl+g"},
+		"HelpGrow":         {"+"},
+		"HelpShrink":       {"-"},
+		"InsertRow":        {"a"},
+		"SetTemplate":      {"T"},
+		"CopyCell":         {"c"},
+		"CopyRow":          {"C"},
+		"CopyColumn":       {"alt+c"},
+		"HeaderMode":       {"H"},
+		"Paste":            {"p"},
+		"DiffRevision":     {"D"},
+		"Visual":           {"v"},
+		"Undo":             {"U"},
+		"FillDown":         {"F"},
+		"BulkEdit":         {"E"},
+		"ExternalEdit":     {"ctrl+e"},
+		"ToggleAppend":     {"ctrl+t"},
+		"JumpMin":          {"["},
+		"JumpMax":          {"]"},
+		"JumpBlank":        {"_"},
+		"SplitView":        {"S"},
+		"SwitchPane":       {"ctrl+w"},
+		"FreezeRows":       {"Z"},
+		"FreezeCols":       {"ctrl+f"},
+		"ToggleRowNumbers": {"#"},
+		"CellNote":         {"N"},
+		"RowNote":          {"alt+n"},
+		"WidenColumn":      {">"},
+		"NarrowColumn":     {"<"},
+		"Mark":             {"m"},
+		"FilterMarks":      {"M"},
+		"WrapCells":        {"W"},
+		"ExportMarked":     {"X"},
+		"ToggleMarkColumn": {"ctrl+l"},
+		"SearchColumn":     {"/"},
+		"NextProblem":      {"}"},
+		"PrevProblem":      {"{"},
+		"ProblemsList":     {"!"},
+		"ToggleLegend":     {"L"},
+		"ToggleHelpBar":    {"B"},
+		"CommandLine":      {":"},
+		"GoTop":            {"g g", "home"},
+		"GoBottom":         {"G", "end"},
+		"RecordMacro":      {"Q"},
+		"PlayMacro":        {"@"},
+		"SetBookmark":      {"`"},
+		"Bookmarks":        {"'"},
+		"ColumnJump":       {"f"},
+		"ThemeEditor":      {"alt+t"},
+		"FirstColumn":      {"0"},
+		"LastColumn":       {"$"},
+		"HalfPageDown":     {"ctrl+d"},
+		"HalfPageUp":       {"ctrl+u"},
+		"CenterCursor":     {"z z"},
+		"ColumnStats":      {"<leader> c s"},
+		"HideColumn":       {"<leader> c h"},
+		"ShowColumns":      {"<leader> c u"},
+		"PinColumn":        {"<leader> c p"},
+		"OpenPreview":      {"O"},
+		"CopyFromPreview":  {"<leader> y"},
+		"PinRow":           {"t"},
+		"ClearTray":        {"<leader> t c"},
+		"ExportTray":       {"<leader> t e"},
+		"ToggleFormulaBar": {"alt+e"},
+		"FixRaggedRows":    {"<leader> r"},
+		"FileComments":     {"<leader> i"},
+		"CaseUpper":        {"<leader> c U"},
+		"CaseLower":        {"<leader> c L"},
+		"CaseTitle":        {"<leader> c T"},
+		"PadColumn":        {"<leader> c 0"},
+		"UnpadColumn":      {"<leader> c z"},
+		"ReformatDates":    {"<leader> c d"},
+		"ConvertTimezone":  {"<leader> c t"},
+		"ShowUnits":        {"<leader> c n"},
+		"ConvertUnits":     {"<leader> c N"},
+		"ComputedColumn":   {"<leader> c r"},
+		"ToggleHeatmap":    {"<leader> c m"},
+		"ToggleDataBars":   {"<leader> c b"},
+		"ExportSQL":        {"<leader> s"},
+	}
+}
+
+// keyPresets are alternative sets of bindings, selected with the keyPreset
+// setting or the -keys flag. Each replaces some of the default bindings, and
+// the hotkeys setting still overrides both.
+var keyPresets = map[string]map[string][]string{
+	"default": {},
+	// Closer to vim: ctrl+b/ctrl+f paging, / to search, N for the previous
+	// match, u to undo, i to edit, o to insert a row, and y y to yank
+	"vim": {
+		"PageUp":       {"pgup", "ctrl+b"},
+		"PageDown":     {"pgdown", "ctrl+f"},
+		"PageLeft":     {"alt+h"},
+		"PageRight":    {"alt+l"},
+		"FreezeCols":   {"alt+f"},
+		"Edit":         {"i", "e"},
+		"InsertRow":    {"o", "a"},
+		"Undo":         {"u"},
+		"Search":       {"/"},
+		"SearchColumn": {"alt+/"},
+		"PrevMatch":    {"N"},
+		"CellNote":     {"ctrl+n"},
+		"CopyCell":     {"y y"},
+		"CopyRow":      {"Y"},
+	},
+	// Emacs movement and paging, with ctrl+x sequences for the bindings
+	// those displace
+	"emacs": {
+		"Up":           {"up", "ctrl+p"},
+		"Down":         {"down", "ctrl+n"},
+		"Left":         {"left", "ctrl+b"},
+		"Right":        {"right", "ctrl+f"},
+		"PageUp":       {"pgup", "alt+v"},
+		"PageDown":     {"pgdown", "ctrl+v"},
+		"GoTop":        {"home", "alt+<"},
+		"GoBottom":     {"end", "alt+>"},
+		"FirstColumn":  {"ctrl+a"},
+		"LastColumn":   {"ctrl+e"},
+		"ExternalEdit": {"ctrl+x ctrl+e"},
+		"FreezeCols":   {"ctrl+x f"},
+		"Search":       {"ctrl+s"},
+		"PrevMatch":    {"ctrl+r"},
+		"Cancel":       {"esc", "ctrl+g"},
+		"ToggleGroup":  {"ctrl+x g"},
+		"Undo":         {"ctrl+_"},
+		"CommandLine":  {":", "alt+x"},
+		"GoTo":         {"\\", "alt+g"},
+		"CopyCell":     {"c", "alt+w"},
+		"Paste":        {"p", "ctrl+y"},
+		"Quit":         {"q", "ctrl+c", "ctrl+x ctrl+c"},
+	},
+	// Navigation on the arrow and paging keys only, leaving h, j, k, l, i,
+	// u, y, and o unbound
+	"arrows-only": {
+		"Up":          {"up"},
+		"Down":        {"down"},
+		"Left":        {"left"},
+		"Right":       {"right"},
+		"PageUp":      {"pgup"},
+		"PageDown":    {"pgdown"},
+		"PageLeft":    {"shift+left"},
+		"PageRight":   {"shift+right"},
+		"GoTop":       {"home", "ctrl+home"},
+		"GoBottom":    {"end", "ctrl+end"},
+		"FirstColumn": {"shift+home"},
+		"LastColumn":  {"shift+end"},
+	},
+	// Movement on the keys under h, j, k, l on a QWERTY keyboard, which
+	// are h, n, e, i on Colemak
+	"colemak": {
+		"Up":        {"up", "e"},
+		"Down":      {"down", "n"},
+		"Left":      {"left", "h"},
+		"Right":     {"right", "i"},
+		"Edit":      {"k"},
+		"NextMatch": {"j"},
+		"PageUp":    {"pgup", "l"},
+	},
+}
+
+// keyPresetNames lists the key presets for messages
+func keyPresetNames() string {
+	names := make([]string, 0, len(keyPresets))
+	for name := range keyPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func applyConfigHotkeys(config *Config, defaults map[string][]string) map[string][]string {
+	hotkeys := make(map[string][]string)
+
+	// Copy defaults
+	for k, v := range defaults {
+		hotkeys[k] = make([]string, len(v))
+		copy(hotkeys[k], v)
+	}
+
+	// Apply the preset
+	preset, ok := keyPresets[config.KeyPreset]
+	if !ok && config.KeyPreset != "" {
+		fmt.Fprintf(os.Stderr, "Warning: unknown key preset %q (available: %s), using the default keys\n", config.KeyPreset, keyPresetNames())
+	}
+	for name, keys := range preset {
+		hotkeys[name] = keys
+	}
+
+	// Apply config overrides
+	config.Hotkeys.apply(hotkeys)
+	tidyHotkeys(hotkeys, config.Leader)
+	for _, conflict := range hotkeyConflicts(hotkeys) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", conflict)
+	}
+
+	return hotkeys
+}
+
+// apply overrides the keys of the bindings set in the config
+func (h HotkeyConfig) apply(hotkeys map[string][]string) {
+	if len(h.Up) > 0 {
+		hotkeys["Up"] = h.Up
+	}
+	if len(h.Down) > 0 {
+		hotkeys["Down"] = h.Down
+	}
+	if len(h.Left) > 0 {
+		hotkeys["Left"] = h.Left
+	}
+	if len(h.Right) > 0 {
+		hotkeys["Right"] = h.Right
+	}
+	if len(h.PageUp) > 0 {
+		hotkeys["PageUp"] = h.PageUp
+	}
+	if len(h.PageDown) > 0 {
+		hotkeys["PageDown"] = h.PageDown
+	}
+	if len(h.PageLeft) > 0 {
+		hotkeys["PageLeft"] = h.PageLeft
+	}
+	if len(h.PageRight) > 0 {
+		hotkeys["PageRight"] = h.PageRight
+	}
+	if len(h.Edit) > 0 {
+		hotkeys["Edit"] = h.Edit
+	}
+	if len(h.Help) > 0 {
+		hotkeys["Help"] = h.Help
+	}
+	if len(h.Quit) > 0 {
+		hotkeys["Quit"] = h.Quit
+	}
+	if len(h.Save) > 0 {
+		hotkeys["Save"] = h.Save
+	}
+	if len(h.Cancel) > 0 {
+		hotkeys["Cancel"] = h.Cancel
+	}
+	if len(h.GoTo) > 0 {
+		hotkeys["GoTo"] = h.GoTo
+	}
+	if len(h.Search) > 0 {
+		hotkeys["Search"] = h.Search
+	}
+	if len(h.NextMatch) > 0 {
+		hotkeys["NextMatch"] = h.NextMatch
+	}
+	if len(h.PrevMatch) > 0 {
+		hotkeys["PrevMatch"] = h.PrevMatch
+	}
+	if len(h.Tab) > 0 {
+		hotkeys["Tab"] = h.Tab
+	}
+	if len(h.Filter) > 0 {
+		hotkeys["Filter"] = h.Filter
+	}
+	if len(h.ResetFilters) > 0 {
+		hotkeys["ResetFilters"] = h.ResetFilters
+	}
+	if len(h.Inspect) > 0 {
+		hotkeys["Inspect"] = h.Inspect
+	}
+	if len(h.Replace) > 0 {
+		hotkeys["Replace"] = h.Replace
+	}
+	if len(h.TogglePretty) > 0 {
+		hotkeys["TogglePretty"] = h.TogglePretty
+	}
+	if len(h.SaveMultiline) > 0 {
+		hotkeys["SaveMultiline"] = h.SaveMultiline
+	}
+	if len(h.ToggleGroup) > 0 {
+		hotkeys["ToggleGroup"] = h.ToggleGroup
+	}
+	if len(h.HelpGrow) > 0 {
+		hotkeys["HelpGrow"] = h.HelpGrow
+	}
+	if len(h.HelpShrink) > 0 {
+		hotkeys["HelpShrink"] = h.HelpShrink
+	}
+	if len(h.InsertRow) > 0 {
+		hotkeys["InsertRow"] = h.InsertRow
+	}
+	if len(h.SetTemplate) > 0 {
+		hotkeys["SetTemplate"] = h.SetTemplate
+	}
+	if len(h.CopyCell) > 0 {
+		hotkeys["CopyCell"] = h.CopyCell
+	}
+	if len(h.CopyRow) > 0 {
+		hotkeys["CopyRow"] = h.CopyRow
+	}
+	if len(h.CopyColumn) > 0 {
+		hotkeys["CopyColumn"] = h.CopyColumn
+	}
+	if len(h.HeaderMode) > 0 {
+		hotkeys["HeaderMode"] = h.HeaderMode
+	}
+	if len(h.Paste) > 0 {
+		hotkeys["Paste"] = h.Paste
+	}
+	if len(h.DiffRevision) > 0 {
+		hotkeys["DiffRevision"] = h.DiffRevision
+	}
+	if len(h.Visual) > 0 {
+		hotkeys["Visual"] = h.Visual
+	}
+	if len(h.Undo) > 0 {
+		hotkeys["Undo"] = h.Undo
+	}
+	if len(h.FillDown) > 0 {
+		hotkeys["FillDown"] = h.FillDown
+	}
+	if len(h.BulkEdit) > 0 {
+		hotkeys["BulkEdit"] = h.BulkEdit
+	}
+	if len(h.ExternalEdit) > 0 {
+		hotkeys["ExternalEdit"] = h.ExternalEdit
+	}
+	if len(h.ToggleAppend) > 0 {
+		hotkeys["ToggleAppend"] = h.ToggleAppend
+	}
+	if len(h.JumpMin) > 0 {
+		hotkeys["JumpMin"] = h.JumpMin
+	}
+	if len(h.JumpMax) > 0 {
+		hotkeys["JumpMax"] = h.JumpMax
+	}
+	if len(h.JumpBlank) > 0 {
+		hotkeys["JumpBlank"] = h.JumpBlank
+	}
+	if len(h.SplitView) > 0 {
+		hotkeys["SplitView"] = h.SplitView
+	}
+	if len(h.SwitchPane) > 0 {
+		hotkeys["SwitchPane"] = h.SwitchPane
+	}
+	if len(h.FreezeRows) > 0 {
+		hotkeys["FreezeRows"] = h.FreezeRows
+	}
+	if len(h.FreezeCols) > 0 {
+		hotkeys["FreezeCols"] = h.FreezeCols
+	}
+	if len(h.ToggleRowNumbers) > 0 {
+		hotkeys["ToggleRowNumbers"] = h.ToggleRowNumbers
+	}
+	if len(h.CellNote) > 0 {
+		hotkeys["CellNote"] = h.CellNote
+	}
+	if len(h.RowNote) > 0 {
+		hotkeys["RowNote"] = h.RowNote
+	}
+	if len(h.WidenColumn) > 0 {
+		hotkeys["WidenColumn"] = h.WidenColumn
+	}
+	if len(h.NarrowColumn) > 0 {
+		hotkeys["NarrowColumn"] = h.NarrowColumn
+	}
+	if len(h.Mark) > 0 {
+		hotkeys["Mark"] = h.Mark
+	}
+	if len(h.FilterMarks) > 0 {
+		hotkeys["FilterMarks"] = h.FilterMarks
+	}
+	if len(h.WrapCells) > 0 {
+		hotkeys["WrapCells"] = h.WrapCells
+	}
+	if len(h.ExportMarked) > 0 {
+		hotkeys["ExportMarked"] = h.ExportMarked
+	}
+	if len(h.ToggleMarkColumn) > 0 {
+		hotkeys["ToggleMarkColumn"] = h.ToggleMarkColumn
+	}
+	if len(h.SearchColumn) > 0 {
+		hotkeys["SearchColumn"] = h.SearchColumn
+	}
+	if len(h.NextProblem) > 0 {
+		hotkeys["NextProblem"] = h.NextProblem
+	}
+	if len(h.PrevProblem) > 0 {
+		hotkeys["PrevProblem"] = h.PrevProblem
+	}
+	if len(h.ProblemsList) > 0 {
+		hotkeys["ProblemsList"] = h.ProblemsList
+	}
+	if len(h.ToggleLegend) > 0 {
+		hotkeys["ToggleLegend"] = h.ToggleLegend
+	}
+	if len(h.ToggleHelpBar) > 0 {
+		hotkeys["ToggleHelpBar"] = h.ToggleHelpBar
+	}
+	if len(h.CommandLine) > 0 {
+		hotkeys["CommandLine"] = h.CommandLine
+	}
+	if len(h.GoTop) > 0 {
+		hotkeys["GoTop"] = h.GoTop
+	}
+	if len(h.GoBottom) > 0 {
+		hotkeys["GoBottom"] = h.GoBottom
+	}
+
+	if len(h.RecordMacro) > 0 {
+		hotkeys["RecordMacro"] = h.RecordMacro
+	}
+	if len(h.PlayMacro) > 0 {
+		hotkeys["PlayMacro"] = h.PlayMacro
+	}
+	if len(h.SetBookmark) > 0 {
+		hotkeys["SetBookmark"] = h.SetBookmark
+	}
+	if len(h.Bookmarks) > 0 {
+		hotkeys["Bookmarks"] = h.Bookmarks
+	}
+	if len(h.ColumnJump) > 0 {
+		hotkeys["ColumnJump"] = h.ColumnJump
+	}
+	if len(h.ThemeEditor) > 0 {
+		hotkeys["ThemeEditor"] = h.ThemeEditor
+	}
+	if len(h.FirstColumn) > 0 {
+		hotkeys["FirstColumn"] = h.FirstColumn
+	}
+	if len(h.LastColumn) > 0 {
+		hotkeys["LastColumn"] = h.LastColumn
+	}
+	if len(h.HalfPageDown) > 0 {
+		hotkeys["HalfPageDown"] = h.HalfPageDown
+	}
+	if len(h.HalfPageUp) > 0 {
+		hotkeys["HalfPageUp"] = h.HalfPageUp
+	}
+	if len(h.CenterCursor) > 0 {
+		hotkeys["CenterCursor"] = h.CenterCursor
+	}
+	if len(h.ColumnStats) > 0 {
+		hotkeys["ColumnStats"] = h.ColumnStats
+	}
+	if len(h.HideColumn) > 0 {
+		hotkeys["HideColumn"] = h.HideColumn
+	}
+	if len(h.ShowColumns) > 0 {
+		hotkeys["ShowColumns"] = h.ShowColumns
+	}
+	if len(h.PinColumn) > 0 {
+		hotkeys["PinColumn"] = h.PinColumn
+	}
+	if len(h.OpenPreview) > 0 {
+		hotkeys["OpenPreview"] = h.OpenPreview
+	}
+	if len(h.CopyFromPreview) > 0 {
+		hotkeys["CopyFromPreview"] = h.CopyFromPreview
+	}
+	if len(h.PinRow) > 0 {
+		hotkeys["PinRow"] = h.PinRow
+	}
+	if len(h.ClearTray) > 0 {
+		hotkeys["ClearTray"] = h.ClearTray
+	}
+	if len(h.ExportTray) > 0 {
+		hotkeys["ExportTray"] = h.ExportTray
+	}
+	if len(h.ToggleFormulaBar) > 0 {
+		hotkeys["ToggleFormulaBar"] = h.ToggleFormulaBar
+	}
+	if len(h.FixRaggedRows) > 0 {
+		hotkeys["FixRaggedRows"] = h.FixRaggedRows
+	}
+	if len(h.FileComments) > 0 {
+		hotkeys["FileComments"] = h.FileComments
+	}
+	if len(h.CaseUpper) > 0 {
+		hotkeys["CaseUpper"] = h.CaseUpper
+	}
+	if len(h.CaseLower) > 0 {
+		hotkeys["CaseLower"] = h.CaseLower
+	}
+	if len(h.CaseTitle) > 0 {
+		hotkeys["CaseTitle"] = h.CaseTitle
+	}
+	if len(h.PadColumn) > 0 {
+		hotkeys["PadColumn"] = h.PadColumn
+	}
+	if len(h.UnpadColumn) > 0 {
+		hotkeys["UnpadColumn"] = h.UnpadColumn
+	}
+	if len(h.ReformatDates) > 0 {
+		hotkeys["ReformatDates"] = h.ReformatDates
+	}
+	if len(h.ConvertTimezone) > 0 {
+		hotkeys["ConvertTimezone"] = h.ConvertTimezone
+	}
+	if len(h.ShowUnits) > 0 {
+		hotkeys["ShowUnits"] = h.ShowUnits
+	}
+	if len(h.ConvertUnits) > 0 {
+		hotkeys["ConvertUnits"] = h.ConvertUnits
+	}
+	if len(h.ComputedColumn) > 0 {
+		hotkeys["ComputedColumn"] = h.ComputedColumn
+	}
+	if len(h.ToggleHeatmap) > 0 {
+		hotkeys["ToggleHeatmap"] = h.ToggleHeatmap
+	}
+	if len(h.ToggleDataBars) > 0 {
+		hotkeys["ToggleDataBars"] = h.ToggleDataBars
+	}
+	if len(h.ExportSQL) > 0 {
+		hotkeys["ExportSQL"] = h.ExportSQL
+	}
+}
+
+// tidyHotkeys fills in the leader key and tidies the spacing of key
+// sequences so they match what's typed
+func tidyHotkeys(hotkeys map[string][]string, leader string) {
+	switch leader {
+	case "":
+		leader = ","
+	case " ":
+		leader = "space"
+	}
+	for name, keys := range hotkeys {
+		tidied := make([]string, len(keys))
+		for i, k := range keys {
+			tidied[i] = k
+			if fields := strings.Fields(k); len(fields) > 1 {
+				for j, field := range fields {
+					if field == "<leader>" {
+						fields[j] = leader
+					}
+				}
+				tidied[i] = strings.Join(fields, " ")
+			}
+		}
+		hotkeys[name] = tidied
+	}
+}
+
+// hotkeyConflicts describes the keys that leave a binding unreachable: keys
+// bound to more than one binding, and keys bound alone that also start a
+// sequence, which waits for the next key instead
+func hotkeyConflicts(hotkeys map[string][]string) []string {
+	bound := make(map[string][]string)
+	for name, keys := range hotkeys {
+		// Only read in the multiline editor, where the table keys aren't
+		if name == "SaveMultiline" {
+			continue
+		}
+		for _, k := range keys {
+			if k == " " {
+				k = "space"
+			}
+			if !slices.Contains(bound[k], name) {
+				bound[k] = append(bound[k], name)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(bound))
+	for k := range bound {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var conflicts []string
+	shadowed := make(map[string]bool)
+	for _, k := range keys {
+		names := bound[k]
+		sort.Strings(names)
+		if len(names) > 1 {
+			conflicts = append(conflicts, fmt.Sprintf("%q is bound to both %s", k, strings.Join(names, " and ")))
+		}
+		fields := strings.Fields(k)
+		for i := 1; i < len(fields); i++ {
+			prefix := strings.Join(fields[:i], " ")
+			if others, ok := bound[prefix]; ok && !shadowed[prefix] {
+				shadowed[prefix] = true
+				conflicts = append(conflicts, fmt.Sprintf("%q (%s) can't be typed alone since sequences such as %q (%s) start with it",
+					prefix, strings.Join(others, ", "), k, names[0]))
+			}
+		}
+	}
+	return conflicts
+}
+
+// modeKeyMaps builds the key maps of the modes with their own keys in the
+// config, each starting from the normal-mode keys
+func modeKeyMaps(config *Config, hotkeys map[string][]string) map[string]keyMap {
+	modes := map[string]*HotkeyConfig{
+		"edit":   config.Hotkeys.EditMode,
+		"search": config.Hotkeys.SearchMode,
+		"filter": config.Hotkeys.FilterMode,
+	}
+	keyMaps := make(map[string]keyMap)
+	for mode, overrides := range modes {
+		if overrides == nil {
+			continue
+		}
+		modeHotkeys := make(map[string][]string, len(hotkeys))
+		for name, keys := range hotkeys {
+			modeHotkeys[name] = keys
+		}
+		overrides.apply(modeHotkeys)
+		tidyHotkeys(modeHotkeys, config.Leader)
+		keyMaps[mode] = createKeyMapFromConfig(modeHotkeys)
+	}
+	return keyMaps
+}
+
+// keysFor returns the key map used in a mode: "edit", "search", or "filter"
+func (m model) keysFor(mode string) keyMap {
+	if keys, ok := m.modeKeys[mode]; ok {
+		return keys
+	}
+	return m.keys
+}
+
+// keyName returns the name of a key press as written in a two-key sequence,
+// where the space bar is "space"
+func keyName(msg tea.KeyMsg) string {
+	if msg.String() == " " {
+		return "space"
+	}
+	return msg.String()
+}
+
+// isSequencePrefix reports whether keys typed so far, e.g. "g" or ", c",
+// start a longer bound sequence. Such keys wait for the next key instead of
+// acting alone.
+func (k keyMap) isSequencePrefix(sequence string) bool {
+	for _, group := range k.FullHelp() {
+		for _, binding := range group {
+			for _, keys := range binding.Keys() {
+				if strings.HasPrefix(keys, sequence+" ") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isBound reports whether a key or key sequence is bound to anything
+func (k keyMap) isBound(sequence string) bool {
+	for _, group := range k.FullHelp() {
+		for _, binding := range group {
+			if slices.Contains(binding.Keys(), sequence) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sequenceHint is a key that can follow a partly typed sequence, with what
+// it does or how many bindings it leads on to
+type sequenceHint struct {
+	key, desc string
+}
+
+// sequenceHints lists the keys that can follow a partly typed sequence
+func (k keyMap) sequenceHints(prefix string) []sequenceHint {
+	descs := make(map[string][]string)
+	for _, group := range k.FullHelp() {
+		for _, binding := range group {
+			for _, keys := range binding.Keys() {
+				if rest, ok := strings.CutPrefix(keys, prefix+" "); ok {
+					next := strings.Fields(rest)[0]
+					if next == rest {
+						descs[next] = append(descs[next], binding.Help().Desc)
+					} else {
+						descs[next] = append(descs[next], "")
+					}
+				}
+			}
+		}
+	}
+
+	hints := make([]sequenceHint, 0, len(descs))
+	for next, desc := range descs {
+		hint := sequenceHint{key: next, desc: desc[0]}
+		if len(desc) > 1 || desc[0] == "" {
+			hint.desc = fmt.Sprintf("+%d more", len(desc))
+		}
+		hints = append(hints, hint)
+	}
+	sort.Slice(hints, func(i, j int) bool { return hints[i].key < hints[j].key })
+	return hints
+}
+
+// sequenceHintView renders a which-key style line of what can follow the
+// keys typed so far, in place of the help line
+func (m model) sequenceHintView() string {
+	keyStyle := m.help.Styles.ShortKey
+	descStyle := m.help.Styles.ShortDesc
+	parts := []string{m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true).Render(m.pendingKey + " …")}
+	for _, hint := range m.keys.sequenceHints(m.pendingKey) {
+		parts = append(parts, keyStyle.Render(hint.key)+" "+descStyle.Render(hint.desc))
+	}
+	return ansi.Truncate(strings.Join(parts, m.help.Styles.ShortSeparator.Render(" • ")), m.width, "…")
+}
+
+// helpKeys returns the keys the help shows for a binding: its usual
+// description while it has the default keys, otherwise the keys bound
+func helpKeys(hotkeys map[string][]string, name, defaultHelp string) string {
+	if slices.Equal(hotkeys[name], getDefaultHotkeys()[name]) {
+		return defaultHelp
+	}
+	keys := make([]string, len(hotkeys[name]))
+	for i, k := range hotkeys[name] {
+		keys[i] = k
+		if k == " " {
+			keys[i] = "space"
+		}
+	}
+	return strings.Join(keys, "/")
+}
+
+func createKeyMapFromConfig(hotkeys map[string][]string) keyMap {
+	return keyMap{
+		Up: key.NewBinding(
+			key.WithKeys(hotkeys["Up"]...),
+			key.WithHelp(helpKeys(hotkeys, "Up", "↑/k"), "move up"),
+		),
+		Down: key.NewBinding(
+			key.WithKeys(hotkeys["Down"]...),
+			key.WithHelp(helpKeys(hotkeys, "Down", "↓/j"), "move down"),
+		),
+		Left: key.NewBinding(
+			key.WithKeys(hotkeys["Left"]...),
+			key.WithHelp(helpKeys(hotkeys, "Left", "←/h"), "move left"),
+		),
+		Right: key.NewBinding(
+			key.WithKeys(hotkeys["Right"]...),
+			key.WithHelp(helpKeys(hotkeys, "Right", "→/l"), "move right"),
+		),
+		PageUp: key.NewBinding(
+			key.WithKeys(hotkeys["PageUp"]...),
+			key.WithHelp(helpKeys(hotkeys, "PageUp", "pgup/i"), "page up"),
+		),
+		PageDown: key.NewBinding(
+			key.WithKeys(hotkeys["PageDown"]...),
+			key.WithHelp(helpKeys(hotkeys, "PageDown", "pgdn/u"), "page down"),
+		),
+		PageLeft: key.NewBinding(
+			key.WithKeys(hotkeys["PageLeft"]...),
+			key.WithHelp(helpKeys(hotkeys, "PageLeft", "y"), "page left"),
+		),
+		PageRight: key.NewBinding(
+			key.WithKeys(hotkeys["PageRight"]...),
+			key.WithHelp(helpKeys(hotkeys, "PageRight", "o"), "page right"),
+		),
+		Edit: key.NewBinding(
+			key.WithKeys(hotkeys["Edit"]...),
+			key.WithHelp(helpKeys(hotkeys, "Edit", "e"), "edit cell"),
+		),
+		Help: key.NewBinding(
+			key.WithKeys(hotkeys["Help"]...),
+			key.WithHelp(helpKeys(hotkeys, "Help", "?"), "toggle help"),
+		),
+		Quit: key.NewBinding(
+			key.WithKeys(hotkeys["Quit"]...),
+			key.WithHelp(helpKeys(hotkeys, "Quit", "q"), "quit"),
+		),
+		Save: key.NewBinding(
+			key.WithKeys(hotkeys["Save"]...),
+			key.WithHelp(helpKeys(hotkeys, "Save", "enter"), "save edit"),
+		),
+		Cancel: key.NewBinding(
+			key.WithKeys(hotkeys["Cancel"]...),
+			key.WithHelp(helpKeys(hotkeys, "Cancel", "esc"), "cancel"),
+		),
+		GoTo: key.NewBinding(
+			key.WithKeys(hotkeys["GoTo"]...),
+			key.WithHelp(helpKeys(hotkeys, "GoTo", "\\"), "go to position"),
+		),
+		Search: key.NewBinding(
+			key.WithKeys(hotkeys["Search"]...),
+			key.WithHelp(helpKeys(hotkeys, "Search", "space"), "search"),
+		),
+		NextMatch: key.NewBinding(
+			key.WithKeys(hotkeys["NextMatch"]...),
+			key.WithHelp(helpKeys(hotkeys, "NextMatch", "n"), "next match"),
+		),
+		PrevMatch: key.NewBinding(
+			key.WithKeys(hotkeys["PrevMatch"]...),
+			key.WithHelp(helpKeys(hotkeys, "PrevMatch", "b"), "prev match"),
+		),
+		Tab: key.NewBinding(
+			key.WithKeys(hotkeys["Tab"]...),
+			key.WithHelp(helpKeys(hotkeys, "Tab", "tab"), "next field"),
+		),
+		Filter: key.NewBinding(
+			key.WithKeys(hotkeys["Filter"]...),
+			key.WithHelp(helpKeys(hotkeys, "Filter", "~"), "filter data"),
+		),
+		ResetFilters: key.NewBinding(
+			key.WithKeys(hotkeys["ResetFilters"]...),
+			key.WithHelp(helpKeys(hotkeys, "ResetFilters", "="), "reset filters"),
+		),
+		Inspect: key.NewBinding(
+			key.WithKeys(hotkeys["Inspect"]...),
+			key.WithHelp(helpKeys(hotkeys, "Inspect", "I"), "toggle inspector"),
+		),
+		Replace: key.NewBinding(
+			key.WithKeys(hotkeys["Replace"]...),
+			key.WithHelp(helpKeys(hotkeys, "Replace", "r"), "find & replace"),
+		),
+		TogglePretty: key.NewBinding(
+			key.WithKeys(hotkeys["TogglePretty"]...),
+			key.WithHelp(helpKeys(hotkeys, "TogglePretty", "P"), "pretty-print JSON/XML"),
+		),
+		SaveMultiline: key.NewBinding(
+			key.WithKeys(hotkeys["SaveMultiline"]...),
+			key.WithHelp(helpKeys(hotkeys, "SaveMultiline", "ctrl+s"), "save multi-line edit"),
+		),
+		ToggleGroup: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleGroup"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleGroup", "ctrl+g"), "collapse/expand group"),
+		),
+		HelpGrow: key.NewBinding(
+			key.WithKeys(hotkeys["HelpGrow"]...),
+			key.WithHelp(helpKeys(hotkeys, "HelpGrow", "+"), "expand help"),
+		),
+		HelpShrink: key.NewBinding(
+			key.WithKeys(hotkeys["HelpShrink"]...),
+			key.WithHelp(helpKeys(hotkeys, "HelpShrink", "-"), "collapse help"),
+		),
+		InsertRow: key.NewBinding(
+			key.WithKeys(hotkeys["InsertRow"]...),
+			key.WithHelp(helpKeys(hotkeys, "InsertRow", "a"), "insert row"),
+		),
+		SetTemplate: key.NewBinding(
+			key.WithKeys(hotkeys["SetTemplate"]...),
+			key.WithHelp(helpKeys(hotkeys, "SetTemplate", "T"), "use row as template"),
+		),
+		CopyCell: key.NewBinding(
+			key.WithKeys(hotkeys["CopyCell"]...),
+			key.WithHelp(helpKeys(hotkeys, "CopyCell", "c"), "copy cell"),
+		),
+		CopyRow: key.NewBinding(
+			key.WithKeys(hotkeys["CopyRow"]...),
+			key.WithHelp(helpKeys(hotkeys, "CopyRow", "C"), "copy row"),
+		),
+		CopyColumn: key.NewBinding(
+			key.WithKeys(hotkeys["CopyColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "CopyColumn", "alt+c"), "copy column"),
+		),
+		HeaderMode: key.NewBinding(
+			key.WithKeys(hotkeys["HeaderMode"]...),
+			key.WithHelp(helpKeys(hotkeys, "HeaderMode", "H"), "header mode"),
+		),
+		Paste: key.NewBinding(
+			key.WithKeys(hotkeys["Paste"]...),
+			key.WithHelp(helpKeys(hotkeys, "Paste", "p"), "paste"),
+		),
+		DiffRevision: key.NewBinding(
+			key.WithKeys(hotkeys["DiffRevision"]...),
+			key.WithHelp(helpKeys(hotkeys, "DiffRevision", "D"), "diff vs git"),
+		),
+		Visual: key.NewBinding(
+			key.WithKeys(hotkeys["Visual"]...),
+			key.WithHelp(helpKeys(hotkeys, "Visual", "v"), "visual select"),
+		),
+		Undo: key.NewBinding(
+			key.WithKeys(hotkeys["Undo"]...),
+			key.WithHelp(helpKeys(hotkeys, "Undo", "U"), "undo"),
+		),
+		FillDown: key.NewBinding(
+			key.WithKeys(hotkeys["FillDown"]...),
+			key.WithHelp(helpKeys(hotkeys, "FillDown", "F"), "fill down"),
+		),
+		BulkEdit: key.NewBinding(
+			key.WithKeys(hotkeys["BulkEdit"]...),
+			key.WithHelp(helpKeys(hotkeys, "BulkEdit", "E"), "bulk edit"),
+		),
+		ExternalEdit: key.NewBinding(
+			key.WithKeys(hotkeys["ExternalEdit"]...),
+			key.WithHelp(helpKeys(hotkeys, "ExternalEdit", "ctrl+e"), "edit in $EDITOR"),
+		),
+		ToggleAppend: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleAppend"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleAppend", "ctrl+t"), "append on export"),
+		),
+		JumpMin: key.NewBinding(
+			key.WithKeys(hotkeys["JumpMin"]...),
+			key.WithHelp(helpKeys(hotkeys, "JumpMin", "["), "jump to min"),
+		),
+		JumpMax: key.NewBinding(
+			key.WithKeys(hotkeys["JumpMax"]...),
+			key.WithHelp(helpKeys(hotkeys, "JumpMax", "]"), "jump to max"),
+		),
+		JumpBlank: key.NewBinding(
+			key.WithKeys(hotkeys["JumpBlank"]...),
+			key.WithHelp(helpKeys(hotkeys, "JumpBlank", "_"), "next blank"),
+		),
+		SplitView: key.NewBinding(
+			key.WithKeys(hotkeys["SplitView"]...),
+			key.WithHelp(helpKeys(hotkeys, "SplitView", "S"), "split view"),
+		),
+		SwitchPane: key.NewBinding(
+			key.WithKeys(hotkeys["SwitchPane"]...),
+			key.WithHelp(helpKeys(hotkeys, "SwitchPane", "ctrl+w"), "switch pane"),
+		),
+		FreezeRows: key.NewBinding(
+			key.WithKeys(hotkeys["FreezeRows"]...),
+			key.WithHelp(helpKeys(hotkeys, "FreezeRows", "Z"), "freeze rows"),
+		),
+		FreezeCols: key.NewBinding(
+			key.WithKeys(hotkeys["FreezeCols"]...),
+			key.WithHelp(helpKeys(hotkeys, "FreezeCols", "ctrl+f"), "freeze columns"),
+		),
+		ToggleRowNumbers: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleRowNumbers"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleRowNumbers", "#"), "row numbers"),
+		),
+		CellNote: key.NewBinding(
+			key.WithKeys(hotkeys["CellNote"]...),
+			key.WithHelp(helpKeys(hotkeys, "CellNote", "N"), "note on cell"),
+		),
+		RowNote: key.NewBinding(
+			key.WithKeys(hotkeys["RowNote"]...),
+			key.WithHelp(helpKeys(hotkeys, "RowNote", "alt+n"), "note on row"),
+		),
+		WidenColumn: key.NewBinding(
+			key.WithKeys(hotkeys["WidenColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "WidenColumn", ">"), "widen column"),
+		),
+		NarrowColumn: key.NewBinding(
+			key.WithKeys(hotkeys["NarrowColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "NarrowColumn", "<"), "narrow column"),
+		),
+		Mark: key.NewBinding(
+			key.WithKeys(hotkeys["Mark"]...),
+			key.WithHelp(helpKeys(hotkeys, "Mark", "m"), "cycle review mark"),
+		),
+		FilterMarks: key.NewBinding(
+			key.WithKeys(hotkeys["FilterMarks"]...),
+			key.WithHelp(helpKeys(hotkeys, "FilterMarks", "M"), "filter by mark"),
+		),
+		WrapCells: key.NewBinding(
+			key.WithKeys(hotkeys["WrapCells"]...),
+			key.WithHelp(helpKeys(hotkeys, "WrapCells", "W"), "wrap cells"),
+		),
+		ExportMarked: key.NewBinding(
+			key.WithKeys(hotkeys["ExportMarked"]...),
+			key.WithHelp(helpKeys(hotkeys, "ExportMarked", "X"), "export marked rows"),
+		),
+		ToggleMarkColumn: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleMarkColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleMarkColumn", "ctrl+l"), "add flag column on export"),
+		),
+		SearchColumn: key.NewBinding(
+			key.WithKeys(hotkeys["SearchColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "SearchColumn", "/"), "search column"),
+		),
+		NextProblem: key.NewBinding(
+			key.WithKeys(hotkeys["NextProblem"]...),
+			key.WithHelp(helpKeys(hotkeys, "NextProblem", "}"), "next problem"),
+		),
+		PrevProblem: key.NewBinding(
+			key.WithKeys(hotkeys["PrevProblem"]...),
+			key.WithHelp(helpKeys(hotkeys, "PrevProblem", "{"), "prev problem"),
+		),
+		ProblemsList: key.NewBinding(
+			key.WithKeys(hotkeys["ProblemsList"]...),
+			key.WithHelp(helpKeys(hotkeys, "ProblemsList", "!"), "problems"),
+		),
+		ToggleLegend: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleLegend"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleLegend", "L"), "toggle legend"),
+		),
+		ToggleHelpBar: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleHelpBar"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleHelpBar", "B"), "toggle help bar"),
+		),
+		CommandLine: key.NewBinding(
+			key.WithKeys(hotkeys["CommandLine"]...),
+			key.WithHelp(helpKeys(hotkeys, "CommandLine", ":"), "command line"),
+		),
+		GoTop: key.NewBinding(
+			key.WithKeys(hotkeys["GoTop"]...),
+			key.WithHelp(helpKeys(hotkeys, "GoTop", "g g"), "first row"),
+		),
+		GoBottom: key.NewBinding(
+			key.WithKeys(hotkeys["GoBottom"]...),
+			key.WithHelp(helpKeys(hotkeys, "GoBottom", "G"), "last row"),
+		),
+		RecordMacro: key.NewBinding(
+			key.WithKeys(hotkeys["RecordMacro"]...),
+			key.WithHelp(helpKeys(hotkeys, "RecordMacro", "Q"), "record macro"),
+		),
+		PlayMacro: key.NewBinding(
+			key.WithKeys(hotkeys["PlayMacro"]...),
+			key.WithHelp(helpKeys(hotkeys, "PlayMacro", "@"), "play macro"),
+		),
+		SetBookmark: key.NewBinding(
+			key.WithKeys(hotkeys["SetBookmark"]...),
+			key.WithHelp(helpKeys(hotkeys, "SetBookmark", "`"), "set bookmark"),
+		),
+		Bookmarks: key.NewBinding(
+			key.WithKeys(hotkeys["Bookmarks"]...),
+			key.WithHelp(helpKeys(hotkeys, "Bookmarks", "'"), "bookmarks"),
+		),
+		ColumnJump: key.NewBinding(
+			key.WithKeys(hotkeys["ColumnJump"]...),
+			key.WithHelp(helpKeys(hotkeys, "ColumnJump", "f"), "jump to column"),
+		),
+		ThemeEditor: key.NewBinding(
+			key.WithKeys(hotkeys["ThemeEditor"]...),
+			key.WithHelp(helpKeys(hotkeys, "ThemeEditor", "alt+t"), "theme editor"),
+		),
+		FirstColumn: key.NewBinding(
+			key.WithKeys(hotkeys["FirstColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "FirstColumn", "0"), "first column"),
+		),
+		LastColumn: key.NewBinding(
+			key.WithKeys(hotkeys["LastColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "LastColumn", "$"), "last column"),
+		),
+		HalfPageDown: key.NewBinding(
+			key.WithKeys(hotkeys["HalfPageDown"]...),
+			key.WithHelp(helpKeys(hotkeys, "HalfPageDown", "ctrl+d"), "half page down"),
+		),
+		HalfPageUp: key.NewBinding(
+			key.WithKeys(hotkeys["HalfPageUp"]...),
+			key.WithHelp(helpKeys(hotkeys, "HalfPageUp", "ctrl+u"), "half page up"),
+		),
+		CenterCursor: key.NewBinding(
+			key.WithKeys(hotkeys["CenterCursor"]...),
+			key.WithHelp(helpKeys(hotkeys, "CenterCursor", "z z"), "center cursor row"),
+		),
+		ColumnStats: key.NewBinding(
+			key.WithKeys(hotkeys["ColumnStats"]...),
+			key.WithHelp(helpKeys(hotkeys, "ColumnStats", ", c s"), "column stats"),
+		),
+		HideColumn: key.NewBinding(
+			key.WithKeys(hotkeys["HideColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "HideColumn", ", c h"), "hide column"),
+		),
+		ShowColumns: key.NewBinding(
+			key.WithKeys(hotkeys["ShowColumns"]...),
+			key.WithHelp(helpKeys(hotkeys, "ShowColumns", ", c u"), "show all columns"),
+		),
+		PinColumn: key.NewBinding(
+			key.WithKeys(hotkeys["PinColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "PinColumn", ", c p"), "pin column"),
+		),
+		OpenPreview: key.NewBinding(
+			key.WithKeys(hotkeys["OpenPreview"]...),
+			key.WithHelp(helpKeys(hotkeys, "OpenPreview", "O"), "preview a file"),
+		),
+		CopyFromPreview: key.NewBinding(
+			key.WithKeys(hotkeys["CopyFromPreview"]...),
+			key.WithHelp(helpKeys(hotkeys, "CopyFromPreview", ", y"), "copy from preview"),
+		),
+		PinRow: key.NewBinding(
+			key.WithKeys(hotkeys["PinRow"]...),
+			key.WithHelp(helpKeys(hotkeys, "PinRow", "t"), "pin row to tray"),
+		),
+		ClearTray: key.NewBinding(
+			key.WithKeys(hotkeys["ClearTray"]...),
+			key.WithHelp(helpKeys(hotkeys, "ClearTray", ", t c"), "clear tray"),
+		),
+		ExportTray: key.NewBinding(
+			key.WithKeys(hotkeys["ExportTray"]...),
+			key.WithHelp(helpKeys(hotkeys, "ExportTray", ", t e"), "export tray"),
+		),
+		ToggleFormulaBar: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleFormulaBar"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleFormulaBar", "alt+e"), "formula bar"),
+		),
+		FixRaggedRows: key.NewBinding(
+			key.WithKeys(hotkeys["FixRaggedRows"]...),
+			key.WithHelp(helpKeys(hotkeys, "FixRaggedRows", ", r"), "fix ragged rows"),
+		),
+		FileComments: key.NewBinding(
+			key.WithKeys(hotkeys["FileComments"]...),
+			key.WithHelp(helpKeys(hotkeys, "FileComments", ", i"), "file comments"),
+		),
+		CaseUpper: key.NewBinding(
+			key.WithKeys(hotkeys["CaseUpper"]...),
+			key.WithHelp(helpKeys(hotkeys, "CaseUpper", ", c U"), "UPPER case"),
+		),
+		CaseLower: key.NewBinding(
+			key.WithKeys(hotkeys["CaseLower"]...),
+			key.WithHelp(helpKeys(hotkeys, "CaseLower", ", c L"), "lower case"),
+		),
+		CaseTitle: key.NewBinding(
+			key.WithKeys(hotkeys["CaseTitle"]...),
+			key.WithHelp(helpKeys(hotkeys, "CaseTitle", ", c T"), "Title Case"),
+		),
+		PadColumn: key.NewBinding(
+			key.WithKeys(hotkeys["PadColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "PadColumn", ", c 0"), "pad values"),
+		),
+		UnpadColumn: key.NewBinding(
+			key.WithKeys(hotkeys["UnpadColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "UnpadColumn", ", c z"), "strip leading zeros"),
+		),
+		ReformatDates: key.NewBinding(
+			key.WithKeys(hotkeys["ReformatDates"]...),
+			key.WithHelp(helpKeys(hotkeys, "ReformatDates", ", c d"), "reformat dates"),
+		),
+		ConvertTimezone: key.NewBinding(
+			key.WithKeys(hotkeys["ConvertTimezone"]...),
+			key.WithHelp(helpKeys(hotkeys, "ConvertTimezone", ", c t"), "convert time zone"),
+		),
+		ShowUnits: key.NewBinding(
+			key.WithKeys(hotkeys["ShowUnits"]...),
+			key.WithHelp(helpKeys(hotkeys, "ShowUnits", ", c n"), "show in units"),
+		),
+		ConvertUnits: key.NewBinding(
+			key.WithKeys(hotkeys["ConvertUnits"]...),
+			key.WithHelp(helpKeys(hotkeys, "ConvertUnits", ", c N"), "convert units"),
+		),
+		ComputedColumn: key.NewBinding(
+			key.WithKeys(hotkeys["ComputedColumn"]...),
+			key.WithHelp(helpKeys(hotkeys, "ComputedColumn", ", c r"), "add computed column"),
+		),
+		ToggleHeatmap: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleHeatmap"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleHeatmap", ", c m"), "toggle column heatmap"),
+		),
+		ToggleDataBars: key.NewBinding(
+			key.WithKeys(hotkeys["ToggleDataBars"]...),
+			key.WithHelp(helpKeys(hotkeys, "ToggleDataBars", ", c b"), "toggle column data bars"),
+		),
+		ExportSQL: key.NewBinding(
+			key.WithKeys(hotkeys["ExportSQL"]...),
+			key.WithHelp(helpKeys(hotkeys, "ExportSQL", ", s"), "export SQL inserts"),
+		),
+	}
+}
+
+// keyMap defines keybindings for the CSV TUI
+type keyMap struct {
+	Up               key.Binding
+	Down             key.Binding
+	Left             key.Binding
+	Right            key.Binding
+	PageUp           key.Binding
+	PageDown         key.Binding
+	PageLeft         key.Binding
+	PageRight        key.Binding
+	Edit             key.Binding
+	Help             key.Binding
+	Quit             key.Binding
+	Save             key.Binding
+	Cancel           key.Binding
+	GoTo             key.Binding
+	Search           key.Binding
+	NextMatch        key.Binding
+	PrevMatch        key.Binding
+	Tab              key.Binding
+	Filter           key.Binding
+	ResetFilters     key.Binding
+	Inspect          key.Binding
+	Replace          key.Binding
+	TogglePretty     key.Binding
+	SaveMultiline    key.Binding
+	ToggleGroup      key.Binding
+	HelpGrow         key.Binding
+	HelpShrink       key.Binding
+	InsertRow        key.Binding
+	SetTemplate      key.Binding
+	CopyCell         key.Binding
+	CopyRow          key.Binding
+	CopyColumn       key.Binding
+	HeaderMode       key.Binding
+	Paste            key.Binding
+	DiffRevision     key.Binding
+	Visual           key.Binding
+	Undo             key.Binding
+	FillDown         key.Binding
+	BulkEdit         key.Binding
+	ExternalEdit     key.Binding
+	ToggleAppend     key.Binding
+	JumpMin          key.Binding
+	JumpMax          key.Binding
+	JumpBlank        key.Binding
+	SplitView        key.Binding
+	SwitchPane       key.Binding
+	FreezeRows       key.Binding
+	FreezeCols       key.Binding
+	ToggleRowNumbers key.Binding
+	CellNote         key.Binding
+	RowNote          key.Binding
+	WidenColumn      key.Binding
+	NarrowColumn     key.Binding
+	Mark             key.Binding
+	FilterMarks      key.Binding
+	WrapCells        key.Binding
+	ExportMarked     key.Binding
+	ToggleMarkColumn key.Binding
+	SearchColumn     key.Binding
+	NextProblem      key.Binding
+	PrevProblem      key.Binding
+	ProblemsList     key.Binding
+	ToggleLegend     key.Binding
+	ToggleHelpBar    key.Binding
+	CommandLine      key.Binding
+	GoTop            key.Binding
+	GoBottom         key.Binding
+	RecordMacro      key.Binding
+	PlayMacro        key.Binding
+	SetBookmark      key.Binding
+	Bookmarks        key.Binding
+	ColumnJump       key.Binding
+	ThemeEditor      key.Binding
+	FirstColumn      key.Binding
+	LastColumn       key.Binding
+	HalfPageDown     key.Binding
+	HalfPageUp       key.Binding
+	CenterCursor     key.Binding
+	ColumnStats      key.Binding
+	HideColumn       key.Binding
+	ShowColumns      key.Binding
+	PinColumn        key.Binding
+	OpenPreview      key.Binding
+	CopyFromPreview  key.Binding
+	PinRow           key.Binding
+	ClearTray        key.Binding
+	ExportTray       key.Binding
+	ToggleFormulaBar key.Binding
+	FixRaggedRows    key.Binding
+	FileComments     key.Binding
+	CaseUpper        key.Binding
+	CaseLower        key.Binding
+	CaseTitle        key.Binding
+	PadColumn        key.Binding
+	UnpadColumn      key.Binding
+	ReformatDates    key.Binding
+	ConvertTimezone  key.Binding
+	ShowUnits        key.Binding
+	ConvertUnits     key.Binding
+	ComputedColumn   key.Binding
+	ToggleHeatmap    key.Binding
+	ToggleDataBars   key.Binding
+	ExportSQL        key.Binding
+}
+
+// ShortHelp returns keybindings to be shown in the mini help view
+func (k keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Help, k.Edit, k.Quit}
+}
+
+// FullHelp returns keybindings for the expanded help view
+func (k keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down, k.Left, k.Right, k.GoTop, k.GoBottom, k.FirstColumn, k.LastColumn},             // Navigation
+		{k.PageUp, k.PageDown, k.PageLeft, k.PageRight, k.HalfPageUp, k.HalfPageDown, k.CenterCursor}, // Page navigation
+		{k.Edit, k.GoTo, k.Search, k.CommandLine, k.Save, k.Cancel},                                   // Edit actions
+		{k.ExternalEdit, k.FillDown, k.BulkEdit, k.CaseUpper, k.CaseLower, k.CaseTitle, k.PadColumn, k.UnpadColumn, k.ReformatDates, k.ConvertTimezone, k.ShowUnits, k.ConvertUnits, k.Undo, k.RecordMacro, k.PlayMacro}, // Editing tools
+		{k.InsertRow, k.SetTemplate, k.Visual, k.CellNote, k.RowNote, k.Mark}, // Row actions
+		{k.CopyCell, k.CopyRow, k.CopyColumn, k.Paste},                        // Clipboard
+		{k.SearchColumn, k.NextMatch, k.PrevMatch, k.Replace},                 // Search navigation
+		{k.NextProblem, k.PrevProblem, k.ProblemsList, k.FixRaggedRows},       // Problems
+		{k.SetBookmark, k.Bookmarks},                                          // Bookmarks
+		{k.PinRow, k.ClearTray, k.ExportTray},                                 // Comparison tray
+		{k.ColumnJump, k.JumpMin, k.JumpMax, k.JumpBlank},                     // Column jumps
+		{k.Filter, k.ResetFilters, k.FilterMarks, k.DiffRevision, k.ToggleAppend, k.ExportMarked, k.ToggleMarkColumn, k.ExportSQL},                                                                             // Filter actions
+		{k.Inspect, k.ToggleFormulaBar, k.FileComments, k.TogglePretty, k.ToggleRowNumbers, k.WrapCells, k.SaveMultiline, k.ThemeEditor},                                                                       // Display
+		{k.SplitView, k.SwitchPane, k.OpenPreview, k.CopyFromPreview},                                                                                                                                          // Panes
+		{k.HeaderMode, k.ToggleGroup, k.FreezeRows, k.FreezeCols, k.WidenColumn, k.NarrowColumn, k.ColumnStats, k.HideColumn, k.ShowColumns, k.PinColumn, k.ToggleHeatmap, k.ToggleDataBars, k.ComputedColumn}, // Columns
+		{k.Help, k.HelpGrow, k.HelpShrink, k.ToggleHelpBar, k.ToggleLegend, k.Quit},                                                                                                                            // General
+	}
+}
+
+func detectDataType(value string) DataType {
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return DataTypeEmpty
+	}
+
+	if strings.ToLower(value) == "true" || strings.ToLower(value) == "false" {
+		return DataTypeBool
+	}
+
+	// Zero-padded numbers such as IDs and ZIP codes are strings, since
+	// treating them as numbers is how spreadsheets lose the zeros
+	if leadingZeros.MatchString(value) && !strings.Contains(value, ".") {
+		return DataTypeString
+	}
+
+	if _, err := strconv.Atoi(value); err == nil {
+		return DataTypeInt
+	}
+
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return DataTypeFloat
+	}
+
+	return DataTypeString
+}
+
+// dataTypeName returns the short name used to label a column's type
+func dataTypeName(dataType DataType) string {
+	switch dataType {
+	case DataTypeString:
+		return "str"
+	case DataTypeInt:
+		return "int"
+	case DataTypeFloat:
+		return "float"
+	case DataTypeBool:
+		return "bool"
+	case DataTypeEmpty:
+		return "empty"
+	default:
+		return "unknown"
+	}
+}
+
+// sampleRows returns the first head rows plus random rows picked from the
+// rest, and whether that is a sample rather than every row
+func sampleRows(rows [][]string, head, random int) ([][]string, bool) {
+	if head <= 0 {
+		head = 10000
+	}
+	if random <= 0 {
+		random = 1000
+	}
+	if len(rows) <= head+random {
+		return rows, false
+	}
+
+	sample := make([][]string, 0, head+random)
+	sample = append(sample, rows[:head]...)
+	rest := rows[head:]
+	for i := 0; i < random; i++ {
+		sample = append(sample, rest[rand.Intn(len(rest))])
+	}
+	return sample, true
+}
+
+// columnTypesMsg carries the column types found by the background full scan
+type columnTypesMsg struct {
+	types []DataType
+}
+
+// scanColumnTypes detects the column types from every row of the file as
+// loaded, which is never modified, so it's safe to read in the background
+func (m model) scanColumnTypes() tea.Cmd {
+	rows := m.originalData[1:]
+	return func() tea.Msg {
+		return columnTypesMsg{types: analyzeColumnTypes(rows)}
+	}
+}
+
+// applyScannedTypes replaces the sampled column types with the full scan's.
+// Edits rescan the types themselves, so the scan is only used while there
+// are none.
+func (m *model) applyScannedTypes(types []DataType) {
+	m.typesSampled = false
+	if m.hasChanges {
+		return
+	}
+	if m.isFiltered {
+		m.originalColumnTypes = types
+		return
+	}
+	m.activeColumnTypes = types
+}
+
+func analyzeColumnTypes(rows [][]string) []DataType {
+	if len(rows) == 0 {
+		return []DataType{}
+	}
+
+	maxCols := 0
+	for _, row := range rows {
+		if len(row) > maxCols {
+			maxCols = len(row)
+		}
+	}
+
+	columnTypes := make([]DataType, maxCols)
+	typeCounts := make([]map[DataType]int, maxCols)
+
+	for i := range typeCounts {
+		typeCounts[i] = make(map[DataType]int)
+	}
+
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < maxCols {
+				dataType := detectDataType(cell)
+				typeCounts[i][dataType]++
+			}
+		}
+	}
+
+	for i := range columnTypes {
+		maxCount := 0
+		dominantType := DataTypeString
+
+		for dataType, count := range typeCounts[i] {
+			if count > maxCount && dataType != DataTypeEmpty {
+				maxCount = count
+				dominantType = dataType
+			}
+		}
+
+		columnTypes[i] = dominantType
+	}
+
+	return columnTypes
+}
+
+func (m model) createColorLegend(styles StyleConfig) string {
+	legendItems := []string{}
+
+	typeOrder := []DataType{DataTypeString, DataTypeInt, DataTypeFloat, DataTypeBool, DataTypeEmpty}
+	typeNames := map[DataType]string{
+		DataTypeString: "str",
+		DataTypeInt:    "int",
+		DataTypeFloat:  "float",
+		DataTypeBool:   "bool",
+		DataTypeEmpty:  "empty",
+	}
+
+	for _, dataType := range typeOrder {
+		typeName := typeNames[dataType]
+		color := styles.typeColors[dataType]
+		if color != "" {
+			coloredText := styles.baseStyle.Foreground(color).Bold(true).Render("■") +
+				styles.baseStyle.Foreground(styles.textColor).Render(typeName)
+			legendItems = append(legendItems, coloredText)
+		}
+	}
+
+	if len(legendItems) > 0 {
+		return "Legend: " + strings.Join(legendItems, " ")
+	}
+	return ""
+}
+
+type StyleConfig struct {
+	baseStyle     lipgloss.Style
+	headerStyle   lipgloss.Style
+	selectedStyle lipgloss.Style
+	diffStyle     lipgloss.Style
+	visualStyle   lipgloss.Style
+	gutterStyle   lipgloss.Style
+	textColor     lipgloss.Color
+	typeColors    map[DataType]lipgloss.Color
+	dimTypeColors map[DataType]lipgloss.Color
+	evenRowColor  lipgloss.Color
+	oddRowColor   lipgloss.Color
+}
+
+func createTableStyles(renderer *lipgloss.Renderer, theme Theme, typeColors, dimTypeColors map[DataType]lipgloss.Color) StyleConfig {
+	baseStyle := renderer.NewStyle().Padding(0, 1)
+	headerStyle := baseStyle.Foreground(theme.Header).Bold(true)
+	selectedStyle := baseStyle.Foreground(theme.SelectedForeground).Background(theme.SelectedBackground)
+	visualStyle := baseStyle.Foreground(theme.VisualForeground).Background(theme.VisualBackground)
+	diffStyle := baseStyle.Foreground(theme.DiffForeground).Background(theme.DiffBackground)
+
+	// Without colors, highlights fall back to text attributes
+	if renderer.ColorProfile() == termenv.Ascii {
+		selectedStyle = baseStyle.Reverse(true).Bold(true)
+		visualStyle = baseStyle.Reverse(true)
+		diffStyle = baseStyle.Underline(true)
+	}
+
+	return StyleConfig{
+		baseStyle:     baseStyle,
+		headerStyle:   headerStyle,
+		selectedStyle: selectedStyle,
+		gutterStyle:   baseStyle.Foreground(theme.Muted),
+		visualStyle:   visualStyle,
+		diffStyle:     diffStyle,
+		textColor:     theme.Text,
+		typeColors:    typeColors,
+		dimTypeColors: dimTypeColors,
+		evenRowColor:  theme.EvenRow,
+		oddRowColor:   theme.OddRow,
+	}
+}
+
+// errorStyle renders error messages
+func (m model) errorStyle() lipgloss.Style {
+	return m.renderer.NewStyle().Foreground(m.theme.Error).Bold(true)
+}
+
+// inspectorHeight is the number of screen lines used by the inspector pane
+// (one title line plus the wrapped cell content)
+const inspectorHeight = 6
+
+// maxUndoEntries caps the undo history
+const maxUndoEntries = 100
+
+// structuredEditorHeight is the number of lines used by the multi-line editor
+// for JSON/XML cells
+const structuredEditorHeight = 10
+
+// Help area sizes, from a single hint line up to the full key reference
+const (
+	helpHint = iota
+	helpShort
+	helpFull
+)
+
+// setHelpLevel resizes the help area and keeps the cursor in view, since the
+// number of visible data rows depends on the help height
+func (m *model) setHelpLevel(level int) {
+	if level < helpHint {
+		level = helpHint
+	}
+	if level > helpFull {
+		level = helpFull
+	}
+	m.helpLevel = level
+	m.help.ShowAll = level == helpFull
+	m.adjustViewportAfterResize()
+}
+
+// helpView renders the help area for the current help level
+func (m model) helpView() string {
+	if m.helpLevel == helpHint {
+		hintStyle := m.renderer.NewStyle().Foreground(m.theme.Muted)
+		return hintStyle.Render(fmt.Sprintf("%s help • %s more", m.keys.Help.Help().Key, m.keys.HelpGrow.Help().Key))
+	}
+	return m.help.View(m.keys)
+}
+
+// helpHeight returns the number of lines taken by the help area
+func (m model) helpHeight() int {
+	if m.helpLevel != helpFull {
+		if m.hideHelp {
+			return 0
+		}
+		return 1
+	}
+	return lipgloss.Height(m.help.View(m.keys))
+}
+
+// gutterWidth returns the width of the row-number gutter's content, or 0 when
+// it's hidden
+func (m model) gutterWidth() int {
+	if !m.rowNumbers {
+		return 0
+	}
+	return len(strconv.Itoa(len(m.csvData) - 1))
+}
+
+// rowNumberLabel returns the gutter label of an active row: its 1-based
+// position in the file, which stays the same when sorted or filtered
+func (m model) rowNumberLabel(row int) string {
+	source := m.sourceRow(row)
+	if source < 0 {
+		return "+"
+	}
+	return strconv.Itoa(source + 1)
+}
+
+// visibleFrozenRows returns how many frozen rows are drawn above the
+// scrolling rows
+func (m model) visibleFrozenRows() int {
+	return min(m.frozenRows, len(m.activeRows))
+}
+
+// toggleFrozenRows freezes the data rows down to the cursor, or unfreezes
+func (m *model) toggleFrozenRows() {
+	if m.frozenRows > 0 {
+		m.frozenRows = 0
+		m.statusMessage = "Rows unfrozen"
+	} else {
+		m.frozenRows = m.cursorRow + 1
+		m.statusMessage = fmt.Sprintf("Froze the first %d row(s)", m.frozenRows)
+	}
+	m.adjustViewportAfterResize()
+	m.clampViewportToFrozenRows()
+}
+
+// clampViewportToFrozenRows keeps the scrolling rows from starting inside the
+// frozen rows, which are always drawn
+func (m *model) clampViewportToFrozenRows() {
+	frozen := m.visibleFrozenRows()
+	if m.viewportY < frozen {
+		m.viewportY = frozen
+	}
+	if m.otherPane.viewportY < frozen {
+		m.otherPane.viewportY = frozen
+	}
+}
+
+// scrollWrappedRows scrolls down far enough to keep the cursor on screen when
+// wrapped rows take up more than one line each
+func (m *model) scrollWrappedRows() {
+	if !m.wrapCells || m.cursorRow < m.visibleFrozenRows() {
+		return
+	}
+	m.widthCache = make(map[int]int)
+	defer func() { m.widthCache = nil }()
+	for m.viewportY < m.cursorRow {
+		rows := m.wrappedRows(max(m.viewportY, m.visibleFrozenRows()))
+		if len(rows) > 0 && rows[len(rows)-1] >= m.cursorRow {
+			return
+		}
+		m.viewportY++
+	}
+}
+
+// wrappedRows returns the frozen rows and the rows from start on that fit on
+// screen with their values wrapped to the column widths
+func (m model) wrappedRows(start int) []int {
+	startCol, endCol := m.calculateVisibleColumns()
+	cols := m.tableColumns(startCol, endCol)
+
+	lines := m.maxVisibleRows() + m.visibleFrozenRows()
+	var rows []int
+	for i := 0; i < m.visibleFrozenRows(); i++ {
+		rows = append(rows, i)
+		lines -= m.wrappedRowHeight(i, cols)
+	}
+	for i := start; i < len(m.activeRows); i++ {
+		height := m.wrappedRowHeight(i, cols)
+		// Always show at least one scrolling row, even if it overflows
+		if height > lines && i > start {
+			break
+		}
+		rows = append(rows, i)
+		lines -= height
+	}
+	return rows
+}
+
+// wrappedRowHeight returns how many lines a row takes once the values in the
+// given columns are wrapped to the column widths
+// cursorLine returns the screen line within the cursor row the cursor is on
+// when values wrap, and how many lines the row takes up
+func (m model) cursorLine() (int, int) {
+	startCol, endCol := m.calculateVisibleColumns()
+	height := m.wrappedRowHeight(m.cursorRow, m.tableColumns(startCol, endCol))
+	if m.wrapLineRow != m.cursorRow {
+		return 0, height
+	}
+	return min(m.wrapLine, height-1), height
+}
+
+// pageWrappedLines moves the cursor by a number of screen lines rather than
+// rows, so paging through wrapped rows of different heights scrolls evenly.
+// A negative count moves up.
+func (m *model) pageWrappedLines(lines int) {
+	m.widthCache = make(map[int]int)
+	defer func() { m.widthCache = nil }()
+
+	startCol, endCol := m.calculateVisibleColumns()
+	cols := m.tableColumns(startCol, endCol)
+	row := m.cursorRow
+	line, _ := m.cursorLine()
+	for ; lines > 0; lines-- {
+		if line+1 < m.wrappedRowHeight(row, cols) {
+			line++
+		} else if row+1 < len(m.activeRows) {
+			row, line = row+1, 0
+		} else {
+			break
+		}
+	}
+	for ; lines < 0; lines++ {
+		if line > 0 {
+			line--
+		} else if row > 0 {
+			row--
+			line = m.wrappedRowHeight(row, cols) - 1
+		} else {
+			break
+		}
+	}
+
+	m.cursorRow = row
+	m.wrapLine, m.wrapLineRow = line, row
+	if m.cursorRow < m.viewportY {
+		m.viewportY = m.cursorRow
+	}
+}
+
+func (m model) wrappedRowHeight(row int, cols []int) int {
+	height := 1
+	for _, c := range cols {
+		if c >= len(m.activeRows[row]) {
+			continue
+		}
+		lines := 0
+		for _, line := range strings.Split(m.activeRows[row][c], "\n") {
+			lines += strings.Count(ansi.Wrap(line, m.columnWidth(c), ""), "\n") + 1
+		}
+		height = max(height, lines)
+	}
+	return height
+}
+
+// maxVisibleRows returns how many data rows fit on screen once the header,
+// legend, status, help, and any optional panes are accounted for
+func (m model) maxVisibleRows() int {
+	if m.paneRows > 0 {
+		return m.paneRows
+	}
+	maxRows := m.height - 5 - m.helpHeight() // Account for table, column info, status, and help lines
+	if !m.hideLegend {
+		maxRows--
+	}
+	maxRows -= m.visibleFrozenRows()
+	maxRows -= len(m.tray)
+	if m.border == borderNone {
+		maxRows += 3 // No top, header, or bottom border lines
+	}
+	if m.readOnly {
+		maxRows-- // Read-only banner
+	}
+	if m.formulaBar {
+		maxRows--
+	}
+	if m.showInspector {
+		maxRows -= inspectorHeight
+	}
+	if m.editMode && m.editSyntax != "" {
+		maxRows -= structuredEditorHeight
+	}
+	if len(m.columnGroups) > 0 {
+		maxRows-- // Group header band
+	}
+	if m.splitView || m.preview != nil {
+		// The second pane's table needs its own borders, header, and group band,
+		// and the remaining rows are shared between both panes
+		if m.border == borderNone {
+			maxRows--
+		} else {
+			maxRows -= 4
+		}
+		if len(m.columnGroups) > 0 {
+			maxRows--
+		}
+		maxRows /= 2
+	}
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	return maxRows
+}
+
+func (m *model) adjustViewportAfterResize() {
+	// Adjust horizontal viewport if cursor is out of visible area (pinned
+	// columns are always visible)
+	startCol, endCol := m.calculateVisibleColumns()
+	pinned := m.isColumnPinned(m.cursorCol)
+	if !pinned && m.cursorCol < startCol {
+		m.viewportX = m.cursorCol
+	} else if !pinned && m.cursorCol >= endCol {
+		// Move viewport right to show cursor
+		m.viewportX = m.cursorCol - (endCol - startCol - 1)
+		if m.viewportX < 0 {
+			m.viewportX = 0
+		}
+		if m.viewportX >= len(m.activeHeaders) {
+			m.viewportX = len(m.activeHeaders) - 1
+		}
+	}
+
+	// Adjust vertical viewport if cursor is out of visible area
+	maxRows := m.maxVisibleRows()
+
+	if m.cursorRow < m.viewportY {
+		m.viewportY = m.cursorRow
+	} else if m.cursorRow >= m.viewportY+maxRows {
+		m.viewportY = m.cursorRow - maxRows + 1
+		if m.viewportY < 0 {
+			m.viewportY = 0
+		}
+	}
+}
+
+// mouseWheelRows is how many rows one step of the scroll wheel scrolls
+const mouseWheelRows = 3
+
+// promptOpen reports whether a prompt or overlay has the keyboard, so the
+// mouse leaves the table alone
+func (m model) promptOpen() bool {
+	return m.savePrompt || m.altSavePrompt || m.privilegedSave || m.editMode || m.gotoMode ||
+		m.searchMode || m.replaceMode || m.replaceConfirm || m.filterMode || m.saveFilteredPrompt ||
+		m.renameMode || m.pastePrompt || m.bulkEditMode || m.transformName != "" || m.padPrompt || m.datePrompt || m.zonePrompt || m.unitPrompt || m.computedPrompt || m.noteMode || m.markFilterPrompt ||
+		m.exportMarksPrompt || m.diffPrompt || m.statsView != "" || m.problemsView || m.commandMode ||
+		m.macroPrompt || m.bookmarkPrompt || m.bookmarkView || m.columnJumpMode ||
+		m.themeEditor || m.previewPrompt || m.trayExportPrompt || m.sqlExportPrompt || m.formulaEditing
+}
+
+// playMacro replays the recorded keys count times, stopping early when a
+// key reports an error so a failed edit isn't repeated down the file
+func (m model) playMacro(count int) (model, tea.Cmd) {
+	if len(m.macroKeys) == 0 {
+		m.statusMessage = "No macro recorded"
+		m.statusIsError = true
+		return m, nil
+	}
+
+	var cmds []tea.Cmd
+	m.playingMacro = true
+	for i := 0; i < count; i++ {
+		for _, msg := range m.macroKeys {
+			updated, cmd := m.Update(msg)
+			m = updated.(model)
+			cmds = append(cmds, cmd)
+			if m.statusIsError || m.quitting {
+				m.playingMacro = false
+				m.statusMessage = fmt.Sprintf("Macro stopped on run %d: %s", i+1, m.statusMessage)
+				return m, tea.Batch(cmds...)
+			}
+		}
+	}
+	m.playingMacro = false
+	if m.statusMessage == "" {
+		m.statusMessage = fmt.Sprintf("Played macro %d times", count)
+		if count == 1 {
+			m.statusMessage = "Played macro"
+		}
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// handleMouse moves the cursor to clicked cells, selects the cells dragged
+// over, sorts by clicked headers, and scrolls with the wheel, sideways when
+// shift is held
+func (m *model) handleMouse(msg tea.MouseMsg) {
+	if m.promptOpen() || len(m.activeRows) == 0 {
+		return
+	}
+
+	switch {
+	case msg.Button == tea.MouseButtonWheelLeft, msg.Button == tea.MouseButtonWheelUp && msg.Shift:
+		m.scrollColumns(-1)
+	case msg.Button == tea.MouseButtonWheelRight, msg.Button == tea.MouseButtonWheelDown && msg.Shift:
+		m.scrollColumns(1)
+	case msg.Button == tea.MouseButtonWheelUp:
+		m.scrollRows(-mouseWheelRows)
+	case msg.Button == tea.MouseButtonWheelDown:
+		m.scrollRows(mouseWheelRows)
+	case msg.Button == tea.MouseButtonLeft && msg.Action == tea.MouseActionPress:
+		row, col, ok := m.cellAt(msg.X, msg.Y)
+		if !ok && m.splitView {
+			// Clicking the other pane focuses it
+			other := *m
+			other.switchPane()
+			if row, col, ok = other.cellAt(msg.X, msg.Y); ok {
+				m.switchPane()
+			}
+		}
+		if !ok {
+			return
+		}
+		// A click starts over rather than extending the selection
+		m.visualMode = false
+		if row < 0 {
+			// Clicking a header sorts by it, and clicking it again reverses the order
+			if col >= 0 {
+				header := m.activeHeaders[col]
+				m.sortDesc = m.sortColumn == header && !m.sortDesc
+				m.sortColumn = header
+				m.applySort()
+				m.cursorCol = col
+			}
+			return
+		}
+		m.cursorRow = row
+		if col >= 0 {
+			m.cursorCol = col
+		}
+		m.dragging = true
+		m.adjustViewportAfterResize()
+	case msg.Action == tea.MouseActionMotion && m.dragging:
+		// Dragging onto another cell selects from where the drag started
+		row, col, ok := m.cellAt(msg.X, msg.Y)
+		if !ok || (row == m.cursorRow && (col < 0 || col == m.cursorCol)) {
+			return
+		}
+		if !m.visualMode {
+			m.visualMode = true
+			m.anchorRow = m.cursorRow
+			m.anchorCol = m.cursorCol
+		}
+		if row >= 0 {
+			m.cursorRow = row
+		}
+		if col >= 0 {
+			m.cursorCol = col
+		}
+		m.adjustViewportAfterResize()
+	case msg.Action == tea.MouseActionRelease:
+		m.dragging = false
+	}
+}
+
+// scrollRows scrolls the table by a number of rows, dragging the cursor along
+// when it would leave the screen. A negative count scrolls up.
+func (m *model) scrollRows(delta int) {
+	maxRows := m.maxVisibleRows()
+	frozen := m.visibleFrozenRows()
+	m.viewportY = min(max(m.viewportY+delta, frozen), max(len(m.activeRows)-maxRows, frozen))
+	if m.cursorRow >= frozen {
+		m.cursorRow = min(max(m.cursorRow, m.viewportY), m.viewportY+maxRows-1, len(m.activeRows)-1)
+	}
+}
+
+// scrollHalfPage moves the cursor and the view together by half a screen of
+// rows, like ctrl+d and ctrl+u in vim. A negative direction moves up.
+func (m *model) scrollHalfPage(dir int) {
+	half := max(m.maxVisibleRows()/2, 1)
+	if m.wrapCells {
+		m.pageWrappedLines(dir * half)
+		return
+	}
+	m.cursorRow = min(max(m.cursorRow+dir*half, 0), len(m.activeRows)-1)
+	m.scrollRows(dir * half)
+	m.adjustViewportAfterResize()
+}
+
+// centerCursor scrolls the view so the cursor row is in the middle of the
+// screen, as far as the ends of the data allow
+func (m *model) centerCursor() {
+	maxRows := m.maxVisibleRows()
+	frozen := m.visibleFrozenRows()
+	m.viewportY = min(max(m.cursorRow-(maxRows-frozen)/2, frozen), max(len(m.activeRows)-maxRows, frozen))
+}
+
+// scrollColumns scrolls the table sideways by a number of columns, keeping the
+// cursor on a visible column. A negative count scrolls left.
+func (m *model) scrollColumns(delta int) {
+	for ; delta > 0 && m.viewportX < len(m.activeHeaders)-1; delta-- {
+		m.viewportX++
+		for m.viewportX < len(m.activeHeaders)-1 && m.isColumnHidden(m.viewportX) {
+			m.viewportX++
+		}
+	}
+	for ; delta < 0 && m.viewportX > 0; delta++ {
+		m.viewportX--
+		for m.viewportX > 0 && m.isColumnHidden(m.viewportX) {
+			m.viewportX--
+		}
+	}
+
+	startCol, endCol := m.calculateVisibleColumns()
+	if !m.isColumnPinned(m.cursorCol) {
+		m.cursorCol = m.visibleColumn(min(max(m.cursorCol, startCol), endCol-1))
+	}
+}
+
+// cellAt returns the row and column of the focused pane's table drawn at a
+// screen position. The row is -1 on the header and the column is -1 in the
+// row-number gutter; ok is false outside the table.
+func (m model) cellAt(x, y int) (row, col int, ok bool) {
+	m.widthCache = make(map[int]int)
+	styles := createTableStyles(m.renderer, m.theme, m.typeColors, m.dimColors)
+
+	// Find the top of the table, as laid out by View
+	if m.readOnly {
+		y--
+	}
+	if m.formulaBar {
+		y--
+	}
+	if m.splitView && m.focusBottom {
+		other := m
+		other.pane = m.otherPane
+		otherView, _, _, _ := other.renderTable(styles, false)
+		y -= lipgloss.Height(otherView)
+	}
+	tableView, startCol, endCol, _ := m.renderTable(styles, true)
+	if len(m.columnGroups) > 0 {
+		y--
+		tableView = strings.SplitN(tableView, "\n", 2)[1]
+	}
+	if y < 0 || y >= lipgloss.Height(tableView) {
+		return 0, 0, false
+	}
+
+	displayCols := m.tableColumns(startCol, endCol)
+	if m.rowNumbers {
+		displayCols = append([]int{-1}, displayCols...)
+	}
+	bounds := m.columnBounds(tableView, displayCols)
+	if len(bounds) != len(displayCols)+1 {
+		return 0, 0, false
+	}
+	col = -2
+	for j := range displayCols {
+		if x > bounds[j] && x <= bounds[j+1] {
+			col = displayCols[j]
+			break
+		}
+	}
+	if col == -2 {
+		return 0, 0, false
+	}
+
+	// The header sits below the top border, and the rows below its separator
+	line := 1
+	if m.border == borderNone {
+		line = 0
+	}
+	if y == line {
+		return -1, col, true
+	}
+	line++
+	if m.border != borderNone {
+		line++
+	}
+	cols := m.tableColumns(startCol, endCol)
+	for _, i := range m.visibleRowIndices() {
+		height := 1
+		if m.wrapCells {
+			height = m.wrappedRowHeight(i, cols)
+		}
+		if y >= line && y < line+height {
+			return i, col, true
+		}
+		line += height
+	}
+	return 0, 0, false
+}
+
+// switchPane moves focus to the other pane of a split view
+func (m *model) switchPane() {
+	m.pane, m.otherPane = m.otherPane, m.pane
+	m.focusBottom = !m.focusBottom
+
+	// The data may have shrunk (e.g. filtered) since the pane was last focused
+	if m.cursorRow >= len(m.activeRows) {
+		m.cursorRow = max(len(m.activeRows)-1, 0)
+	}
+	if m.cursorCol >= len(m.activeHeaders) {
+		m.cursorCol = max(len(m.activeHeaders)-1, 0)
+	}
+	m.cursorCol = m.visibleColumn(m.cursorCol)
+	m.adjustViewportAfterResize()
+}
+
+// openPreview opens a companion file read-only in a pane below the data
+func (m *model) openPreview(filename string) error {
+	delimiter, separator, ok, err := configDelimiter(filename, m.config)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if delimiter, err = detectDelimiter(filename, m.config.Import); err != nil {
+			return err
+		}
+	}
+	options := m.config.Import
+	options.separator = separator
+	records, err := readCSV(filename, delimiter, options)
+	if err != nil {
+		return err
+	}
+
+	rows := records[1:]
+	preview := model{
+		csvData:           records,
+		filename:          filename,
+		config:            m.config,
+		activeHeaders:     records[0],
+		activeRows:        rows,
+		activeColumnTypes: analyzeColumnTypes(rows),
+		keys:              m.keys,
+		renderer:          m.renderer,
+		theme:             m.theme,
+		border:            m.border,
+		locale:            m.locale,
+		typeColors:        m.typeColors,
+		dimColors:         m.dimColors,
+		unitConversions:   m.unitConversions,
+		units:             m.units,
+	}
+	preview.rowIndex = make([]int, len(rows))
+	for i := range preview.rowIndex {
+		preview.rowIndex[i] = i
+	}
+
+	// The preview takes the place of a split view
+	if m.splitView && m.focusBottom {
+		m.pane = m.otherPane
+	}
+	m.splitView = false
+	m.focusBottom = false
+	m.preview = &preview
+	m.previewFocused = false
+	m.syncPreview()
+	m.adjustViewportAfterResize()
+	return nil
+}
+
+// syncPreview lays the preview pane out for the current screen, with as many
+// rows as the data above it
+func (m *model) syncPreview() {
+	preview := *m.preview
+	preview.width = m.width
+	preview.height = m.height
+	preview.paneRows = m.maxVisibleRows()
+	if preview.cursorRow >= len(preview.activeRows) {
+		preview.cursorRow = max(len(preview.activeRows)-1, 0)
+	}
+	preview.adjustViewportAfterResize()
+	m.preview = &preview
+}
+
+// isNavigationKey reports whether a key only moves the cursor or scrolls
+func (m model) isNavigationKey(msg tea.KeyMsg) bool {
+	k := m.keys
+	return key.Matches(msg, k.Up, k.Down, k.Left, k.Right, k.PageUp, k.PageDown, k.PageLeft, k.PageRight,
+		k.GoTop, k.GoBottom, k.FirstColumn, k.LastColumn, k.HalfPageUp, k.HalfPageDown, k.CenterCursor)
+}
+
+// copyFromPreview copies the cell under the preview pane's cursor into the
+// data's current cell
+func (m *model) copyFromPreview() {
+	if m.preview == nil {
+		m.statusMessage = "No file is being previewed"
+		m.statusIsError = true
+		return
+	}
+	p := m.preview
+	if p.cursorRow >= len(p.activeRows) || p.cursorCol >= len(p.activeRows[p.cursorRow]) {
+		m.statusMessage = "The preview has no cell to copy"
+		m.statusIsError = true
+		return
+	}
+	if m.cursorRow >= len(m.activeRows) {
+		return
+	}
+	value := p.activeRows[p.cursorRow][p.cursorCol]
+	m.setCell(m.cursorRow, m.cursorCol, value)
+	m.statusMessage = fmt.Sprintf("Copied %q from %s", value, filepath.Base(p.filename))
+}
+
+// sourceName returns the name of the file, table, object or stream the data
+// came from, for display
+func (m model) sourceName() string {
+	switch {
+	case m.fromStdin:
+		return "stdin"
+	case m.objectURL != "":
+		return path.Base(m.objectURL)
+	case m.compressedFile != "":
+		return filepath.Base(m.compressedFile)
+	case m.sqliteFile != "":
+		return filepath.Base(m.sqliteFile) + ":" + m.sqliteTable
+	case m.parquetFile != "":
+		return filepath.Base(m.parquetFile)
+	case m.jsonFile != "":
+		return filepath.Base(m.jsonFile)
+	}
+	return filepath.Base(m.filename)
+}
+
+// noteViewedRows records the rows on screen for the exit summary
+func (m *model) noteViewedRows() {
+	if m.viewedRows == nil {
+		m.viewedRows = make(map[int]bool)
+	}
+	for row := m.viewportY; row < min(m.viewportY+m.maxVisibleRows(), len(m.activeRows)); row++ {
+		if source := m.sourceRow(row); source >= 0 {
+			m.viewedRows[source] = true
+		}
+	}
+}
+
+// noteWritten records a file saved or exported to for the exit summary
+func (m *model) noteWritten(path string) {
+	if !slices.Contains(m.filesWritten, path) {
+		m.filesWritten = append(m.filesWritten, path)
+	}
+}
+
+// exitSummary describes what the session did, to print on quit so it shows
+// up in the shell's scrollback
+func (m model) exitSummary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "csvtui session on %s\n", m.sourceName())
+	fmt.Fprintf(&b, "  Rows viewed:     %d of %d\n", len(m.viewedRows), len(m.csvData)-1)
+	filters := "none"
+	if len(m.filterLog) > 0 {
+		filters = fmt.Sprintf("%d (%s)", len(m.filterLog), strings.Join(m.filterLog, "; "))
+	}
+	fmt.Fprintf(&b, "  Filters applied: %s\n", filters)
+	changed := strconv.Itoa(m.cellsChanged)
+	if m.hasChanges {
+		changed += ", with changes left unsaved"
+	}
+	fmt.Fprintf(&b, "  Cells changed:   %s\n", changed)
+	written := "none"
+	if len(m.filesWritten) > 0 {
+		written = strings.Join(m.filesWritten, ", ")
+	}
+	fmt.Fprintf(&b, "  Files written:   %s\n", written)
+	return b.String()
+}
+
+// windowTitle returns the terminal title for the session, so multiple
+// sessions are distinguishable in terminal tabs
+func (m model) windowTitle() string {
+	title := "csvtui — " + m.sourceName()
+	if m.outputFile != "" {
+		title += " → " + filepath.Base(m.outputFile)
+	}
+	if m.hasChanges {
+		title += " [modified]"
+	}
+	return title
+}
+
+func (m model) Init() tea.Cmd {
+	if m.typesSampled {
+		return tea.Batch(tea.SetWindowTitle(m.lastWindowTitle), m.scanColumnTypes())
+	}
+	return tea.SetWindowTitle(m.lastWindowTitle)
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	updated, cmd := m.update(msg)
+	next := updated.(model)
+
+	next.clampViewportToFrozenRows()
+	next.scrollWrappedRows()
+	next.noteViewedRows()
+
+	// Heatmap and data bar ranges are kept until other rows or columns are
+	// in view; edits clear them in setCell
+	if next.rangeCache == nil || len(next.activeRows) != len(m.activeRows) ||
+		(len(m.activeRows) > 0 && &next.activeRows[0] != &m.activeRows[0]) ||
+		!slices.Equal(next.activeHeaders, m.activeHeaders) {
+		next.rangeCache = make(map[int][2]float64)
+	}
+
+	// Everything edited while handling one message is undone together
+	if len(next.undoBatch) > 0 {
+		next.undoStack = append(next.undoStack, next.undoBatch)
+		if len(next.undoStack) > maxUndoEntries {
+			next.undoStack = next.undoStack[1:]
+		}
+		next.undoBatch = nil
+	}
+
+	// Keep the terminal title in sync with the modified state
+	if title := next.windowTitle(); title != next.lastWindowTitle {
+		next.lastWindowTitle = title
+		cmd = tea.Batch(cmd, tea.SetWindowTitle(title))
+	}
+	return next, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		if m.inlineHeight > 0 {
+			m.height = min(msg.Height, m.inlineHeight)
+		}
+		m.help.Width = msg.Width
+
+		// Adjust viewport if necessary after resize
+		(&m).adjustViewportAfterResize()
+		if m.splitView {
+			m.pane, m.otherPane = m.otherPane, m.pane
+			(&m).adjustViewportAfterResize()
+			m.pane, m.otherPane = m.otherPane, m.pane
+		}
+		if m.preview != nil {
+			(&m).syncPreview()
+		}
+	case externalEditMsg:
+		m.applyExternalEdit(msg)
+	case privilegedSaveMsg:
+		return m, m.finishPrivilegedSave(msg)
+	case clipboardMsg:
+		m.finishCopy(msg)
+	case columnTypesMsg:
+		m.applyScannedTypes(msg.types)
+	case tea.ResumeMsg:
+		// The terminal may have been resized or retitled while suspended
+		return m, tea.Batch(tea.WindowSize(), tea.SetWindowTitle(m.windowTitle()))
+	case tea.MouseMsg:
+		m.handleMouse(msg)
+	case tea.KeyMsg:
+		// Any key press dismisses the previous status message
+		m.statusMessage = ""
+		m.statusIsError = false
+
+		// Capture keys for the macro being recorded, apart from the key that
+		// stops recording
+		if m.recordingMacro && !m.playingMacro && (m.promptOpen() || !key.Matches(msg, m.keys.RecordMacro)) {
+			m.macroKeys = append(m.macroKeys, msg)
+		}
+
+		// Key sequences: keys that start a sequence wait for the next one,
+		// and a complete sequence is then matched against the bindings as one
+		// key named e.g. "g g". A key that continues no sequence acts on its
+		// own.
+		if !m.promptOpen() {
+			sequence := keyName(msg)
+			if m.pendingKey != "" {
+				sequence = m.pendingKey + " " + sequence
+			}
+			started := m.pendingKey != ""
+			m.pendingKey = ""
+			if m.keys.isSequencePrefix(sequence) {
+				m.pendingKey = sequence
+				return m, nil
+			}
+			if started && m.keys.isBound(sequence) {
+				msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(sequence)}
+			}
+		}
+
+		// Navigation keys scroll the preview pane while it's focused; other
+		// keys still act on the data
+		if m.previewFocused && !m.promptOpen() && m.isNavigationKey(msg) {
+			m.syncPreview()
+			updated, _ := m.preview.Update(msg)
+			preview := updated.(model)
+			m.preview = &preview
+			return m, nil
+		}
+
+		// Handle save prompt mode first
+		// Confirm saving through sudo or doas
+		if m.privilegedSave {
+			switch msg.String() {
+			case "y", "Y":
+				m.privilegedSave = false
+				return m, m.saveWithPrivileges()
+			case "n", "N":
+				m.privilegedSave = false
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.privilegedSave = false
+			}
+			return m, nil
+		}
+
+		// Handle the prompt for another path to save to
+		if m.altSavePrompt {
+			if key.Matches(msg, m.keys.Save) {
+				path := strings.TrimSpace(m.altSaveInput.Value())
+				if path == "" {
+					return m, nil
+				}
+				if !isWritable(path) {
+					m.statusMessage = fmt.Sprintf("%s isn't writable either", path)
+					m.statusIsError = true
+					return m, nil
+				}
+				m.outputFile = path
+				m.readOnly = false
+				if err := m.saveToOriginal(); err != nil {
+					m.statusMessage = fmt.Sprintf("Save failed: %v", err)
+					m.statusIsError = true
+					return m, nil
+				}
+				if m.statusIsError {
+					// Stay to show what else failed to save
+					m.altSavePrompt = false
+					return m, nil
+				}
+				m.quitting = true
+				return m, tea.Quit
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.altSavePrompt = false
+				return m, nil
+			}
+			return m, updateTextInput(&m.altSaveInput, msg)
+		}
+
+		if m.savePrompt {
+			switch msg.String() {
+			case "y", "Y":
+				if m.readOnly {
+					return m, nil
+				}
+				// Save changes to original file, staying on the prompt if that fails
+				if err := m.saveToOriginal(); err != nil {
+					m.statusMessage = fmt.Sprintf("Save failed: %v", err)
+					m.statusIsError = true
+					return m, nil
+				}
+				if m.statusIsError {
+					// Stay to show what else failed to save
+					m.savePrompt = false
+					return m, nil
+				}
+				m.quitting = true
+				return m, tea.Quit
+			case "a", "A":
+				if !m.readOnly {
+					break
+				}
+				m.altSavePrompt = true
+				m.altSaveInput = textinput.New()
+				m.altSaveInput.Focus()
+				m.altSaveInput.Placeholder = "Path to save to"
+				return m, textinput.Blink
+			case "s", "S":
+				if !m.readOnly || m.unsavableSource() != "" {
+					break
+				}
+				tool, err := findPrivilegeTool()
+				if err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					return m, nil
+				}
+				m.privilegedTool = tool
+				m.privilegedSave = true
+				return m, nil
+			case "n", "N":
+				// Don't save, just quit
+				m.removeBackup()
+				m.quitting = true
+				return m, tea.Quit
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				// Cancel save prompt
+				m.savePrompt = false
+				return m, nil
+			}
+		}
+
+		// Handle save filtered CSV prompt
+		if m.saveFilteredPrompt {
+			if key.Matches(msg, m.keys.Save) {
+				filename := expandHome(m.saveFilteredInput.Value())
+				database, table, isSQLite := m.sqliteTarget(filename)
+				if filename != "" {
+					path, _ := splitSQLiteName(filename)
+					if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+						m.statusMessage = fmt.Sprintf("There's no directory %s to save into", filepath.Dir(path))
+						m.statusIsError = true
+						return m, nil
+					}
+				}
+
+				// Overwriting a file takes a second Enter, and overwriting the
+				// source with the view drops whatever is filtered out
+				warning := ""
+				_, statErr := os.Stat(filename)
+				switch {
+				case filename == "" || m.exportAppend || m.saveFilteredWarned == filename:
+				case m.isSourceFile(filename):
+					warning = m.lossySaveWarning(filename)
+				case isSQLite && sqliteHasTable(database, table):
+					warning = fmt.Sprintf("Table %s already exists in %s. Press Enter again to replace it, or change the name", table, filepath.Base(database))
+				case !isSQLite && statErr == nil:
+					warning = fmt.Sprintf("%s already exists. Press Enter again to overwrite it, or change the filename", filename)
+				}
+				if warning != "" {
+					m.saveFilteredWarned = filename
+					m.statusMessage = warning
+					m.statusIsError = true
+					return m, nil
+				}
+
+				if isSQLite {
+					// Keep the prompt open if the table can't be written. A
+					// table with other columns is replaced, as confirmed above.
+					view := append([][]string{m.activeHeaders}, m.activeRows...)
+					if err := writeSQLite(database, table, view, m.exportAppend, true); err != nil {
+						m.statusMessage = fmt.Sprintf("Error writing table %s: %v", table, err)
+						m.statusIsError = true
+						return m, nil
+					}
+					m.noteWritten(database + ":" + table)
+				} else if filename != "" && m.exportAppend {
+					// Keep the prompt open if the rows don't fit the target file
+					if m.compressionFor(filename) != "" || m.formatFor(filename) != "" {
+						m.statusMessage = fmt.Sprintf("Can't append to %s, only to delimited files", filepath.Base(filename))
+						m.statusIsError = true
+						return m, nil
+					}
+					if err := appendCSV(filename, m.activeHeaders, m.activeRows, m.delimiter, m.quoting); err != nil {
+						m.statusMessage = err.Error()
+						m.statusIsError = true
+						return m, nil
+					}
+					m.noteWritten(filename)
+				} else if filename != "" {
+					// Create filtered CSV data
+					filteredData := make([][]string, 0, len(m.activeRows)+1)
+					filteredData = append(filteredData, m.activeHeaders)
+					filteredData = append(filteredData, m.activeRows...)
+
+					// Keep the prompt open if the file can't be written. It's
+					// written as JSON or compressed when its name, or the
+					// source it saves over, calls for it.
+					if err := m.writeRecordsAs(filename, filename, filteredData, nil, nil); err != nil {
+						m.statusMessage = err.Error()
+						m.statusIsError = true
+						return m, nil
+					}
+					m.noteWritten(filename)
+				}
+				m.quitting = true
+				return m, tea.Quit
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				// Cancel save filtered prompt
+				m.saveFilteredPrompt = false
+				return m, nil
+			}
+
+			if key.Matches(msg, m.keys.Tab) {
+				// Complete the path while there's more of it to complete,
+				// and cycle through the quoting styles otherwise
+				if completion := m.saveFilteredInput.CurrentSuggestion(); len(completion) > len(m.saveFilteredInput.Value()) {
+					m.saveFilteredInput.SetValue(completion)
+					m.saveFilteredInput.CursorEnd()
+					m.saveFilteredInput.SetSuggestions(pathCompletions(completion))
+					return m, nil
+				}
+				next := (slices.Index(quoteStyles, m.quoting.style) + 1) % len(quoteStyles)
+				m.quoting.style = quoteStyles[next]
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.ToggleAppend) {
+				m.exportAppend = !m.exportAppend
+				return m, nil
+			}
+
+			// Update save filtered input and its completions, taking back
+			// the warning once the filename changes
+			var cmd tea.Cmd
+			m.saveFilteredInput, cmd = m.saveFilteredInput.Update(msg)
+			m.saveFilteredInput.SetSuggestions(pathCompletions(m.saveFilteredInput.Value()))
+			if m.saveFilteredWarned != "" && expandHome(m.saveFilteredInput.Value()) != m.saveFilteredWarned {
+				m.saveFilteredWarned = ""
+				m.statusMessage = ""
+			}
+			return m, cmd
+		}
+
+		// Handle the tray export prompt
+		if m.trayExportPrompt {
+			if key.Matches(msg, m.keys.Save) {
+				filename := strings.TrimSpace(m.trayExportInput.Value())
+				if filename == "" {
+					return m, nil
+				}
+				// Keep the prompt open on errors so the filename can be fixed
+				if err := m.exportTray(filename); err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					return m, nil
+				}
+				m.trayExportPrompt = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.trayExportPrompt = false
+				return m, nil
+			}
+			return m, updateTextInput(&m.trayExportInput, msg)
+		}
+
+		// Handle the SQL export prompt
+		if m.sqlExportPrompt {
+			if key.Matches(msg, m.keys.Save) {
+				table := strings.TrimSpace(m.sqlTableInput.Value())
+				filename := strings.TrimSpace(m.sqlFileInput.Value())
+				if table == "" || filename == "" {
+					return m, nil
+				}
+				// Keep the prompt open on errors so the filename can be fixed
+				if err := m.exportSQL(filename, table); err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					return m, nil
+				}
+				m.sqlExportPrompt = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.sqlExportPrompt = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Tab) {
+				// Switch between the table name and the filename
+				m.sqlExportStep = 1 - m.sqlExportStep
+				if m.sqlExportStep == 0 {
+					m.sqlTableInput.Focus()
+					m.sqlFileInput.Blur()
+				} else {
+					m.sqlFileInput.Focus()
+					m.sqlTableInput.Blur()
+				}
+				return m, textinput.Blink
+			}
+			if m.sqlExportStep == 0 {
+				return m, updateTextInput(&m.sqlTableInput, msg)
+			}
+			return m, updateTextInput(&m.sqlFileInput, msg)
+		}
+
+		// Handle the marked rows export prompt
+		if m.exportMarksPrompt {
+			if key.Matches(msg, m.keys.Save) {
+				filename := strings.TrimSpace(m.exportMarksInput.Value())
+				if filename == "" {
+					return m, nil
+				}
+				// Keep the prompt open on errors so the filename can be fixed
+				if err := m.exportMarked(filename); err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					return m, nil
+				}
+				m.exportMarksPrompt = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.exportMarksPrompt = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Tab) {
+				// Cycle through the marks, then any mark
+				m.exportMark = nextMark(m.exportMark)
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.ToggleMarkColumn) {
+				m.exportMarkColumn = !m.exportMarkColumn
+				return m, nil
+			}
+
+			return m, updateTextInput(&m.exportMarksInput, msg)
+		}
+
+		// Handle filter input mode
+		if m.filterMode {
+			keys := m.keysFor("filter")
+			if key.Matches(msg, keys.Save) {
+				// Apply the filter
+				query := m.filterInput.Value()
+				if query != "" {
+					if err := m.applyFilter(query); err != nil {
+						// Could show error in status, but for now just ignore
+					}
+				}
+				m.filterMode = false
+				return m, nil
+			}
+			if key.Matches(msg, keys.Cancel) {
+				// Cancel filter mode
+				m.filterMode = false
+				return m, nil
+			}
+
+			// Update filter input
+			var cmd tea.Cmd
+			cmd = updateTextInput(&m.filterInput, msg)
+			return m, cmd
+		}
+
+		// Handle editing in the formula bar
+		if m.formulaEditing {
+			keys := m.keysFor("edit")
+			if key.Matches(msg, keys.Save) {
+				m.setCell(m.cursorRow, m.cursorCol, m.formulaInput.Value())
+				m.formulaEditing = false
+				return m, nil
+			}
+			if key.Matches(msg, keys.Cancel) {
+				m.formulaEditing = false
+				return m, nil
+			}
+			return m, updateTextInput(&m.formulaInput, msg)
+		}
+
+		// Handle structured (JSON/XML) edit mode
+		if m.editMode && m.editSyntax != "" {
+			keys := m.keysFor("edit")
+			if key.Matches(msg, keys.SaveMultiline) {
+				original, _ := m.cursorValue()
+				value, err := m.structuredSaveValue(original, m.textArea.Value())
+				if err != nil {
+					m.editError = err.Error()
+					return m, nil
+				}
+				m.setCell(m.cursorRow, m.cursorCol, value)
+				m.editMode = false
+				m.editSyntax = ""
+				m.editError = ""
+				return m, nil
+			}
+			if key.Matches(msg, keys.Cancel) {
+				// Cancel edit
+				m.editMode = false
+				m.editSyntax = ""
+				m.editError = ""
+				return m, nil
+			}
+
+			// Update text area
+			m.editError = ""
+			var cmd tea.Cmd
+			m.textArea, cmd = m.textArea.Update(msg)
+			return m, cmd
+		}
+
+		// Handle edit mode
+		if m.editMode {
+			keys := m.keysFor("edit")
+			if key.Matches(msg, keys.Save) {
+				// Save the edit
+				m.setCell(m.cursorRow, m.cursorCol, m.textInput.Value())
+				m.editMode = false
+				return m, nil
+			}
+			if key.Matches(msg, keys.Cancel) {
+				// Cancel edit
+				m.editMode = false
+				return m, nil
+			}
+
+			// Update text input
+			var cmd tea.Cmd
+			cmd = updateTextInput(&m.textInput, msg)
+			return m, cmd
+		}
+		// Handle goto mode keys
+		if m.gotoMode {
+			if key.Matches(msg, m.keys.Save) {
+				// Process the current input
+				if m.gotoStep == 0 {
+					// Validate row input
+					rowStr := m.rowInput.Value()
+
+					// A cell reference like C42 or name:42 jumps straight there
+					if _, err := strconv.Atoi(rowStr); err != nil && strings.TrimSpace(rowStr) != "" {
+						row, col, err := m.cellReference(strings.TrimSpace(rowStr))
+						if err != nil {
+							m.gotoError = strings.ToUpper(err.Error()[:1]) + err.Error()[1:]
+							return m, nil
+						}
+						m.cursorRow = row
+						m.cursorCol = col
+						m.adjustViewportAfterResize()
+						m.gotoMode = false
+						m.gotoStep = 0
+						m.gotoError = ""
+						return m, nil
+					}
+
+					if rowNum, err := strconv.Atoi(rowStr); err != nil || rowNum < 1 || rowNum > len(m.activeRows) {
+						// Invalid row input - show error
+						m.gotoError = fmt.Sprintf("Invalid row: valid range 1-%d", len(m.activeRows))
+						return m, nil
+					}
+
+					// Row input valid, move to column input
+					m.gotoError = "" // Clear any previous error
+					m.gotoStep = 1
+					m.colInput = textinput.New()
+					m.colInput.Focus()
+					m.colInput.Placeholder = "Enter column number (1-" + strconv.Itoa(len(m.activeHeaders)) + ")"
+					return m, textinput.Blink
+				} else {
+					// Validate column input
+					colStr := m.colInput.Value()
+					if colNum, err := strconv.Atoi(colStr); err != nil || colNum < 1 || colNum > len(m.activeHeaders) {
+						// Invalid column input - show error
+						m.gotoError = fmt.Sprintf("Invalid column: valid range 1-%d", len(m.activeHeaders))
+						return m, nil
+					}
+
+					// Both inputs valid - jump to position
+					rowNum, _ := strconv.Atoi(m.rowInput.Value())
+					colNum, _ := strconv.Atoi(colStr)
+
+					// Jump to the specified position (convert from 1-based to 0-based)
+					m.cursorRow = rowNum - 1
+					m.cursorCol = colNum - 1
+
+					// Adjust viewport to show the new cursor position
+					m.adjustViewportAfterResize()
+					// Exit goto mode
+					m.gotoMode = false
+					m.gotoStep = 0
+					m.gotoError = ""
+					return m, nil
+				}
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				// Cancel goto mode
+				m.gotoMode = false
+				m.gotoStep = 0
+				return m, nil
+			}
+
+			// Clear error when user starts typing
+			m.gotoError = ""
+
+			// Update the appropriate text input
+			var cmd tea.Cmd
+			if m.gotoStep == 0 {
+				m.rowInput, cmd = m.rowInput.Update(msg)
+			} else {
+				m.colInput, cmd = m.colInput.Update(msg)
+			}
+			return m, cmd
+		}
+
+		// Handle search mode keys
+		if m.searchMode {
+			keys := m.keysFor("search")
+			if key.Matches(msg, keys.Save) {
+				// Perform search with filters
+				query := m.searchInput.Value()
+				rowFilter := m.searchRowInput.Value()
+				colFilter := m.searchColInput.Value()
+				m.performSearchWithFilters(query, rowFilter, colFilter)
+				m.searchMode = false
+				m.searchStep = 0
+				return m, nil
+			}
+			if key.Matches(msg, keys.Cancel) {
+				// Cancel search mode
+				m.searchMode = false
+				m.searchStep = 0
+				return m, nil
+			}
+			if key.Matches(msg, keys.Tab) {
+				// Navigate between search inputs and option toggles
+				m.searchStep = (m.searchStep + 1) % 6
+				switch m.searchStep {
+				case 0:
+					m.searchInput.Focus()
+					m.searchRowInput.Blur()
+					m.searchColInput.Blur()
+				case 1:
+					m.searchInput.Blur()
+					m.searchRowInput.Focus()
+					m.searchColInput.Blur()
+				case 2:
+					m.searchInput.Blur()
+					m.searchRowInput.Blur()
+					m.searchColInput.Focus()
+				default:
+					m.searchInput.Blur()
+					m.searchRowInput.Blur()
+					m.searchColInput.Blur()
+				}
+				return m, textinput.Blink
+			}
+
+			// Update the appropriate search input
+			var cmd tea.Cmd
+			switch m.searchStep {
+			case 0:
+				cmd = updateTextInput(&m.searchInput, msg)
+			case 1:
+				m.searchRowInput, cmd = m.searchRowInput.Update(msg)
+			case 2:
+				m.searchColInput, cmd = m.searchColInput.Update(msg)
+			case 3:
+				m.toggleSearchOption(msg, &m.searchCaseSensitive)
+			case 4:
+				m.toggleSearchOption(msg, &m.searchWholeCell)
+			case 5:
+				m.toggleSearchOption(msg, &m.searchHeaders)
+			}
+			return m, cmd
+		}
+
+		// Handle note prompt
+		if m.noteMode {
+			if key.Matches(msg, m.keys.Save) {
+				m.setNote(m.noteColumn, m.noteInput.Value())
+				m.noteMode = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.noteMode = false
+				return m, nil
+			}
+
+			return m, updateTextInput(&m.noteInput, msg)
+		}
+
+		// Handle diff revision prompt
+		if m.diffPrompt {
+			if key.Matches(msg, m.keys.Save) {
+				ref := strings.TrimSpace(m.diffInput.Value())
+				if ref == "" {
+					ref = "HEAD"
+				}
+				if err := m.diffAgainstRevision(ref); err != nil {
+					m.statusMessage = fmt.Sprintf("Diff failed: %v", err)
+					m.statusIsError = true
+				}
+				m.diffPrompt = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.diffPrompt = false
+				return m, nil
+			}
+
+			var cmd tea.Cmd
+			m.diffInput, cmd = m.diffInput.Update(msg)
+			return m, cmd
+		}
+
+		// Handle the prompt for a file to preview
+		if m.previewPrompt {
+			if key.Matches(msg, m.keys.Save) {
+				filename := strings.TrimSpace(m.previewInput.Value())
+				if filename == "" {
+					return m, nil
+				}
+				// Keep the prompt open on errors so the filename can be fixed
+				if err := m.openPreview(filename); err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					return m, nil
+				}
+				m.previewPrompt = false
+				m.statusMessage = fmt.Sprintf("Previewing %s: %s switches to it, %s copies its cell", filepath.Base(filename),
+					m.keys.SwitchPane.Help().Key, m.keys.CopyFromPreview.Help().Key)
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.previewPrompt = false
+				return m, nil
+			}
+			return m, updateTextInput(&m.previewInput, msg)
+		}
+
+		// Handle multi-cell paste confirmation
+		if m.pastePrompt {
+			switch msg.String() {
+			case "y", "Y":
+				m.pasteGridAtCursor()
+				m.pastePrompt = false
+			case "n", "N":
+				// Paste the text as is into the current cell
+				if m.setCell(m.cursorRow, m.cursorCol, m.pasteText) {
+					m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+					m.statusMessage = "Pasted into cell"
+				}
+				m.pastePrompt = false
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.pastePrompt = false
+			}
+			return m, nil
+		}
+
+		// Handle the review mark filter prompt
+		if m.markFilterPrompt {
+			mark, ok := map[string]string{
+				"t": markTodo,
+				"a": markApproved,
+				"f": markFlagged,
+				"u": "",
+			}[msg.String()]
+			if ok {
+				if err := m.applyMarkFilter(mark); err != nil {
+					m.statusMessage = fmt.Sprintf("Filter failed: %v", err)
+					m.statusIsError = true
+				}
+				m.markFilterPrompt = false
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.markFilterPrompt = false
+			}
+			return m, nil
+		}
+
+		// Handle the fuzzy column jump: the cursor follows the best match as
+		// the header is typed
+		if m.columnJumpMode {
+			var cmd tea.Cmd
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				m.columnJumpMode = false
+				if len(m.columnMatches) == 0 && m.columnJumpInput.Value() != "" {
+					m.statusMessage = fmt.Sprintf("no column matches %q", m.columnJumpInput.Value())
+					m.statusIsError = true
+				}
+				return m, nil
+			case key.Matches(msg, m.keys.Cancel):
+				m.columnJumpMode = false
+				m.cursorCol = m.columnJumpOrigin
+				m.adjustViewportAfterResize()
+				return m, nil
+			case key.Matches(msg, m.keys.Tab):
+				if len(m.columnMatches) > 0 {
+					m.columnMatchIndex = (m.columnMatchIndex + 1) % len(m.columnMatches)
+				}
+			default:
+				cmd = updateTextInput(&m.columnJumpInput, msg)
+				m.columnMatches = m.fuzzyColumns(m.columnJumpInput.Value())
+				m.columnMatchIndex = 0
+			}
+			if len(m.columnMatches) > 0 {
+				m.cursorCol = m.columnMatches[m.columnMatchIndex]
+			} else {
+				m.cursorCol = m.columnJumpOrigin
+			}
+			m.adjustViewportAfterResize()
+			return m, cmd
+		}
+
+		// Handle the theme editor's color input
+		if m.themeEditor && m.themeHexMode {
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				value := strings.TrimSpace(m.themeHexInput.Value())
+				if _, _, _, ok := colorRGB(lipgloss.Color(value)); !ok && !isBasicColor(value) {
+					m.statusMessage = fmt.Sprintf("invalid color %q: use #RRGGBB or a number from 0 to 255", value)
+					m.statusIsError = true
+					return m, nil
+				}
+				m.setThemeColor(themeSettings[m.themeSetting], lipgloss.Color(strings.ToUpper(value)))
+				m.themeHexMode = false
+			case key.Matches(msg, m.keys.Cancel):
+				m.themeHexMode = false
+			default:
+				return m, updateTextInput(&m.themeHexInput, msg)
+			}
+			return m, nil
+		}
+
+		// Handle the theme editor: pick a setting, then a color for it
+		if m.themeEditor {
+			switch {
+			case msg.String() == "w":
+				if len(m.themeEdits) == 0 {
+					m.statusMessage = "No color changes to write"
+					break
+				}
+				path, err := writeConfigColors(m.themeEdits)
+				if err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					break
+				}
+				m.statusMessage = fmt.Sprintf("Wrote %d colors to %s", len(m.themeEdits), path)
+				m.themeEdits = nil
+			case key.Matches(msg, m.keys.Tab):
+				m.themeOnPalette = !m.themeOnPalette
+			case key.Matches(msg, m.keys.Edit):
+				m.themeHexMode = true
+				m.themeHexInput = textinput.New()
+				m.themeHexInput.Placeholder = "#RRGGBB or 0-255"
+				m.themeHexInput.SetValue(string(m.themeColor(themeSettings[m.themeSetting])))
+				m.themeHexInput.Focus()
+				return m, textinput.Blink
+			case key.Matches(msg, m.keys.Cancel), key.Matches(msg, m.keys.ThemeEditor):
+				m.themeEditor = false
+				if len(m.themeEdits) > 0 {
+					m.statusMessage = fmt.Sprintf("%d color changes kept for this session; w in the theme editor writes them to the config", len(m.themeEdits))
+				}
+			case !m.themeOnPalette && key.Matches(msg, m.keys.Up):
+				m.themeSetting = max(m.themeSetting-1, 0)
+			case !m.themeOnPalette && key.Matches(msg, m.keys.Down):
+				m.themeSetting = min(m.themeSetting+1, len(themeSettings)-1)
+			case !m.themeOnPalette && key.Matches(msg, m.keys.Save):
+				m.themeOnPalette = true
+			case key.Matches(msg, m.keys.Up):
+				m.themeSwatchRow = max(m.themeSwatchRow-1, 0)
+				m.themeSwatchCol = min(m.themeSwatchCol, paletteRows[m.themeSwatchRow]-1)
+			case key.Matches(msg, m.keys.Down):
+				m.themeSwatchRow = min(m.themeSwatchRow+1, len(paletteRows)-1)
+				m.themeSwatchCol = min(m.themeSwatchCol, paletteRows[m.themeSwatchRow]-1)
+			case key.Matches(msg, m.keys.Left):
+				m.themeSwatchCol = max(m.themeSwatchCol-1, 0)
+			case key.Matches(msg, m.keys.Right):
+				m.themeSwatchCol = min(m.themeSwatchCol+1, paletteRows[m.themeSwatchRow]-1)
+			case key.Matches(msg, m.keys.Save):
+				color := paletteColor(m.themeSwatchRow, m.themeSwatchCol)
+				m.setThemeColor(themeSettings[m.themeSetting], lipgloss.Color(strconv.Itoa(color)))
+			}
+			return m, nil
+		}
+
+		// Name a new bookmark after the letter pressed
+		if m.bookmarkPrompt {
+			if name := msg.String(); isBookmarkName(name) {
+				m.setBookmark(name)
+				m.bookmarkPrompt = false
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.bookmarkPrompt = false
+			}
+			return m, nil
+		}
+
+		// Handle the bookmarks overlay: a bookmark's letter jumps straight to it
+		if m.bookmarkView {
+			names := m.bookmarkNames()
+			_, named := m.bookmarks[msg.String()]
+			switch {
+			case named:
+				m.jumpToBookmark(msg.String())
+				m.bookmarkView = false
+			case key.Matches(msg, m.keys.Up):
+				m.bookmarkCursor = max(m.bookmarkCursor-1, 0)
+			case key.Matches(msg, m.keys.Down):
+				m.bookmarkCursor = min(m.bookmarkCursor+1, len(names)-1)
+			case key.Matches(msg, m.keys.Save):
+				m.jumpToBookmark(names[m.bookmarkCursor])
+				m.bookmarkView = false
+			case key.Matches(msg, m.keys.Cancel), key.Matches(msg, m.keys.Bookmarks):
+				m.bookmarkView = false
+			}
+			return m, nil
+		}
+
+		// Handle the macro repeat count prompt
+		if m.macroPrompt {
+			if key.Matches(msg, m.keys.Save) {
+				count := 1
+				if value := strings.TrimSpace(m.macroInput.Value()); value != "" {
+					n, err := strconv.Atoi(value)
+					if err != nil || n < 1 {
+						m.statusMessage = fmt.Sprintf("invalid repeat count %q", value)
+						m.statusIsError = true
+						return m, nil
+					}
+					count = n
+				}
+				m.macroPrompt = false
+				return m.playMacro(count)
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.macroPrompt = false
+				return m, nil
+			}
+			return m, updateTextInput(&m.macroInput, msg)
+		}
+
+		// Handle the : command line
+		if m.commandMode {
+			if key.Matches(msg, m.keys.Save) {
+				m.commandMode = false
+				cmd, err := m.runCommand(m.commandInput.Value())
+				if err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+				}
+				return m, cmd
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.commandMode = false
+				return m, nil
+			}
+			return m, updateTextInput(&m.commandInput, msg)
+		}
+
+		// Handle the problems overlay
+		if m.problemsView {
+			switch {
+			case key.Matches(msg, m.keys.Up):
+				m.problemCursor = max(m.problemCursor-1, 0)
+			case key.Matches(msg, m.keys.Down):
+				m.problemCursor = min(m.problemCursor+1, len(m.problemList)-1)
+			case key.Matches(msg, m.keys.Save):
+				if m.problemCursor < len(m.problemList) {
+					m.jumpToProblem(m.problemList[m.problemCursor], m.problemCursor, len(m.problemList))
+				}
+				m.problemsView = false
+			case key.Matches(msg, m.keys.Cancel), key.Matches(msg, m.keys.ProblemsList):
+				m.problemsView = false
+			}
+			return m, nil
+		}
+
+		// Any key closes the column statistics overlay
+		if m.statsView != "" {
+			m.statsView = ""
+			return m, nil
+		}
+
+		// Handle column rename prompt
+		if m.renameMode {
+			if key.Matches(msg, m.keys.Save) {
+				if err := m.renameColumn(m.cursorCol, m.renameInput.Value()); err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					return m, nil
+				}
+				m.renameMode = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.renameMode = false
+				return m, nil
+			}
+
+			var cmd tea.Cmd
+			cmd = updateTextInput(&m.renameInput, msg)
+			return m, cmd
+		}
+
+		// Handle bulk edit prompt
+		if m.bulkEditMode {
+			if key.Matches(msg, m.keys.Save) {
+				m.bulkEdit(m.bulkEditInput.Value())
+				m.bulkEditMode = false
+				m.visualMode = false
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.bulkEditMode = false
+				return m, nil
+			}
+
+			var cmd tea.Cmd
+			cmd = updateTextInput(&m.bulkEditInput, msg)
+			return m, cmd
+		}
+
+		// Handle transform confirmation
+		if m.transformName != "" {
+			if key.Matches(msg, m.keys.Save) {
+				m.applyTransform()
+				m.visualMode = false
+			} else if key.Matches(msg, m.keys.Cancel) {
+				m.transformName, m.transform, m.transformNote, m.transformHead = "", nil, "", ""
+			}
+			return m, nil
+		}
+
+		// Handle the layout prompt for reformatting dates
+		if m.datePrompt {
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				layout := m.dateInput.Value()
+				if strings.TrimSpace(layout) == "" {
+					return m, nil
+				}
+				m.datePrompt = false
+				transform, note := m.dateReformat(layout)
+				m.transformNote = note
+				m.startTransform("dates as "+layout, transform)
+				return m, nil
+			case key.Matches(msg, m.keys.Cancel):
+				m.datePrompt = false
+				return m, nil
+			case msg.String() == "tab":
+				// Cycle through the presets, starting over from a typed layout
+				next := dateFormatPresets[0]
+				if i := slices.Index(dateFormatPresets, m.dateInput.Value()); i >= 0 {
+					next = dateFormatPresets[(i+1)%len(dateFormatPresets)]
+				}
+				m.dateInput.SetValue(next)
+				m.dateInput.CursorEnd()
+				return m, nil
+			}
+			return m, updateTextInput(&m.dateInput, msg)
+		}
+
+		// Handle the zone prompt for converting times between time zones
+		if m.zonePrompt {
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				from, err := time.LoadLocation(strings.TrimSpace(m.zoneFrom.Value()))
+				if err != nil {
+					m.statusMessage = fmt.Sprintf("Unknown time zone %q", m.zoneFrom.Value())
+					m.statusIsError = true
+					return m, nil
+				}
+				to, err := time.LoadLocation(strings.TrimSpace(m.zoneTo.Value()))
+				if err != nil {
+					m.statusMessage = fmt.Sprintf("Unknown time zone %q", m.zoneTo.Value())
+					m.statusIsError = true
+					return m, nil
+				}
+				m.zonePrompt = false
+				transform, note := m.timezoneConversion(from, to)
+				m.transformNote = note
+				m.startTransform(fmt.Sprintf("times in %s (from %s)", to, from), transform)
+				return m, nil
+			case key.Matches(msg, m.keys.Cancel):
+				m.zonePrompt = false
+				return m, nil
+			case msg.String() == "tab":
+				// Switch between the source and target zone
+				if m.zoneTo.Focused() {
+					m.zoneTo.Blur()
+					return m, m.zoneFrom.Focus()
+				}
+				m.zoneFrom.Blur()
+				return m, m.zoneTo.Focus()
+			}
+			if m.zoneFrom.Focused() {
+				return m, updateTextInput(&m.zoneFrom, msg)
+			}
+			return m, updateTextInput(&m.zoneTo, msg)
+		}
+
+		// Handle the function prompt for computed columns
+		if m.computedPrompt {
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				if err := m.addComputedColumn(m.computedInput.Value()); err != nil {
+					m.statusMessage = err.Error()
+					m.statusIsError = true
+					return m, nil
+				}
+				m.computedPrompt = false
+				m.adjustViewportAfterResize()
+				return m, nil
+			case key.Matches(msg, m.keys.Cancel):
+				m.computedPrompt = false
+				return m, nil
+			case msg.String() == "tab":
+				next := computedPresets[0]
+				if i := slices.Index(computedPresets, strings.TrimSpace(m.computedInput.Value())); i >= 0 {
+					next = computedPresets[(i+1)%len(computedPresets)]
+				}
+				m.computedInput.SetValue(next)
+				m.computedInput.CursorEnd()
+				return m, nil
+			}
+			return m, updateTextInput(&m.computedInput, msg)
+		}
+
+		// Handle the unit conversion prompt
+		if m.unitPrompt {
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				name := unitName(m.unitInput.Value())
+				if !m.unitRewrite {
+					if err := m.setUnits(name); err != nil {
+						m.statusMessage = err.Error()
+						m.statusIsError = true
+						return m, nil
+					}
+					m.unitPrompt = false
+					return m, nil
+				}
+				conversion, ok := m.unitConversions[name]
+				if !ok {
+					m.statusMessage = fmt.Sprintf("unknown conversion %q", name)
+					m.statusIsError = true
+					return m, nil
+				}
+				m.unitPrompt = false
+				if !m.visualMode {
+					m.transformHead = unitHeader(m.activeHeaders[m.cursorCol], conversion.Unit)
+				}
+				m.startTransform(name, conversion.apply)
+				return m, nil
+			case key.Matches(msg, m.keys.Cancel):
+				m.unitPrompt = false
+				return m, nil
+			case msg.String() == "tab":
+				names := m.unitNames()
+				next := names[0]
+				if i := slices.Index(names, unitName(m.unitInput.Value())); i >= 0 {
+					next = names[(i+1)%len(names)]
+				}
+				m.unitInput.SetValue(next)
+				m.unitInput.CursorEnd()
+				return m, nil
+			}
+			return m, updateTextInput(&m.unitInput, msg)
+		}
+
+		// Handle the width prompt for padding values
+		if m.padPrompt {
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				width, err := strconv.Atoi(strings.TrimSpace(m.padInput.Value()))
+				if err != nil || width < 1 {
+					m.statusMessage = "Width must be a positive number"
+					m.statusIsError = true
+					return m, nil
+				}
+				m.padPrompt = false
+				fill, name := '0', fmt.Sprintf("width %d with zeros", width)
+				if m.padSpaces {
+					fill, name = ' ', fmt.Sprintf("width %d with spaces", width)
+				}
+				m.startTransform(name, func(value string) string { return padValue(value, width, fill) })
+				return m, nil
+			case key.Matches(msg, m.keys.Cancel):
+				m.padPrompt = false
+				return m, nil
+			case msg.String() == "tab":
+				m.padSpaces = !m.padSpaces
+				return m, nil
+			}
+			return m, updateTextInput(&m.padInput, msg)
+		}
+
+		// Handle visual mode: navigation extends the selection, other keys act on it
+		if m.visualMode {
+			switch {
+			case key.Matches(msg, m.keys.Cancel), key.Matches(msg, m.keys.Visual):
+				m.visualMode = false
+				return m, nil
+			case key.Matches(msg, m.keys.CopyCell), key.Matches(msg, m.keys.CopyRow), msg.String() == "y":
+				rowStart, rowEnd, _, _ := m.selectionBounds()
+				cmd := m.copyToClipboard(m.formatRecords(m.selectionRecords()),
+					fmt.Sprintf("%dx%d selection", rowEnd-rowStart+1, len(m.selectedColumns())))
+				m.visualMode = false
+				return m, cmd
+			case msg.String() == "d", msg.String() == "x":
+				m.clearSelection()
+				m.visualMode = false
+				return m, nil
+			case key.Matches(msg, m.keys.FillDown):
+				m.fillDown()
+				m.visualMode = false
+				return m, nil
+			case key.Matches(msg, m.keys.Edit), key.Matches(msg, m.keys.BulkEdit):
+				// Edit the whole selection at once
+				return m, m.startBulkEdit()
+			case key.Matches(msg, m.keys.Mark):
+				m.cycleMark()
+				m.visualMode = false
+				return m, nil
+			}
+		}
+
+		// Handle header focus mode: column commands act on the header under the cursor
+		if m.headerMode {
+			switch {
+			case key.Matches(msg, m.keys.Save):
+				m.cycleSort(m.cursorCol)
+				return m, nil
+			case key.Matches(msg, m.keys.Cancel), key.Matches(msg, m.keys.HeaderMode), key.Matches(msg, m.keys.Down):
+				m.headerMode = false
+				return m, nil
+			case key.Matches(msg, m.keys.Left), key.Matches(msg, m.keys.Right),
+				key.Matches(msg, m.keys.PageLeft), key.Matches(msg, m.keys.PageRight), key.Matches(msg, m.keys.Quit),
+				key.Matches(msg, m.keys.WidenColumn), key.Matches(msg, m.keys.NarrowColumn):
+				// Fall through to normal navigation
+			default:
+				switch msg.String() {
+				case "x":
+					m.hideColumn(m.cursorCol)
+				case "U":
+					m.showAllColumns()
+				case "p":
+					m.togglePin(m.cursorCol)
+				case "r":
+					m.renameMode = true
+					m.renameInput = textinput.New()
+					m.renameInput.Focus()
+					m.renameInput.SetValue(m.activeHeaders[m.cursorCol])
+					m.renameInput.CursorEnd()
+					return m, textinput.Blink
+				case "s":
+					m.statsView = m.renderColumnStats(m.cursorCol)
+				}
+				return m, nil
+			}
+		}
+
+		// Handle replace confirmation (stepping through matches)
+		if m.replaceConfirm {
+			switch msg.String() {
+			case "y", "Y":
+				// Replace the current match and move on
+				m.replaceMatch(m.searchIndex)
+				m.dropSearchResult(m.searchIndex)
+			case "n", "N":
+				// Skip the current match
+				m.dropSearchResult(m.searchIndex)
+			case "a", "A":
+				// Replace every remaining match
+				for i := range m.searchResults {
+					m.replaceMatch(i)
+				}
+				m.searchResults = [][]int{}
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				m.searchResults = [][]int{}
+			}
+			if len(m.searchResults) == 0 {
+				m.finishReplace()
+			}
+			return m, nil
+		}
+
+		// Handle replace mode keys
+		if m.replaceMode {
+			if key.Matches(msg, m.keys.Save) {
+				// Find matches and start confirming replacements
+				m.performSearchWithFilters(m.searchInput.Value(), m.searchRowInput.Value(), m.searchColInput.Value())
+				m.replaceMode = false
+				m.replaceStep = 0
+				if len(m.searchResults) > 0 {
+					m.replaceConfirm = true
+					m.replaceCount = 0
+				} else if !m.statusIsError {
+					m.hasSearched = false
+					m.statusMessage = "Replace: no matches found"
+				}
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Cancel) {
+				// Cancel replace mode
+				m.replaceMode = false
+				m.replaceStep = 0
+				return m, nil
+			}
+			if key.Matches(msg, m.keys.Tab) {
+				// Navigate between replace inputs
+				m.replaceStep = (m.replaceStep + 1) % 6
+				inputs := []*textinput.Model{&m.searchInput, &m.replaceInput, &m.searchRowInput, &m.searchColInput}
+				for i, input := range inputs {
+					if i == m.replaceStep {
+						input.Focus()
+					} else {
+						input.Blur()
+					}
+				}
+				return m, textinput.Blink
+			}
+
+			// Update the appropriate replace input
+			var cmd tea.Cmd
+			switch m.replaceStep {
+			case 0:
+				cmd = updateTextInput(&m.searchInput, msg)
+			case 1:
+				cmd = updateTextInput(&m.replaceInput, msg)
+			case 2:
+				m.searchRowInput, cmd = m.searchRowInput.Update(msg)
+			case 3:
+				m.searchColInput, cmd = m.searchColInput.Update(msg)
+			case 4:
+				m.toggleSearchOption(msg, &m.searchCaseSensitive)
+			case 5:
+				m.toggleSearchOption(msg, &m.searchWholeCell)
+			}
+			return m, cmd
+		}
+		// Normal navigation mode
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			// Check if we're viewing filtered data and offer to save
+			if m.isFiltered && !m.pipeOutput {
+				m.saveFilteredPrompt = true
+				m.saveFilteredWarned = ""
 				m.saveFilteredInput = textinput.New()
+				m.saveFilteredInput.ShowSuggestions = true
 				m.saveFilteredInput.Focus()
 				m.saveFilteredInput.Placeholder = "Enter filename to save filtered CSV (or press Esc to quit without saving)"
 				return m, textinput.Blink
 			}
-			// Check if there are unsaved changes
-			if m.hasChanges {
-				m.savePrompt = true
-				return m, nil
+			// Check if there are unsaved changes
+			if m.hasChanges {
+				m.savePrompt = true
+				return m, nil
+			}
+			m.quitting = true
+			return m, tea.Quit
+		case msg.String() == "ctrl+z":
+			if runtime.GOOS == "windows" {
+				m.statusMessage = "Suspending isn't supported on Windows"
+				m.statusIsError = true
+				return m, nil
+			}
+			// Keep a backup of unsaved edits in case the suspended process is never resumed
+			if m.hasChanges {
+				if err := m.writeBackup(); err != nil {
+					m.statusMessage = fmt.Sprintf("Backup failed: %v", err)
+					m.statusIsError = true
+					return m, nil
+				}
+			}
+			return m, tea.Sequence(tea.SetWindowTitle(""), tea.Suspend)
+		case key.Matches(msg, m.keys.Help):
+			if m.helpLevel == helpFull {
+				m.setHelpLevel(helpShort)
+			} else {
+				m.setHelpLevel(helpFull)
+			}
+		case key.Matches(msg, m.keys.HelpGrow):
+			m.setHelpLevel(m.helpLevel + 1)
+		case key.Matches(msg, m.keys.HelpShrink):
+			m.setHelpLevel(m.helpLevel - 1)
+		case key.Matches(msg, m.keys.ToggleLegend):
+			m.hideLegend = !m.hideLegend
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.ToggleHelpBar):
+			m.hideHelp = !m.hideHelp
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.TogglePretty):
+			m.prettyPrint = !m.prettyPrint
+			if m.prettyPrint {
+				m.statusMessage = "Pretty-print: on"
+			} else {
+				m.statusMessage = "Pretty-print: off"
+			}
+		case key.Matches(msg, m.keys.ToggleGroup):
+			m.toggleGroupCollapse()
+		case key.Matches(msg, m.keys.HeaderMode):
+			m.headerMode = true
+		case key.Matches(msg, m.keys.FreezeRows):
+			m.toggleFrozenRows()
+		case key.Matches(msg, m.keys.FreezeCols):
+			m.toggleFrozenCols()
+		case key.Matches(msg, m.keys.SplitView):
+			// Split into two panes starting at the same position, or back to one
+			m.splitView = !m.splitView
+			if m.splitView {
+				m.otherPane = m.pane
+				m.preview = nil
+				m.previewFocused = false
+			}
+			m.focusBottom = false
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.SwitchPane):
+			if m.preview != nil {
+				m.previewFocused = !m.previewFocused
+			} else if m.splitView {
+				m.switchPane()
+			}
+		case key.Matches(msg, m.keys.OpenPreview):
+			// Close the preview pane, or ask for a file to preview
+			if m.preview != nil {
+				m.preview = nil
+				m.previewFocused = false
+				m.adjustViewportAfterResize()
+				m.statusMessage = "Preview closed"
+				return m, nil
+			}
+			m.previewPrompt = true
+			m.previewInput = textinput.New()
+			m.previewInput.Focus()
+			m.previewInput.Placeholder = "File to open read-only, e.g. codes.csv"
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.CopyFromPreview):
+			m.copyFromPreview()
+		case key.Matches(msg, m.keys.ToggleRowNumbers):
+			m.rowNumbers = !m.rowNumbers
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.ToggleFormulaBar):
+			m.formulaBar = !m.formulaBar
+			m.adjustViewportAfterResize()
+		case m.formulaBar && key.Matches(msg, m.keys.Save):
+			// Edit the cell in place in the formula bar
+			if value, ok := m.cursorValue(); ok {
+				m.formulaEditing = true
+				m.formulaInput = textinput.New()
+				m.formulaInput.Prompt = ""
+				m.formulaInput.SetValue(value)
+				m.formulaInput.CursorEnd()
+				m.formulaInput.Focus()
+				return m, textinput.Blink
+			}
+		case key.Matches(msg, m.keys.WrapCells):
+			m.wrapCells = !m.wrapCells
+			if m.wrapCells {
+				m.statusMessage = "Wrapping long values"
+			} else {
+				m.statusMessage = "Showing values on one line"
+			}
+		case key.Matches(msg, m.keys.WidenColumn):
+			m.resizeColumn(columnWidthStep)
+		case key.Matches(msg, m.keys.NarrowColumn):
+			m.resizeColumn(-columnWidthStep)
+		case key.Matches(msg, m.keys.Inspect):
+			// Toggle the inspector pane and keep the cursor on screen
+			m.showInspector = !m.showInspector
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.Edit):
+			// Enter edit mode
+			if value, ok := m.cursorValue(); ok {
+				m.editMode = true
+
+				// Edit JSON/XML in indented form when pretty-printing is on
+				if syntax := detectCellSyntax(value); m.prettyPrint && syntax != "" {
+					if formatted, err := formatStructured(value, syntax); err == nil {
+						m.editSyntax = syntax
+						m.editError = ""
+						m.textArea = textarea.New()
+						m.textArea.MaxHeight = 0
+						m.textArea.ShowLineNumbers = false
+						m.textArea.SetWidth(m.width - 2)
+						m.textArea.SetHeight(structuredEditorHeight)
+						m.textArea.SetValue(formatted)
+						m.textArea.Focus()
+						return m, textarea.Blink
+					}
+				}
+
+				m.textInput = textinput.New()
+				m.textInput.Focus()
+				m.textInput.SetValue(value)
+				m.textInput.CursorEnd()
+				return m, textinput.Blink
+			}
+		case key.Matches(msg, m.keys.CellNote):
+			return m, m.startNote(m.activeHeaders[m.cursorCol])
+		case key.Matches(msg, m.keys.RowNote):
+			return m, m.startNote("")
+		case key.Matches(msg, m.keys.Mark):
+			m.cycleMark()
+		case key.Matches(msg, m.keys.FilterMarks):
+			m.markFilterPrompt = true
+		case key.Matches(msg, m.keys.ExportMarked):
+			if len(m.marks) == 0 {
+				m.statusMessage = "No rows are marked"
+				m.statusIsError = true
+				return m, nil
+			}
+			m.exportMarksPrompt = true
+			m.exportMark = markFlagged
+			m.exportMarksInput = textinput.New()
+			m.exportMarksInput.Focus()
+			m.exportMarksInput.Placeholder = "Filename for the marked rows"
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.JumpMin):
+			m.jumpToExtreme(false)
+		case key.Matches(msg, m.keys.JumpMax):
+			m.jumpToExtreme(true)
+		case key.Matches(msg, m.keys.JumpBlank):
+			m.jumpToBlank()
+		case key.Matches(msg, m.keys.ExternalEdit):
+			return m, m.editInExternalEditor()
+		case key.Matches(msg, m.keys.Undo):
+			m.undo()
+		case key.Matches(msg, m.keys.FillDown):
+			m.fillDown()
+		case key.Matches(msg, m.keys.BulkEdit):
+			return m, m.startBulkEdit()
+		case key.Matches(msg, m.keys.CaseUpper):
+			m.startTransform("UPPER", caseTransforms["UPPER"])
+		case key.Matches(msg, m.keys.CaseLower):
+			m.startTransform("lower", caseTransforms["lower"])
+		case key.Matches(msg, m.keys.CaseTitle):
+			m.startTransform("Title Case", caseTransforms["Title Case"])
+		case key.Matches(msg, m.keys.PadColumn):
+			return m, m.startPad()
+		case key.Matches(msg, m.keys.UnpadColumn):
+			m.startTransform("no padding", unpadValue)
+		case key.Matches(msg, m.keys.ReformatDates):
+			return m, m.startDateReformat()
+		case key.Matches(msg, m.keys.ConvertTimezone):
+			return m, m.startTimezoneConversion()
+		case key.Matches(msg, m.keys.ComputedColumn):
+			if m.cursorCol >= len(m.activeHeaders) {
+				break
+			}
+			if m.isFiltered {
+				m.statusMessage = "Reset filters before adding columns"
+				m.statusIsError = true
+				break
+			}
+			m.computedPrompt = true
+			m.computedInput = textinput.New()
+			m.computedInput.Placeholder = "sum, avg, min, or max, and an optional row count"
+			m.computedInput.SetValue(computedPresets[0])
+			m.computedInput.CursorEnd()
+			m.computedInput.Focus()
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.ShowUnits):
+			return m, m.startUnits(false)
+		case key.Matches(msg, m.keys.ConvertUnits):
+			return m, m.startUnits(true)
+		case key.Matches(msg, m.keys.Visual):
+			m.visualMode = true
+			m.anchorRow = m.cursorRow
+			m.anchorCol = m.cursorCol
+		case key.Matches(msg, m.keys.InsertRow):
+			m.insertRow()
+		case key.Matches(msg, m.keys.SetTemplate):
+			// Use the current row's values as the template for new rows
+			if m.cursorRow < len(m.activeRows) {
+				m.rowTemplate = make(map[string]string)
+				for i, header := range m.activeHeaders {
+					if i < len(m.activeRows[m.cursorRow]) {
+						m.rowTemplate[header] = m.activeRows[m.cursorRow][i]
+					}
+				}
+				m.statusMessage = fmt.Sprintf("Row %d set as template for new rows", m.cursorRow+1)
+			}
+		case key.Matches(msg, m.keys.CopyCell):
+			if m.cursorRow < len(m.activeRows) && m.cursorCol < len(m.activeRows[m.cursorRow]) {
+				cmd := m.copyToClipboard(m.activeRows[m.cursorRow][m.cursorCol], "cell")
+				return m, cmd
+			}
+		case key.Matches(msg, m.keys.CopyRow):
+			if m.cursorRow < len(m.activeRows) {
+				cmd := m.copyToClipboard(m.formatRecords([][]string{m.activeRows[m.cursorRow]}), "row")
+				return m, cmd
+			}
+		case key.Matches(msg, m.keys.CopyColumn):
+			// Copy the header followed by every value in the column, one per line
+			column := [][]string{{m.activeHeaders[m.cursorCol]}}
+			for _, row := range m.activeRows {
+				value := ""
+				if m.cursorCol < len(row) {
+					value = row[m.cursorCol]
+				}
+				column = append(column, []string{value})
+			}
+			cmd := m.copyToClipboard(m.formatRecords(column), "column")
+			return m, cmd
+		case key.Matches(msg, m.keys.DiffRevision):
+			// Clear the current diff, or ask which revision to diff against
+			if m.diffRef != "" {
+				m.diffRef = ""
+				m.diffCells = nil
+				m.statusMessage = "Diff cleared"
+				return m, nil
+			}
+			m.diffPrompt = true
+			m.diffInput = textinput.New()
+			m.diffInput.Focus()
+			m.diffInput.Placeholder = "HEAD"
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.Paste):
+			m.paste()
+		case key.Matches(msg, m.keys.GoTo):
+			// Enter goto mode
+			m.gotoMode = true
+			m.gotoStep = 0
+			m.gotoError = ""
+			m.rowInput = textinput.New()
+			m.rowInput.Focus()
+			m.rowInput.Placeholder = "Enter row number (1-" + strconv.Itoa(len(m.activeRows)) + ") or a cell like C42 or name:42"
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.Search):
+			m.startSearch()
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.SearchColumn):
+			// Search with the column filter set to the cursor's column
+			m.startSearch()
+			m.searchColInput.SetValue(strconv.Itoa(m.cursorCol + 1))
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.Replace):
+			// Enter replace mode
+			m.replaceMode = true
+			m.replaceStep = 0
+
+			// Initialize all replace inputs, sharing the search term and filters
+			m.searchInput = textinput.New()
+			m.searchInput.Focus()
+			m.searchInput.Placeholder = "Enter search term (prefix with / for regex, \\/ for a literal /)..."
+
+			m.replaceInput = textinput.New()
+			m.replaceInput.Placeholder = "Replacement text"
+
+			m.searchRowInput = textinput.New()
+			m.searchRowInput.Placeholder = "Row filter (1-" + strconv.Itoa(len(m.activeRows)) + ", optional)"
+
+			m.searchColInput = textinput.New()
+			m.searchColInput.Placeholder = "Col filter (1-" + strconv.Itoa(len(m.activeHeaders)) + ", optional)"
+
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.Filter):
+			// Enter filter mode
+			m.filterMode = true
+			m.filterInput = textinput.New()
+			m.filterInput.Focus()
+			m.filterInput.Placeholder = "SELECT col1,col2 WHERE col3 == \"value\""
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.ResetFilters):
+			// Reset all filters
+			m.resetFilters()
+		case key.Matches(msg, m.keys.CommandLine):
+			m.commandMode = true
+			m.commandInput = textinput.New()
+			m.commandInput.Prompt = ":"
+			m.commandInput.Focus()
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.ColumnJump):
+			if len(m.activeHeaders) == 0 {
+				break
+			}
+			m.columnJumpMode = true
+			m.columnJumpOrigin = m.cursorCol
+			m.columnMatches = nil
+			m.columnMatchIndex = 0
+			m.columnJumpInput = textinput.New()
+			m.columnJumpInput.Placeholder = "part of a header"
+			m.columnJumpInput.Focus()
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.ThemeEditor):
+			m.themeEditor = true
+			m.themeOnPalette = false
+		case key.Matches(msg, m.keys.SetBookmark):
+			if len(m.activeRows) == 0 {
+				break
+			}
+			m.bookmarkPrompt = true
+		case key.Matches(msg, m.keys.Bookmarks):
+			if len(m.bookmarks) == 0 {
+				m.statusMessage = "No bookmarks set, ` and a letter sets one"
+				break
+			}
+			m.bookmarkCursor = 0
+			m.bookmarkView = true
+		case key.Matches(msg, m.keys.RecordMacro):
+			if m.playingMacro {
+				break
+			}
+			if m.recordingMacro {
+				m.recordingMacro = false
+				m.statusMessage = fmt.Sprintf("Recorded macro of %d keys", len(m.macroKeys))
+			} else {
+				m.recordingMacro = true
+				m.macroKeys = nil
+				m.statusMessage = "Recording macro, Q to stop"
+			}
+		case key.Matches(msg, m.keys.PlayMacro):
+			// Playing from inside a macro would recurse, and playing while
+			// recording would record the playback
+			if m.playingMacro || m.recordingMacro {
+				break
+			}
+			if len(m.macroKeys) == 0 {
+				m.statusMessage = "No macro recorded"
+				m.statusIsError = true
+				break
+			}
+			m.macroPrompt = true
+			m.macroInput = textinput.New()
+			m.macroInput.Placeholder = "1"
+			m.macroInput.Focus()
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.GoTop):
+			m.cursorRow = 0
+			m.viewportY = 0
+		case key.Matches(msg, m.keys.GoBottom):
+			m.cursorRow = len(m.activeRows) - 1
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.FirstColumn):
+			m.cursorCol = m.stepColumn(-1, 1)
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.LastColumn):
+			m.cursorCol = m.stepColumn(len(m.activeHeaders), -1)
+			m.adjustViewportAfterResize()
+		case key.Matches(msg, m.keys.NextProblem):
+			m.navigateToProblem(true)
+		case key.Matches(msg, m.keys.PrevProblem):
+			m.navigateToProblem(false)
+		case key.Matches(msg, m.keys.FixRaggedRows):
+			m.fixRaggedRows()
+		case key.Matches(msg, m.keys.ProblemsList):
+			m.problemList = m.problems()
+			if len(m.problemList) == 0 {
+				m.statusMessage = "No problems found"
+				break
+			}
+			// Start on the first problem at or after the cursor
+			m.problemCursor = 0
+			for i, p := range m.problemList {
+				if p.row > m.cursorRow || (p.row == m.cursorRow && p.col >= m.cursorCol) {
+					m.problemCursor = i
+					break
+				}
+			}
+			m.problemsView = true
+		case key.Matches(msg, m.keys.NextMatch):
+			// Navigate to next search result
+			if m.hasSearched && len(m.searchResults) > 0 {
+				m.navigateToSearchResult(m.searchIndex + 1)
+			}
+		case key.Matches(msg, m.keys.PrevMatch):
+			// Navigate to previous search result
+			if m.hasSearched && len(m.searchResults) > 0 {
+				m.navigateToSearchResult(m.searchIndex - 1)
+			}
+		case key.Matches(msg, m.keys.Left):
+			if prev := m.stepColumn(m.cursorCol, -1); prev != m.cursorCol {
+				m.cursorCol = prev
+				// Adjust viewport if cursor moved out of visible area
+				if !m.isColumnPinned(m.cursorCol) && m.cursorCol < m.viewportX {
+					m.viewportX = m.cursorCol
+				}
+			}
+		case key.Matches(msg, m.keys.Right):
+			if next := m.stepColumn(m.cursorCol, 1); next != m.cursorCol {
+				m.cursorCol = next
+				// Stepping off the pinned columns can land left of the scrolled range
+				if !m.isColumnPinned(m.cursorCol) && m.cursorCol < m.viewportX {
+					m.viewportX = m.cursorCol
+				}
+				// Check if cursor is now out of visible area and adjust viewport
+				_, endCol := m.calculateVisibleColumns()
+				for !m.isColumnPinned(m.cursorCol) && m.cursorCol >= endCol && m.viewportX < m.cursorCol {
+					// Move viewport right by one column to show the cursor
+					m.viewportX++
+					_, endCol = m.calculateVisibleColumns()
+				}
+			}
+		case key.Matches(msg, m.keys.Down):
+			if m.cursorRow < len(m.activeRows)-1 {
+				m.cursorRow++
+				maxRows := m.maxVisibleRows()
+				if m.cursorRow >= m.viewportY+maxRows {
+					m.viewportY++
+				}
+			}
+		case key.Matches(msg, m.keys.Up):
+			if m.cursorRow > 0 {
+				m.cursorRow--
+				if m.cursorRow < m.viewportY {
+					m.viewportY = m.cursorRow
+				}
+			}
+		case key.Matches(msg, m.keys.PageDown) && m.wrapCells:
+			m.pageWrappedLines(m.maxVisibleRows())
+		case key.Matches(msg, m.keys.FileComments):
+			if len(m.preamble) == 0 {
+				m.statusMessage = "No comment lines above the header (set import.commentPrefix to skip them)"
+				return m, nil
+			}
+			m.statsView = m.renderPreamble()
+		case key.Matches(msg, m.keys.ColumnStats):
+			m.statsView = m.renderColumnStats(m.cursorCol)
+		case key.Matches(msg, m.keys.HideColumn):
+			m.hideColumn(m.cursorCol)
+		case key.Matches(msg, m.keys.ShowColumns):
+			m.showAllColumns()
+		case key.Matches(msg, m.keys.PinColumn):
+			m.togglePin(m.cursorCol)
+		case key.Matches(msg, m.keys.ToggleHeatmap):
+			m.toggleHeatmap(m.cursorCol)
+		case key.Matches(msg, m.keys.ToggleDataBars):
+			m.toggleDataBars(m.cursorCol)
+		case key.Matches(msg, m.keys.PinRow):
+			m.togglePinnedRow()
+		case key.Matches(msg, m.keys.ClearTray):
+			if len(m.tray) > 0 {
+				m.statusMessage = fmt.Sprintf("Cleared %d row(s) from the tray", len(m.tray))
+				m.tray = nil
+				m.adjustViewportAfterResize()
+			}
+		case key.Matches(msg, m.keys.ExportTray):
+			if len(m.tray) == 0 {
+				m.statusMessage = "No rows are pinned to the tray"
+				m.statusIsError = true
+				return m, nil
+			}
+			m.trayExportPrompt = true
+			m.trayExportInput = textinput.New()
+			m.trayExportInput.Focus()
+			m.trayExportInput.Placeholder = "Filename for the tray rows"
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.ExportSQL):
+			// The table is named after the data's file to start with
+			name := m.sqliteTable
+			if name == "" {
+				name, _, _ = strings.Cut(filepath.Base(m.saveFilename()), ".")
+			}
+			m.sqlExportPrompt = true
+			m.sqlExportStep = 0
+			m.sqlTableInput = textinput.New()
+			m.sqlTableInput.Placeholder = "Table to insert into"
+			m.sqlTableInput.SetValue(name)
+			m.sqlTableInput.Focus()
+			m.sqlFileInput = textinput.New()
+			m.sqlFileInput.Placeholder = "Filename for the statements"
+			m.sqlFileInput.SetValue(name + ".sql")
+			return m, textinput.Blink
+		case key.Matches(msg, m.keys.HalfPageDown):
+			m.scrollHalfPage(1)
+		case key.Matches(msg, m.keys.HalfPageUp):
+			m.scrollHalfPage(-1)
+		case key.Matches(msg, m.keys.CenterCursor):
+			m.centerCursor()
+		case key.Matches(msg, m.keys.PageUp) && m.wrapCells:
+			m.pageWrappedLines(-m.maxVisibleRows())
+		case key.Matches(msg, m.keys.PageDown):
+			// Page down - jump by visible rows
+			maxRows := m.maxVisibleRows()
+			newRow := m.cursorRow + maxRows
+			if newRow >= len(m.activeRows) {
+				newRow = len(m.activeRows) - 1
+			}
+			m.cursorRow = newRow
+			// Adjust viewport to show the new cursor position
+			if m.cursorRow >= m.viewportY+maxRows {
+				m.viewportY = m.cursorRow - maxRows + 1
+				if m.viewportY < 0 {
+					m.viewportY = 0
+				}
+			}
+		case key.Matches(msg, m.keys.PageUp):
+			// Page up - jump by visible rows
+			maxRows := m.maxVisibleRows()
+			newRow := m.cursorRow - maxRows
+			if newRow < 0 {
+				newRow = 0
+			}
+			m.cursorRow = newRow
+			// Adjust viewport to show the new cursor position
+			if m.cursorRow < m.viewportY {
+				m.viewportY = m.cursorRow
+			}
+		case key.Matches(msg, m.keys.PageRight):
+			// Page right - jump by visible columns
+			startCol, endCol := m.calculateVisibleColumns()
+			visibleCols := endCol - startCol
+			if visibleCols < 1 {
+				visibleCols = 1
+			}
+			newCol := m.cursorCol + visibleCols
+			if newCol >= len(m.activeHeaders) {
+				newCol = len(m.activeHeaders) - 1
+			}
+			m.cursorCol = m.visibleColumn(newCol)
+			// Adjust viewport to show the new cursor position
+			_, currentEndCol := m.calculateVisibleColumns()
+			if !m.isColumnPinned(m.cursorCol) && m.cursorCol >= currentEndCol {
+				m.viewportX = m.cursorCol - visibleCols + 1
+				if m.viewportX < 0 {
+					m.viewportX = 0
+				}
+				if m.viewportX >= len(m.activeHeaders) {
+					m.viewportX = len(m.activeHeaders) - 1
+				}
+			}
+		case key.Matches(msg, m.keys.PageLeft):
+			// Page left - jump by visible columns
+			startCol, endCol := m.calculateVisibleColumns()
+			visibleCols := endCol - startCol
+			if visibleCols < 1 {
+				visibleCols = 1
+			}
+			newCol := m.cursorCol - visibleCols
+			if newCol < 0 {
+				newCol = 0
+			}
+			m.cursorCol = m.visibleColumn(newCol)
+			// Adjust viewport to show the new cursor position, scrolling all the
+			// way back when landing on a pinned column
+			if m.isColumnPinned(m.cursorCol) {
+				m.viewportX = 0
+			} else if m.cursorCol < m.viewportX {
+				m.viewportX = m.cursorCol
+			}
+		}
+	}
+	return m, nil
+}
+
+// columnWidth returns the width a column is laid out at: its widest value
+// clamped to 8-20 characters, or its manual width. Measuring looks at every
+// row, so only the columns being laid out are measured, and while rendering
+// each column is measured once.
+func (m model) columnWidth(col int) int {
+	if width, ok := m.widthCache[col]; ok {
+		return width
+	}
+
+	width, ok := m.columnWidths[m.activeHeaders[col]]
+	if !ok {
+		width = displayWidth(m.headerText(col))
+		for _, row := range m.activeRows {
+			if col < len(row) {
+				width = max(width, displayWidth(m.cellText(row[col], col)))
+			}
+		}
+		width = min(max(width, 8), 20)
+		if m.dataBars[m.activeHeaders[col]] {
+			width += 1 + dataBarWidth
+		}
+	}
+
+	if m.widthCache != nil {
+		m.widthCache[col] = width
+	}
+	return width
+}
+
+// tableChrome returns the width the table's outer borders take up, and the
+// padding and separator each column adds to its content. The compact
+// borderless table pads cells on the right only.
+func (m model) tableChrome() (border, padding, separator int) {
+	if m.border == borderNone {
+		return 0, 1, 0
+	}
+	return 2, 2, 1
+}
+
+func (m model) calculateVisibleColumns() (int, int) {
+	columnCount := len(m.activeHeaders)
+	if columnCount == 0 {
+		return 0, 0
+	}
+
+	// Account for lipgloss table styling overhead:
+	// - Left and right table borders: 2 chars
+	// - Each column has padding (2 chars total per column)
+	// - Column separators between columns: 1 char each
+	// - Additional margin for safety: 4 chars
+	// The compact table has no borders or separators and half the padding.
+	tableBorderWidth, padding, separator := m.tableChrome()
+	marginWidth := 4
+	availableWidth := m.width - tableBorderWidth - marginWidth
+
+	// The gutter and pinned columns are always drawn, so scroll through what's left
+	if m.rowNumbers {
+		availableWidth -= m.gutterWidth() + padding + separator
+	}
+	for _, c := range m.pinnedColumnIndices() {
+		availableWidth -= m.columnWidth(c) + padding + separator
+	}
+
+	startCol := m.viewportX
+	if startCol >= columnCount {
+		startCol = columnCount - 1
+	}
+	if startCol < 0 {
+		startCol = 0
+	}
+	// Never start the view on a hidden column
+	for startCol > 0 && m.isColumnHidden(startCol) {
+		startCol--
+	}
+
+	// Calculate how many columns we can fit starting from startCol
+	currentWidth := 0
+	endCol := startCol
+
+	for i := startCol; i < columnCount; i++ {
+		// Hidden columns take up no space, and pinned ones are already counted
+		if m.isColumnHidden(i) || m.isColumnPinned(i) {
+			endCol = i + 1
+			continue
+		}
+
+		// Calculate space needed for this column:
+		// - column content width
+		// - padding (2 chars: 1 on each side)
+		// - column separator (1 char, but not for the last column we're considering)
+		columnSpace := m.columnWidth(i) + padding
+
+		// Add separator space if this isn't the first column we're adding
+		if i > startCol {
+			columnSpace += separator
+		}
+
+		// Check if adding this column would exceed available width
+		if currentWidth+columnSpace <= availableWidth {
+			currentWidth += columnSpace
+			endCol = i + 1
+		} else {
+			break
+		}
+	}
+
+	// Ensure we show at least one column
+	if endCol <= startCol {
+		endCol = startCol + 1
+	}
+
+	// Ensure endCol doesn't exceed the number of columns
+	if endCol > columnCount {
+		endCol = columnCount
+	}
+
+	return startCol, endCol
+}
+
+// groupsFromRow maps headers to the group named above them in a group row.
+// Blank cells inherit the group to their left, matching how spreadsheets
+// export merged header cells.
+func groupsFromRow(groupRow, headers []string) map[string]string {
+	groups := make(map[string]string)
+	current := ""
+	for i, header := range headers {
+		if i < len(groupRow) && strings.TrimSpace(groupRow[i]) != "" {
+			current = strings.TrimSpace(groupRow[i])
+		}
+		if current != "" {
+			groups[header] = current
+		}
+	}
+	return groups
+}
+
+// loadGroupSpec reads the optional sidecar spec (<file>.groups.json) which maps
+// group names to the headers they span, e.g. {"Billing": ["street", "city"]}
+func loadGroupSpec(filename string) (map[string]string, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	specPath := filename + ".groups.json"
+	data, err := os.ReadFile(specPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group spec %s: %v", specPath, err)
+	}
+
+	var spec map[string][]string
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse group spec %s: %v", specPath, err)
+	}
+
+	groups := make(map[string]string)
+	for group, headers := range spec {
+		for _, header := range headers {
+			groups[header] = group
+		}
+	}
+	return groups, nil
+}
+
+// columnGroup returns the group of an active column, or "" if it has none
+func (m model) columnGroup(col int) string {
+	if m.columnGroups == nil || col < 0 || col >= len(m.activeHeaders) {
+		return ""
+	}
+	return m.columnGroups[m.activeHeaders[col]]
+}
+
+// isColumnHidden reports whether a column is left out of the table view, which
+// is the case for columns hidden from header mode and for all but the first
+// column of a collapsed group
+func (m model) isColumnHidden(col int) bool {
+	if col < 0 || col >= len(m.activeHeaders) {
+		return false
+	}
+	if m.hiddenColumns[m.activeHeaders[col]] {
+		return true
+	}
+	if col == 0 {
+		return false
+	}
+	group := m.columnGroup(col)
+	return group != "" && m.collapsedGroups[group] && m.columnGroup(col-1) == group
+}
+
+// stepColumn returns the nearest non-hidden column from the given column in
+// the given direction, or the column itself when there is none
+func (m model) stepColumn(from, dir int) int {
+	for c := from + dir; c >= 0 && c < len(m.activeHeaders); c += dir {
+		if !m.isColumnHidden(c) {
+			return c
+		}
+	}
+	return from
+}
+
+// visibleColumn returns the column itself when it is shown, otherwise the
+// nearest shown column, looking left first
+func (m model) visibleColumn(col int) int {
+	if !m.isColumnHidden(col) {
+		return col
+	}
+	if prev := m.stepColumn(col, -1); prev != col {
+		return prev
+	}
+	return m.stepColumn(col, 1)
+}
+
+// isColumnPinned reports whether a column is drawn on the left regardless of
+// horizontal scrolling, either because it was pinned or is one of the frozen
+// leading columns
+func (m model) isColumnPinned(col int) bool {
+	return col >= 0 && col < len(m.activeHeaders) && (col < m.frozenCols || m.pinnedColumns[m.activeHeaders[col]])
+}
+
+// toggleFrozenCols freezes the columns up to the cursor, or unfreezes
+func (m *model) toggleFrozenCols() {
+	if m.frozenCols > 0 {
+		m.frozenCols = 0
+		m.statusMessage = "Columns unfrozen"
+	} else {
+		m.frozenCols = m.cursorCol + 1
+		m.statusMessage = fmt.Sprintf("Froze the first %d column(s)", m.frozenCols)
+	}
+	m.adjustViewportAfterResize()
+}
+
+// pinnedColumnIndices returns the shown pinned columns in column order
+func (m model) pinnedColumnIndices() []int {
+	var pinned []int
+	for c := range m.activeHeaders {
+		if m.isColumnPinned(c) && !m.isColumnHidden(c) {
+			pinned = append(pinned, c)
+		}
+	}
+	return pinned
+}
+
+// toggleGroupCollapse collapses or expands the group under the cursor
+func (m *model) toggleGroupCollapse() {
+	group := m.columnGroup(m.cursorCol)
+	if group == "" {
+		m.statusMessage = "Column is not part of a group"
+		return
+	}
+
+	if m.collapsedGroups == nil {
+		m.collapsedGroups = make(map[string]bool)
+	}
+	m.collapsedGroups[group] = !m.collapsedGroups[group]
+
+	// Keep the cursor on the column that stays visible
+	m.cursorCol = m.visibleColumn(m.cursorCol)
+	if m.viewportX > m.cursorCol {
+		m.viewportX = m.cursorCol
+	}
+	m.adjustViewportAfterResize()
+}
+
+// columnBounds returns the screen columns of the separators around each
+// column of a rendered table, found in its top border. The compact table has
+// none, but its columns have fixed widths, so the bounds are where the
+// separators would be if each column's last padding were one.
+func (m model) columnBounds(tableView string, displayCols []int) []int {
+	var bounds []int
+	if m.border == borderNone {
+		bounds = append(bounds, -1)
+		x := -1
+		for _, c := range displayCols {
+			if c < 0 {
+				x += m.gutterWidth() + 1
+			} else {
+				x += m.columnWidth(c) + 1
+			}
+			bounds = append(bounds, x)
+		}
+		return bounds
+	}
+
+	topBorder := []rune(ansi.Strip(strings.SplitN(tableView, "\n", 2)[0]))
+	separator := []rune(tableBorders[m.border].MiddleTop)[0]
+	for i, r := range topBorder {
+		if i == 0 || i == len(topBorder)-1 || r == separator {
+			bounds = append(bounds, i)
+		}
+	}
+	return bounds
+}
+
+// renderGroupBand renders the spanning group header line, aligned to the
+// column boundaries of the rendered table
+func (m model) renderGroupBand(tableView string, visibleCols []int) string {
+	bounds := m.columnBounds(tableView, visibleCols)
+	if len(bounds) != len(visibleCols)+1 {
+		return ""
+	}
+
+	bandStyle := m.renderer.NewStyle().Foreground(m.theme.Text).Background(m.theme.BandBackground).Bold(true)
+
+	var b strings.Builder
+	if bounds[0] >= 0 {
+		b.WriteString(" ")
+	}
+	for j := 0; j < len(visibleCols); {
+		group := m.columnGroup(visibleCols[j])
+
+		// Extend the span across adjacent columns in the same group
+		k := j + 1
+		for group != "" && k < len(visibleCols) && m.columnGroup(visibleCols[k]) == group {
+			k++
+		}
+		width := bounds[k] - bounds[j] - 1
+
+		if group == "" {
+			b.WriteString(strings.Repeat(" ", width))
+		} else {
+			label := group
+			if m.collapsedGroups[group] {
+				hidden := 0
+				for c := visibleCols[j] + 1; c < len(m.activeHeaders) && m.isColumnHidden(c); c++ {
+					hidden++
+				}
+				label = fmt.Sprintf("▸ %s (+%d)", group, hidden)
+			}
+			label = ansi.Truncate(label, width, "…")
+			b.WriteString(bandStyle.Width(width).Align(lipgloss.Center).Render(label))
+		}
+		b.WriteString(" ")
+		j = k
+	}
+	return b.String()
+}
+
+// cycleSort advances the sort of a column through ascending, descending,
+// and unsorted
+func (m *model) cycleSort(col int) {
+	header := m.activeHeaders[col]
+	switch {
+	case m.sortColumn != header:
+		m.sortColumn = header
+		m.sortDesc = false
+	case !m.sortDesc:
+		m.sortDesc = true
+	default:
+		m.sortColumn = ""
+		m.sortDesc = false
+	}
+	m.applySort()
+}
+
+// applySort orders the active rows by the sort column, or back into file
+// order when unsorted. The sort is stable and blank cells always sort last.
+// Only the view is reordered; saving keeps the file's row order.
+func (m *model) applySort() {
+	if len(m.rowIndex) != len(m.activeRows) {
+		return
+	}
+
+	// Remember which row the cursor is on so it can follow it
+	cursorSource := -1
+	if m.cursorRow < len(m.rowIndex) {
+		cursorSource = m.rowIndex[m.cursorRow]
+	}
+
+	col := -1
+	for i, header := range m.activeHeaders {
+		if header == m.sortColumn {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		m.sortColumn = ""
+	}
+	numeric := col >= 0 && col < len(m.activeColumnTypes) &&
+		(m.activeColumnTypes[col] == DataTypeInt || m.activeColumnTypes[col] == DataTypeFloat)
+
+	order := make([]int, len(m.activeRows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if col < 0 {
+			return m.rowIndex[order[a]] < m.rowIndex[order[b]]
+		}
+
+		va, vb := "", ""
+		if col < len(m.activeRows[order[a]]) {
+			va = strings.TrimSpace(m.activeRows[order[a]][col])
+		}
+		if col < len(m.activeRows[order[b]]) {
+			vb = strings.TrimSpace(m.activeRows[order[b]][col])
+		}
+		if va == "" || vb == "" {
+			return va != "" && vb == ""
+		}
+
+		if numeric {
+			fa, errA := strconv.ParseFloat(va, 64)
+			fb, errB := strconv.ParseFloat(vb, 64)
+			if errA == nil && errB == nil {
+				if m.sortDesc {
+					return fa > fb
+				}
+				return fa < fb
+			}
+		}
+		if m.sortDesc {
+			return strings.ToLower(va) > strings.ToLower(vb)
+		}
+		return strings.ToLower(va) < strings.ToLower(vb)
+	})
+
+	rows := make([][]string, len(order))
+	rowIndex := make([]int, len(order))
+	for i, o := range order {
+		rows[i] = m.activeRows[o]
+		rowIndex[i] = m.rowIndex[o]
+		if rowIndex[i] == cursorSource && cursorSource >= 0 {
+			m.cursorRow = i
+		}
+	}
+	m.activeRows = rows
+	m.rowIndex = rowIndex
+	m.adjustViewportAfterResize()
+}
+
+// jumpToExtreme moves the cursor to the smallest or largest value in the
+// cursor column. Numeric columns compare numerically, others as text.
+func (m *model) jumpToExtreme(largest bool) {
+	col := m.cursorCol
+	numeric := col < len(m.activeColumnTypes) &&
+		(m.activeColumnTypes[col] == DataTypeInt || m.activeColumnTypes[col] == DataTypeFloat)
+
+	best := -1
+	var bestNum float64
+	var bestText string
+	for r, row := range m.activeRows {
+		if col >= len(row) || strings.TrimSpace(row[col]) == "" {
+			continue
+		}
+		value := strings.TrimSpace(row[col])
+		if numeric {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if best < 0 || (largest && f > bestNum) || (!largest && f < bestNum) {
+				best, bestNum = r, f
+			}
+			continue
+		}
+		if best < 0 || (largest && value > bestText) || (!largest && value < bestText) {
+			best, bestText = r, value
+		}
+	}
+
+	if best < 0 {
+		m.statusMessage = "Column has no values"
+		return
+	}
+	m.cursorRow = best
+	m.adjustViewportAfterResize()
+	if largest {
+		m.statusMessage = "Largest value in " + m.activeHeaders[col]
+	} else {
+		m.statusMessage = "Smallest value in " + m.activeHeaders[col]
+	}
+}
+
+// jumpToBlank moves the cursor to the next blank cell below it in the cursor
+// column, wrapping around to the top
+func (m *model) jumpToBlank() {
+	for i := 1; i <= len(m.activeRows); i++ {
+		r := (m.cursorRow + i) % len(m.activeRows)
+		if m.cursorCol >= len(m.activeRows[r]) || strings.TrimSpace(m.activeRows[r][m.cursorCol]) == "" {
+			m.cursorRow = r
+			m.adjustViewportAfterResize()
+			return
+		}
+	}
+	m.statusMessage = "No blank cells in " + m.activeHeaders[m.cursorCol]
+}
+
+// showAllColumns brings back every hidden column
+func (m *model) showAllColumns() {
+	m.hiddenColumns = nil
+	m.statusMessage = "Showing all columns"
+	m.adjustViewportAfterResize()
+}
+
+// hideColumn hides a column from the view, keeping at least one column shown
+func (m *model) hideColumn(col int) {
+	shown := 0
+	for c := range m.activeHeaders {
+		if !m.isColumnHidden(c) {
+			shown++
+		}
+	}
+	if shown <= 1 {
+		m.statusMessage = "Cannot hide the last visible column"
+		m.statusIsError = true
+		return
+	}
+
+	if m.hiddenColumns == nil {
+		m.hiddenColumns = make(map[string]bool)
+	}
+	m.hiddenColumns[m.activeHeaders[col]] = true
+	m.statusMessage = fmt.Sprintf("Hid column %q (U to show all)", m.activeHeaders[col])
+
+	m.cursorCol = m.visibleColumn(m.cursorCol)
+	if m.viewportX > m.cursorCol {
+		m.viewportX = m.cursorCol
+	}
+	m.adjustViewportAfterResize()
+}
+
+// togglePin pins or unpins a column to the left of the view
+func (m *model) togglePin(col int) {
+	if m.pinnedColumns == nil {
+		m.pinnedColumns = make(map[string]bool)
+	}
+	header := m.activeHeaders[col]
+	if m.pinnedColumns[header] {
+		delete(m.pinnedColumns, header)
+		m.statusMessage = fmt.Sprintf("Unpinned column %q", header)
+	} else {
+		m.pinnedColumns[header] = true
+		m.statusMessage = fmt.Sprintf("Pinned column %q", header)
+	}
+	m.adjustViewportAfterResize()
+}
+
+// toggleHeatmap colors a column's cells by where their values sit between
+// the column's lowest and highest, or turns that off again
+func (m *model) toggleHeatmap(col int) {
+	if m.heatmaps == nil {
+		m.heatmaps = make(map[string]bool)
+	}
+	header := m.activeHeaders[col]
+	if m.heatmaps[header] {
+		delete(m.heatmaps, header)
+		m.statusMessage = fmt.Sprintf("Heatmap off for column %q", header)
+		return
+	}
+	if _, ok := m.numberRange(col); !ok {
+		m.statusMessage = fmt.Sprintf("Column %q has no numbers to color", header)
+		m.statusIsError = true
+		return
+	}
+	m.heatmaps[header] = true
+	m.statusMessage = fmt.Sprintf("Heatmap on for column %q", header)
+}
+
+// dataBarWidth is the width of a data bar at the column's largest value
+const dataBarWidth = 10
+
+// dataBarBlocks draw the eighths of a data bar's last cell
+var dataBarBlocks = []string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}
+
+// toggleDataBars draws a bar beside each number in a column, sized against
+// the column's largest number, or turns that off again
+func (m *model) toggleDataBars(col int) {
+	if m.dataBars == nil {
+		m.dataBars = make(map[string]bool)
+	}
+	header := m.activeHeaders[col]
+	if m.dataBars[header] {
+		delete(m.dataBars, header)
+		m.statusMessage = fmt.Sprintf("Data bars off for column %q", header)
+	} else if _, ok := m.numberRange(col); !ok {
+		m.statusMessage = fmt.Sprintf("Column %q has no numbers to draw bars for", header)
+		m.statusIsError = true
+		return
+	} else {
+		m.dataBars[header] = true
+		m.statusMessage = fmt.Sprintf("Data bars on for column %q", header)
+	}
+	m.adjustViewportAfterResize()
+}
+
+// dataBar returns a cell's text padded to line up with a bar beside it,
+// scaled by the value's magnitude against the largest in bounds. Cells that
+// aren't numbers, and columns too narrow for a bar, are left as they are.
+func (m model) dataBar(text, value string, col int, bounds [2]float64) string {
+	number, ok := cellNumber(value)
+	textWidth := m.columnWidth(col) - 1 - dataBarWidth
+	largest := max(math.Abs(bounds[0]), math.Abs(bounds[1]))
+	if !ok || textWidth < 1 || strings.Contains(text, "\n") {
+		return text
+	}
+	eighths := 0
+	if largest > 0 {
+		eighths = int(math.Round(math.Abs(number) / largest * dataBarWidth * 8))
+	}
+	bar := strings.Repeat("█", eighths/8) + dataBarBlocks[eighths%8]
+	text = ansi.Truncate(text, textWidth, "…")
+	return text + strings.Repeat(" ", textWidth-displayWidth(text)+1) + bar
+}
+
+// cellNumber returns the number in a cell, for heatmaps and data bars
+func cellNumber(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if dataType := detectDataType(value); dataType != DataTypeInt && dataType != DataTypeFloat {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+		return 0, false
+	}
+	return number, true
+}
+
+// numberRange returns the lowest and highest numbers in a column, across
+// the rows in view, or false if it has none
+func (m model) numberRange(col int) ([2]float64, bool) {
+	bounds, found := [2]float64{math.Inf(1), math.Inf(-1)}, false
+	for _, row := range m.activeRows {
+		if col >= len(row) {
+			continue
+		}
+		if number, ok := cellNumber(row[col]); ok {
+			bounds[0], bounds[1] = min(bounds[0], number), max(bounds[1], number)
+			found = true
+		}
+	}
+	return bounds, found
+}
+
+// cachedNumberRange is numberRange, remembered in rangeCache between renders
+func (m model) cachedNumberRange(col int) ([2]float64, bool) {
+	bounds, ok := m.rangeCache[col]
+	if !ok {
+		bounds, _ = m.numberRange(col)
+		if m.rangeCache != nil {
+			m.rangeCache[col] = bounds
+		}
+	}
+	// A column without numbers has its bounds still the wrong way round
+	return bounds, bounds[0] <= bounds[1]
+}
+
+// numberRanges returns the range of each displayed column turned on in
+// columns, such as the heatmap columns
+func (m model) numberRanges(cols []int, columns map[string]bool) map[int][2]float64 {
+	if len(columns) == 0 {
+		return nil
+	}
+	ranges := make(map[int][2]float64)
+	for _, col := range cols {
+		if col < 0 || !columns[m.activeHeaders[col]] {
+			continue
+		}
+		if bounds, ok := m.cachedNumberRange(col); ok {
+			ranges[col] = bounds
+		}
+	}
+	return ranges
+}
+
+// heatmapStyle colors a cell's background between the theme's low and high
+// heatmap colors by its value's position in bounds, with black or white
+// text, whichever reads better. Cells that aren't numbers get false.
+func (m model) heatmapStyle(base lipgloss.Style, value string, bounds [2]float64) (lipgloss.Style, bool) {
+	number, ok := cellNumber(value)
+	if !ok {
+		return base, false
+	}
+	position := 0.5
+	if bounds[1] > bounds[0] {
+		position = (number - bounds[0]) / (bounds[1] - bounds[0])
+	}
+	lowR, lowG, lowB, lowOK := colorRGB(m.theme.HeatmapLow)
+	highR, highG, highB, highOK := colorRGB(m.theme.HeatmapHigh)
+	if !lowOK || !highOK {
+		// Basic colors can't be blended, so the cell takes the nearer end
+		if position < 0.5 {
+			return base.Background(m.theme.HeatmapLow), true
+		}
+		return base.Background(m.theme.HeatmapHigh), true
+	}
+	blend := func(low, high int) int {
+		return low + int(math.Round(position*float64(high-low)))
+	}
+	r, g, b := blend(lowR, highR), blend(lowG, highG), blend(lowB, highB)
+	foreground := lipgloss.Color("#FFFFFF")
+	if 0.299*float64(r)+0.587*float64(g)+0.114*float64(b) > 140 {
+		foreground = "#000000"
+	}
+	return base.Background(lipgloss.Color(fmt.Sprintf("#%02X%02X%02X", r, g, b))).Foreground(foreground), true
+}
+
+// renameColumn renames a column header, carrying over every setting that is
+// keyed by the header name
+func (m *model) renameColumn(col int, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("column name cannot be empty")
+	}
+	if m.isFiltered {
+		return fmt.Errorf("reset filters before renaming columns")
+	}
+	old := m.activeHeaders[col]
+	if name == old {
+		return nil
+	}
+	for _, header := range m.activeHeaders {
+		if header == name {
+			return fmt.Errorf("column %q already exists", name)
+		}
+	}
+
+	m.activeHeaders[col] = name
+	m.csvData[0][col] = name
+	m.hasChanges = true
+
+	if group, ok := m.columnGroups[old]; ok {
+		delete(m.columnGroups, old)
+		m.columnGroups[name] = group
+	}
+	if value, ok := m.rowTemplate[old]; ok {
+		delete(m.rowTemplate, old)
+		m.rowTemplate[name] = value
+	}
+	if m.hiddenColumns[old] {
+		delete(m.hiddenColumns, old)
+		m.hiddenColumns[name] = true
+	}
+	if m.pinnedColumns[old] {
+		delete(m.pinnedColumns, old)
+		m.pinnedColumns[name] = true
+	}
+	if width, ok := m.columnWidths[old]; ok {
+		delete(m.columnWidths, old)
+		m.columnWidths[name] = width
+		m.saveColumnWidths()
+	}
+	if unit, ok := m.units[old]; ok {
+		delete(m.units, old)
+		m.units[name] = unit
+	}
+	if m.heatmaps[old] {
+		delete(m.heatmaps, old)
+		m.heatmaps[name] = true
+	}
+	if m.dataBars[old] {
+		delete(m.dataBars, old)
+		m.dataBars[name] = true
+	}
+	if m.sortColumn == old {
+		m.sortColumn = name
+	}
+	return nil
+}
+
+// insertColumn adds a column at col with a value for each row in view, in
+// view order. The rows must all be in view, so filters have to be reset
+// first.
+func (m *model) insertColumn(col int, header string, values []string) error {
+	if m.isFiltered {
+		return fmt.Errorf("reset filters before adding columns")
+	}
+	insert := func(record []string, value string) []string {
+		for len(record) < col {
+			record = append(record, "")
+		}
+		return slices.Insert(record, col, value)
+	}
+	for i, value := range values {
+		source := m.sourceRow(i) + 1
+		m.activeRows[i] = insert(m.activeRows[i], value)
+		m.csvData[source] = insert(m.csvData[source], value)
+	}
+	m.csvData[0] = insert(m.csvData[0], header)
+	m.activeHeaders = slices.Insert(slices.Clone(m.activeHeaders), col, header)
+	if m.groupHeaderRow != nil {
+		// A blank continues the group of the column to the left
+		m.groupHeaderRow = insert(m.groupHeaderRow, "")
+		if group, ok := m.columnGroups[m.activeHeaders[max(col-1, 0)]]; ok && col > 0 {
+			m.columnGroups[header] = group
+		}
+	}
+	if col < m.frozenCols {
+		m.frozenCols++
+	}
+	m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	// Rows moved and columns shifted under the history
+	m.undoStack, m.undoBatch = nil, nil
+	m.hasChanges = true
+	return nil
+}
+
+// computedPresets are the functions Tab cycles through in the computed
+// column prompt. A running function with a number covers that many rows.
+var computedPresets = []string{"sum", "avg", "avg 7", "avg 30", "min", "max", "rank", "dense_rank", "percentile"}
+
+// distributionFunctions place each value among the others in a column: the
+// computed column functions that also work in a filter's SELECT list
+var distributionFunctions = []string{"rank", "dense_rank", "percentile"}
+
+// distribution computes a distribution function over values. rank numbers the
+// values from the largest down, with ties sharing a rank and leaving gaps
+// after them, and dense_rank leaves no gaps. percentile is the percentage of
+// the other values below a value, from 0 to 100. Values that aren't numbers
+// get "".
+func distribution(function string, values []string) []string {
+	numbers := make([]float64, len(values))
+	isNumber := make([]bool, len(values))
+	var sorted []float64
+	for i, value := range values {
+		value = strings.TrimSpace(value)
+		if dataType := detectDataType(value); dataType != DataTypeInt && dataType != DataTypeFloat {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) {
+			continue
+		}
+		numbers[i], isNumber[i] = number, true
+		sorted = append(sorted, number)
+	}
+	slices.Sort(sorted)
+	distinct := slices.Compact(slices.Clone(sorted))
+
+	results := make([]string, len(values))
+	for i, number := range numbers {
+		if !isNumber[i] {
+			continue
+		}
+		switch function {
+		case "rank":
+			above := len(sorted) - sort.SearchFloat64s(sorted, math.Nextafter(number, math.Inf(1)))
+			results[i] = strconv.Itoa(above + 1)
+		case "dense_rank":
+			above := len(distinct) - sort.SearchFloat64s(distinct, math.Nextafter(number, math.Inf(1)))
+			results[i] = strconv.Itoa(above + 1)
+		case "percentile":
+			percent := 0.0
+			if len(sorted) > 1 {
+				percent = float64(sort.SearchFloat64s(sorted, number)) / float64(len(sorted)-1) * 100
+			}
+			results[i] = strconv.FormatFloat(percent, 'f', 1, 64)
+		}
+	}
+	return results
+}
+
+// computedColumn computes a column from a numeric one, down the rows in
+// view so running functions follow the current sort. sum, avg, min and max
+// run over every row so far, or with a window only that many rows up to
+// each row, skipping cells that aren't numbers; the distribution functions
+// place each value among those in view. It returns the values and the new
+// column's header.
+func (m model) computedColumn(col int, function string) ([]string, string, error) {
+	fields := strings.Fields(strings.ToLower(function))
+	if len(fields) == 1 && slices.Contains(distributionFunctions, fields[0]) {
+		values := make([]string, len(m.activeRows))
+		for i, row := range m.activeRows {
+			if col < len(row) {
+				values[i] = row[col]
+			}
+		}
+		header := m.activeHeaders[col] + " " + fields[0]
+		for name, n := header, 2; slices.Contains(m.activeHeaders, header); n++ {
+			header = fmt.Sprintf("%s %d", name, n)
+		}
+		return distribution(fields[0], values), header, nil
+	}
+	if len(fields) == 0 || len(fields) > 2 || !slices.Contains([]string{"sum", "avg", "min", "max"}, fields[0]) {
+		return nil, "", fmt.Errorf("unknown function %q (use sum, avg, min, or max, optionally with a row count, or rank, dense_rank, or percentile)", function)
+	}
+	window := 0
+	if len(fields) == 2 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 {
+			return nil, "", fmt.Errorf("row count must be a positive number, got %q", fields[1])
+		}
+		window = n
+	}
+
+	numbers := make([]*float64, len(m.activeRows))
+	decimals := 0
+	found := false
+	for i, row := range m.activeRows {
+		if col >= len(row) {
+			continue
+		}
+		value := strings.TrimSpace(row[col])
+		if dataType := detectDataType(value); dataType != DataTypeInt && dataType != DataTypeFloat {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+			continue
+		}
+		numbers[i] = &number
+		found = true
+		if _, fraction, ok := strings.Cut(value, "."); ok {
+			decimals = max(decimals, len(fraction))
+		}
+	}
+	if !found {
+		return nil, "", fmt.Errorf("column %q has no numbers", m.activeHeaders[col])
+	}
+	if fields[0] == "avg" {
+		decimals += 2
+	}
+
+	values := make([]string, len(m.activeRows))
+	for i := range m.activeRows {
+		start := 0
+		if window > 0 {
+			start = max(i-window+1, 0)
+		}
+		var result float64
+		count := 0
+		for _, number := range numbers[start : i+1] {
+			if number == nil {
+				continue
+			}
+			switch {
+			case count == 0:
+				result = *number
+			case fields[0] == "min":
+				result = min(result, *number)
+			case fields[0] == "max":
+				result = max(result, *number)
+			default:
+				result += *number
+			}
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		if fields[0] == "avg" {
+			result /= float64(count)
+		}
+		values[i] = strconv.FormatFloat(result, 'f', decimals, 64)
+	}
+
+	header := m.activeHeaders[col] + " running " + fields[0]
+	if window > 0 {
+		header = fmt.Sprintf("%s %d-row %s", m.activeHeaders[col], window, fields[0])
+	}
+	for name, n := header, 2; slices.Contains(m.activeHeaders, header); n++ {
+		header = fmt.Sprintf("%s %d", name, n)
+	}
+	return values, header, nil
+}
+
+// addComputedColumn adds a column computed from the cursor column to its
+// right
+func (m *model) addComputedColumn(function string) error {
+	values, header, err := m.computedColumn(m.cursorCol, function)
+	if err != nil {
+		return err
+	}
+	if err := m.insertColumn(m.cursorCol+1, header, values); err != nil {
+		return err
+	}
+	m.cursorCol++
+	m.statusMessage = fmt.Sprintf("Added column %q", header)
+	if m.sortColumn != "" {
+		m.statusMessage += fmt.Sprintf(", computed in the order sorted by %q", m.sortColumn)
+	}
+	return nil
+}
+
+// ColumnStats summarizes the values of a single column
+type ColumnStats struct {
+	Count    int // Non-blank values
+	Blanks   int
+	Distinct int
+	Min, Max string
+	Sum      float64
+	Mean     float64 // Only set for numeric columns
+	Numeric  bool
+}
+
+// computeColumnStats summarizes a column of the given rows. Numeric columns
+// compare numerically, everything else compares as text.
+func computeColumnStats(rows [][]string, col int, dataType DataType) ColumnStats {
+	stats := ColumnStats{Numeric: dataType == DataTypeInt || dataType == DataTypeFloat}
+	seen := make(map[string]bool)
+	var minNum, maxNum float64
+
+	for _, row := range rows {
+		value := ""
+		if col < len(row) {
+			value = strings.TrimSpace(row[col])
+		}
+		if value == "" {
+			stats.Blanks++
+			continue
+		}
+		stats.Count++
+		if !seen[value] {
+			seen[value] = true
+			stats.Distinct++
+		}
+
+		if stats.Numeric {
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				stats.Sum += f
+				if stats.Count == 1 || f < minNum {
+					minNum, stats.Min = f, value
+				}
+				if stats.Count == 1 || f > maxNum {
+					maxNum, stats.Max = f, value
+				}
+			}
+			continue
+		}
+		if stats.Count == 1 || value < stats.Min {
+			stats.Min = value
+		}
+		if stats.Count == 1 || value > stats.Max {
+			stats.Max = value
+		}
+	}
+
+	if stats.Numeric && stats.Count > 0 {
+		stats.Mean = stats.Sum / float64(stats.Count)
+	}
+	return stats
+}
+
+// renderColumnStats renders the statistics overlay for a column
+func (m model) renderColumnStats(col int) string {
+	dataType := DataTypeEmpty
+	if col < len(m.activeColumnTypes) {
+		dataType = m.activeColumnTypes[col]
+	}
+	stats := computeColumnStats(m.activeRows, col, dataType)
+
+	titleStyle := m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true)
+	labelStyle := m.renderer.NewStyle().Foreground(m.theme.Subtle).Width(10)
+
+	lines := []string{
+		titleStyle.Render(fmt.Sprintf("Column %q (%s)", m.activeHeaders[col], dataTypeName(dataType))),
+		"",
+		labelStyle.Render("Rows") + strconv.Itoa(len(m.activeRows)),
+		labelStyle.Render("Values") + strconv.Itoa(stats.Count),
+		labelStyle.Render("Blank") + strconv.Itoa(stats.Blanks),
+		labelStyle.Render("Distinct") + strconv.Itoa(stats.Distinct),
+		labelStyle.Render("Min") + stats.Min,
+		labelStyle.Render("Max") + stats.Max,
+	}
+	if stats.Numeric {
+		lines = append(lines,
+			labelStyle.Render("Sum")+strconv.FormatFloat(stats.Sum, 'f', -1, 64),
+			labelStyle.Render("Mean")+strconv.FormatFloat(stats.Mean, 'f', 4, 64))
+	}
+
+	return m.renderer.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.theme.Border).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+}
+
+// cellStyle returns the style of a table cell, given the data rows and
+// columns the table is showing
+// trayCellStyle returns the style of a cell in a row pinned to the tray:
+// its column's type color, without alternating or cursor highlights
+func (m model) trayCellStyle(styles StyleConfig, col int, displayCols []int) lipgloss.Style {
+	if m.plainText {
+		return styles.baseStyle
+	}
+	if col >= len(displayCols) || displayCols[col] < 0 {
+		return styles.gutterStyle
+	}
+	if c := displayCols[col]; c < len(m.activeColumnTypes) {
+		if color := styles.typeColors[m.activeColumnTypes[c]]; color != "" {
+			return styles.baseStyle.Foreground(color)
+		}
+	}
+	return styles.baseStyle.Foreground(styles.oddRowColor)
+}
+
+func (m model) cellStyle(styles StyleConfig, focused bool, row, col int, visibleRowIndices, visibleCols []int, startCol int, heat map[int][2]float64) lipgloss.Style {
+	actualRow := -1
+	if row >= 0 && row < len(visibleRowIndices) {
+		actualRow = visibleRowIndices[row]
+	}
+	actualCol := startCol
+	if col < len(visibleCols) {
+		actualCol = visibleCols[col]
+	}
+	if actualCol < 0 {
+		if m.plainText {
+			return styles.baseStyle
+		}
+		return styles.gutterStyle
+	}
+
+	if row == table.HeaderRow {
+		if focused && m.headerMode && actualCol == m.cursorCol {
+			return styles.selectedStyle
+		}
+		return styles.headerStyle
+	}
+
+	if focused && !m.headerMode && actualRow == m.cursorRow && actualCol == m.cursorCol {
+		return styles.selectedStyle
+	}
+
+	if focused && m.isSelected(actualRow, actualCol) {
+		return styles.visualStyle
+	}
+
+	if m.diffCells[diffCell{m.sourceRow(actualRow), m.activeHeaders[actualCol]}] {
+		return styles.diffStyle
+	}
+
+	if bounds, ok := heat[actualCol]; ok && actualCol < len(m.activeRows[actualRow]) {
+		if style, ok := m.heatmapStyle(styles.baseStyle, m.activeRows[actualRow][actualCol], bounds); ok {
+			return style
+		}
+	}
+
+	if m.plainText {
+		return styles.baseStyle
+	}
+
+	even := row%2 == 0
+
+	if actualCol < len(m.activeColumnTypes) {
+		columnType := m.activeColumnTypes[actualCol]
+
+		var color lipgloss.Color
+		if even {
+			color = styles.dimTypeColors[columnType]
+		} else {
+			color = styles.typeColors[columnType]
+		}
+
+		// If we have a color for this data type, use it
+		if color != "" {
+			return styles.baseStyle.Foreground(color)
+		}
+		// Otherwise fall through to default alternating row colors
+	}
+
+	if even {
+		return styles.baseStyle.Foreground(styles.evenRowColor)
+	}
+	return styles.baseStyle.Foreground(styles.oddRowColor)
+}
+
+// fitColumnWidth truncates a value to the manual width of its column, if the
+// column has one and values aren't wrapped
+func (m model) fitColumnWidth(value string, col int) string {
+	if col < 0 {
+		return value
+	}
+	if _, ok := m.columnWidths[m.activeHeaders[col]]; (!ok && m.border != borderNone) || m.wrapCells {
+		return value
+	}
+	width := m.columnWidth(col)
+	lines := strings.Split(value, "\n")
+	for i, line := range lines {
+		lines[i] = ansi.Truncate(line, width, "…")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tableColumns returns the columns the table draws for a visible range:
+// pinned columns first, then the columns inside the range that aren't hidden
+// (e.g. collapsed groups)
+func (m model) tableColumns(startCol, endCol int) []int {
+	cols := m.pinnedColumnIndices()
+	for c := startCol; c < min(endCol, len(m.activeHeaders)); c++ {
+		if !m.isColumnHidden(c) && !m.isColumnPinned(c) {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// renderTable renders the table for the model's pane, returning it along
+// with the visible column range and the width it uses. Only the focused pane
+// shows the cursor and selection.
+// visibleRowIndices returns the rows the table draws: frozen rows first, then
+// the scrolled window
+func (m model) visibleRowIndices() []int {
+	maxRows := m.maxVisibleRows()
+	if m.viewportY >= len(m.activeRows) {
+		m.viewportY = len(m.activeRows) - 1
+	}
+
+	startRow := max(m.viewportY, m.visibleFrozenRows())
+	if m.wrapCells {
+		return m.wrappedRows(startRow)
+	}
+	endRow := startRow + maxRows
+	if endRow > len(m.activeRows) {
+		endRow = len(m.activeRows)
+	}
+
+	visibleRowIndices := make([]int, 0, m.visibleFrozenRows()+endRow-startRow)
+	for i := 0; i < m.visibleFrozenRows(); i++ {
+		visibleRowIndices = append(visibleRowIndices, i)
+	}
+	for i := startRow; i < endRow; i++ {
+		visibleRowIndices = append(visibleRowIndices, i)
+	}
+	return visibleRowIndices
+}
+
+func (m model) renderTable(styles StyleConfig, focused bool) (string, int, int, int) {
+	visibleRowIndices := m.visibleRowIndices()
+
+	startCol, endCol := m.calculateVisibleColumns()
+
+	if endCol > len(m.activeHeaders) {
+		endCol = len(m.activeHeaders)
+	}
+
+	visibleCols := m.tableColumns(startCol, endCol)
+
+	// The row-number gutter is drawn as a leading column, marked as -1
+	displayCols := visibleCols
+	if m.rowNumbers {
+		displayCols = append([]int{-1}, visibleCols...)
+	}
+
+	visibleHeaders := make([]string, len(displayCols))
+	for j, c := range displayCols {
+		if c < 0 {
+			visibleHeaders[j] = "#"
+			continue
+		}
+		visibleHeaders[j] = m.headerText(c)
+		if m.activeHeaders[c] == m.sortColumn {
+			if m.sortDesc {
+				visibleHeaders[j] += " ↓"
+			} else {
+				visibleHeaders[j] += " ↑"
+			}
+		}
+		visibleHeaders[j] = m.fitColumnWidth(visibleHeaders[j], c)
+	}
+	visibleRows := make([][]string, 0, len(visibleRowIndices))
+	bars := m.numberRanges(displayCols, m.dataBars)
+
+	for _, i := range visibleRowIndices {
+		if i < len(m.activeRows) {
+			row := make([]string, len(visibleHeaders))
+			for j, c := range displayCols {
+				if c < 0 {
+					row[j] = m.rowNumberLabel(i)
+				} else if c < len(m.activeRows[i]) {
+					row[j] = m.cellText(m.activeRows[i][c], c)
+					if bounds, ok := bars[c]; ok {
+						row[j] = m.dataBar(row[j], m.activeRows[i][c], c, bounds)
+					}
+				}
+				if c >= 0 && m.notes[noteKey{m.sourceRow(i), m.activeHeaders[c]}] != "" {
+					row[j] += " " + noteGlyph
+				}
+			}
+			// Row notes are marked in the gutter, or the first column without one
+			if _, ok := m.notes[noteKey{m.sourceRow(i), ""}]; ok && len(row) > 0 {
+				row[0] += " " + noteGlyph
+			}
+			// Review marks go in the same place
+			if mark, ok := m.marks[m.sourceRow(i)]; ok && len(row) > 0 {
+				row[0] += " " + markGlyphs[mark]
+			}
+			for j, c := range displayCols {
+				row[j] = m.fitColumnWidth(row[j], c)
+			}
+			visibleRows = append(visibleRows, row)
+		}
+	}
+
+	// Rows pinned to the tray follow on one line each, so they line up with
+	// the rows scrolling past for comparison
+	for _, source := range m.tray {
+		values := m.trayRow(source)
+		row := make([]string, len(displayCols))
+		for j, c := range displayCols {
+			if c < 0 {
+				row[j] = strconv.Itoa(source + 1)
+				continue
+			}
+			if c < len(values) {
+				row[j] = strings.ReplaceAll(m.cellText(values[c], c), "\n", "⏎")
+				if bounds, ok := bars[c]; ok {
+					row[j] = m.dataBar(row[j], values[c], c, bounds)
+				}
+			}
+			if m.wrapCells {
+				row[j] = ansi.Truncate(row[j], m.columnWidth(c), "…")
+			}
+			row[j] = m.fitColumnWidth(row[j], c)
+		}
+		if len(row) > 0 {
+			row[0] += " " + trayGlyph
+		}
+		visibleRows = append(visibleRows, row)
+	}
+
+	compact := m.border == borderNone
+	tableBorderWidth, padding, separator := m.tableChrome()
+	heat := m.numberRanges(displayCols, m.heatmaps)
+
+	t := table.New().
+		Headers(visibleHeaders...).
+		Rows(visibleRows...).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			var style lipgloss.Style
+			if row >= len(visibleRowIndices) {
+				style = m.trayCellStyle(styles, col, displayCols)
+			} else {
+				style = m.cellStyle(styles, focused, row, col, visibleRowIndices, displayCols, startCol, heat)
+			}
+			// Underline the last frozen row to separate it from the scrolling rows,
+			// the last scrolling row to separate the tray, and the header when
+			// there's no border to do it
+			if row >= 0 && row < len(visibleRowIndices) && visibleRowIndices[row] == m.frozenRows-1 {
+				style = style.Underline(true)
+			}
+			if len(m.tray) > 0 && row == len(visibleRowIndices)-1 {
+				style = style.Underline(true)
+			}
+			if compact {
+				style = style.PaddingLeft(0)
+				if row == table.HeaderRow {
+					style = style.Underline(true)
+				}
+			}
+			// Manually sized columns keep their width however long the values
+			// are, and so does every column when values wrap or the table is
+			// compact
+			if col < len(displayCols) && displayCols[col] >= 0 {
+				if width, ok := m.columnWidths[m.activeHeaders[displayCols[col]]]; ok {
+					style = style.Width(width + padding)
+				} else if m.wrapCells || compact {
+					style = style.Width(m.columnWidth(displayCols[col]) + padding)
+				}
+			} else if compact {
+				style = style.Width(m.gutterWidth() + padding)
+			}
+			return style
+		})
+	if compact {
+		t = t.BorderTop(false).BorderBottom(false).BorderLeft(false).BorderRight(false).
+			BorderColumn(false).BorderHeader(false)
+	} else if m.plainText {
+		t = t.Border(tableBorders[m.border])
+	} else {
+		t = t.Border(tableBorders[m.border]).
+			BorderStyle(m.renderer.NewStyle().Foreground(m.theme.Border))
+	}
+
+	// Calculate total width being used
+	totalUsedWidth := tableBorderWidth
+	if m.rowNumbers {
+		totalUsedWidth += m.gutterWidth() + padding + separator
+	}
+	for j, i := range visibleCols {
+		totalUsedWidth += m.columnWidth(i) + padding
+		if j > 0 {
+			totalUsedWidth += separator // not for first column
+		}
+	}
+
+	tableView := t.String()
+	if len(m.columnGroups) > 0 {
+		tableView = m.renderGroupBand(tableView, displayCols) + "\n" + tableView
+	}
+	return tableView, startCol, endCol, totalUsedWidth
+}
+
+func (m model) View() string {
+	if len(m.activeRows) == 0 {
+		return "No data to display"
+	}
+
+	if m.statsView != "" {
+		return m.statsView + "\n" + "Press any key to close"
+	}
+	// Inline sessions leave just the table behind in the scrollback
+	if m.quitting && m.inlineHeight > 0 {
+		m.widthCache = make(map[int]int)
+		tableView, _, _, _ := m.renderTable(createTableStyles(m.renderer, m.theme, m.typeColors, m.dimColors), false)
+		return tableView
+	}
+	if m.problemsView {
+		return m.renderProblems() + "\n" + "↑/↓ to select, Enter to jump to the cell, Esc to close"
+	}
+	if m.themeEditor {
+		footer := "↑/↓ setting, Enter or Tab to the palette, e to type a color, w to write to the config, Esc to close"
+		if m.themeOnPalette {
+			footer = "Arrows pick a color, Enter applies it, Tab back to the settings, w to write to the config, Esc to close"
+		}
+		switch {
+		case m.themeHexMode:
+			footer = fmt.Sprintf("%s: %s  (Enter to apply, Esc to cancel)", themeSettings[m.themeSetting], m.themeHexInput.View())
+		case m.statusIsError:
+			footer = m.errorStyle().Render(m.statusMessage)
+		case m.statusMessage != "":
+			footer = m.statusMessage
+		}
+		return m.renderThemeEditor() + "\n" + footer
+	}
+	if m.bookmarkView {
+		return m.renderBookmarks() + "\n" + "Press a bookmark's letter, or ↑/↓ to select and Enter to jump; Esc to close"
+	}
+
+	styles := createTableStyles(m.renderer, m.theme, m.typeColors, m.dimColors)
+
+	// Measure each column at most once per frame, and only when it's laid out
+	m.widthCache = make(map[int]int)
+
+	tableView, startCol, endCol, totalUsedWidth := m.renderTable(styles, !m.previewFocused)
+	if m.splitView {
+		other := m
+		other.pane = m.otherPane
+		otherView, _, _, _ := other.renderTable(styles, false)
+		if m.focusBottom {
+			tableView = otherView + "\n" + tableView
+		} else {
+			tableView += "\n" + otherView
+		}
+	}
+	if m.preview != nil {
+		m.syncPreview()
+		preview := *m.preview
+		preview.widthCache = make(map[int]int)
+		previewView, _, _, _ := preview.renderTable(styles, m.previewFocused)
+		tableView += "\n" + previewView
+	}
+	if m.formulaBar {
+		tableView = m.renderFormulaBar() + "\n" + tableView
+	}
+	if m.readOnly {
+		banner := fmt.Sprintf(" READ-ONLY: %s isn't writable, so changes must be saved elsewhere or with sudo/doas ", m.saveFilename())
+		if source := m.unsavableSource(); source != "" && m.outputFile == "" {
+			banner = fmt.Sprintf(" READ-ONLY: the data came from %s, so changes must be saved to a file with Save As ", source)
+		}
+		tableView = m.renderer.NewStyle().Background(m.theme.Error).Foreground(lipgloss.Color("#000000")).Bold(true).Render(banner) + "\n" + tableView
+	}
+	if m.showInspector {
+		tableView += "\n" + m.renderInspector()
+	}
+
+	if !m.hideLegend {
+		tableView += "\n" + m.createColorLegend(styles)
+	}
+
+	// Create status info (row/col info, viewport info, modified status, filter status)
+	changeIndicator := ""
+	if m.hasChanges {
+		changeIndicator = " [MODIFIED]"
+	}
+	filterIndicator := ""
+	if m.isFiltered {
+		filterIndicator = fmt.Sprintf(" [FILTERED: %d filters]", len(m.appliedFilters))
+	}
+	sortIndicator := ""
+	if m.sortColumn != "" {
+		direction := "↑"
+		if m.sortDesc {
+			direction = "↓"
+		}
+		sortIndicator = fmt.Sprintf(" [SORTED: %s %s]", m.sortColumn, direction)
+	}
+	hiddenIndicator := ""
+	if len(m.hiddenColumns) > 0 {
+		hiddenIndicator = fmt.Sprintf(" [HIDDEN: %d cols]", len(m.hiddenColumns))
+	}
+	typesIndicator := ""
+	if m.typesSampled {
+		typesIndicator = " [TYPES: sampled]"
+	}
+	visualIndicator := ""
+	if m.visualMode {
+		rowStart, rowEnd, _, _ := m.selectionBounds()
+		visualIndicator = fmt.Sprintf(" [VISUAL: %dx%d]", rowEnd-rowStart+1, len(m.selectedColumns()))
+	}
+	frozenIndicator := ""
+	if m.frozenRows > 0 {
+		frozenIndicator = fmt.Sprintf(" [FROZEN: %d rows]", m.frozenRows)
+	}
+	if m.frozenCols > 0 {
+		frozenIndicator += fmt.Sprintf(" [FROZEN: %d cols]", m.frozenCols)
+	}
+	splitIndicator := ""
+	if m.splitView {
+		splitIndicator = " [SPLIT: top pane]"
+		if m.focusBottom {
+			splitIndicator = " [SPLIT: bottom pane]"
+		}
+	}
+	if p := m.preview; p != nil {
+		splitIndicator = fmt.Sprintf(" [PREVIEW: %s]", filepath.Base(p.filename))
+		if m.previewFocused {
+			splitIndicator = fmt.Sprintf(" [PREVIEW: %s row %d/%d, col %d/%d]", filepath.Base(p.filename),
+				p.cursorRow+1, len(p.activeRows), p.cursorCol+1, len(p.activeHeaders))
+		}
+	}
+	trayIndicator := ""
+	if len(m.tray) > 0 {
+		trayIndicator = fmt.Sprintf(" [TRAY: %d rows]", len(m.tray))
+	}
+	reviewIndicator := ""
+	if len(m.marks) > 0 {
+		reviewIndicator = fmt.Sprintf(" [REVIEW: %s]", m.reviewProgress())
+	}
+	wrapIndicator := ""
+	if m.wrapCells && m.cursorRow < len(m.activeRows) {
+		line, height := m.cursorLine()
+		wrapIndicator = fmt.Sprintf(" [WRAP: line %d/%d]", line+1, height)
+	}
+	macroIndicator := ""
+	if m.recordingMacro {
+		macroIndicator = fmt.Sprintf(" [REC: %d keys]", len(m.macroKeys))
+	}
+	diffIndicator := ""
+	if m.diffRef != "" {
+		diffIndicator = fmt.Sprintf(" [DIFF vs %s: %s]", m.diffRef, m.diffSummary)
+	}
+	statusInfo := fmt.Sprintf("Row: %d/%d, Col: %d/%d | Showing cols %d-%d | Width: %d/%d%s%s%s%s%s%s%s%s%s%s%s%s%s",
+		m.cursorRow+1, len(m.activeRows), m.cursorCol+1, len(m.activeHeaders), startCol+1, endCol, totalUsedWidth, m.width,
+		changeIndicator, typesIndicator, filterIndicator, sortIndicator, hiddenIndicator, frozenIndicator, trayIndicator, reviewIndicator, wrapIndicator, diffIndicator, splitIndicator, visualIndicator, macroIndicator)
+	if m.theme.StatusLine != "" {
+		statusInfo = m.renderer.NewStyle().Foreground(m.theme.StatusLine).Render(statusInfo)
+	}
+
+	// Handle different modes
+	if m.privilegedSave {
+		savePrompt := fmt.Sprintf("Run %s cp <temp file> %s to save?", m.privilegedTool, m.saveFilename())
+		saveStatus := fmt.Sprintf("%s may ask for your password. (y/n, Esc to cancel)", m.privilegedTool)
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, savePrompt, saveStatus)
+	}
+
+	if m.altSavePrompt {
+		savePrompt := "Save changes to: " + m.altSaveInput.View()
+		saveStatus := "Enter a writable path (later saves go there too), Esc to cancel"
+		if m.statusIsError {
+			saveStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, savePrompt, saveStatus)
+	}
+
+	if m.savePrompt {
+		savePrompt := fmt.Sprintf("Save changes to %s?", m.saveFilename())
+		saveStatus := "You have unsaved changes. Save to original file? (y/n, Esc to cancel)"
+		if m.outputFile != "" {
+			saveStatus = "You have unsaved changes. Save to output file? (y/n, Esc to cancel)"
+		}
+		if m.readOnly {
+			savePrompt = fmt.Sprintf("%s isn't writable. Save your changes elsewhere?", m.saveFilename())
+			saveStatus = "a to save to another path, s to save with sudo/doas, n to quit without saving, Esc to cancel"
+			if source := m.unsavableSource(); source != "" && m.outputFile == "" {
+				savePrompt = fmt.Sprintf("The data came from %s. Save your changes to a file?", source)
+				saveStatus = "a to save as a file, n to quit without saving, Esc to cancel"
+			}
+		}
+		if m.statusIsError {
+			saveStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, savePrompt, saveStatus)
+	}
+
+	if m.saveFilteredPrompt {
+		savePrompt := "Save filtered CSV as: " + m.saveFilteredInput.View()
+		if m.exportAppend {
+			savePrompt = "Append filtered rows to: " + m.saveFilteredInput.View()
+		}
+		saveStatus := fmt.Sprintf("Enter filename (or DB.sqlite:TABLE) to save filtered data (quoting: %s, Tab to complete the path or change it; %s to toggle append), or Esc to quit without saving",
+			m.quoting.style, m.keys.ToggleAppend.Help().Key)
+		if m.statusMessage != "" {
+			saveStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, savePrompt, saveStatus)
+	}
+
+	if m.trayExportPrompt {
+		exportPrompt := fmt.Sprintf("Export the tray's %d row(s) as: %s", len(m.tray), m.trayExportInput.View())
+		exportStatus := "EXPORT - Enter to save, Esc to cancel"
+		if m.statusIsError {
+			exportStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, exportPrompt, exportStatus)
+	}
+
+	if m.sqlExportPrompt {
+		focusIndicator := func(step int) string {
+			if m.sqlExportStep == step {
+				return "► "
+			}
+			return "  "
+		}
+		tablePrompt := fmt.Sprintf("%sInsert %d row(s) into table: %s", focusIndicator(0), len(m.activeRows), m.sqlTableInput.View())
+		filePrompt := fmt.Sprintf("%sWrite the INSERT statements to: %s", focusIndicator(1), m.sqlFileInput.View())
+		exportStatus := "EXPORT SQL - Tab to switch fields, Enter to save, Esc to cancel"
+		if m.statusIsError {
+			exportStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s", tableView, statusInfo, tablePrompt, filePrompt, exportStatus)
+	}
+
+	if m.exportMarksPrompt {
+		rows := "rows with any mark"
+		if m.exportMark != "" {
+			rows = m.exportMark + " rows"
+		}
+		exportPrompt := fmt.Sprintf("Export %s as: %s", rows, m.exportMarksInput.View())
+		column := "off"
+		if m.exportMarkColumn {
+			column = "on"
+		}
+		exportStatus := fmt.Sprintf("EXPORT - Tab to change the mark; %s to toggle the %q column (%s); Enter to save, Esc to cancel",
+			m.keys.ToggleMarkColumn.Help().Key, markColumnHeader, column)
+		if m.statusIsError {
+			exportStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, exportPrompt, exportStatus)
+	}
+
+	if m.filterMode {
+		filterPrompt := "Filter: " + m.filterInput.View()
+		keys := m.keysFor("filter")
+		filterStatus := fmt.Sprintf("FILTER MODE - Enter SQL-like query (SELECT col1,col2 WHERE col3 == \"value\"), %s to apply, %s to cancel",
+			keys.Save.Help().Key, keys.Cancel.Help().Key)
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, filterPrompt, filterStatus)
+	}
+
+	if m.editMode && m.editSyntax != "" {
+		editPrompt := fmt.Sprintf("Editing %s cell [%d,%d]:\n%s", m.editSyntax, m.cursorRow+1, m.cursorCol+1, m.textArea.View())
+		keys := m.keysFor("edit")
+		editStatus := fmt.Sprintf("EDIT MODE - %s to save, %s to cancel", keys.SaveMultiline.Help().Key, keys.Cancel.Help().Key)
+		if m.editError != "" {
+			editStatus = m.errorStyle().Render(m.editError)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, editPrompt, editStatus)
+	}
+
+	if m.editMode {
+		editPrompt := fmt.Sprintf("Editing cell [%d,%d]: %s", m.cursorRow+1, m.cursorCol+1, m.textInput.View())
+		keys := m.keysFor("edit")
+		editStatus := fmt.Sprintf("EDIT MODE - %s to save, %s to cancel", keys.Save.Help().Key, keys.Cancel.Help().Key)
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, editPrompt, editStatus)
+	}
+
+	if m.gotoMode {
+		var gotoPrompt, gotoStatus string
+		if m.gotoStep == 0 {
+			gotoPrompt = fmt.Sprintf("Go to row: %s", m.rowInput.View())
+			gotoStatus = "GOTO MODE - Enter a row number, or a cell like C42 or name:42, then press Enter"
+		} else {
+			gotoPrompt = fmt.Sprintf("Go to row %s, column: %s", m.rowInput.Value(), m.colInput.View())
+			gotoStatus = "GOTO MODE - Enter column number, then press Enter (Esc to cancel)"
+		}
+
+		// Show error message if there is one
+		if m.gotoError != "" {
+			gotoStatus = m.errorStyle().Render(m.gotoError)
+		}
+
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, gotoPrompt, gotoStatus)
+	}
+
+	if m.searchMode {
+		// Create focused indicator for current input
+		focusIndicator := func(step int) string {
+			if m.searchStep == step {
+				return "► "
+			}
+			return "  "
+		}
+
+		searchPrompt := fmt.Sprintf("%sSearch: %s", focusIndicator(0), m.searchInput.View())
+		rowPrompt := fmt.Sprintf("%sRow filter: %s", focusIndicator(1), m.searchRowInput.View())
+		colPrompt := fmt.Sprintf("%sCol filter: %s", focusIndicator(2), m.searchColInput.View())
+		optionsPrompt := fmt.Sprintf("%s%s  %s%s  %s%s", focusIndicator(3), checkbox(m.searchCaseSensitive, "Case sensitive"),
+			focusIndicator(4), checkbox(m.searchWholeCell, "Whole cell"), focusIndicator(5), checkbox(m.searchHeaders, "Include headers"))
+		keys := m.keysFor("search")
+		searchStatus := fmt.Sprintf("SEARCH MODE - %s to switch fields, space to toggle options, %s to search, %s to cancel",
+			keys.Tab.Help().Key, keys.Save.Help().Key, keys.Cancel.Help().Key)
+
+		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s", tableView, statusInfo, searchPrompt, rowPrompt, colPrompt, optionsPrompt, searchStatus)
+	}
+
+	if m.replaceMode {
+		focusIndicator := func(step int) string {
+			if m.replaceStep == step {
+				return "► "
+			}
+			return "  "
+		}
+
+		searchPrompt := fmt.Sprintf("%sFind: %s", focusIndicator(0), m.searchInput.View())
+		replacePrompt := fmt.Sprintf("%sReplace with: %s", focusIndicator(1), m.replaceInput.View())
+		rowPrompt := fmt.Sprintf("%sRow filter: %s", focusIndicator(2), m.searchRowInput.View())
+		colPrompt := fmt.Sprintf("%sCol filter: %s", focusIndicator(3), m.searchColInput.View())
+		optionsPrompt := fmt.Sprintf("%s%s  %s%s", focusIndicator(4), checkbox(m.searchCaseSensitive, "Case sensitive"),
+			focusIndicator(5), checkbox(m.searchWholeCell, "Whole cell"))
+		replaceStatus := "REPLACE MODE - Tab to switch fields, Space to toggle options, Enter to find matches, Esc to cancel"
+
+		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s", tableView, statusInfo, searchPrompt, replacePrompt, rowPrompt, colPrompt, optionsPrompt, replaceStatus)
+	}
+
+	if m.columnJumpMode {
+		columnPrompt := "Jump to column: " + m.columnJumpInput.View()
+		columnStatus := "COLUMN - Type part of a header, Tab for the next match, Enter to stay, Esc to go back"
+		if len(m.columnMatches) > 0 {
+			names := make([]string, len(m.columnMatches))
+			for i, col := range m.columnMatches {
+				names[i] = m.activeHeaders[col]
+				if i == m.columnMatchIndex {
+					names[i] = m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true).Render(names[i])
+				}
+			}
+			columnStatus = ansi.Truncate(fmt.Sprintf("%d matches: %s", len(names), strings.Join(names, "  ")), m.width, "…")
+		} else if m.columnJumpInput.Value() != "" {
+			columnStatus = m.errorStyle().Render("No matching columns")
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, columnPrompt, columnStatus)
+	}
+
+	if m.bookmarkPrompt {
+		bookmarkPrompt := fmt.Sprintf("Bookmark cell [%d,%d] as: ", m.cursorRow+1, m.cursorCol+1)
+		bookmarkStatus := "BOOKMARK - Press a letter (a-z, A-Z) to name it, Esc to cancel"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, bookmarkPrompt, bookmarkStatus)
+	}
+
+	if m.macroPrompt {
+		macroPrompt := fmt.Sprintf("Play macro of %d keys how many times: %s", len(m.macroKeys), m.macroInput.View())
+		macroStatus := "MACRO - Enter a repeat count (default 1), Esc to cancel"
+		if m.statusIsError {
+			macroStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, macroPrompt, macroStatus)
+	}
+
+	if m.commandMode {
+		commandStatus := "COMMAND - w, w FILE, saveas FILE, wq, q, q!, sort COL [asc|desc], goto ROW[,COL] or CELL; Enter to run, Esc to cancel"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, m.commandInput.View(), commandStatus)
+	}
+
+	if m.noteMode {
+		target := fmt.Sprintf("row %d", m.cursorRow+1)
+		if m.noteColumn != "" {
+			target = fmt.Sprintf("cell [%d,%d]", m.cursorRow+1, m.cursorCol+1)
+		}
+		notePrompt := fmt.Sprintf("Note on %s: %s", target, m.noteInput.View())
+		noteStatus := "NOTE - Enter to save (empty removes the note), Esc to cancel"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, notePrompt, noteStatus)
+	}
+
+	if m.previewPrompt {
+		previewPrompt := "Preview file: " + m.previewInput.View()
+		previewStatus := "PREVIEW - Enter a file to show read-only below the data, Esc to cancel"
+		if m.statusIsError {
+			previewStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, previewPrompt, previewStatus)
+	}
+
+	if m.diffPrompt {
+		diffPrompt := "Diff against git revision: " + m.diffInput.View()
+		diffStatus := "DIFF - Enter a branch, tag, or commit (default HEAD), Esc to cancel"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, diffPrompt, diffStatus)
+	}
+
+	if m.pastePrompt {
+		columns := 0
+		for _, values := range m.pasteGrid {
+			if len(values) > columns {
+				columns = len(values)
 			}
-			return m, tea.Quit
-		case msg.String() == "ctrl+z":
-			return m, tea.Suspend
-		case key.Matches(msg, m.keys.Help):
-			m.help.ShowAll = !m.help.ShowAll
-		case key.Matches(msg, m.keys.Edit):
-			// Enter edit mode
-			if m.cursorRow < len(m.activeRows) && m.cursorCol < len(m.activeRows[m.cursorRow]) {
-				m.editMode = true
-				m.textInput = textinput.New()
-				m.textInput.Focus()
-				m.textInput.SetValue(m.activeRows[m.cursorRow][m.cursorCol])
-				m.textInput.CursorEnd()
-				return m, textinput.Blink
+		}
+		pastePrompt := fmt.Sprintf("Clipboard holds %d row(s) x %d column(s). Spread them across cells from [%d,%d]?",
+			len(m.pasteGrid), columns, m.cursorRow+1, m.cursorCol+1)
+		pasteStatus := "PASTE - y to fill adjacent cells, n to paste as one cell, Esc to cancel"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, pastePrompt, pasteStatus)
+	}
+
+	if m.markFilterPrompt {
+		markPrompt := fmt.Sprintf("Show rows marked: [t]odo, [a]pproved, [f]lagged, or [u]nmarked? (%s)", m.reviewProgress())
+		markStatus := "FILTER - press t, a, f, or u, Esc to cancel"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, markPrompt, markStatus)
+	}
+
+	if m.renameMode {
+		renamePrompt := fmt.Sprintf("Rename column %q to: %s", m.activeHeaders[m.cursorCol], m.renameInput.View())
+		renameStatus := "RENAME - Enter to save, Esc to cancel"
+		if m.statusMessage != "" {
+			renameStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, renamePrompt, renameStatus)
+	}
+
+	if m.bulkEditMode {
+		rows, cols := m.editTargets(0)
+		bulkPrompt := fmt.Sprintf("Set %d cell(s) in %s to: %s", len(rows)*len(cols), m.editTargetName(), m.bulkEditInput.View())
+		bulkStatus := "BULK EDIT - Enter to apply, Esc to cancel"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, bulkPrompt, bulkStatus)
+	}
+
+	if m.transformName != "" {
+		changes := m.transformChanges()
+
+		// Preview the first few changes
+		var examples []string
+		for _, cell := range changes[:min(len(changes), 3)] {
+			value := m.activeRows[cell[0]][cell[1]]
+			examples = append(examples, fmt.Sprintf("%q → %q", value, m.transform(value)))
+		}
+		if len(changes) > 3 {
+			examples = append(examples, "…")
+		}
+		transformPrompt := fmt.Sprintf("Change %d cell(s) in %s to %s: %s", len(changes), m.editTargetName(), m.transformName, strings.Join(examples, ", "))
+		transformPrompt = ansi.Truncate(strings.ReplaceAll(transformPrompt, "\n", "⏎"), max(m.width, 20), "…")
+		transformStatus := fmt.Sprintf("TRANSFORM - %s to apply, %s to cancel", m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
+		if m.transformNote != "" {
+			transformStatus = fmt.Sprintf("TRANSFORM - %s; %s to apply, %s to cancel", m.transformNote, m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, transformPrompt, transformStatus)
+	}
+
+	if m.datePrompt {
+		datePrompt := fmt.Sprintf("Rewrite dates in %s as: %s", m.editTargetName(), m.dateInput.View())
+		example := time.Date(2024, time.March, 9, 14, 30, 0, 0, time.UTC).Format(m.dateInput.Value())
+		dateStatus := fmt.Sprintf("DATES - e.g. %s (Tab for presets, or type a Go layout), %s to preview, %s to cancel", example, m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, datePrompt, dateStatus)
+	}
+
+	if m.computedPrompt {
+		computedPrompt := fmt.Sprintf("Add a column computed from %q: %s", m.activeHeaders[m.cursorCol], m.computedInput.View())
+		computedStatus := fmt.Sprintf("COMPUTED - running sum, avg, min, or max (over N rows as in \"avg 7\"), or rank, dense_rank, or percentile; Tab for presets, %s to add, %s to cancel",
+			m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
+		if m.statusMessage != "" {
+			computedStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, computedPrompt, computedStatus)
+	}
+
+	if m.unitPrompt {
+		unitPrompt := fmt.Sprintf("Show column %q in: %s", m.activeHeaders[m.cursorCol], m.unitInput.View())
+		if m.unitRewrite {
+			unitPrompt = fmt.Sprintf("Convert %s with: %s", m.editTargetName(), m.unitInput.View())
+		}
+		unitStatus := fmt.Sprintf("UNITS - Tab for conversions, %s to confirm, %s to cancel", m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
+		if conversion, ok := m.unitConversions[unitName(m.unitInput.Value())]; ok && m.cursorRow < len(m.activeRows) && m.cursorCol < len(m.activeRows[m.cursorRow]) {
+			if value := m.activeRows[m.cursorRow][m.cursorCol]; conversion.apply(value) != value {
+				unitStatus = fmt.Sprintf("UNITS - e.g. %s → %s %s; Tab for conversions, %s to confirm, %s to cancel",
+					value, conversion.apply(value), conversion.Unit, m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
 			}
-		case key.Matches(msg, m.keys.GoTo):
-			// Enter goto mode
-			m.gotoMode = true
-			m.gotoStep = 0
-			m.gotoError = ""
-			m.rowInput = textinput.New()
-			m.rowInput.Focus()
-			m.rowInput.Placeholder = "Enter row number (1-" + strconv.Itoa(len(m.activeRows)) + ")"
-			return m, textinput.Blink
-		case key.Matches(msg, m.keys.Search):
-			// Enter search mode
-			m.searchMode = true
-			m.searchStep = 0
+		}
+		if m.statusMessage != "" {
+			unitStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, unitPrompt, unitStatus)
+	}
+
+	if m.zonePrompt {
+		zonePrompt := fmt.Sprintf("Convert times in %s from %s to %s", m.editTargetName(), m.zoneFrom.View(), m.zoneTo.View())
+		zoneStatus := fmt.Sprintf("TIME ZONE - IANA names such as Europe/Berlin; Tab switches zone, %s to preview, %s to cancel", m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
+		if m.statusMessage != "" {
+			zoneStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, zonePrompt, zoneStatus)
+	}
+
+	if m.padPrompt {
+		fill := "zeros"
+		if m.padSpaces {
+			fill = "spaces"
+		}
+		padPrompt := fmt.Sprintf("Pad values in %s to width: %s", m.editTargetName(), m.padInput.View())
+		padStatus := fmt.Sprintf("PAD - filling with %s (Tab to change), %s to preview, %s to cancel", fill, m.keys.Save.Help().Key, m.keys.Cancel.Help().Key)
+		if m.statusIsError {
+			padStatus = m.errorStyle().Render(m.statusMessage)
+		}
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, padPrompt, padStatus)
+	}
+
+	if m.visualMode {
+		visualPrompt := fmt.Sprintf("Selection from [%d,%d] to [%d,%d]", m.anchorRow+1, m.anchorCol+1, m.cursorRow+1, m.cursorCol+1)
+		if m.statusMessage != "" {
+			visualPrompt += " | " + m.statusMessage
+		}
+		visualStatus := "VISUAL MODE - Move to extend the selection, c/y to copy, d to clear, F to fill down, e to edit all, Esc to exit"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, visualPrompt, visualStatus)
+	}
+
+	if m.headerMode {
+		headerPrompt := fmt.Sprintf("Column %q", m.activeHeaders[m.cursorCol])
+		if m.statusMessage != "" {
+			headerPrompt += " | " + m.statusMessage
+		}
+		headerStatus := "HEADER MODE - ←/→ to move, Enter to cycle sort, x hide, U show all, p pin, r rename, s stats, Esc to exit"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, headerPrompt, headerStatus)
+	}
+
+	if m.replaceConfirm {
+		replacePrompt := fmt.Sprintf("Replace %q with %q in cell [%d,%d]? (%d matches left)",
+			m.searchInput.Value(), m.replaceInput.Value(), m.cursorRow+1, m.cursorCol+1, len(m.searchResults))
+		replaceStatus := "REPLACE - y to replace, n to skip, a to replace all, Esc to stop"
+		return fmt.Sprintf("%s\n%s\n%s\n%s", tableView, statusInfo, replacePrompt, replaceStatus)
+	}
+
+	// Normal mode - show help with search results info
+	var statusWithSearch string
+	if m.hasSearched {
+		if len(m.searchResults) > 0 {
+			statusWithSearch = fmt.Sprintf("%s | Search: %d/%d matches (n/b to navigate)",
+				statusInfo, m.searchIndex+1, len(m.searchResults))
+			if m.searchIndex < len(m.searchResults) && m.searchResults[m.searchIndex][0] < 0 {
+				statusWithSearch += " [header]"
+			}
+		} else {
+			statusWithSearch = fmt.Sprintf("%s | Search: no matches found", statusInfo)
+		}
+	} else {
+		statusWithSearch = statusInfo
+	}
+	if m.statusMessage != "" {
+		message := m.statusMessage
+		if m.statusIsError {
+			message = m.errorStyle().Render(message)
+		}
+		statusWithSearch = fmt.Sprintf("%s | %s", statusWithSearch, message)
+	}
+
+	if m.formulaEditing {
+		keys := m.keysFor("edit")
+		formulaStatus := fmt.Sprintf("FORMULA BAR - %s to commit, %s to cancel", keys.Save.Help().Key, keys.Cancel.Help().Key)
+		return fmt.Sprintf("%s\n%s\n%s", tableView, statusWithSearch, formulaStatus)
+	}
+
+	// Normal mode - show help, or what can follow a partly typed sequence
+	if m.pendingKey != "" {
+		return fmt.Sprintf("%s\n%s\n%s", tableView, statusWithSearch, m.sequenceHintView())
+	}
+	if m.hideHelp && m.helpLevel != helpFull {
+		return fmt.Sprintf("%s\n%s", tableView, statusWithSearch)
+	}
+	helpView := m.helpView()
+	return fmt.Sprintf("%s\n%s\n%s", tableView, statusWithSearch, helpView)
+}
+
+// renderFormulaBar renders the formula bar: the cell reference and header of
+// the cell under the cursor, and its raw value or the input editing it
+func (m model) renderFormulaBar() string {
+	label := ""
+	if m.cursorCol < len(m.activeHeaders) {
+		label = fmt.Sprintf("%s%d %s", columnLetters(m.cursorCol), m.cursorRow+1, m.activeHeaders[m.cursorCol])
+	}
+	label = m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true).Render(ansi.Truncate(label, max(m.width/3, 10), "…")) + " │ "
+	if m.formulaEditing {
+		m.formulaInput.Width = max(m.width-lipgloss.Width(label)-1, 1)
+		return label + m.formulaInput.View()
+	}
+
+	value := ""
+	if m.cursorRow < len(m.activeRows) && m.cursorCol < len(m.activeRows[m.cursorRow]) {
+		value = strings.ReplaceAll(m.activeRows[m.cursorRow][m.cursorCol], "\n", "⏎")
+	}
+	return label + ansi.Truncate(value, max(m.width-lipgloss.Width(label), 1), "…")
+}
+
+// renderInspector renders the inspector pane showing the full, wrapped content
+// of the cell under the cursor
+func (m model) renderInspector() string {
+	titleStyle := m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true)
+	dimStyle := m.renderer.NewStyle().Foreground(m.theme.Subtle)
+
+	value := ""
+	if m.cursorRow < len(m.activeRows) && m.cursorCol < len(m.activeRows[m.cursorRow]) {
+		value = m.activeRows[m.cursorRow][m.cursorCol]
+	}
+	header := ""
+	if m.cursorCol < len(m.activeHeaders) {
+		header = m.activeHeaders[m.cursorCol]
+	}
+
+	syntax := detectCellSyntax(value)
+	title := fmt.Sprintf("Inspector [%d,%d] %s (%d chars)", m.cursorRow+1, m.cursorCol+1, header, uniseg.GraphemeClusterCount(value))
+	if syntax != "" {
+		title += " " + syntax
+	}
+
+	content := value
+	if header != "" {
+		content = m.cellText(value, m.cursorCol)
+	}
+	if localized := m.locale.format(content); localized != value {
+		content = localized
+		title += " (stored as " + value + ")"
+	}
+	if m.prettyPrint && syntax != "" {
+		if formatted, err := formatStructured(value, syntax); err == nil {
+			content = formatted
+		}
+	}
+	switch syntax {
+	case "JSON":
+		content = m.highlightJSON(content)
+	case "XML":
+		content = m.highlightXML(content)
+	}
+
+	width := m.width - 2
+	if width < 10 {
+		width = 10
+	}
+	lines := strings.Split(m.renderer.NewStyle().Width(width).Render(content), "\n")
+
+	// Notes go above the value
+	noteStyle := m.renderer.NewStyle().Foreground(m.theme.Note)
+	if m.cursorRow < len(m.activeRows) {
+		source := m.sourceRow(m.cursorRow)
+		var noteLines []string
+		if note := m.notes[noteKey{source, ""}]; note != "" {
+			noteLines = append(noteLines, noteStyle.Render(ansi.Truncate(noteGlyph+" Row: "+note, width, "…")))
+		}
+		if note := m.notes[noteKey{source, header}]; note != "" {
+			noteLines = append(noteLines, noteStyle.Render(ansi.Truncate(noteGlyph+" Cell: "+note, width, "…")))
+		}
+		lines = append(noteLines, lines...)
+	}
+
+	// Keep the pane at a fixed height so viewport math stays predictable
+	contentLines := inspectorHeight - 1
+	if len(lines) > contentLines {
+		hidden := len(lines) - contentLines + 1
+		lines = append(lines[:contentLines-1], dimStyle.Render(fmt.Sprintf("… %d more lines", hidden)))
+	}
+	for len(lines) < contentLines {
+		lines = append(lines, "")
+	}
+
+	return titleStyle.Render(title) + "\n" + strings.Join(lines, "\n")
+}
+
+// displayWidth returns the number of terminal cells a string occupies,
+// counting each grapheme cluster (e.g. an emoji with modifiers) once
+func displayWidth(s string) int {
+	return uniseg.StringWidth(s)
+}
+
+// graphemeBoundaries returns the rune offsets at which the grapheme clusters
+// of a string start, followed by its length in runes
+func graphemeBoundaries(s string) []int {
+	boundaries := []int{0}
+	offset := 0
+	graphemes := uniseg.NewGraphemes(s)
+	for graphemes.Next() {
+		offset += len(graphemes.Runes())
+		boundaries = append(boundaries, offset)
+	}
+	return boundaries
+}
+
+// updateTextInput passes a key to a text input, moving and deleting by whole
+// grapheme clusters so accented text and emoji sequences aren't split apart
+func updateTextInput(input *textinput.Model, msg tea.KeyMsg) tea.Cmd {
+	runes := []rune(input.Value())
+	pos := input.Position()
+	boundaries := graphemeBoundaries(input.Value())
+
+	// The cluster boundaries either side of the cursor
+	prev, next := 0, len(runes)
+	for _, b := range boundaries {
+		if b < pos {
+			prev = b
+		}
+		if b > pos && next == len(runes) {
+			next = b
+		}
+	}
+
+	switch {
+	case key.Matches(msg, input.KeyMap.CharacterBackward):
+		input.SetCursor(prev)
+		return nil
+	case key.Matches(msg, input.KeyMap.CharacterForward):
+		input.SetCursor(next)
+		return nil
+	case key.Matches(msg, input.KeyMap.DeleteCharacterBackward) && pos > 0:
+		input.SetValue(string(runes[:prev]) + string(runes[pos:]))
+		input.SetCursor(prev)
+		return nil
+	case key.Matches(msg, input.KeyMap.DeleteCharacterForward) && pos < len(runes):
+		input.SetValue(string(runes[:pos]) + string(runes[next:]))
+		input.SetCursor(pos)
+		return nil
+	}
+
+	var cmd tea.Cmd
+	*input, cmd = input.Update(msg)
+	return cmd
+}
+
+// detectCellSyntax reports whether a cell value looks like JSON or XML
+func detectCellSyntax(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return ""
+	}
+
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "JSON"
+	}
+
+	if strings.HasPrefix(trimmed, "<") && strings.HasSuffix(trimmed, ">") {
+		decoder := xml.NewDecoder(strings.NewReader(trimmed))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				return "XML"
+			}
+			if err != nil {
+				return ""
+			}
+		}
+	}
+
+	return ""
+}
+
+// formatStructured returns an indented rendering of a JSON or XML value
+func formatStructured(value, syntax string) (string, error) {
+	switch syntax {
+	case "JSON":
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(strings.TrimSpace(value)), "", "  "); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case "XML":
+		return reencodeXML(value, true)
+	}
+	return value, nil
+}
+
+// compactStructured returns a minified rendering of a JSON or XML value
+func compactStructured(value, syntax string) (string, error) {
+	switch syntax {
+	case "JSON":
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(strings.TrimSpace(value))); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case "XML":
+		return reencodeXML(value, false)
+	}
+	return value, nil
+}
+
+// reencodeXML round-trips XML through the encoder, dropping insignificant
+// whitespace and optionally indenting nested elements
+func reencodeXML(value string, indent bool) (string, error) {
+	// Namespace prefixes are kept verbatim rather than resolved to URLs
+	flatten := func(name xml.Name) xml.Name {
+		if name.Space != "" {
+			return xml.Name{Local: name.Space + ":" + name.Local}
+		}
+		return name
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(strings.TrimSpace(value)))
+	var buf bytes.Buffer
+	encoder := xml.NewEncoder(&buf)
+	if indent {
+		encoder.Indent("", "  ")
+	}
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			t.Name = flatten(t.Name)
+			attrs := make([]xml.Attr, len(t.Attr))
+			for i, attr := range t.Attr {
+				attrs[i] = xml.Attr{Name: flatten(attr.Name), Value: attr.Value}
+			}
+			t.Attr = attrs
+			token = t
+		case xml.EndElement:
+			t.Name = flatten(t.Name)
+			token = t
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		}
+
+		if err := encoder.EncodeToken(token); err != nil {
+			return "", err
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// structuredSaveValue converts the text from the structured editor back into
+// a cell value according to the configured save format
+func (m model) structuredSaveValue(original, edited string) (string, error) {
+	compact, err := compactStructured(edited, m.editSyntax)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %v", m.editSyntax, err)
+	}
+
+	// Leave the cell untouched when the content didn't actually change
+	if originalCompact, err := compactStructured(original, m.editSyntax); err == nil && originalCompact == compact {
+		return original, nil
+	}
+
+	// "as-was" keeps multi-line originals in their indented form
+	if m.config.PrettyPrint.SaveFormat == "as-was" && strings.Contains(original, "\n") {
+		return edited, nil
+	}
+	return compact, nil
+}
+
+// highlightJSON colors JSON tokens using the data type palette, in a single
+// pass over the value
+func (m model) highlightJSON(value string) string {
+	keyStyle := m.renderer.NewStyle().Foreground(m.theme.Accent)
+	punctStyle := m.renderer.NewStyle().Foreground(m.theme.Subtle)
+	tokenStyle := func(dataType DataType) lipgloss.Style {
+		return m.renderer.NewStyle().Foreground(m.typeColors[dataType])
+	}
+
+	// Tokens are all ASCII, so bytes of other characters are copied as is
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		c := value[i]
+		switch {
+		case c == '"':
+			// Scan to the closing quote, honouring escapes
+			j := i + 1
+			for j < len(value) && value[j] != '"' {
+				if value[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(value))
+			str := value[i:j]
+
+			// A string followed by a colon is an object key
+			k := j
+			for k < len(value) && strings.IndexByte(" \t\n\r", value[k]) >= 0 {
+				k++
+			}
+			if k < len(value) && value[k] == ':' {
+				b.WriteString(keyStyle.Render(str))
+			} else {
+				b.WriteString(tokenStyle(DataTypeString).Render(str))
+			}
+			i = j
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i
+			for j < len(value) && strings.IndexByte("+-.eE0123456789", value[j]) >= 0 {
+				j++
+			}
+			number := value[i:j]
+			b.WriteString(tokenStyle(detectDataType(number)).Render(number))
+			i = j
+		case strings.HasPrefix(value[i:], "true"), strings.HasPrefix(value[i:], "false"):
+			word := "true"
+			if c == 'f' {
+				word = "false"
+			}
+			b.WriteString(tokenStyle(DataTypeBool).Render(word))
+			i += len(word)
+		case strings.HasPrefix(value[i:], "null"):
+			b.WriteString(tokenStyle(DataTypeEmpty).Render("null"))
+			i += 4
+		case strings.IndexByte("{}[],:", c) >= 0:
+			b.WriteString(punctStyle.Render(string(c)))
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+var (
+	xmlTagPattern  = regexp.MustCompile(`<[^>]+>`)
+	xmlAttrPattern = regexp.MustCompile(`([\w:.-]+)(=)("[^"]*"|'[^']*')`)
+)
+
+// highlightXML colors XML tags and attribute values, leaving text content plain
+func (m model) highlightXML(value string) string {
+	tagStyle := m.renderer.NewStyle().Foreground(m.theme.Accent)
+	attrStyle := m.renderer.NewStyle().Foreground(m.typeColors[DataTypeBool])
+	valueStyle := m.renderer.NewStyle().Foreground(m.typeColors[DataTypeString])
+
+	return xmlTagPattern.ReplaceAllStringFunc(value, func(tag string) string {
+		var b strings.Builder
+		last := 0
+		for _, loc := range xmlAttrPattern.FindAllStringSubmatchIndex(tag, -1) {
+			b.WriteString(tagStyle.Render(tag[last:loc[0]]))
+			b.WriteString(attrStyle.Render(tag[loc[2]:loc[3]]))
+			b.WriteString(tagStyle.Render("="))
+			b.WriteString(valueStyle.Render(tag[loc[6]:loc[7]]))
+			last = loc[1]
+		}
+		b.WriteString(tagStyle.Render(tag[last:]))
+		return b.String()
+	})
+}
+
+// isRegexQuery reports whether a search query is a regular expression,
+// which is marked by a leading slash (e.g. /^[0-9]+$)
+func isRegexQuery(query string) bool {
+	return strings.HasPrefix(query, "/") && len(query) > 1
+}
+
+// compileSearchPattern builds a matcher for a search query, treating it as a
+// regular expression when it starts with a slash. A backslash before the
+// slash searches for the slash itself (e.g. \/usr/bin).
+func compileSearchPattern(query string, caseSensitive, wholeCell bool) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(query)
+	if isRegexQuery(query) {
+		expr = query[1:]
+	} else if literal, ok := strings.CutPrefix(query, `\/`); ok {
+		expr = regexp.QuoteMeta("/" + literal)
+	}
+	if wholeCell {
+		expr = "^(?:" + expr + ")$"
+	}
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// toggleSearchOption flips a search option checkbox on space or x
+func (m *model) toggleSearchOption(msg tea.KeyMsg, option *bool) {
+	switch msg.String() {
+	case " ", "x":
+		*option = !*option
+	}
+}
+
+// checkbox renders a labelled checkbox for option toggles
+func checkbox(checked bool, label string) string {
+	if checked {
+		return "[x] " + label
+	}
+	return "[ ] " + label
+}
+
+// startSearch enters search mode with empty inputs
+func (m *model) startSearch() {
+	m.searchMode = true
+	m.searchStep = 0
+
+	// Initialize all search inputs
+	m.searchInput = textinput.New()
+	m.searchInput.Focus()
+	m.searchInput.Placeholder = "Enter search term (prefix with / for regex, \\/ for a literal /)..."
+
+	m.searchRowInput = textinput.New()
+	m.searchRowInput.Placeholder = "Row filter (1-" + strconv.Itoa(len(m.activeRows)) + ", optional)"
+
+	m.searchColInput = textinput.New()
+	m.searchColInput.Placeholder = "Col filter (1-" + strconv.Itoa(len(m.activeHeaders)) + ", optional)"
+}
+
+func (m *model) performSearchWithFilters(query, rowFilter, colFilter string) {
+	m.searchResults = [][]int{}
+	if query == "" {
+		return
+	}
+
+	pattern, err := compileSearchPattern(query, m.searchCaseSensitive, m.searchWholeCell)
+	if err != nil {
+		m.hasSearched = false
+		m.statusMessage = fmt.Sprintf("Invalid regex: %v", err)
+		m.statusIsError = true
+		return
+	}
+
+	// Parse row filter (1-based, convert to 0-based)
+	var targetRow int = -1
+	if rowFilter != "" {
+		if rowNum, err := strconv.Atoi(rowFilter); err == nil && rowNum >= 1 && rowNum <= len(m.activeRows) {
+			targetRow = rowNum - 1
+		}
+	}
 
-			// Initialize all search inputs
-			m.searchInput = textinput.New()
-			m.searchInput.Focus()
-			m.searchInput.Placeholder = "Enter search term..."
+	// Parse column filter (1-based, convert to 0-based)
+	var targetCol int = -1
+	if colFilter != "" {
+		if colNum, err := strconv.Atoi(colFilter); err == nil && colNum >= 1 && colNum <= len(m.activeHeaders) {
+			targetCol = colNum - 1
+		}
+	}
 
-			m.searchRowInput = textinput.New()
-			m.searchRowInput.Placeholder = "Row filter (1-" + strconv.Itoa(len(m.activeRows)) + ", optional)"
+	// Header matches come first; they only apply when no row filter is set
+	if m.searchHeaders && !m.replaceMode && targetRow == -1 {
+		for colIdx, header := range m.activeHeaders {
+			if (targetCol == -1 || colIdx == targetCol) && pattern.MatchString(header) {
+				m.searchResults = append(m.searchResults, []int{-1, colIdx})
+			}
+		}
+	}
 
-			m.searchColInput = textinput.New()
-			m.searchColInput.Placeholder = "Col filter (1-" + strconv.Itoa(len(m.activeHeaders)) + ", optional)"
+	// Search through cells with filters applied
+	for rowIdx, row := range m.activeRows {
+		// Skip row if row filter is specified and doesn't match
+		if targetRow != -1 && rowIdx != targetRow {
+			continue
+		}
 
-			return m, textinput.Blink
-		case key.Matches(msg, m.keys.Filter):
-			// Enter filter mode
-			m.filterMode = true
-			m.filterInput = textinput.New()
-			m.filterInput.Focus()
-			m.filterInput.Placeholder = "SELECT col1,col2 WHERE col3 == \"value\""
-			return m, textinput.Blink
-		case key.Matches(msg, m.keys.ResetFilters):
-			// Reset all filters
-			m.resetFilters()
-		case key.Matches(msg, m.keys.NextMatch):
-			// Navigate to next search result
-			if m.hasSearched && len(m.searchResults) > 0 {
-				m.navigateToSearchResult(m.searchIndex + 1)
-			}
-		case key.Matches(msg, m.keys.PrevMatch):
-			// Navigate to previous search result
-			if m.hasSearched && len(m.searchResults) > 0 {
-				m.navigateToSearchResult(m.searchIndex - 1)
-			}
-		case key.Matches(msg, m.keys.Left):
-			if m.cursorCol > 0 {
-				m.cursorCol--
-				// Adjust viewport if cursor moved out of visible area
-				if m.cursorCol < m.viewportX {
-					m.viewportX = m.cursorCol
-				}
-			}
-		case key.Matches(msg, m.keys.Right):
-			if m.cursorCol < len(m.activeHeaders)-1 {
-				m.cursorCol++
-				// Check if cursor is now out of visible area and adjust viewport
-				_, endCol := m.calculateVisibleColumns()
-				if m.cursorCol >= endCol {
-					// Move viewport right by one column to show the cursor
-					m.viewportX++
-					// Ensure we don't go beyond the available columns
-					if m.viewportX >= len(m.activeHeaders) {
-						m.viewportX = len(m.activeHeaders) - 1
-					}
-				}
-			}
-		case key.Matches(msg, m.keys.Down):
-			if m.cursorRow < len(m.activeRows)-1 {
-				m.cursorRow++
-				maxRows := m.height - 7 // Account for extra legend line
-				if maxRows < 1 {
-					maxRows = 1
-				}
-				if m.cursorRow >= m.viewportY+maxRows {
-					m.viewportY++
-				}
+		for colIdx, cell := range row {
+			// Skip column if column filter is specified and doesn't match
+			if targetCol != -1 && colIdx != targetCol {
+				continue
 			}
-		case key.Matches(msg, m.keys.Up):
-			if m.cursorRow > 0 {
-				m.cursorRow--
-				if m.cursorRow < m.viewportY {
-					m.viewportY = m.cursorRow
-				}
+
+			if pattern.MatchString(cell) {
+				m.searchResults = append(m.searchResults, []int{rowIdx, colIdx})
 			}
-		case key.Matches(msg, m.keys.PageDown):
-			// Page down - jump by visible rows
-			maxRows := m.height - 7 // Account for table, legend, and status lines
-			if maxRows < 1 {
-				maxRows = 1
+		}
+	}
+
+	// Reset search index
+	m.searchIndex = 0
+	m.hasSearched = true
+
+	// If we have results, jump to the first one
+	if len(m.searchResults) > 0 {
+		m.navigateToSearchResult(0)
+	}
+}
+func (m *model) navigateToSearchResult(index int) {
+	if len(m.searchResults) == 0 {
+		return
+	}
+
+	// Handle wrapping
+	if index >= len(m.searchResults) {
+		index = 0
+	} else if index < 0 {
+		index = len(m.searchResults) - 1
+	}
+
+	m.searchIndex = index
+	m.cursorRow = m.searchResults[index][0]
+	m.cursorCol = m.searchResults[index][1]
+
+	// Header matches put the cursor at the top of their column
+	if m.cursorRow < 0 {
+		m.cursorRow = 0
+	}
+	m.adjustViewportAfterResize()
+}
+
+// findColumn returns the column a command refers to, by 1-based number or by
+// header name
+func (m model) findColumn(ref string) (int, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(m.activeHeaders) {
+			return 0, fmt.Errorf("invalid column: valid range 1-%d", len(m.activeHeaders))
+		}
+		return n - 1, nil
+	}
+	for i, header := range m.activeHeaders {
+		if strings.EqualFold(header, ref) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column named %q", ref)
+}
+
+// cellReferencePattern matches spreadsheet-style references like C42
+var cellReferencePattern = regexp.MustCompile(`^([A-Za-z]+)([0-9]+)$`)
+
+// cellReference resolves a spreadsheet-style reference like C42, or a column
+// name or number and a row like revenue:42, to a 0-based row and column
+func (m model) cellReference(ref string) (row, col int, err error) {
+	var rowRef string
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		// Column names may contain colons themselves; the row follows the last
+		if col, err = m.findColumn(strings.TrimSpace(ref[:i])); err != nil {
+			return 0, 0, err
+		}
+		rowRef = ref[i+1:]
+	} else if match := cellReferencePattern.FindStringSubmatch(ref); match != nil {
+		// Letters count columns like a spreadsheet: A-Z, then AA, AB, ...
+		for _, r := range strings.ToUpper(match[1]) {
+			col = col*26 + int(r-'A') + 1
+			if col > len(m.activeHeaders) {
+				return 0, 0, fmt.Errorf("invalid column %s: valid range A-%s", match[1], columnLetters(len(m.activeHeaders)-1))
 			}
-			newRow := m.cursorRow + maxRows
-			if newRow >= len(m.activeRows) {
-				newRow = len(m.activeRows) - 1
+		}
+		col--
+		rowRef = match[2]
+	} else {
+		return 0, 0, fmt.Errorf("invalid cell %q: use a reference like C42 or name:42", ref)
+	}
+
+	row, err = strconv.Atoi(strings.TrimSpace(rowRef))
+	if err != nil || row < 1 || row > len(m.activeRows) {
+		return 0, 0, fmt.Errorf("invalid row: valid range 1-%d", len(m.activeRows))
+	}
+	return row - 1, col, nil
+}
+
+// columnLetters returns the spreadsheet letters of a 0-based column: A, B, ...
+// Z, AA, AB, ...
+func columnLetters(col int) string {
+	letters := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		letters = string(rune('A'+(col-1)%26)) + letters
+	}
+	return letters
+}
+
+// runCommand runs a : command line, returning tea.Quit when the command quits
+func (m *model) runCommand(line string) (tea.Cmd, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	name, args := fields[0], fields[1:]
+
+	// A bare number goes to that row, like :120
+	if _, err := strconv.Atoi(name); err == nil {
+		name, args = "goto", fields
+	}
+
+	quit := func() (tea.Cmd, error) {
+		m.removeBackup()
+		m.quitting = true
+		return tea.Quit, nil
+	}
+
+	switch name {
+	case "w", "write":
+		if len(args) > 0 {
+			// Write a copy, leaving the save target as it is
+			path := strings.Join(args, " ")
+			if err := m.saveTo(path); err != nil {
+				return nil, fmt.Errorf("write failed: %v", err)
 			}
-			m.cursorRow = newRow
-			// Adjust viewport to show the new cursor position
-			if m.cursorRow >= m.viewportY+maxRows {
-				m.viewportY = m.cursorRow - maxRows + 1
-				if m.viewportY < 0 {
-					m.viewportY = 0
-				}
+			m.noteWritten(path)
+			m.statusMessage = fmt.Sprintf("Wrote %s", path)
+			return nil, nil
+		}
+		if m.readOnly {
+			return nil, fmt.Errorf("%s, use :saveas FILE", m.readOnlyReason())
+		}
+		if err := m.saveToOriginal(); err != nil {
+			return nil, fmt.Errorf("save failed: %v", err)
+		}
+		if !m.statusIsError {
+			m.statusMessage = fmt.Sprintf("Saved %s", m.saveFilename())
+		}
+	case "saveas":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("usage: saveas FILE")
+		}
+		path := strings.Join(args, " ")
+		if !isWritable(path) {
+			return nil, fmt.Errorf("%s isn't writable", path)
+		}
+		m.outputFile = path
+		m.readOnly = false
+		if err := m.saveToOriginal(); err != nil {
+			return nil, fmt.Errorf("save failed: %v", err)
+		}
+		if !m.statusIsError {
+			m.statusMessage = fmt.Sprintf("Saved %s, later saves go there too", path)
+		}
+	case "wq", "x":
+		if m.hasChanges {
+			if m.readOnly {
+				return nil, fmt.Errorf("%s, use :saveas FILE", m.readOnlyReason())
 			}
-		case key.Matches(msg, m.keys.PageUp):
-			// Page up - jump by visible rows
-			maxRows := m.height - 7 // Account for table, legend, and status lines
-			if maxRows < 1 {
-				maxRows = 1
+			if err := m.saveToOriginal(); err != nil {
+				return nil, fmt.Errorf("save failed: %v", err)
 			}
-			newRow := m.cursorRow - maxRows
-			if newRow < 0 {
-				newRow = 0
+			if m.statusIsError {
+				// Stay to show what else failed to save
+				return nil, nil
 			}
-			m.cursorRow = newRow
-			// Adjust viewport to show the new cursor position
-			if m.cursorRow < m.viewportY {
-				m.viewportY = m.cursorRow
+		}
+		return quit()
+	case "q", "quit":
+		if m.hasChanges {
+			return nil, fmt.Errorf("unsaved changes (add ! to discard them)")
+		}
+		return quit()
+	case "q!", "quit!":
+		return quit()
+	case "sort":
+		if len(args) == 0 {
+			m.sortColumn = ""
+			m.sortDesc = false
+			m.applySort()
+			m.statusMessage = "Sort cleared"
+			return nil, nil
+		}
+		col, err := m.findColumn(args[0])
+		if err != nil {
+			return nil, err
+		}
+		desc := false
+		if len(args) > 1 {
+			switch strings.ToLower(args[1]) {
+			case "asc":
+			case "desc":
+				desc = true
+			default:
+				return nil, fmt.Errorf("unknown sort order %q (use asc or desc)", args[1])
 			}
-		case key.Matches(msg, m.keys.PageRight):
-			// Page right - jump by visible columns
-			startCol, endCol := m.calculateVisibleColumns()
-			visibleCols := endCol - startCol
-			if visibleCols < 1 {
-				visibleCols = 1
+		}
+		m.sortColumn = m.activeHeaders[col]
+		m.sortDesc = desc
+		m.applySort()
+	case "goto", "g":
+		parts := strings.FieldsFunc(strings.Join(args, " "), func(r rune) bool { return r == ',' || r == ' ' })
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("usage: goto ROW[,COL] or goto CELL")
+		}
+		if _, err := strconv.Atoi(parts[0]); err != nil && len(parts) == 1 {
+			row, col, err := m.cellReference(parts[0])
+			if err != nil {
+				return nil, err
 			}
-			newCol := m.cursorCol + visibleCols
-			if newCol >= len(m.activeHeaders) {
-				newCol = len(m.activeHeaders) - 1
+			m.cursorRow = row
+			m.cursorCol = col
+			m.adjustViewportAfterResize()
+			return nil, nil
+		}
+		row, err := strconv.Atoi(parts[0])
+		if err != nil || row < 1 || row > len(m.activeRows) {
+			return nil, fmt.Errorf("invalid row: valid range 1-%d", len(m.activeRows))
+		}
+		col := m.cursorCol
+		if len(parts) == 2 {
+			if col, err = m.findColumn(parts[1]); err != nil {
+				return nil, err
 			}
-			m.cursorCol = newCol
-			// Adjust viewport to show the new cursor position
-			_, currentEndCol := m.calculateVisibleColumns()
-			if m.cursorCol >= currentEndCol {
-				m.viewportX = m.cursorCol - visibleCols + 1
-				if m.viewportX < 0 {
-					m.viewportX = 0
-				}
-				if m.viewportX >= len(m.activeHeaders) {
-					m.viewportX = len(m.activeHeaders) - 1
-				}
+		}
+		m.cursorRow = row - 1
+		m.cursorCol = col
+		m.adjustViewportAfterResize()
+	default:
+		return nil, fmt.Errorf("unknown command %q", name)
+	}
+	return nil, nil
+}
+
+// cellProblem is a cell whose value doesn't fit its column's type
+type cellProblem struct {
+	row, col int
+	message  string
+}
+
+// typeMismatch reports whether a value doesn't fit a column's type. Blank
+// values fit every type, every value fits a string column, and integers fit
+// float columns.
+func typeMismatch(value string, columnType DataType) bool {
+	valueType := detectDataType(value)
+	switch {
+	case valueType == DataTypeEmpty, columnType == DataTypeString, valueType == columnType:
+		return false
+	case columnType == DataTypeFloat && valueType == DataTypeInt:
+		return false
+	}
+	return true
+}
+
+// problems returns the cells in visible columns whose values don't fit their
+// column's type, in reading order
+func (m model) problems() []cellProblem {
+	var problems []cellProblem
+	for row, values := range m.activeRows {
+		for col, value := range values {
+			if col >= len(m.activeHeaders) || col >= len(m.activeColumnTypes) || m.isColumnHidden(col) ||
+				!typeMismatch(value, m.activeColumnTypes[col]) {
+				continue
 			}
-		case key.Matches(msg, m.keys.PageLeft):
-			// Page left - jump by visible columns
-			startCol, endCol := m.calculateVisibleColumns()
-			visibleCols := endCol - startCol
-			if visibleCols < 1 {
-				visibleCols = 1
+			problems = append(problems, cellProblem{
+				row:     row,
+				col:     col,
+				message: fmt.Sprintf("expected %s, got %q", dataTypeName(m.activeColumnTypes[col]), value),
+			})
+		}
+		// Ragged rows are reported at their first missing or last field
+		if len(values) != len(m.activeHeaders) && len(m.activeHeaders) > 0 {
+			problems = append(problems, cellProblem{
+				row: row,
+				col: min(len(values), len(m.activeHeaders)-1),
+				message: fmt.Sprintf("row has %d fields for %d headers (%s pads or truncates ragged rows)",
+					len(values), len(m.activeHeaders), m.keys.FixRaggedRows.Help().Key),
+			})
+		}
+	}
+	return problems
+}
+
+// countRaggedRows counts the records after the header with a different
+// number of fields than the header
+func countRaggedRows(records [][]string) int {
+	count := 0
+	for _, record := range records[1:] {
+		if len(record) != len(records[0]) {
+			count++
+		}
+	}
+	return count
+}
+
+// fixRaggedRows pads rows with too few fields with blanks and truncates rows
+// with too many, so every row has a field per header. A filter's view may
+// leave out columns, so the rows are only fixed when unfiltered.
+func (m *model) fixRaggedRows() {
+	if m.isFiltered {
+		m.statusMessage = "Reset filters before fixing ragged rows"
+		m.statusIsError = true
+		return
+	}
+	width := len(m.csvData[0])
+	padded, truncated := 0, 0
+	for _, record := range m.csvData[1:] {
+		if len(record) < width {
+			padded++
+		} else if len(record) > width {
+			truncated++
+		}
+	}
+
+	// Padded rows are new slices, so undo entries pointing at the old ones
+	// are moved over
+	moved := make(map[*string][]string)
+	fit := func(row []string) []string {
+		if len(row) > width {
+			return row[:width]
+		}
+		return padRecord(row, width, moved)
+	}
+	for i, row := range m.activeRows {
+		m.activeRows[i] = fit(row)
+	}
+	for i := 1; i < len(m.csvData); i++ {
+		m.csvData[i] = fit(m.csvData[i])
+	}
+	m.moveUndoEntries(moved)
+
+	if padded+truncated == 0 {
+		m.statusMessage = "No ragged rows"
+		return
+	}
+	m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	m.hasChanges = true
+	m.statusMessage = fmt.Sprintf("Padded %d and truncated %d row(s) to %d fields", padded, truncated, width)
+}
+
+// navigateToProblem moves the cursor to the next or previous problem cell,
+// wrapping around the table
+func (m *model) navigateToProblem(forward bool) {
+	problems := m.problems()
+	if len(problems) == 0 {
+		m.statusMessage = "No problems found"
+		return
+	}
+
+	before := func(p cellProblem) bool {
+		return p.row < m.cursorRow || (p.row == m.cursorRow && p.col < m.cursorCol)
+	}
+	after := func(p cellProblem) bool {
+		return p.row > m.cursorRow || (p.row == m.cursorRow && p.col > m.cursorCol)
+	}
+	var index int
+	if forward {
+		index = slices.IndexFunc(problems, after)
+		if index < 0 {
+			index = 0
+		}
+	} else {
+		index = len(problems) - 1
+		for index > 0 && !before(problems[index]) {
+			index--
+		}
+		if !before(problems[index]) {
+			index = len(problems) - 1
+		}
+	}
+	m.jumpToProblem(problems[index], index, len(problems))
+}
+
+// jumpToProblem moves the cursor to a problem cell and describes it
+func (m *model) jumpToProblem(p cellProblem, index, total int) {
+	m.cursorRow = p.row
+	m.cursorCol = p.col
+	m.adjustViewportAfterResize()
+	m.statusMessage = fmt.Sprintf("Problem %d/%d in %s: %s", index+1, total, m.activeHeaders[p.col], p.message)
+}
+
+// fuzzyScore scores how well query matches text as a case-insensitive
+// subsequence, higher being better, or -1 when it doesn't match. Matches at
+// the start of words and runs of consecutive letters score higher, so "rev"
+// prefers revenue over prev_value.
+func fuzzyScore(query, text string) int {
+	query, text = strings.ToLower(query), strings.ToLower(text)
+	if query == text {
+		return 1000
+	}
+
+	// Match from each place the query's first letter appears and keep the
+	// best, so "usd" finds the usd in revenue_usd rather than the u in revenue
+	runes := []rune(text)
+	best := -1
+	for start := range runes {
+		if score := fuzzyScoreFrom([]rune(query), runes, start); score > best {
+			best = score
+		}
+	}
+	if best < 0 {
+		return -1
+	}
+	if strings.HasPrefix(text, query) {
+		best += 50
+	}
+	// Among equal matches, shorter headers are closer to what was typed
+	return best*100 - len(runes)
+}
+
+// fuzzyScoreFrom scores a subsequence match of query in text that starts at
+// start, matching each letter as early as possible, or -1 when it doesn't
+// match
+func fuzzyScoreFrom(query, text []rune, start int) int {
+	if len(query) == 0 || text[start] != query[0] {
+		return -1
+	}
+	score := 0
+	pos, last := start, -2
+	for _, q := range query {
+		for pos < len(text) && text[pos] != q {
+			pos++
+		}
+		if pos == len(text) {
+			return -1
+		}
+		score++
+		if pos == last+1 {
+			score += 5
+		}
+		if pos == 0 || !unicode.IsLetter(text[pos-1]) && !unicode.IsDigit(text[pos-1]) {
+			score += 10
+		}
+		last = pos
+		pos++
+	}
+	return score
+}
+
+// fuzzyColumns returns the shown columns whose headers fuzzily match query,
+// best first
+func (m model) fuzzyColumns(query string) []int {
+	if strings.TrimSpace(query) == "" {
+		return nil
+	}
+	scores := make(map[int]int)
+	var matches []int
+	for col, header := range m.activeHeaders {
+		if m.isColumnHidden(col) {
+			continue
+		}
+		if score := fuzzyScore(strings.TrimSpace(query), header); score >= 0 {
+			scores[col] = score
+			matches = append(matches, col)
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		return scores[matches[a]] > scores[matches[b]]
+	})
+	return matches
+}
+
+// themeSettings are the colors the theme editor changes, by their name in
+// the config's colors
+var themeSettings = []string{
+	"DataTypeString", "DataTypeInt", "DataTypeFloat", "DataTypeBool", "DataTypeEmpty",
+	"Header", "SelectedForeground", "SelectedBackground", "Border", "EvenRow", "OddRow", "StatusLine", "Error",
+	"HeatmapLow", "HeatmapHigh",
+}
+
+// themeSettingTypes are the data types behind the data type color settings
+var themeSettingTypes = map[string]DataType{
+	"DataTypeString": DataTypeString,
+	"DataTypeInt":    DataTypeInt,
+	"DataTypeFloat":  DataTypeFloat,
+	"DataTypeBool":   DataTypeBool,
+	"DataTypeEmpty":  DataTypeEmpty,
+}
+
+// themeField returns the theme color behind a setting that isn't a data type
+// color
+func (m *model) themeField(setting string) *lipgloss.Color {
+	return map[string]*lipgloss.Color{
+		"Header":             &m.theme.Header,
+		"SelectedForeground": &m.theme.SelectedForeground,
+		"SelectedBackground": &m.theme.SelectedBackground,
+		"Border":             &m.theme.Border,
+		"EvenRow":            &m.theme.EvenRow,
+		"OddRow":             &m.theme.OddRow,
+		"StatusLine":         &m.theme.StatusLine,
+		"Error":              &m.theme.Error,
+		"HeatmapLow":         &m.theme.HeatmapLow,
+		"HeatmapHigh":        &m.theme.HeatmapHigh,
+	}[setting]
+}
+
+// themeColor returns the color a theme editor setting has now
+func (m model) themeColor(setting string) lipgloss.Color {
+	if dataType, ok := themeSettingTypes[setting]; ok {
+		return m.typeColors[dataType]
+	}
+	return *m.themeField(setting)
+}
+
+// setThemeColor changes a color for the rest of the session and remembers it
+// for writing to the config. Like a configured color, a data type color is
+// used on alternate rows too.
+func (m *model) setThemeColor(setting string, color lipgloss.Color) {
+	if dataType, ok := themeSettingTypes[setting]; ok {
+		m.typeColors[dataType] = color
+		m.dimColors[dataType] = color
+	} else {
+		*m.themeField(setting) = color
+	}
+	if m.themeEdits == nil {
+		m.themeEdits = make(map[string]string)
+	}
+	m.themeEdits[setting] = string(color)
+}
+
+// isBasicColor reports whether a color setting is one of the 16 basic colors
+func isBasicColor(value string) bool {
+	n, err := strconv.Atoi(value)
+	return err == nil && n >= 0 && n < 16
+}
+
+// paletteRows are the widths of the palette grid's rows: the 16 basic colors,
+// the 6x6x6 color cube as six rows, and the grayscale ramp
+var paletteRows = []int{16, 36, 36, 36, 36, 36, 36, 24}
+
+// paletteColor returns the ANSI 256 color number at a palette grid position
+func paletteColor(row, col int) int {
+	switch {
+	case row == 0:
+		return col
+	case row == len(paletteRows)-1:
+		return 232 + col
+	default:
+		return 16 + (row-1)*36 + col
+	}
+}
+
+// renderThemeEditor renders the theme editor overlay: the settings and their
+// colors next to a preview, above the palette grid
+func (m model) renderThemeEditor() string {
+	titleStyle := m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true)
+	labelStyle := m.renderer.NewStyle().Foreground(m.theme.Subtle)
+	selectedStyle := m.renderer.NewStyle().Foreground(m.theme.SelectedForeground).Background(m.theme.SelectedBackground)
+	swatch := func(color lipgloss.Color) string {
+		return m.renderer.NewStyle().Background(color).Render("  ")
+	}
+
+	settings := []string{titleStyle.Render("Settings")}
+	for i, setting := range themeSettings {
+		line := fmt.Sprintf("%-18s %-8s", setting, m.themeColor(setting))
+		if _, edited := m.themeEdits[setting]; edited {
+			line += "*"
+		} else {
+			line += " "
+		}
+		if i == m.themeSetting && !m.themeOnPalette {
+			line = selectedStyle.Render(line)
+		} else if i == m.themeSetting {
+			line = titleStyle.Render(line)
+		}
+		settings = append(settings, line+" "+swatch(m.themeColor(setting)))
+	}
+
+	// A small table in the current colors, with alternate rows dimmed like
+	// the real one
+	sample := map[DataType]string{
+		DataTypeString: "alice", DataTypeInt: "42", DataTypeFloat: "3.14", DataTypeBool: "true", DataTypeEmpty: "·",
+	}
+	order := []DataType{DataTypeString, DataTypeInt, DataTypeFloat, DataTypeBool, DataTypeEmpty}
+	border := m.renderer.NewStyle().Foreground(m.theme.Border)
+	header := make([]string, len(order))
+	for i := range order {
+		header[i] = m.renderer.NewStyle().Foreground(m.theme.Header).Bold(true).Width(6).Render(strings.TrimPrefix(themeSettings[i], "DataType"))
+	}
+	preview := []string{
+		titleStyle.Render("Preview"),
+		strings.Join(header, border.Render("│")),
+		border.Render(strings.Repeat("─", 6*len(order)+len(order)-1)),
+	}
+	for row := 0; row < 4; row++ {
+		cells := make([]string, len(order))
+		for i, dataType := range order {
+			color := m.typeColors[dataType]
+			if row%2 == 1 {
+				color = m.dimColors[dataType]
 			}
-			newCol := m.cursorCol - visibleCols
-			if newCol < 0 {
-				newCol = 0
+			style := m.renderer.NewStyle().Foreground(color).Width(6)
+			if row == 1 && i == 1 {
+				style = selectedStyle.Width(6)
 			}
-			m.cursorCol = newCol
-			// Adjust viewport to show the new cursor position
-			if m.cursorCol < m.viewportX {
-				m.viewportX = m.cursorCol
+			cells[i] = style.Render(sample[dataType])
+		}
+		preview = append(preview, strings.Join(cells, border.Render("│")))
+	}
+	preview = append(preview, "",
+		m.renderer.NewStyle().Foreground(m.theme.StatusLine).Render("Row: 2/4, Col: 2/5"),
+		m.errorStyle().Render("Error message"))
+
+	// The palette, with the picked swatch marked
+	palette := []string{titleStyle.Render("Palette") + labelStyle.Render(fmt.Sprintf("  color %d", paletteColor(m.themeSwatchRow, m.themeSwatchCol)))}
+	for row, width := range paletteRows {
+		var line strings.Builder
+		for col := 0; col < width; col++ {
+			color := lipgloss.Color(strconv.Itoa(paletteColor(row, col)))
+			if m.themeOnPalette && row == m.themeSwatchRow && col == m.themeSwatchCol {
+				line.WriteString(m.renderer.NewStyle().Background(color).Reverse(true).Render("[]"))
+			} else {
+				line.WriteString(swatch(color))
 			}
 		}
+		palette = append(palette, line.String())
 	}
-	return m, nil
+
+	body := lipgloss.JoinHorizontal(lipgloss.Top, strings.Join(settings, "\n"), "   ", strings.Join(preview, "\n"))
+	return m.renderer.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.theme.Border).
+		Padding(0, 1).
+		Render(body + "\n\n" + strings.Join(palette, "\n"))
 }
-func (m model) calculateColumnWidths() []int {
-	if len(m.activeHeaders) == 0 {
-		return []int{}
+
+// bookmark is a named cell, kept by source row and header so it survives
+// sorting and filtering
+type bookmark struct {
+	row    int
+	column string
+}
+
+// isBookmarkName reports whether a key names a bookmark: a single letter
+func isBookmarkName(name string) bool {
+	return len(name) == 1 && (name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z')
+}
+
+// bookmarkNames returns the names of the bookmarks set, in order
+func (m model) bookmarkNames() []string {
+	names := make([]string, 0, len(m.bookmarks))
+	for name := range m.bookmarks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setBookmark names the cell under the cursor, replacing any bookmark
+// already called name
+func (m *model) setBookmark(name string) {
+	row := m.sourceRow(m.cursorRow)
+	if row < 0 {
+		m.statusMessage = "rows added while filtered can't be bookmarked"
+		m.statusIsError = true
+		return
+	}
+	if m.bookmarks == nil {
+		m.bookmarks = make(map[string]bookmark)
 	}
+	m.bookmarks[name] = bookmark{row: row, column: m.activeHeaders[m.cursorCol]}
+	m.statusMessage = fmt.Sprintf("Bookmarked [%d,%d] as %s", m.cursorRow+1, m.cursorCol+1, name)
+}
 
-	columnWidths := make([]int, len(m.activeHeaders))
+// bookmarkPosition finds a bookmark's cell among the active rows and columns
+func (m model) bookmarkPosition(b bookmark) (row, col int, err error) {
+	row = slices.Index(m.rowIndex, b.row)
+	if row < 0 {
+		return 0, 0, fmt.Errorf("row %d is filtered out", b.row+1)
+	}
+	col = slices.Index(m.activeHeaders, b.column)
+	if col < 0 {
+		return 0, 0, fmt.Errorf("column %s is filtered out", b.column)
+	}
+	return row, col, nil
+}
 
-	for i, header := range m.activeHeaders {
-		columnWidths[i] = len(header)
+// jumpToBookmark moves the cursor to a bookmarked cell
+func (m *model) jumpToBookmark(name string) {
+	row, col, err := m.bookmarkPosition(m.bookmarks[name])
+	if err != nil {
+		m.statusMessage = fmt.Sprintf("bookmark %s: %v", name, err)
+		m.statusIsError = true
+		return
 	}
+	m.cursorRow = row
+	m.cursorCol = col
+	m.adjustViewportAfterResize()
+	m.statusMessage = "Jumped to bookmark " + name
+}
 
-	for _, row := range m.activeRows {
-		for i, cell := range row {
-			if i < len(columnWidths) && len(cell) > columnWidths[i] {
-				columnWidths[i] = len(cell)
-			}
+// renderBookmarks renders the bookmarks overlay with each bookmark's cell and
+// value, or why it can't be reached
+func (m model) renderBookmarks() string {
+	titleStyle := m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true)
+	locationStyle := m.renderer.NewStyle().Foreground(m.theme.Subtle)
+	selectedStyle := m.renderer.NewStyle().Foreground(m.theme.SelectedForeground).Background(m.theme.SelectedBackground)
+
+	width := max(m.width-4, 20)
+	lines := []string{titleStyle.Render(fmt.Sprintf("Bookmarks (%d)", len(m.bookmarks)))}
+	for i, name := range m.bookmarkNames() {
+		b := m.bookmarks[name]
+		location := fmt.Sprintf("%s  row %d, %s", name, b.row+1, b.column)
+		detail := ""
+		if row, col, err := m.bookmarkPosition(b); err != nil {
+			detail = ": " + err.Error()
+		} else {
+			detail = ": " + strings.ReplaceAll(m.activeRows[row][col], "\n", "⏎")
+		}
+		if i == m.bookmarkCursor {
+			lines = append(lines, selectedStyle.Render(ansi.Truncate(location+detail, width, "…")))
+		} else {
+			lines = append(lines, locationStyle.Render(location)+ansi.Truncate(detail, width-displayWidth(location), "…"))
 		}
 	}
 
-	for i := range columnWidths {
-		if columnWidths[i] < 8 {
-			columnWidths[i] = 8
+	return m.renderer.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.theme.Border).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+}
+
+// renderPreamble renders the comment lines from above the header
+func (m model) renderPreamble() string {
+	titleStyle := m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true)
+
+	width := max(m.width-4, 20)
+	lines := []string{titleStyle.Render(fmt.Sprintf("Comments above the header (%d)", len(m.preamble)))}
+	for _, line := range m.preamble {
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, ansi.Truncate(line, width, "…"))
+	}
+
+	return m.renderer.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.theme.Border).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+}
+
+// renderProblems renders the problems overlay, scrolled to keep the selected
+// problem in view
+func (m model) renderProblems() string {
+	titleStyle := m.renderer.NewStyle().Foreground(m.theme.Accent).Bold(true)
+	locationStyle := m.renderer.NewStyle().Foreground(m.theme.Subtle)
+	selectedStyle := m.renderer.NewStyle().Foreground(m.theme.SelectedForeground).Background(m.theme.SelectedBackground)
+
+	// Leave room for the border, title, and footer
+	visible := max(m.height-4, 1)
+	start := 0
+	if m.problemCursor >= visible {
+		start = m.problemCursor - visible + 1
+	}
+	end := min(start+visible, len(m.problemList))
+
+	width := max(m.width-4, 20)
+	lines := []string{titleStyle.Render(fmt.Sprintf("Problems (%d)", len(m.problemList)))}
+	for i := start; i < end; i++ {
+		p := m.problemList[i]
+		location := fmt.Sprintf("[%d,%d] %s", p.row+1, p.col+1, m.activeHeaders[p.col])
+		if i == m.problemCursor {
+			lines = append(lines, selectedStyle.Render(ansi.Truncate(location+": "+p.message, width, "…")))
+		} else {
+			lines = append(lines, locationStyle.Render(location)+ansi.Truncate(": "+p.message, width-displayWidth(location), "…"))
 		}
-		if columnWidths[i] > 20 {
-			columnWidths[i] = 20
+	}
+
+	return m.renderer.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(m.theme.Border).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+}
+
+// replaceMatch replaces every occurrence of the search term in the cell
+// referenced by the given search result
+func (m *model) replaceMatch(index int) {
+	if index < 0 || index >= len(m.searchResults) {
+		return
+	}
+	row, col := m.searchResults[index][0], m.searchResults[index][1]
+	if row < 0 || row >= len(m.activeRows) || col >= len(m.activeRows[row]) {
+		return
+	}
+
+	query := m.searchInput.Value()
+	pattern, err := compileSearchPattern(query, m.searchCaseSensitive, m.searchWholeCell)
+	if err != nil {
+		return
+	}
+
+	// Regex replacements may reference capture groups ($1, ${name})
+	cell := m.activeRows[row][col]
+	replaced := pattern.ReplaceAllLiteralString(cell, m.replaceInput.Value())
+	if isRegexQuery(query) {
+		replaced = pattern.ReplaceAllString(cell, m.replaceInput.Value())
+	}
+
+	// Replacements are meant for the data, so they aren't left behind in a
+	// filtered view like other edits
+	occurrences := len(pattern.FindAllStringIndex(cell, -1))
+	if m.setCell(row, col, replaced) {
+		m.replaceCount += occurrences
+		if m.isFiltered {
+			m.writeThrough(row, col, replaced)
+			m.undoBatch[len(m.undoBatch)-1].through = true
 		}
 	}
+}
 
-	return columnWidths
+// dropSearchResult removes a handled match and moves the cursor to the next one
+func (m *model) dropSearchResult(index int) {
+	if index < 0 || index >= len(m.searchResults) {
+		return
+	}
+	m.searchResults = append(m.searchResults[:index], m.searchResults[index+1:]...)
+	if len(m.searchResults) > 0 {
+		m.navigateToSearchResult(index)
+	}
 }
 
-func (m model) calculateVisibleColumns() (int, int) {
-	columnWidths := m.calculateColumnWidths()
-	if len(columnWidths) == 0 {
-		return 0, 0
+// finishReplace leaves replace confirmation and reports what was changed
+func (m *model) finishReplace() {
+	m.replaceConfirm = false
+	m.hasSearched = false
+
+	if m.replaceCount > 0 {
+		m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	}
+	m.statusMessage = fmt.Sprintf("Replaced %d occurrence(s)", m.replaceCount)
+	if m.isFiltered && m.replaceCount > 0 {
+		m.statusMessage += " in filtered view"
 	}
+}
 
-	// Account for lipgloss table styling overhead:
-	// - Left and right table borders: 2 chars
-	// - Each column has padding (2 chars total per column)
-	// - Column separators between columns: 1 char each
-	// - Additional margin for safety: 4 chars
-	tableBorderWidth := 2
-	marginWidth := 4
-	availableWidth := m.width - tableBorderWidth - marginWidth
+type FilterCondition struct {
+	Column   string
+	Operator string
+	Value    string
+}
 
-	startCol := m.viewportX
-	if startCol >= len(columnWidths) {
-		startCol = len(columnWidths) - 1
+type FilterQuery struct {
+	SelectColumns []string
+	Columns       []int    // Index of each selected column in the headers, which may repeat a name
+	Functions     []string // Distribution function of each selected column, "" to select it as is
+	Conditions    []FilterCondition
+}
+
+// headers returns the headers of the selected columns, with any function
+// around them as written in the query, e.g. "rank(score)"
+func (fq *FilterQuery) headers() []string {
+	headers := make([]string, len(fq.SelectColumns))
+	for i, column := range fq.SelectColumns {
+		headers[i] = column
+		if fq.Functions[i] != "" {
+			headers[i] = fmt.Sprintf("%s(%s)", fq.Functions[i], column)
+		}
 	}
-	if startCol < 0 {
-		startCol = 0
+	return headers
+}
+
+// project picks the selected columns out of rows matching the query, with
+// the distribution functions computed over those rows
+func (fq *FilterQuery) project(headers []string, rows [][]string) [][]string {
+	projected := make([][]string, len(rows))
+	for i := range projected {
+		projected[i] = make([]string, len(fq.SelectColumns))
+	}
+	for j, col := range fq.Columns {
+		values := make([]string, len(rows))
+		for i, row := range rows {
+			if col < len(row) {
+				values[i] = row[col]
+			}
+		}
+		if fq.Functions[j] != "" {
+			values = distribution(fq.Functions[j], values)
+		}
+		for i, value := range values {
+			projected[i][j] = value
+		}
 	}
+	return projected
+}
 
-	// Calculate how many columns we can fit starting from startCol
-	currentWidth := 0
-	endCol := startCol
+func parseFilterQuery(query string, headers []string) (*FilterQuery, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("empty query")
+	}
 
-	for i := startCol; i < len(columnWidths); i++ {
-		// Calculate space needed for this column:
-		// - column content width
-		// - padding (2 chars: 1 on each side)
-		// - column separator (1 char, but not for the last column we're considering)
-		columnSpace := columnWidths[i] + 2 // content + padding
+	// Create case-insensitive regex patterns
+	selectPattern := regexp.MustCompile(`(?i)^select\s+(.+?)(?:\s+where\s+(.+))?$`)
+	matches := selectPattern.FindStringSubmatch(query)
 
-		// Add separator space if this isn't the first column we're adding
-		if i > startCol {
-			columnSpace += 1 // separator
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("invalid query format. Use: SELECT col1,col2 WHERE col3 == \"value\"")
+	}
+
+	fq := &FilterQuery{}
+
+	// Parse SELECT columns, which may be wrapped in a distribution function
+	// such as rank(score)
+	functionPattern := regexp.MustCompile(`^(\w+)\s*\(\s*(.+?)\s*\)$`)
+	selectPart := strings.TrimSpace(matches[1])
+	if selectPart == "*" {
+		fq.SelectColumns = headers
+		fq.Functions = make([]string, len(headers))
+		for i := range headers {
+			fq.Columns = append(fq.Columns, i)
+		}
+	} else {
+		columns := strings.Split(selectPart, ",")
+		for _, col := range columns {
+			col = strings.TrimSpace(col)
+			function := ""
+			if call := functionPattern.FindStringSubmatch(col); call != nil && !slices.ContainsFunc(headers, func(header string) bool { return strings.EqualFold(header, col) }) {
+				function = strings.ToLower(call[1])
+				if !slices.Contains(distributionFunctions, function) {
+					return nil, fmt.Errorf("unknown function '%s' (use %s)", call[1], strings.Join(distributionFunctions, ", "))
+				}
+				col = call[2]
+			}
+			if col != "" {
+				// Check if column exists
+				found := false
+				for i, header := range headers {
+					if strings.EqualFold(header, col) {
+						fq.SelectColumns = append(fq.SelectColumns, header)
+						fq.Columns = append(fq.Columns, i)
+						fq.Functions = append(fq.Functions, function)
+						found = true
+						break
+					}
+				}
+				if !found {
+					return nil, fmt.Errorf("column '%s' not found", col)
+				}
+			}
+		}
+	}
+
+	// Parse WHERE conditions if present
+	if len(matches) > 2 && matches[2] != "" {
+		wherePart := strings.TrimSpace(matches[2])
+		conditions, err := parseWhereConditions(wherePart, headers)
+		if err != nil {
+			return nil, err
+		}
+		fq.Conditions = conditions
+	}
+
+	return fq, nil
+}
+
+func parseWhereConditions(wherePart string, headers []string) ([]FilterCondition, error) {
+	var conditions []FilterCondition
+
+	// Split by AND (case-insensitive)
+	andPattern := regexp.MustCompile(`(?i)\s+and\s+`)
+	conditionParts := andPattern.Split(wherePart, -1)
+
+	for _, part := range conditionParts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		// Parse individual condition: column operator value
+		condPattern := regexp.MustCompile(`(\w+)\s*(==|!=|>=|<=|>|<|LIKE|like)\s*"([^"]*)"`)
+		matches := condPattern.FindStringSubmatch(part)
+
+		if len(matches) != 4 {
+			return nil, fmt.Errorf("invalid condition format: %s. Use: column == \"value\"", part)
+		}
+
+		column := strings.TrimSpace(matches[1])
+		operator := strings.TrimSpace(matches[2])
+		value := matches[3]
+
+		// Check if column exists
+		found := false
+		for _, header := range headers {
+			if strings.EqualFold(header, column) {
+				column = header // Use the actual header name
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("column '%s' not found in WHERE clause", column)
 		}
 
-		// Check if adding this column would exceed available width
-		if currentWidth+columnSpace <= availableWidth {
-			currentWidth += columnSpace
-			endCol = i + 1
-		} else {
-			break
+		conditions = append(conditions, FilterCondition{
+			Column:   column,
+			Operator: strings.ToUpper(operator),
+			Value:    value,
+		})
+	}
+
+	return conditions, nil
+}
+
+// saveOriginalData keeps the unfiltered data so the filters can be reset,
+// unless a filter is already applied
+func (m *model) saveOriginalData() {
+	if !m.isFiltered {
+		m.originalHeaders = make([]string, len(m.activeHeaders))
+		copy(m.originalHeaders, m.activeHeaders)
+
+		m.originalRows = make([][]string, len(m.activeRows))
+		for i, row := range m.activeRows {
+			m.originalRows[i] = make([]string, len(row))
+			copy(m.originalRows[i], row)
 		}
-	}
 
-	// Ensure we show at least one column
-	if endCol <= startCol {
-		endCol = startCol + 1
-	}
+		m.originalColumnTypes = make([]DataType, len(m.activeColumnTypes))
+		copy(m.originalColumnTypes, m.activeColumnTypes)
 
-	// Ensure endCol doesn't exceed the number of columns
-	if endCol > len(columnWidths) {
-		endCol = len(columnWidths)
+		m.originalRowIndex = make([]int, len(m.rowIndex))
+		copy(m.originalRowIndex, m.rowIndex)
 	}
-
-	return startCol, endCol
 }
-func (m model) View() string {
-	if len(m.activeRows) == 0 {
-		return "No data to display"
-	}
 
-	styles := createTableStyles(m.renderer, m.typeColors, m.dimColors)
+func (m *model) applyFilter(query string) error {
+	// Store original data if this is the first filter
+	m.saveOriginalData()
 
-	maxRows := m.height - 7 // Account for table, column info, legend, and status lines
-	if maxRows < 1 {
-		maxRows = 1
+	// Parse the filter query using current active headers
+	filterQuery, err := parseFilterQuery(query, m.activeHeaders)
+	if err != nil {
+		return err
 	}
 
-	startRow := m.viewportY
-	endRow := startRow + maxRows
-	if endRow > len(m.activeRows) {
-		endRow = len(m.activeRows)
+	// Filter current active rows based on WHERE conditions
+	var matchingRows [][]string
+	var filteredRowIndex []int
+	for rowIdx, row := range m.activeRows {
+		if rowMatchesCurrentConditions(row, filterQuery.Conditions, m.activeHeaders) {
+			filteredRowIndex = append(filteredRowIndex, m.sourceRow(rowIdx))
+			matchingRows = append(matchingRows, row)
+		}
 	}
 
-	startCol, endCol := m.calculateVisibleColumns()
+	// Select only the specified columns
+	filteredRows := filterQuery.project(m.activeHeaders, matchingRows)
 
-	if endCol > len(m.activeHeaders) {
-		endCol = len(m.activeHeaders)
+	// Update active data with filtered results, keeping track of the
+	// columns behind the selected ones
+	columnIndex := make([]int, len(filterQuery.Columns))
+	for j, col := range filterQuery.Columns {
+		columnIndex[j] = -1
+		if filterQuery.Functions[j] == "" {
+			columnIndex[j] = m.sourceColumn(col)
+		}
 	}
+	m.columnIndex = columnIndex
+	m.activeHeaders = filterQuery.headers()
+	m.activeRows = filteredRows
+	m.rowIndex = filteredRowIndex
+	m.undoStack = nil // Rows and columns no longer line up with the history
+	m.activeColumnTypes = analyzeColumnTypes(filteredRows)
+	m.isFiltered = true
+	m.appliedFilters = append(m.appliedFilters, query)
+	m.filterLog = append(m.filterLog, query)
+
+	// Reset cursor position
+	m.cursorRow = 0
+	m.cursorCol = 0
+	m.viewportX = 0
+	m.viewportY = 0
 
-	visibleHeaders := m.activeHeaders[startCol:endCol]
-	visibleRows := make([][]string, 0, endRow-startRow)
+	return nil
+}
 
-	for i := startRow; i < endRow; i++ {
-		if i < len(m.activeRows) {
-			row := make([]string, len(visibleHeaders))
-			for j := 0; j < len(visibleHeaders) && startCol+j < len(m.activeRows[i]); j++ {
-				row[j] = m.activeRows[i][startCol+j]
-			}
-			visibleRows = append(visibleRows, row)
+// applyMarkFilter narrows the view to the rows carrying a review mark, or to
+// the unmarked rows when mark is "". It stacks with the other filters.
+func (m *model) applyMarkFilter(mark string) error {
+	var filteredRows [][]string
+	var filteredRowIndex []int
+	for rowIdx, row := range m.activeRows {
+		source := m.sourceRow(rowIdx)
+		if source >= 0 && m.marks[source] == mark {
+			filteredRows = append(filteredRows, row)
+			filteredRowIndex = append(filteredRowIndex, source)
 		}
 	}
+	label := mark
+	if label == "" {
+		label = "unmarked"
+	}
+	if len(filteredRows) == 0 {
+		return fmt.Errorf("no %s rows", label)
+	}
 
-	t := table.New().
-		Border(lipgloss.NormalBorder()).
-		BorderStyle(m.renderer.NewStyle().Foreground(lipgloss.Color("238"))).
-		Headers(visibleHeaders...).
-		Rows(visibleRows...).
-		StyleFunc(func(row, col int) lipgloss.Style {
-			if row == table.HeaderRow {
-				return styles.headerStyle
-			}
+	m.saveOriginalData()
+	m.activeRows = filteredRows
+	m.rowIndex = filteredRowIndex
+	m.undoStack = nil
+	m.activeColumnTypes = analyzeColumnTypes(filteredRows)
+	m.isFiltered = true
+	m.appliedFilters = append(m.appliedFilters, "mark == "+label)
+	m.filterLog = append(m.filterLog, "mark == "+label)
 
-			actualRow := startRow + row
-			actualCol := startCol + col
+	m.cursorRow = 0
+	m.cursorCol = 0
+	m.viewportX = 0
+	m.viewportY = 0
+	return nil
+}
 
-			if actualRow == m.cursorRow && actualCol == m.cursorCol {
-				return styles.selectedStyle
+func (m *model) rowMatchesConditions(row []string, conditions []FilterCondition) bool {
+	for _, condition := range conditions {
+		// Find column index in original headers
+		colIndex := -1
+		for i, header := range m.originalHeaders {
+			if header == condition.Column {
+				colIndex = i
+				break
 			}
+		}
 
-			even := row%2 == 0
-
-			if actualCol < len(m.activeColumnTypes) {
-				columnType := m.activeColumnTypes[actualCol]
+		if colIndex == -1 || colIndex >= len(row) {
+			return false
+		}
 
-				var color lipgloss.Color
-				if even {
-					color = styles.dimTypeColors[columnType]
-				} else {
-					color = styles.typeColors[columnType]
-				}
+		cellValue := row[colIndex]
+		if !evaluateCondition(cellValue, condition.Operator, condition.Value) {
+			return false
+		}
+	}
+	return true
+}
 
-				// If we have a color for this data type, use it
-				if color != "" {
-					return styles.baseStyle.Foreground(color)
-				}
-				// Otherwise fall through to default alternating row colors
+// rowMatchesCurrentConditions reports whether a row under headers meets all
+// the conditions
+func rowMatchesCurrentConditions(row []string, conditions []FilterCondition, currentHeaders []string) bool {
+	for _, condition := range conditions {
+		// Find column index in current headers
+		colIndex := -1
+		for i, header := range currentHeaders {
+			if header == condition.Column {
+				colIndex = i
+				break
 			}
+		}
 
-			if even {
-				return styles.baseStyle.Foreground(styles.evenRowColor)
-			}
-			return styles.baseStyle.Foreground(styles.oddRowColor)
-		})
+		if colIndex == -1 || colIndex >= len(row) {
+			return false
+		}
 
-	typeInfo := make([]string, 0, len(visibleHeaders))
-	for i, header := range visibleHeaders {
-		actualCol := startCol + i
-		if actualCol < len(m.activeColumnTypes) {
-			var typeStr string
-			switch m.activeColumnTypes[actualCol] {
-			case DataTypeString:
-				typeStr = "str"
-			case DataTypeInt:
-				typeStr = "int"
-			case DataTypeFloat:
-				typeStr = "float"
-			case DataTypeBool:
-				typeStr = "bool"
-			case DataTypeEmpty:
-				typeStr = "empty"
-			default:
-				typeStr = "unknown"
-			}
-			typeInfo = append(typeInfo, fmt.Sprintf("%s(%s)", header, typeStr))
-		} else {
-			typeInfo = append(typeInfo, header)
+		cellValue := row[colIndex]
+		if !evaluateCondition(cellValue, condition.Operator, condition.Value) {
+			return false
 		}
 	}
+	return true
+}
 
-	// Calculate total width being used
-	columnWidths := m.calculateColumnWidths()
-	totalUsedWidth := 2 // left and right borders
-	for i := startCol; i < endCol; i++ {
-		if i < len(columnWidths) {
-			totalUsedWidth += columnWidths[i] + 2 // content + padding
-			if i > startCol {
-				totalUsedWidth += 1 // separator (not for first column)
+// evaluateCondition compares a cell with a condition's value, as numbers when
+// both are
+func evaluateCondition(cellValue, operator, filterValue string) bool {
+	switch operator {
+	case "==":
+		return strings.EqualFold(cellValue, filterValue)
+	case "!=":
+		return !strings.EqualFold(cellValue, filterValue)
+	case "LIKE":
+		return strings.Contains(strings.ToLower(cellValue), strings.ToLower(filterValue))
+	case ">":
+		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
+			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
+				return cellFloat > filterFloat
+			}
+		}
+		return cellValue > filterValue
+	case "<":
+		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
+			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
+				return cellFloat < filterFloat
+			}
+		}
+		return cellValue < filterValue
+	case ">=":
+		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
+			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
+				return cellFloat >= filterFloat
+			}
+		}
+		return cellValue >= filterValue
+	case "<=":
+		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
+			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
+				return cellFloat <= filterFloat
 			}
 		}
+		return cellValue <= filterValue
 	}
+	return false
+}
 
-	legend := m.createColorLegend(styles)
-
-	// Create status info (row/col info, viewport info, modified status, filter status)
-	changeIndicator := ""
-	if m.hasChanges {
-		changeIndicator = " [MODIFIED]"
-	}
-	filterIndicator := ""
-	if m.isFiltered {
-		filterIndicator = fmt.Sprintf(" [FILTERED: %d filters]", len(m.appliedFilters))
+func (m *model) resetFilters() {
+	if !m.isFiltered {
+		return
 	}
-	statusInfo := fmt.Sprintf("Row: %d/%d, Col: %d/%d | Showing cols %d-%d | Width: %d/%d%s%s",
-		m.cursorRow+1, len(m.activeRows), m.cursorCol+1, len(m.activeHeaders), startCol+1, endCol, totalUsedWidth, m.width, changeIndicator, filterIndicator)
 
-	// Handle different modes
-	if m.savePrompt {
-		savePrompt := fmt.Sprintf("Save changes to %s?", m.filename)
-		saveStatus := "You have unsaved changes. Save to original file? (y/n, Esc to cancel)"
-		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s", t.String(), legend, statusInfo, savePrompt, saveStatus)
-	}
+	// Restore original data to active data
+	m.activeHeaders = make([]string, len(m.originalHeaders))
+	copy(m.activeHeaders, m.originalHeaders)
 
-	if m.saveFilteredPrompt {
-		savePrompt := "Save filtered CSV as: " + m.saveFilteredInput.View()
-		saveStatus := "Enter filename to save filtered data, or Esc to quit without saving"
-		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s", t.String(), legend, statusInfo, savePrompt, saveStatus)
+	m.activeRows = make([][]string, len(m.originalRows))
+	for i, row := range m.originalRows {
+		m.activeRows[i] = make([]string, len(row))
+		copy(m.activeRows[i], row)
 	}
 
-	if m.filterMode {
-		filterPrompt := "Filter: " + m.filterInput.View()
-		filterStatus := "FILTER MODE - Enter SQL-like query (SELECT col1,col2 WHERE col3 == \"value\"), Enter to apply, Esc to cancel"
-		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s", t.String(), legend, statusInfo, filterPrompt, filterStatus)
+	m.activeColumnTypes = make([]DataType, len(m.originalColumnTypes))
+	copy(m.activeColumnTypes, m.originalColumnTypes)
+
+	m.rowIndex = make([]int, len(m.originalRowIndex))
+	copy(m.rowIndex, m.originalRowIndex)
+
+	// Reset filter state
+	m.isFiltered = false
+	m.columnIndex = nil
+	m.undoStack = nil
+	m.appliedFilters = []string{}
+
+	// Reset cursor position
+	m.cursorRow = 0
+	m.cursorCol = 0
+	m.viewportX = 0
+	m.viewportY = 0
+
+	// Sorting is a view setting, so it survives the reset
+	m.applySort()
+}
+
+// Exit codes used by the non-interactive -assert mode and schema-diff
+const (
+	exitAssertFailed = 1
+	exitAssertError  = 2
+)
+
+// runAssertion evaluates a COUNT [WHERE ...] query against the rows and
+// compares the count with the expectation (e.g. "0", ">10", "<=5")
+func runAssertion(query, expect string, headers []string, rows [][]string) (int, bool, error) {
+	countPattern := regexp.MustCompile(`(?i)^count(?:\s+where\s+(.+))?$`)
+	matches := countPattern.FindStringSubmatch(strings.TrimSpace(query))
+	if matches == nil {
+		return 0, false, fmt.Errorf("invalid assertion format. Use: COUNT WHERE col == \"value\"")
 	}
 
-	if m.editMode {
-		editPrompt := fmt.Sprintf("Editing cell [%d,%d]: %s", m.cursorRow+1, m.cursorCol+1, m.textInput.View())
-		editStatus := "EDIT MODE - Enter to save, Esc to cancel"
-		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s", t.String(), legend, statusInfo, editPrompt, editStatus)
+	expectPattern := regexp.MustCompile(`^(==|!=|>=|<=|>|<)?\s*(-?\d+)$`)
+	expectMatches := expectPattern.FindStringSubmatch(strings.TrimSpace(expect))
+	if expectMatches == nil {
+		return 0, false, fmt.Errorf("invalid expectation '%s'. Use a number, optionally prefixed with ==, !=, >, <, >=, or <=", expect)
 	}
+	operator := expectMatches[1]
+	expected, _ := strconv.Atoi(expectMatches[2])
 
-	if m.gotoMode {
-		var gotoPrompt, gotoStatus string
-		if m.gotoStep == 0 {
-			gotoPrompt = fmt.Sprintf("Go to row: %s", m.rowInput.View())
-			gotoStatus = "GOTO MODE - Enter row number, then press Enter"
-		} else {
-			gotoPrompt = fmt.Sprintf("Go to row %s, column: %s", m.rowInput.Value(), m.colInput.View())
-			gotoStatus = "GOTO MODE - Enter column number, then press Enter (Esc to cancel)"
+	var conditions []FilterCondition
+	if matches[1] != "" {
+		var err error
+		conditions, err = parseWhereConditions(strings.TrimSpace(matches[1]), headers)
+		if err != nil {
+			return 0, false, err
 		}
+	}
 
-		// Show error message if there is one
-		if m.gotoError != "" {
-			errorStyle := m.renderer.NewStyle().Foreground(lipgloss.Color("#FF6B6B")).Bold(true)
-			gotoStatus = errorStyle.Render(m.gotoError)
+	count := 0
+	for _, row := range rows {
+		if rowMatchesCurrentConditions(row, conditions, headers) {
+			count++
 		}
+	}
 
-		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s", t.String(), legend, statusInfo, gotoPrompt, gotoStatus)
+	switch operator {
+	case "!=":
+		return count, count != expected, nil
+	case ">":
+		return count, count > expected, nil
+	case "<":
+		return count, count < expected, nil
+	case ">=":
+		return count, count >= expected, nil
+	case "<=":
+		return count, count <= expected, nil
 	}
+	return count, count == expected, nil
+}
 
-	if m.searchMode {
-		// Create focused indicator for current input
-		focusIndicator := func(step int) string {
-			if m.searchStep == step {
-				return "► "
+// configDelimiter returns the config's delimiter for the file's extension,
+// and whether there is one
+func configDelimiter(filename string, config *Config) (rune, *fieldSeparator, bool, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for pattern, delimiter := range config.Delimiters {
+		if !strings.HasPrefix(pattern, ".") {
+			pattern = "." + pattern
+		}
+		if ext != "" && strings.ToLower(pattern) == ext {
+			d, separator, err := parseDelimiterSpec(delimiter)
+			if err != nil {
+				return d, nil, true, fmt.Errorf("config delimiter for %s files: %v", ext, err)
 			}
-			return "  "
+			return d, separator, true, nil
 		}
+	}
+	return 0, nil, false, nil
+}
 
-		searchPrompt := fmt.Sprintf("%sSearch: %s", focusIndicator(0), m.searchInput.View())
-		rowPrompt := fmt.Sprintf("%sRow filter: %s", focusIndicator(1), m.searchRowInput.View())
-		colPrompt := fmt.Sprintf("%sCol filter: %s", focusIndicator(2), m.searchColInput.View())
-		searchStatus := "SEARCH MODE - Tab to switch fields, Enter to search, Esc to cancel"
+// chooseDelimiter parses the delimiter flag, or the config's delimiter for
+// the file's extension, and otherwise detects the file's delimiter, falling
+// back to a comma
+func chooseDelimiter(filename, delimiterFlag string, config *Config) (rune, ImportConfig, error) {
+	options := config.Import
+	if delimiterFlag != "" {
+		delimiter, separator, err := parseDelimiterSpec(delimiterFlag)
+		options.separator = separator
+		return delimiter, options, err
+	}
+	if delimiter, separator, ok, err := configDelimiter(filename, config); ok {
+		options.separator = separator
+		return delimiter, options, err
+	}
+	delimiter, err := detectDelimiter(filename, config.Import)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error detecting delimiter: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Falling back to comma delimiter\n")
+		return ',', options, nil
+	}
+	return delimiter, options, nil
+}
 
-		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%s", t.String(), legend, statusInfo, searchPrompt, rowPrompt, colPrompt, searchStatus)
+// displayFromConfig creates the renderer for stdout and picks the theme,
+// type colors, and border style from the config, warning about bad settings
+func displayFromConfig(config *Config, output io.Writer) (*lipgloss.Renderer, Theme, map[DataType]lipgloss.Color, map[DataType]lipgloss.Color, string) {
+	// Colors are picked for the terminal background, unless the config says
+	// which background to assume. Bubble Tea has the default renderer ask the
+	// terminal on stdout when it starts, so that answer is reused, and a
+	// renderer for another terminal becomes the default so styles that don't
+	// take one, like the help's, don't ask again.
+	renderer := lipgloss.DefaultRenderer()
+	if output != io.Writer(os.Stdout) {
+		renderer = lipgloss.NewRenderer(output)
+		lipgloss.SetDefaultRenderer(renderer)
+	}
+	switch config.Colors.Background {
+	case "", "auto":
+	case "dark":
+		renderer.SetHasDarkBackground(true)
+	case "light":
+		renderer.SetHasDarkBackground(false)
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: unknown background %q (use auto, dark, or light), detecting it\n", config.Colors.Background)
 	}
+	theme, err := themeFromConfig(config.Colors, renderer.HasDarkBackground())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, using the default theme\n", err)
+	}
+	typeColors, dimColors := applyConfigColors(config, theme.TypeColors, theme.DimTypeColors)
 
-	// Normal mode - show help with search results info
-	var statusWithSearch string
-	if m.hasSearched {
-		if len(m.searchResults) > 0 {
-			statusWithSearch = fmt.Sprintf("%s | Search: %d/%d matches (n/b to navigate)",
-				statusInfo, m.searchIndex+1, len(m.searchResults))
+	// Colors are mapped down to what the terminal shows, detected unless the
+	// config forces a profile. Without colors the selection is reversed
+	// instead by createTableStyles.
+	switch config.Colors.ColorProfile {
+	case "", "auto":
+	default:
+		if profile, ok := colorProfiles[config.Colors.ColorProfile]; ok {
+			renderer.SetColorProfile(profile)
 		} else {
-			statusWithSearch = fmt.Sprintf("%s | Search: no matches found", statusInfo)
+			fmt.Fprintf(os.Stderr, "Warning: unknown color profile %q (use auto, truecolor, 256, 16, or none), detecting it\n", config.Colors.ColorProfile)
 		}
-	} else {
-		statusWithSearch = statusInfo
+	}
+	if renderer.ColorProfile() == termenv.ANSI {
+		theme = ansiTheme(theme)
+		typeColors, dimColors = ansiColors(typeColors), ansiColors(dimColors)
 	}
 
-	// Normal mode - show help
-	helpView := m.help.View(m.keys)
-	return fmt.Sprintf("%s\n%s\n%s\n%s", t.String(), legend, statusWithSearch, helpView)
+	border, err := borderFromConfig(config.Border)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, using normal borders\n", err)
+	}
+	return renderer, theme, typeColors, dimColors, border
 }
 
-func (m *model) performSearchWithFilters(query, rowFilter, colFilter string) {
-	m.searchResults = [][]int{}
-	if query == "" {
-		return
+// terminalWidth returns the width of the terminal on stdout, or of $COLUMNS
+// when it isn't one, or 80
+func terminalWidth() int {
+	if width, _, err := term.GetSize(os.Stdout.Fd()); err == nil && width > 0 {
+		return width
 	}
-
-	queryLower := strings.ToLower(query)
-
-	// Parse row filter (1-based, convert to 0-based)
-	var targetRow int = -1
-	if rowFilter != "" {
-		if rowNum, err := strconv.Atoi(rowFilter); err == nil && rowNum >= 1 && rowNum <= len(m.activeRows) {
-			targetRow = rowNum - 1
-		}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
 	}
+	return 80
+}
 
-	// Parse column filter (1-based, convert to 0-based)
-	var targetCol int = -1
-	if colFilter != "" {
-		if colNum, err := strconv.Atoi(colFilter); err == nil && colNum >= 1 && colNum <= len(m.activeHeaders) {
-			targetCol = colNum - 1
+// parseInterspersed parses subcommand flags that may come before, between,
+// or after the file arguments, and returns the file arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
 		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
 	}
+}
 
-	// Search through cells with filters applied
-	for rowIdx, row := range m.activeRows {
-		// Skip row if row filter is specified and doesn't match
-		if targetRow != -1 && rowIdx != targetRow {
-			continue
-		}
+// runPeek prints the first rows of a file as a typed, colored table fitted to
+// the terminal width, like head, and returns the exit code
+func runPeek(args []string) int {
+	flags := flag.NewFlagSet("peek", flag.ExitOnError)
+	rowsFlag := flags.Int("n", 10, "Number of rows to print")
+	delimiterFlag := flags.String("d", "", "CSV delimiter character. If not specified, auto-detection will be used.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s peek [options] <csv-file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	files := parseInterspersed(flags, args)
+	if len(files) < 1 {
+		flags.Usage()
+		return 1
+	}
+	filename := files[0]
 
-		for colIdx, cell := range row {
-			// Skip column if column filter is specified and doesn't match
-			if targetCol != -1 && colIdx != targetCol {
-				continue
-			}
+	filename, cleanup, err := readableInput(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer cleanup()
 
-			if strings.Contains(strings.ToLower(cell), queryLower) {
-				m.searchResults = append(m.searchResults, []int{rowIdx, colIdx})
-			}
-		}
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load config: %v\n", err)
+		config = &Config{}
+	}
+	delimiter, options, err := chooseDelimiter(filename, *delimiterFlag, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error choosing delimiter: %v\n", err)
+		return 1
 	}
+	renderer, theme, typeColors, dimColors, border := displayFromConfig(config, os.Stdout)
 
-	// Reset search index
-	m.searchIndex = 0
-	m.hasSearched = true
+	records, err := readCSV(filename, delimiter, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	rows := records[1:]
+	shown := rows[:min(max(*rowsFlag, 0), len(rows))]
 
-	// If we have results, jump to the first one
-	if len(m.searchResults) > 0 {
-		m.cursorRow = m.searchResults[0][0]
-		m.cursorCol = m.searchResults[0][1]
-		m.adjustViewportAfterResize()
+	// Types come from the same sample the TUI starts with
+	typeSample, _ := sampleRows(rows, config.TypeSample.HeadRows, config.TypeSample.RandomRows)
+	m := model{
+		csvData:           records,
+		filename:          filename,
+		config:            config,
+		activeHeaders:     records[0],
+		activeRows:        shown,
+		activeColumnTypes: analyzeColumnTypes(typeSample),
+		width:             terminalWidth(),
+		height:            len(shown) + 20,
+		renderer:          renderer,
+		rowNumbers:        config.RowNumbers,
+		plainText:         config.PlainText,
+		theme:             theme,
+		border:            border,
+		typeColors:        typeColors,
+		dimColors:         dimColors,
+		helpLevel:         helpHint,
 	}
-}
-func (m *model) navigateToSearchResult(index int) {
-	if len(m.searchResults) == 0 {
-		return
+	m.rowIndex = make([]int, len(shown))
+	for i := range m.rowIndex {
+		m.rowIndex[i] = i
 	}
 
-	// Handle wrapping
-	if index >= len(m.searchResults) {
-		index = 0
-	} else if index < 0 {
-		index = len(m.searchResults) - 1
+	// Long values are cut to the width their column is laid out at
+	m.columnWidths = make(map[string]int)
+	for i, header := range m.activeHeaders {
+		m.columnWidths[header] = m.columnWidth(i)
 	}
 
-	m.searchIndex = index
-	m.cursorRow = m.searchResults[index][0]
-	m.cursorCol = m.searchResults[index][1]
-	m.adjustViewportAfterResize()
-}
+	m.widthCache = make(map[int]int)
+	tableView, _, endCol, _ := m.renderTable(createTableStyles(renderer, theme, typeColors, dimColors), false)
+	fmt.Println(tableView)
 
-type FilterCondition struct {
-	Column   string
-	Operator string
-	Value    string
+	var more []string
+	if hidden := len(rows) - len(shown); hidden > 0 {
+		more = append(more, fmt.Sprintf("%d more rows", hidden))
+	}
+	if hidden := len(m.activeHeaders) - endCol; hidden > 0 {
+		more = append(more, fmt.Sprintf("%d more columns", hidden))
+	}
+	if len(more) > 0 {
+		fmt.Println(renderer.NewStyle().Foreground(theme.Muted).Render("… " + strings.Join(more, ", ")))
+	}
+	return 0
 }
 
-type FilterQuery struct {
-	SelectColumns []string
-	Conditions    []FilterCondition
+// columnSummary is the JSON form of a column's statistics printed by the
+// stats subcommand
+type columnSummary struct {
+	Column   string   `json:"column"`
+	Type     string   `json:"type"`
+	Values   int      `json:"values"`
+	Blank    int      `json:"blank"`
+	Distinct int      `json:"distinct"`
+	Min      string   `json:"min,omitempty"`
+	Max      string   `json:"max,omitempty"`
+	Sum      *float64 `json:"sum,omitempty"` // Only set for numeric columns
+	Mean     *float64 `json:"mean,omitempty"`
 }
 
-func parseFilterQuery(query string, headers []string) (*FilterQuery, error) {
-	query = strings.TrimSpace(query)
-	if query == "" {
-		return nil, fmt.Errorf("empty query")
+// runStats prints the statistics of every column of a file as a table or as
+// JSON, and returns the exit code
+func runStats(args []string) int {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "Print JSON instead of a table")
+	delimiterFlag := flags.String("d", "", "CSV delimiter character. If not specified, auto-detection will be used.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s stats [options] <csv-file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
 	}
+	files := parseInterspersed(flags, args)
+	if len(files) < 1 {
+		flags.Usage()
+		return 1
+	}
+	filename := files[0]
 
-	// Create case-insensitive regex patterns
-	selectPattern := regexp.MustCompile(`(?i)^select\s+(.+?)(?:\s+where\s+(.+))?$`)
-	matches := selectPattern.FindStringSubmatch(query)
+	filename, cleanup, err := readableInput(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer cleanup()
 
-	if len(matches) == 0 {
-		return nil, fmt.Errorf("invalid query format. Use: SELECT col1,col2 WHERE col3 == \"value\"")
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load config: %v\n", err)
+		config = &Config{}
+	}
+	delimiter, options, err := chooseDelimiter(filename, *delimiterFlag, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error choosing delimiter: %v\n", err)
+		return 1
+	}
+	records, err := readCSV(filename, delimiter, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
+	headers, rows := records[0], records[1:]
+	columnTypes := analyzeColumnTypes(rows)
 
-	fq := &FilterQuery{}
+	summaries := make([]columnSummary, len(headers))
+	dataTypes := make([]DataType, len(headers))
+	for col, header := range headers {
+		dataType := DataTypeEmpty
+		if col < len(columnTypes) {
+			dataType = columnTypes[col]
+		}
+		dataTypes[col] = dataType
+		stats := computeColumnStats(rows, col, dataType)
+		summaries[col] = columnSummary{
+			Column:   header,
+			Type:     dataTypeName(dataType),
+			Values:   stats.Count,
+			Blank:    stats.Blanks,
+			Distinct: stats.Distinct,
+			Min:      stats.Min,
+			Max:      stats.Max,
+		}
+		if stats.Numeric && stats.Count > 0 {
+			summaries[col].Sum = &stats.Sum
+			summaries[col].Mean = &stats.Mean
+		}
+	}
 
-	// Parse SELECT columns
-	selectPart := strings.TrimSpace(matches[1])
-	if selectPart == "*" {
-		fq.SelectColumns = headers
-	} else {
-		columns := strings.Split(selectPart, ",")
-		for _, col := range columns {
-			col = strings.TrimSpace(col)
-			if col != "" {
-				// Check if column exists
-				found := false
-				for _, header := range headers {
-					if strings.EqualFold(header, col) {
-						fq.SelectColumns = append(fq.SelectColumns, header)
-						found = true
-						break
-					}
-				}
-				if !found {
-					return nil, fmt.Errorf("column '%s' not found", col)
-				}
-			}
+	if *jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summaries); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
 		}
+		return 0
 	}
 
-	// Parse WHERE conditions if present
-	if len(matches) > 2 && matches[2] != "" {
-		wherePart := strings.TrimSpace(matches[2])
-		conditions, err := parseWhereConditions(wherePart, headers)
-		if err != nil {
-			return nil, err
+	renderer, theme, typeColors, _, border := displayFromConfig(config, os.Stdout)
+
+	tableRows := make([][]string, len(summaries))
+	for i, summary := range summaries {
+		sum, mean := "", ""
+		if summary.Sum != nil {
+			sum = strconv.FormatFloat(*summary.Sum, 'f', -1, 64)
+			mean = strconv.FormatFloat(*summary.Mean, 'f', 4, 64)
+		}
+		tableRows[i] = []string{
+			summary.Column, summary.Type, strconv.Itoa(summary.Values), strconv.Itoa(summary.Blank),
+			strconv.Itoa(summary.Distinct), summary.Min, summary.Max, sum, mean,
 		}
-		fq.Conditions = conditions
 	}
 
-	return fq, nil
+	cellStyle := renderer.NewStyle().Padding(0, 1)
+	t := table.New().
+		Headers("Column", "Type", "Values", "Blank", "Distinct", "Min", "Max", "Sum", "Mean").
+		Rows(tableRows...).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			switch {
+			case row == table.HeaderRow:
+				return cellStyle.Foreground(theme.Header).Bold(true)
+			case col == 1:
+				// Color each type name like the legend does
+				return cellStyle.Foreground(typeColors[dataTypes[row]])
+			}
+			return cellStyle
+		})
+	if border == borderNone {
+		t = t.BorderTop(false).BorderBottom(false).BorderLeft(false).BorderRight(false).
+			BorderColumn(false).BorderHeader(false)
+	} else {
+		t = t.Border(tableBorders[border]).BorderStyle(renderer.NewStyle().Foreground(theme.Border))
+	}
+	fmt.Println(t.String())
+	fmt.Printf("%d rows, %d columns\n", len(rows), len(headers))
+	return 0
 }
 
-func parseWhereConditions(wherePart string, headers []string) ([]FilterCondition, error) {
-	var conditions []FilterCondition
+// runSchemaDiff prints the columns added, removed, or changed in type between
+// two files, with types inferred as in the TUI. With -exit-code it exits with
+// 1 when the schemas differ, for pipelines to gate on.
+func runSchemaDiff(args []string) int {
+	flags := flag.NewFlagSet("schema-diff", flag.ExitOnError)
+	exitCodeFlag := flags.Bool("exit-code", false, "Exit with 1 when the schemas differ")
+	delimiterFlag := flags.String("d", "", "CSV delimiter character for both files. If not specified, each file's delimiter is detected.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s schema-diff [options] <old-csv> <new-csv>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExit codes: 0 = same schema (or differences without -exit-code), 1 = schemas differ, 2 = invalid file\n")
+	}
+	files := parseInterspersed(flags, args)
+	if len(files) != 2 {
+		flags.Usage()
+		return exitAssertError
+	}
 
-	// Split by AND (case-insensitive)
-	andPattern := regexp.MustCompile(`(?i)\s+and\s+`)
-	conditionParts := andPattern.Split(wherePart, -1)
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load config: %v\n", err)
+		config = &Config{}
+	}
 
-	for _, part := range conditionParts {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
+	// schema reads a file's headers and the type of each column
+	schema := func(filename string) ([]string, map[string]DataType, error) {
+		filename, cleanup, err := readableInput(filename)
+		if err != nil {
+			return nil, nil, err
 		}
-
-		// Parse individual condition: column operator value
-		condPattern := regexp.MustCompile(`(\w+)\s*(==|!=|>=|<=|>|<|LIKE|like)\s*"([^"]*)"`)
-		matches := condPattern.FindStringSubmatch(part)
-
-		if len(matches) != 4 {
-			return nil, fmt.Errorf("invalid condition format: %s. Use: column == \"value\"", part)
+		defer cleanup()
+		delimiter, options, err := chooseDelimiter(filename, *delimiterFlag, config)
+		if err != nil {
+			return nil, nil, err
 		}
-
-		column := strings.TrimSpace(matches[1])
-		operator := strings.TrimSpace(matches[2])
-		value := matches[3]
-
-		// Check if column exists
-		found := false
-		for _, header := range headers {
-			if strings.EqualFold(header, column) {
-				column = header // Use the actual header name
-				found = true
-				break
+		records, err := readCSV(filename, delimiter, options)
+		if err != nil {
+			return nil, nil, err
+		}
+		columnTypes := analyzeColumnTypes(records[1:])
+		types := make(map[string]DataType, len(records[0]))
+		for col, header := range records[0] {
+			types[header] = DataTypeEmpty
+			if col < len(columnTypes) {
+				types[header] = columnTypes[col]
 			}
 		}
-		if !found {
-			return nil, fmt.Errorf("column '%s' not found in WHERE clause", column)
+		return records[0], types, nil
+	}
+	oldHeaders, oldTypes, err := schema(files[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", files[0], err)
+		return exitAssertError
+	}
+	newHeaders, newTypes, err := schema(files[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", files[1], err)
+		return exitAssertError
+	}
+
+	differences := 0
+	for _, header := range oldHeaders {
+		newType, ok := newTypes[header]
+		switch {
+		case !ok:
+			fmt.Printf("- %s (%s)\n", header, dataTypeName(oldTypes[header]))
+		case newType != oldTypes[header]:
+			fmt.Printf("~ %s: %s -> %s\n", header, dataTypeName(oldTypes[header]), dataTypeName(newType))
+		default:
+			continue
 		}
+		differences++
+	}
+	for _, header := range newHeaders {
+		if _, ok := oldTypes[header]; !ok {
+			fmt.Printf("+ %s (%s)\n", header, dataTypeName(newTypes[header]))
+			differences++
+		}
+	}
 
-		conditions = append(conditions, FilterCondition{
-			Column:   column,
-			Operator: strings.ToUpper(operator),
-			Value:    value,
-		})
+	if differences == 0 {
+		fmt.Println("Schemas match")
+		return 0
 	}
+	if *exitCodeFlag {
+		return exitAssertFailed
+	}
+	return 0
+}
 
-	return conditions, nil
+// filterRecords runs a SELECT query over rows the way the filter prompt
+// does, and returns the selected headers followed by the matching rows
+func filterRecords(query string, headers []string, rows [][]string) ([][]string, error) {
+	filterQuery, err := parseFilterQuery(query, headers)
+	if err != nil {
+		return nil, err
+	}
+	var matching [][]string
+	for _, row := range rows {
+		if rowMatchesCurrentConditions(row, filterQuery.Conditions, headers) {
+			matching = append(matching, row)
+		}
+	}
+	return append([][]string{filterQuery.headers()}, filterQuery.project(headers, matching)...), nil
 }
 
-func (m *model) applyFilter(query string) error {
-	// Store original data if this is the first filter
-	if !m.isFiltered {
-		m.originalHeaders = make([]string, len(m.activeHeaders))
-		copy(m.originalHeaders, m.activeHeaders)
+// runFilter prints the rows of a file matching a SELECT query as CSV, and
+// returns the exit code
+func runFilter(args []string) int {
+	flags := flag.NewFlagSet("filter", flag.ExitOnError)
+	delimiterFlag := flags.String("d", "", "CSV delimiter character. If not specified, auto-detection will be used.")
+	outputFlag := flags.String("o", "", "Write the rows to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s filter [options] <csv-file> <query>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nThe query uses the filter prompt's syntax: SELECT col1,col2 WHERE col3 == \"value\"\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 {
+		flags.Usage()
+		return 1
+	}
+	filename, query := positional[0], positional[1]
 
-		m.originalRows = make([][]string, len(m.activeRows))
-		for i, row := range m.activeRows {
-			m.originalRows[i] = make([]string, len(row))
-			copy(m.originalRows[i], row)
-		}
+	filename, cleanup, err := readableInput(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer cleanup()
 
-		m.originalColumnTypes = make([]DataType, len(m.activeColumnTypes))
-		copy(m.originalColumnTypes, m.activeColumnTypes)
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load config: %v\n", err)
+		config = &Config{}
+	}
+	delimiter, options, err := chooseDelimiter(filename, *delimiterFlag, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error choosing delimiter: %v\n", err)
+		return 1
+	}
+	records, err := readCSV(filename, delimiter, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	filtered, err := filterRecords(query, records[0], records[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid query: %v\n", err)
+		return 1
 	}
 
-	// Parse the filter query using current active headers
-	filterQuery, err := parseFilterQuery(query, m.activeHeaders)
+	// The rows keep the input's delimiter and line endings
+	quoting, err := quotingFromConfig(config.Export)
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "Warning: %v, using minimal quoting\n", err)
+	}
+	quoting.crlf = detectCRLF(filename)
+	if options.separator != nil {
+		quoting.separator = options.separator.text
+	}
+	if *outputFlag != "" {
+		quoting.bom = writeBOM(config.Export.BOM, detectBOM(filename))
+		err = writeCSV(*outputFlag, filtered, delimiter, quoting)
+	} else {
+		err = writeRecords(os.Stdout, filtered, delimiter, quoting)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
+	return 0
+}
 
-	// Apply column selection based on current active headers
-	selectedColumnIndices := make([]int, 0, len(filterQuery.SelectColumns))
-	for _, selectedCol := range filterQuery.SelectColumns {
-		for i, header := range m.activeHeaders {
-			if header == selectedCol {
-				selectedColumnIndices = append(selectedColumnIndices, i)
-				break
-			}
+// convertFormats maps output file extensions to the formats convert writes
+var convertFormats = map[string]string{
+	".csv":    "csv",
+	".tsv":    "tsv",
+	".tab":    "tsv",
+	".json":   "json",
+	".jsonl":  "jsonl",
+	".ndjson": "jsonl",
+	".md":     "markdown",
+	".sql":    "sql",
+}
+
+// jsonValue encodes a cell as JSON, typed by its column: numbers and booleans
+// are written bare and blank cells in those columns become null, while
+// anything else stays a string
+func jsonValue(value string, columnType DataType) json.RawMessage {
+	trimmed := strings.TrimSpace(value)
+	switch columnType {
+	case DataTypeInt, DataTypeFloat, DataTypeBool:
+		if trimmed == "" {
+			return json.RawMessage("null")
+		}
+	}
+	switch detectDataType(trimmed) {
+	case DataTypeInt, DataTypeFloat:
+		// NaN, Inf and the like parse as floats but aren't JSON numbers
+		if (columnType == DataTypeInt || columnType == DataTypeFloat) && json.Valid([]byte(trimmed)) {
+			return json.RawMessage(trimmed)
+		}
+	case DataTypeBool:
+		if columnType == DataTypeBool {
+			return json.RawMessage(strings.ToLower(trimmed))
 		}
 	}
+	encoded, _ := json.Marshal(value)
+	return encoded
+}
 
-	// Filter current active rows based on WHERE conditions
-	var filteredRows [][]string
-	for _, row := range m.activeRows {
-		if m.rowMatchesCurrentConditions(row, filterQuery.Conditions, m.activeHeaders) {
-			// Select only the specified columns
-			newRow := make([]string, len(selectedColumnIndices))
-			for i, colIdx := range selectedColumnIndices {
-				if colIdx < len(row) {
-					newRow[i] = row[colIdx]
-				}
-			}
-			filteredRows = append(filteredRows, newRow)
+// jsonObject encodes a row as a JSON object with its keys in column order
+func jsonObject(headers []string, row []string, columnTypes []DataType, kinds jsonKinds) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for col, header := range headers {
+		if col > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(header)
+		b.Write(key)
+		b.WriteByte(':')
+		value, columnType := "", DataTypeString
+		if col < len(row) {
+			value = row[col]
+		}
+		if col < len(columnTypes) {
+			columnType = columnTypes[col]
+		}
+		if encoded, ok := kinds.value(header, value); ok {
+			b.Write(encoded)
+		} else {
+			b.Write(jsonValue(value, columnType))
 		}
 	}
-
-	// Update active data with filtered results
-	m.activeHeaders = filterQuery.SelectColumns
-	m.activeRows = filteredRows
-	m.activeColumnTypes = analyzeColumnTypes(filteredRows)
-	m.isFiltered = true
-	m.appliedFilters = append(m.appliedFilters, query)
-
-	// Reset cursor position
-	m.cursorRow = 0
-	m.cursorCol = 0
-	m.viewportX = 0
-	m.viewportY = 0
-
-	return nil
+	b.WriteByte('}')
+	return b.Bytes()
 }
 
-func (m *model) rowMatchesConditions(row []string, conditions []FilterCondition) bool {
-	for _, condition := range conditions {
-		// Find column index in original headers
-		colIndex := -1
-		for i, header := range m.originalHeaders {
-			if header == condition.Column {
-				colIndex = i
-				break
-			}
+// sqlValue writes a cell as a SQL literal, typed by its column like
+// jsonValue: numbers bare, booleans as TRUE or FALSE and blank cells in those
+// columns as NULL, while anything else is a quoted string
+func sqlValue(value string, columnType DataType) string {
+	trimmed := strings.TrimSpace(value)
+	switch columnType {
+	case DataTypeInt, DataTypeFloat, DataTypeBool:
+		if trimmed == "" {
+			return "NULL"
 		}
-
-		if colIndex == -1 || colIndex >= len(row) {
-			return false
+	}
+	switch detectDataType(trimmed) {
+	case DataTypeInt, DataTypeFloat:
+		// JSON numbers are valid SQL numbers, unlike NaN, Inf and the like
+		if (columnType == DataTypeInt || columnType == DataTypeFloat) && json.Valid([]byte(trimmed)) {
+			return trimmed
 		}
-
-		cellValue := row[colIndex]
-		if !m.evaluateCondition(cellValue, condition.Operator, condition.Value) {
-			return false
+	case DataTypeBool:
+		if columnType == DataTypeBool {
+			return strings.ToUpper(trimmed)
 		}
 	}
-	return true
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
 }
 
-func (m *model) rowMatchesCurrentConditions(row []string, conditions []FilterCondition, currentHeaders []string) bool {
-	for _, condition := range conditions {
-		// Find column index in current headers
-		colIndex := -1
-		for i, header := range currentHeaders {
-			if header == condition.Column {
-				colIndex = i
-				break
+// writeSQLInserts writes rows as one INSERT statement each into table
+func writeSQLInserts(w io.Writer, table string, headers []string, rows [][]string, columnTypes []DataType) error {
+	columns := make([]string, len(headers))
+	for col, header := range headers {
+		columns[col] = quoteIdentifier(header)
+	}
+	prefix := "INSERT INTO " + quoteIdentifier(table) + " (" + strings.Join(columns, ", ") + ") VALUES ("
+	bw := bufio.NewWriter(w)
+	values := make([]string, len(headers))
+	for _, row := range rows {
+		for col := range headers {
+			value, columnType := "", DataTypeString
+			if col < len(row) {
+				value = row[col]
 			}
+			if col < len(columnTypes) {
+				columnType = columnTypes[col]
+			}
+			values[col] = sqlValue(value, columnType)
 		}
-
-		if colIndex == -1 || colIndex >= len(row) {
-			return false
-		}
-
-		cellValue := row[colIndex]
-		if !m.evaluateCondition(cellValue, condition.Operator, condition.Value) {
-			return false
+		if _, err := bw.WriteString(prefix + strings.Join(values, ", ") + ");\n"); err != nil {
+			return err
 		}
 	}
-	return true
+	return bw.Flush()
 }
-func (m *model) evaluateCondition(cellValue, operator, filterValue string) bool {
-	switch operator {
-	case "==":
-		return strings.EqualFold(cellValue, filterValue)
-	case "!=":
-		return !strings.EqualFold(cellValue, filterValue)
-	case "LIKE":
-		return strings.Contains(strings.ToLower(cellValue), strings.ToLower(filterValue))
-	case ">":
-		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
-			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
-				return cellFloat > filterFloat
+
+// markdownCell escapes a value for a markdown table cell
+func markdownCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(strings.ReplaceAll(value, "\r\n", "<br>"), "\n", "<br>")
+}
+
+// writeConverted writes the records of a file in one of the convertFormats
+func writeConverted(w io.Writer, format string, records [][]string, quoting csvQuoting, kinds jsonKinds) error {
+	headers, rows := records[0], records[1:]
+	switch format {
+	case "csv":
+		return writeRecords(w, records, ',', quoting)
+	case "tsv":
+		return writeRecords(w, records, '\t', quoting)
+	case "json", "jsonl":
+		columnTypes := analyzeColumnTypes(rows)
+		for i, row := range rows {
+			prefix, suffix := "", "\n"
+			if format == "json" {
+				prefix, suffix = ",\n  ", ""
+				if i == 0 {
+					prefix = "[\n  "
+				}
 			}
-		}
-		return cellValue > filterValue
-	case "<":
-		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
-			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
-				return cellFloat < filterFloat
+			if _, err := fmt.Fprintf(w, "%s%s%s", prefix, jsonObject(headers, row, columnTypes, kinds), suffix); err != nil {
+				return err
 			}
 		}
-		return cellValue < filterValue
-	case ">=":
-		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
-			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
-				return cellFloat >= filterFloat
+		if format == "json" {
+			end := "\n]\n"
+			if len(rows) == 0 {
+				end = "[]\n"
 			}
+			_, err := io.WriteString(w, end)
+			return err
 		}
-		return cellValue >= filterValue
-	case "<=":
-		if cellFloat, err1 := strconv.ParseFloat(cellValue, 64); err1 == nil {
-			if filterFloat, err2 := strconv.ParseFloat(filterValue, 64); err2 == nil {
-				return cellFloat <= filterFloat
+		return nil
+	case "markdown":
+		var b strings.Builder
+		line := func(cells []string) {
+			b.WriteString("|")
+			for col := range headers {
+				value := ""
+				if col < len(cells) {
+					value = cells[col]
+				}
+				b.WriteString(" " + markdownCell(value) + " |")
 			}
+			b.WriteString("\n")
 		}
-		return cellValue <= filterValue
+		line(headers)
+		b.WriteString(strings.Repeat("| --- ", len(headers)) + "|\n")
+		for _, row := range rows {
+			line(row)
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
 	}
-	return false
+	return fmt.Errorf("unknown format %q", format)
 }
 
-func (m *model) resetFilters() {
-	if !m.isFiltered {
-		return
+// runConvert writes a file in another format, chosen by the output file's
+// extension or -to, and returns the exit code
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	delimiterFlag := flags.String("d", "", "CSV delimiter character of the input. If not specified, auto-detection will be used.")
+	toFlag := flags.String("to", "", "Output format: csv, tsv, json, jsonl, markdown, or sql. If not specified, the output file's extension decides.")
+	tableFlag := flags.String("table", "", "Table the sql format inserts into. If not specified, it's named after the output file.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s convert [options] <csv-file> <output-file or - for stdout>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nJSON and SQL values are typed by column: numbers and booleans are written bare.\n")
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flags.PrintDefaults()
 	}
+	files := parseInterspersed(flags, args)
+	if len(files) != 2 {
+		flags.Usage()
+		return 1
+	}
+	filename, output := files[0], files[1]
 
-	// Restore original data to active data
-	m.activeHeaders = make([]string, len(m.originalHeaders))
-	copy(m.activeHeaders, m.originalHeaders)
-
-	m.activeRows = make([][]string, len(m.originalRows))
-	for i, row := range m.originalRows {
-		m.activeRows[i] = make([]string, len(row))
-		copy(m.activeRows[i], row)
+	filename, cleanup, err := readableInput(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
+	defer cleanup()
 
-	m.activeColumnTypes = make([]DataType, len(m.originalColumnTypes))
-	copy(m.activeColumnTypes, m.originalColumnTypes)
+	format := *toFlag
+	if format == "" {
+		format = convertFormats[strings.ToLower(filepath.Ext(output))]
+	}
+	if format == "" {
+		fmt.Fprintf(os.Stderr, "Can't tell the format of %s, use -to\n", output)
+		return 1
+	}
+	if !slices.Contains([]string{"csv", "tsv", "json", "jsonl", "markdown", "sql"}, format) {
+		fmt.Fprintf(os.Stderr, "Unknown format %q (use csv, tsv, json, jsonl, markdown, or sql)\n", format)
+		return 1
+	}
+	table := *tableFlag
+	if table == "" {
+		table, _, _ = strings.Cut(filepath.Base(output), ".")
+	}
+	if format == "sql" && (table == "" || table == "-") {
+		fmt.Fprintf(os.Stderr, "Name the table to insert into with -table\n")
+		return 1
+	}
 
-	// Reset filter state
-	m.isFiltered = false
-	m.appliedFilters = []string{}
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load config: %v\n", err)
+		config = &Config{}
+	}
+	delimiter, options, err := chooseDelimiter(filename, *delimiterFlag, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error choosing delimiter: %v\n", err)
+		return 1
+	}
+	records, err := readCSV(filename, delimiter, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	quoting, err := quotingFromConfig(config.Export)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, using minimal quoting\n", err)
+	}
 
-	// Reset cursor position
-	m.cursorRow = 0
-	m.cursorCol = 0
-	m.viewportX = 0
-	m.viewportY = 0
+	w := io.Writer(os.Stdout)
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating file %s: %v\n", output, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if format == "sql" {
+		err = writeSQLInserts(w, table, records[0], records[1:], analyzeColumnTypes(records[1:]))
+	} else {
+		err = writeConverted(w, format, records, quoting, nil)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
 }
-func main() {
+
+// run runs csvtui on the command line's arguments and returns the exit
+// code, so the temp copies read in place of the input are removed by its
+// deferred calls before main exits
+func run() int {
+	// Subcommands work on a file without the TUI
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "peek":
+			return runPeek(os.Args[2:])
+		case "stats":
+			return runStats(os.Args[2:])
+		case "schema-diff":
+			return runSchemaDiff(os.Args[2:])
+		case "filter":
+			return runFilter(os.Args[2:])
+		case "convert":
+			return runConvert(os.Args[2:])
+		}
+	}
+
 	// Define command-line flags
-	var delimiterFlag = flag.String("delimiter", "", "CSV delimiter character (comma, semicolon, tab, pipe, or any single character). If not specified, auto-detection will be used.")
+	var delimiterFlag = flag.String("delimiter", "", "CSV delimiter (comma, semicolon, tab, pipe, or any single character), text: and a multi-character separator such as \"text: | \", or regex:PATTERN. If not specified, auto-detection will be used.")
 	flag.StringVar(delimiterFlag, "d", "", "CSV delimiter character (shorthand)")
+	var assertFlag = flag.String("assert", "", "Evaluate a COUNT [WHERE ...] query without starting the TUI and exit non-zero if it doesn't meet -expect")
+	var expectFlag = flag.String("expect", "0", "Expected result for -assert: a number, optionally prefixed with ==, !=, >, <, >=, or <=")
+	var outputFlag = flag.String("output", "", "Save changes to this file instead, leaving the input file untouched")
+	flag.StringVar(outputFlag, "o", "", "Output file (shorthand)")
+	var freezeRowsFlag = flag.Int("freeze-rows", 0, "Keep the first N data rows visible while scrolling (e.g. a units row)")
+	var freezeColsFlag = flag.Int("freeze-cols", 0, "Keep the first N columns visible while scrolling right (e.g. IDs)")
+	var groupHeaderFlag = flag.Bool("group-header", false, "Treat the first row as a column group row above the headers")
+	var inlineFlag = flag.Bool("inline", false, "Draw below the prompt instead of on the alternate screen, leaving the table in the scrollback on exit")
+	var inlineHeightFlag = flag.Int("inline-height", 20, "Lines drawn with -inline")
+	var pipeFlag = flag.Bool("pipe", false, "Write the rows in view (after filtering and sorting) to stdout on quit, drawing the TUI on the terminal, for use in a shell pipeline")
+	var summaryFlag = flag.Bool("summary", false, "Print what the session did (rows viewed, filters applied, cells changed, files written) on quit; to stderr with -pipe")
+	var keysFlag = flag.String("keys", "", "Key binding preset: default, vim, emacs, arrows-only, or colemak (overrides keyPreset in the config)")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <csv-file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <csv-file, s3:// or gs:// URL, or - for stdin>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -1856,37 +13981,136 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -d semicolon data.csv          # Use semicolon delimiter (shorthand)\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -delimiter=tab data.csv        # Use tab delimiter\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d '|' data.csv                # Use pipe delimiter (shorthand)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -output cleaned.csv data.csv   # Keep data.csv as is, save to cleaned.csv\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -group-header report.csv       # First row groups the columns below it\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -inline data.csv               # Quick peek that stays in the scrollback\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  cat data.csv | %s -              # Read from stdin; save with Save As\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s data.json                   # Open a JSON array of objects (or .jsonl); saves write JSON back\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s extract.parquet             # Browse a Parquet file read-only; :saveas FILE.csv keeps changes\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s app.db                      # Pick a table of a SQLite database (or app.db:users); saves write it back\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s s3://bucket/data.csv          # Open from S3 (or gs:// for Cloud Storage); saves upload back\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -pipe data.csv | wc -l         # Filter interactively, then pass the rows on\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -summary data.csv             # Say what was viewed, changed and saved on quit\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s peek -n 20 data.csv            # Print the first 20 rows and exit\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s stats -json data.csv           # Print per-column statistics as JSON\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s schema-diff -exit-code old.csv new.csv  # Fail when columns or types changed\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s filter data.csv 'SELECT name,score WHERE score > \"5\"'  # Print matching rows as CSV\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s convert data.csv data.json     # Write typed JSON (also .tsv, .jsonl, .md, .sql)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s convert app.jsonl app.csv      # Flatten JSON Lines logs to CSV\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -assert 'COUNT WHERE status == \"error\"' -expect 0 data.csv  # Data check for CI\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nExit codes with -assert: 0 = passed, 1 = assertion failed, 2 = invalid query or file\n")
 	}
 	flag.Parse()
 
 	if flag.NArg() < 1 {
 		flag.Usage()
-		os.Exit(1)
+		return 1
 	}
 
 	filename := flag.Arg(0)
 
-	// Determine delimiter
-	var delimiter rune
-	var err error
+	// Data piped in is read from a temp copy, and the TUI reads keys from
+	// the terminal instead
+	fromStdin := filename == "-"
+	if fromStdin {
+		path, err := spoolStdin()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+			return 1
+		}
+		defer os.Remove(path)
+		filename = path
+	}
+
+	// Sidecars and git diffs go by the file named on the command line, not
+	// the temp copies read in its place below
+	sourcePath := filename
+	if fromStdin || isObjectURL(filename) {
+		sourcePath = ""
+	}
 
-	if *delimiterFlag == "" {
-		// Auto-detect delimiter
-		delimiter, err = detectDelimiter(filename)
+	// Objects in S3 or Cloud Storage are read from a temp copy too, and
+	// saves upload it back
+	objectURL := ""
+	if isObjectURL(filename) {
+		path, err := downloadObject(filename)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error detecting delimiter: %v\n", err)
-			fmt.Fprintf(os.Stderr, "Falling back to comma delimiter\n")
-			delimiter = ','
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
 		}
-	} else {
-		// Parse user-specified delimiter
-		delimiter, err = parseDelimiterFlag(*delimiterFlag)
+		defer os.Remove(path)
+		objectURL, filename = filename, path
+	}
+
+	// SQLite databases are read a table at a time, picked from a list when
+	// there are several, and saves write the table back
+	sqliteFile, sqliteTable := "", ""
+	var sqliteTypes []DataType
+	if database, table := splitSQLiteName(filename); isSQLiteFile(database) {
+		if table == "" {
+			var options []tea.ProgramOption
+			if fromStdin {
+				options = append(options, tea.WithInputTTY())
+			}
+			picked, err := pickTable(database, options...)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			if picked == "" {
+				return 0
+			}
+			table = picked
+		}
+		path, columnTypes, err := sqliteInput(database, table)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing delimiter flag: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer os.Remove(path)
+		sqliteFile, sqliteTable, sqliteTypes, filename = database, table, columnTypes, path
+		if sourcePath != "" {
+			sourcePath = database
 		}
 	}
 
+	// So are gzip and zstd files, and saves compress them again
+	compressedFile := ""
+	path, compression, err := decompressInput(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if compression != "" {
+		defer os.Remove(path)
+		compressedFile, filename = filename, path
+	}
+
+	// And JSON arrays and JSON Lines, and saves write them back
+	jsonFile := ""
+	path, jsonFormat, jsonKinds, err := jsonInput(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if jsonFormat != "" {
+		defer os.Remove(path)
+		jsonFile, filename = filename, path
+	}
+
+	// Parquet files too, with their column types, but saves can't write
+	// Parquet back
+	parquetFile := ""
+	path, parquetTypes, err := parquetInput(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if parquetTypes != nil {
+		defer os.Remove(path)
+		parquetFile, filename = filename, path
+	}
+
 	// Load config
 	config, err := loadConfig()
 	if err != nil {
@@ -1894,24 +14118,141 @@ func main() {
 		config = &Config{} // Use empty config (defaults will be used)
 	}
 
+	delimiter, importOptions, err := chooseDelimiter(filename, *delimiterFlag, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error choosing delimiter: %v\n", err)
+		return 1
+	}
+	config.Import = importOptions
+	if *keysFlag != "" {
+		config.KeyPreset = *keysFlag
+	}
+
+	// In a pipeline stdout carries the rows, so the TUI draws on the terminal
+	screen := os.Stdout
+	if *pipeFlag {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening the terminal for -pipe: %v\n", err)
+			return 1
+		}
+		defer tty.Close()
+		screen = tty
+	}
+
 	// Apply config to colors and hotkeys
-	defaultColors := getDefaultColors()
-	defaultDimColors := getDefaultDimColors()
-	typeColors, dimColors := applyConfigColors(config, defaultColors, defaultDimColors)
+	renderer, theme, typeColors, dimColors, border := displayFromConfig(config, screen)
+
+	quoting, err := quotingFromConfig(config.Export)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, using minimal quoting\n", err)
+	}
+	quoting.crlf = detectCRLF(filename)
+	quoting.bom = writeBOM(config.Export.BOM, detectBOM(filename))
+
+	locale, err := localeFromConfig(config.Locale)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, showing values as stored\n", err)
+	}
+	conversions, units, err := unitsFromConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
 
 	defaultHotkeys := getDefaultHotkeys()
 	hotkeys := applyConfigHotkeys(config, defaultHotkeys)
 	keyMap := createKeyMapFromConfig(hotkeys)
+	modeKeys := modeKeyMaps(config, hotkeys)
 
-	records, err := readCSV(filename, delimiter)
+	records, err := readCSV(filename, delimiter, config.Import)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		if *assertFlag != "" {
+			return exitAssertError
+		}
+		return 1
+	}
+	if importOptions.separator != nil {
+		quoting.separator = importOptions.separator.text
+	}
+
+	// Split off the column group row, or fall back to a sidecar group spec
+	sidecar := sidecarPath(sourcePath, sqliteTable)
+	var groupHeaderRow []string
+	var columnGroups map[string]string
+	if *groupHeaderFlag {
+		if len(records) < 2 {
+			fmt.Fprintf(os.Stderr, "CSV file has no header row below the group row\n")
+			return 1
+		}
+		groupHeaderRow = records[0]
+		records = records[1:]
+		columnGroups = groupsFromRow(groupHeaderRow, records[0])
+	} else if columnGroups, err = loadGroupSpec(sidecar); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
 
 	headers := records[0]
 	rows := records[1:]
-	columnTypes := analyzeColumnTypes(rows)
+
+	// Non-interactive assertion mode for CI data checks
+	if *assertFlag != "" {
+		count, passed, err := runAssertion(*assertFlag, *expectFlag, headers, rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error evaluating assertion: %v\n", err)
+			return exitAssertError
+		}
+		if !passed {
+			fmt.Fprintf(os.Stderr, "FAIL: %s returned %d, expected %s\n", *assertFlag, count, *expectFlag)
+			return exitAssertFailed
+		}
+		fmt.Printf("PASS: %s returned %d\n", *assertFlag, count)
+		return 0
+	}
+
+	// Big files start with types from a sample and get the rest from a
+	// background scan
+	typeSample, typesSampled := sampleRows(rows, config.TypeSample.HeadRows, config.TypeSample.RandomRows)
+	columnTypes := analyzeColumnTypes(typeSample)
+	if parquetTypes != nil {
+		// Parquet columns have their types in the schema
+		columnTypes, typesSampled = parquetTypes, false
+	}
+	if sqliteTypes != nil {
+		// SQLite columns mostly do too
+		columnTypes, typesSampled = sqliteTypes, false
+	}
+
+	notes, err := loadNotes(sidecar)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	marks, err := loadMarks(sidecar)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	var columnWidths map[string]int
+	if config.RememberWidths {
+		if columnWidths, err = loadColumnWidths(sidecar); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	saveTarget := filename
+	if jsonFile != "" {
+		saveTarget = jsonFile
+	}
+	if sqliteFile != "" {
+		saveTarget = sqliteFile
+	}
+	if compressedFile != "" {
+		saveTarget = compressedFile
+	}
+	if *outputFlag != "" {
+		saveTarget = *outputFlag
+	}
 
 	// Create a deep copy of the original data for comparison
 	originalData := make([][]string, len(records))
@@ -1921,31 +14262,66 @@ func main() {
 	}
 
 	m := model{
-		csvData:      records,
-		filename:     filename,
-		delimiter:    delimiter,
-		originalData: originalData,
-		savePrompt:   false,
-		hasChanges:   false,
+		csvData:        records,
+		filename:       filename,
+		outputFile:     *outputFlag,
+		readOnly:       !isWritable(saveTarget) || ((fromStdin || parquetFile != "") && *outputFlag == ""),
+		fromStdin:      fromStdin,
+		objectURL:      objectURL,
+		compressedFile: compressedFile,
+		compression:    compression,
+		jsonFile:       jsonFile,
+		jsonFormat:     jsonFormat,
+		jsonKinds:      jsonKinds,
+		parquetFile:    parquetFile,
+		sqliteFile:     sqliteFile,
+		sqliteTable:    sqliteTable,
+		sourcePath:     sourcePath,
+		pipeOutput:     *pipeFlag,
+		screen:         screen,
+		quoting:        quoting,
+		delimiter:      delimiter,
+		originalData:   originalData,
+		savePrompt:     false,
+		hasChanges:     false,
 
 		// Initialize active data with original data
 		activeHeaders:     make([]string, len(headers)),
 		activeRows:        make([][]string, len(rows)),
 		activeColumnTypes: make([]DataType, len(columnTypes)),
-
-		cursorRow: 0,
-		cursorCol: 0,
-		viewportX: 0,
-		viewportY: 0,
-		width:     80,
-		height:    24,
-		renderer:  lipgloss.NewRenderer(os.Stdout),
-
+		typesSampled:      typesSampled,
+
+		width:    80,
+		height:   24,
+		renderer: renderer,
+
+		rowNumbers:         config.RowNumbers,
+		formulaBar:         config.FormulaBar,
+		hideLegend:         config.HideLegend || config.PlainText, // The legend explains the type colors
+		plainText:          config.PlainText,
+		hideHelp:           config.HideHelpBar,
+		notes:              notes,
+		marks:              marks,
+		columnWidths:       columnWidths,
+		frozenRows:         max(*freezeRowsFlag, 0),
+		frozenCols:         max(*freezeColsFlag, 0),
+		groupHeaderRow:     groupHeaderRow,
+		preamble:           filePreamble(filename, config.Import),
+		columnGroups:       columnGroups,
+		prettyPrint:        config.PrettyPrint.Enabled,
+		rowTemplate:        config.RowTemplate,
 		keys:               keyMap,
+		modeKeys:           modeKeys,
 		help:               help.New(),
+		helpLevel:          helpShort,
 		config:             config,
+		theme:              theme,
+		border:             border,
+		locale:             locale,
 		typeColors:         typeColors,
 		dimColors:          dimColors,
+		unitConversions:    conversions,
+		units:              units,
 		isFiltered:         false,
 		appliedFilters:     []string{},
 		filterMode:         false,
@@ -1959,9 +14335,98 @@ func main() {
 		copy(m.activeRows[i], row)
 	}
 	copy(m.activeColumnTypes, columnTypes)
+	m.rowIndex = make([]int, len(rows))
+	for i := range m.rowIndex {
+		m.rowIndex[i] = i
+	}
+
+	if column, desc, ok := matchSortRule(config.SortRules, headers); ok {
+		m.sortColumn = column
+		m.sortDesc = desc
+		m.applySort()
+	}
+
+	if jsonFile != "" {
+		format := "JSON"
+		if jsonFormat == "jsonl" {
+			format = "JSON Lines"
+		}
+		m.statusMessage = fmt.Sprintf("Read %d object(s) with %d key(s); saving writes %s back, :saveas FILE.csv writes CSV",
+			len(rows), len(headers), format)
+	}
+	if sqliteFile != "" {
+		m.statusMessage = fmt.Sprintf("Read %d row(s) from table %s; saving replaces its rows, :saveas FILE.db:TABLE writes another table",
+			len(rows), sqliteTable)
+	}
+	if len(m.preamble) > 0 {
+		m.statusMessage = fmt.Sprintf("Skipped %d comment line(s) above the header (%s to view)",
+			len(m.preamble), keyMap.FileComments.Help().Key)
+	}
+	if ragged := countRaggedRows(records); ragged > 0 {
+		m.statusMessage = fmt.Sprintf("%d row(s) don't have %d fields: %s lists them, %s pads or truncates them",
+			ragged, len(headers), keyMap.ProblemsList.Help().Key, keyMap.FixRaggedRows.Help().Key)
+		m.statusIsError = true
+	}
+
+	m.lastWindowTitle = m.windowTitle()
+
+	// Mouse positions are relative to the screen, so the mouse is only used
+	// on the alternate screen, where the view starts at the top
+	var options []tea.ProgramOption
+	if *inlineFlag {
+		m.inlineHeight = max(*inlineHeightFlag, 8)
+		m.height = m.inlineHeight
+	} else {
+		options = append(options, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	}
+	if fromStdin {
+		options = append(options, tea.WithInputTTY())
+	}
+	if *pipeFlag {
+		options = append(options, tea.WithOutput(screen))
+	}
 
-	p := tea.NewProgram(m, tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
-		log.Fatal(err)
+	p := tea.NewProgram(m, options...)
+	final, err := p.Run()
+
+	// Clear the terminal title we set for the session
+	fmt.Fprint(screen, ansi.SetWindowTitle(""))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	// Marks set since the last save are kept whether or not the data was
+	if final := final.(model); final.marksChanged {
+		if err := final.saveMarks(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save marks: %v\n", err)
+		}
+	}
+
+	// Pass the rows in view on down the pipeline, as a stream without a byte
+	// order mark
+	if *pipeFlag {
+		m := final.(model)
+		quoting := m.quoting
+		quoting.bom = false
+		if err := writeRecords(os.Stdout, append([][]string{m.activeHeaders}, m.activeRows...), m.delimiter, quoting); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing rows to stdout: %v\n", err)
+			return 1
+		}
+	}
+
+	// The summary stays out of the rows going down a pipeline
+	if *summaryFlag || config.ExitSummary {
+		out := os.Stdout
+		if *pipeFlag {
+			out = os.Stderr
+		}
+		fmt.Fprint(out, final.(model).exitSummary())
 	}
+	return 0
+}
+
+func main() {
+	os.Exit(run())
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// newTestModel returns a model showing records, unfiltered and unsorted
+func newTestModel(records [][]string) model {
+	m := model{csvData: records, config: &Config{}, width: 80, height: 24}
+	m.activeHeaders = slices.Clone(records[0])
+	for i, row := range records[1:] {
+		m.activeRows = append(m.activeRows, slices.Clone(row))
+		m.rowIndex = append(m.rowIndex, i)
+	}
+	m.activeColumnTypes = analyzeColumnTypes(m.activeRows)
+	return m
+}
+
+func TestInsertRowWhileFiltered(t *testing.T) {
+	m := newTestModel([][]string{
+		{"id", "name", "city"},
+		{"1", "ann", "oslo"},
+		{"2", "bob", "rome"},
+		{"3", "cat", "oslo"},
+	})
+	m.rowTemplate = map[string]string{"city": "oslo"}
+	if err := m.applyFilter(`SELECT name WHERE city == "oslo"`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Below ann, the first row in view
+	m.insertRow()
+	if len(m.activeRows) != 3 {
+		t.Fatalf("filtered view has %d rows after the insert, want 3", len(m.activeRows))
+	}
+
+	m.resetFilters()
+	want := [][]string{
+		{"1", "ann", "oslo"},
+		{"", "", "oslo"},
+		{"2", "bob", "rome"},
+		{"3", "cat", "oslo"},
+	}
+	if !slices.EqualFunc(m.activeRows, want, slices.Equal) {
+		t.Errorf("rows after resetting the filter = %q, want %q", m.activeRows, want)
+	}
+	if !slices.EqualFunc(m.csvData[1:], want, slices.Equal) {
+		t.Errorf("data after resetting the filter = %q, want %q", m.csvData[1:], want)
+	}
+	if !m.hasChanges {
+		t.Error("inserting a row while filtered didn't mark the data as changed")
+	}
+}